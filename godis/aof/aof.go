@@ -1,6 +1,9 @@
 package aof
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"godis/config"
 	"godis/interface/database"
 	"godis/lib/logger"
@@ -10,94 +13,352 @@ import (
 	"godis/redis/reply"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
 const (
-	aofQueueSize = 1 << 16
+	defaultAppendFilename = "appendonly.aof"
+	aofQueueSize          = 1 << 16
+	// max commands written by one group commit
+	maxBatchSize = 1024
+)
+
+// fsync policies
+const (
+	// FsyncAlways fsync after every write, commands wait until their data reaches disk
+	FsyncAlways = "always"
+	// FsyncEverySec fsync every second in background
+	FsyncEverySec = "everysec"
+	// FsyncNo leaves fsync to operating system
+	FsyncNo = "no"
 )
 
 type payload struct {
 	cmdLine CmdLine
 	dbIndex int
+	// closed after cmdLine has been fsynced, only used by FsyncAlways
+	synced chan struct{}
 }
 
 // Handler receive msgs from channel and write to AOF file
 type Handler struct {
-	db          database.EmbedDB
-	tmpDBMaker  func() database.EmbedDB
-	aofChan     chan *payload
+	db         database.EmbedDB
+	tmpDBMaker func() database.EmbedDB
+	aofChan    chan *payload
+	// aofFile is the incremental file being appended
 	aofFile     *os.File
 	aofFilename string
+	// dir holds manifest and all aof files
+	dir string
+	// baseName is prefix of files in dir
+	baseName string
+	manifest *manifest
 	// aof goroutine will send msg to main goroutine through this channel when aof tasks finished and ready to shutdown
 	aofFinished chan struct{}
 	// pause aof for start/finish aof rewrite progress
 	pausingAof sync.RWMutex
 	currentDB  int
+	// closed to stop background fsync of FsyncEverySec
+	stopFsync chan struct{}
+
+	// total size of aof files, compared with baseSize to trigger auto rewrite
+	currentSize int64
+	// size of aof files after last rewrite or loading
+	baseSize  int64
+	rewriting int32
+	// closeMu guards closing, so that no rewrite starts after Close began waiting for rewriteWg,
+	// and AddAof holds its read lock while sending, so that aofChan is not closed during sending
+	closeMu   sync.RWMutex
+	closing   bool
+	rewriteWg sync.WaitGroup
+}
+
+func getFsyncPolicy() string {
+	switch config.Properties().AppendFsync {
+	case FsyncAlways, FsyncNo:
+		return config.Properties().AppendFsync
+	case FsyncEverySec, "":
+		return FsyncEverySec
+	default:
+		logger.Warn("unknown appendfsync policy " + config.Properties().AppendFsync + ", use everysec")
+		return FsyncEverySec
+	}
+}
+
+// getAofDir returns directory of aof files and prefix of their names
+func getAofDir() (string, string) {
+	filename := config.Properties().AppendFilename
+	if filename == "" {
+		filename = defaultAppendFilename
+	}
+	dir := config.Properties().AppendDirname
+	if dir == "" {
+		dir = filepath.Dir(filename)
+	}
+	return dir, filepath.Base(filename)
 }
 
 // NewAOFHandler creates a new aof.Handler
 func NewAOFHandler(db database.EmbedDB, tmpDBMaker func() database.EmbedDB) (*Handler, error) {
 	handler := &Handler{}
-	handler.aofFilename = config.Properties.AppendFilename
+	handler.dir, handler.baseName = getAofDir()
 	handler.db = db
 	handler.tmpDBMaker = tmpDBMaker
-	handler.LoadAof(0)
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	err := os.MkdirAll(handler.dir, 0755)
 	if err != nil {
 		return nil, err
 	}
-	handler.aofFile = aofFile
+	handler.manifest, err = handler.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	err = handler.checkAof()
+	if err != nil {
+		return nil, err
+	}
+	err = handler.LoadAof()
+	if err != nil {
+		return nil, err
+	}
+	err = handler.openIncrFile()
+	if err != nil {
+		return nil, err
+	}
+	handler.currentSize = handler.getAofSize()
+	handler.baseSize = handler.currentSize
 	handler.aofChan = make(chan *payload, aofQueueSize)
 	handler.aofFinished = make(chan struct{})
 	go func() {
 		handler.handleAof()
 	}()
+	// appendfsync may be changed by CONFIG SET, so background fsync is always ready
+	handler.stopFsync = make(chan struct{})
+	go handler.fsyncEverySec()
 	return handler, nil
 }
 
-// AddAof send command to aof goroutine through channel
+// loadManifest reads manifest file,
+// if it does not exist, a single aof file written by older version will be used as base file
+func (handler *Handler) loadManifest() (*manifest, error) {
+	file, err := os.Open(filepath.Join(handler.dir, getManifestName(handler.baseName)))
+	if err == nil {
+		defer func() {
+			_ = file.Close()
+		}()
+		return parseManifest(file)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	m := &manifest{}
+	legacyFilename := config.Properties().AppendFilename
+	if legacyFilename == "" {
+		return m, nil
+	}
+	info, err := os.Stat(legacyFilename)
+	if err != nil || info.IsDir() {
+		return m, nil
+	}
+	target := filepath.Join(handler.dir, handler.baseName)
+	if filepath.Clean(legacyFilename) != filepath.Clean(target) {
+		err = os.Rename(legacyFilename, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger.Info("upgrade " + legacyFilename + " to base file of multi part aof")
+	m.base = &aofFileInfo{
+		name:     handler.baseName,
+		seq:      1,
+		fileType: baseFileType,
+	}
+	return m, nil
+}
+
+// openIncrFile opens the last incremental file for appending, creates one if there is none
+func (handler *Handler) openIncrFile() error {
+	m := handler.manifest
+	if len(m.incrs) == 0 {
+		// the new file will be loaded from db 0
+		handler.currentDB = 0
+		m = m.clone()
+		m.incrs = append(m.incrs, &aofFileInfo{
+			name:     getIncrFileName(handler.baseName, 1),
+			seq:      1,
+			fileType: incrFileType,
+		})
+		err := writeManifest(handler.dir, handler.baseName, m)
+		if err != nil {
+			return err
+		}
+		handler.manifest = m
+	}
+	filename := filepath.Join(handler.dir, m.incrs[len(m.incrs)-1].name)
+	aofFile, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	handler.aofFile = aofFile
+	handler.aofFilename = filename
+	return nil
+}
+
+func (handler *Handler) getAofSize() int64 {
+	var size int64
+	for _, file := range handler.manifest.files() {
+		info, err := os.Stat(filepath.Join(handler.dir, file.name))
+		if err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// checkAof finds out broken tail of the last aof file, trims it if aof-load-truncated is enabled
+func (handler *Handler) checkAof() error {
+	files := handler.manifest.files()
+	if len(files) == 0 {
+		return nil
+	}
+	filename := filepath.Join(handler.dir, files[len(files)-1].name)
+	if isSnapshotFile(filename) {
+		// snapshot has its own checksum
+		return nil
+	}
+	result, err := CheckFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if result.Err == nil {
+		return nil
+	}
+	if result.Err != ErrTruncated {
+		return fmt.Errorf("%v, use godis-check-aof --fix to repair it", result.Err)
+	}
+	if !config.Properties().AofLoadTruncated {
+		return fmt.Errorf("%v, set aof-load-truncated yes or use godis-check-aof --fix to repair it", result.Err)
+	}
+	logger.Warn(fmt.Sprintf("aof file is truncated, trim it to %d bytes and %d commands",
+		result.ValidSize, result.Commands))
+	return os.Truncate(filename, result.ValidSize)
+}
+
+// AddAof send command to aof goroutine through channel, commands are dropped once Close started
+// when appendfsync is always, AddAof returns after the command has been fsynced
 func (handler *Handler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
+	if config.Properties().AppendOnly && handler.aofChan != nil {
+		p := &payload{
 			cmdLine: cmdLine,
 			dbIndex: dbIndex,
 		}
+		if getFsyncPolicy() == FsyncAlways {
+			p.synced = make(chan struct{})
+		}
+		handler.closeMu.RLock()
+		if handler.closing {
+			handler.closeMu.RUnlock()
+			return
+		}
+		handler.aofChan <- p
+		handler.closeMu.RUnlock()
+		if p.synced != nil {
+			<-p.synced
+		}
 	}
 }
 
 // handleAof listen aof channel and write into file
 func (handler *Handler) handleAof() {
-	// serialized execution
-	handler.currentDB = 0
+	// serialized execution, currentDB has been set by LoadAof
+	buf := &bytes.Buffer{}
+	batch := make([]*payload, 0, maxBatchSize)
 	for p := range handler.aofChan {
+		batch = append(batch[:0], p)
+		fsync := getFsyncPolicy()
+		if fsync == FsyncAlways {
+			// group commit: write all waiting commands then fsync only once
+			batch = handler.collectBatch(batch)
+		}
 		handler.pausingAof.RLock() // prevent other goroutines from pausing aof
-		if p.dbIndex != handler.currentDB {
-			// select db
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				logger.Warn(err)
-				continue // skip this command
+		buf.Reset()
+		for _, p := range batch {
+			if p.dbIndex != handler.currentDB {
+				// select db
+				buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes())
+				handler.currentDB = p.dbIndex
 			}
-			handler.currentDB = p.dbIndex
+			buf.Write(reply.MakeMultiBulkReply(p.cmdLine).ToBytes())
 		}
-		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
+		n, err := handler.aofFile.Write(buf.Bytes())
 		if err != nil {
 			logger.Warn(err)
 		}
+		atomic.AddInt64(&handler.currentSize, int64(n))
+		// p is waiting for fsync if appendfsync was always when it was sent
+		if fsync == FsyncAlways || p.synced != nil {
+			if err := handler.aofFile.Sync(); err != nil {
+				logger.Warn("fsync failed: " + err.Error())
+			}
+		}
 		handler.pausingAof.RUnlock()
+		for _, p := range batch {
+			if p.synced != nil {
+				close(p.synced)
+			}
+		}
 	}
 	handler.aofFinished <- struct{}{}
 }
 
-// LoadAof read aof file
-func (handler *Handler) LoadAof(maxBytes int) {
+// collectBatch appends payloads already in the channel to batch without blocking
+func (handler *Handler) collectBatch(batch []*payload) []*payload {
+	for len(batch) < maxBatchSize {
+		select {
+		case p, ok := <-handler.aofChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, p)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (handler *Handler) fsyncEverySec() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if getFsyncPolicy() != FsyncEverySec {
+				continue
+			}
+			handler.pausingAof.RLock() // aofFile may be replaced by rewrite
+			if err := handler.aofFile.Sync(); err != nil {
+				logger.Warn("fsync failed: " + err.Error())
+			}
+			handler.pausingAof.RUnlock()
+		case <-handler.stopFsync:
+			return
+		}
+	}
+}
+
+// LoadAof reads all aof files listed in manifest
+func (handler *Handler) LoadAof() error {
 	// delete aofChan to prevent write again
 	aofChan := handler.aofChan
 	handler.aofChan = nil
@@ -105,24 +366,60 @@ func (handler *Handler) LoadAof(maxBytes int) {
 		handler.aofChan = aofChan
 	}(aofChan)
 
-	file, err := os.Open(handler.aofFilename)
+	for _, file := range handler.manifest.files() {
+		dbIndex, err := handler.loadFile(filepath.Join(handler.dir, file.name))
+		if err != nil {
+			return err
+		}
+		// appending will continue from the last file
+		handler.currentDB = dbIndex
+	}
+	return nil
+}
+
+func isSnapshotFile(filename string) bool {
+	file, err := os.Open(filename)
 	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return
+		return false
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return isSnapshot(bufio.NewReader(file))
+}
+
+// loadFile reads a base file or an incremental file, returns the selected db index at the end of file
+func (handler *Handler) loadFile(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logger.Warn("aof file " + filename + " not found")
+			return 0, nil
 		}
-		logger.Warn(err)
-		return
+		return 0, err
 	}
-	defer file.Close()
+	defer func() {
+		_ = file.Close()
+	}()
 
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
+	reader := bufio.NewReader(file)
+	fakeConn := &connection.FakeConn{} // saves dbIndex and marks commands replayed from aof
+	fakeConn.SetReplaying(true)
+	if isSnapshot(reader) {
+		err = loadSnapshot(reader, func(dbIndex int, cmdLine CmdLine) {
+			fakeConn.SelectDB(dbIndex)
+			ret := handler.db.Exec(fakeConn, cmdLine)
+			if reply.IsErrorReply(ret) {
+				logger.Error("exec err", string(ret.ToBytes()))
+			}
+		})
+		if err != nil {
+			return 0, fmt.Errorf("load %s failed: %v", filename, err)
+		}
+		return fakeConn.GetDBIndex(), nil
 	}
+
 	ch := parser.ParseStream(reader)
-	fakeConn := &connection.FakeConn{} // only used for save dbIndex
 	for p := range ch {
 		if p.Err != nil {
 			if p.Err == io.EOF {
@@ -142,16 +439,59 @@ func (handler *Handler) LoadAof(maxBytes int) {
 		}
 		ret := handler.db.Exec(fakeConn, r.Args)
 		if reply.IsErrorReply(ret) {
-			logger.Error("exec err", err)
+			logger.Error("exec err", string(ret.ToBytes()))
 		}
 	}
+	return fakeConn.GetDBIndex(), nil
 }
 
-// Close gracefully stops aof persistence procedure
+// LoadFile replays an aof file into db without appending to it, it is used by offline tools.
+// If filename is a manifest of multi part aof, the files listed in it are loaded in order
+func LoadFile(db database.EmbedDB, filename string) error {
+	handler := &Handler{
+		db:  db,
+		dir: filepath.Dir(filename),
+	}
+	if !strings.HasSuffix(filename, manifestSuffix) {
+		_, err := handler.loadFile(filename)
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	handler.manifest, err = parseManifest(file)
+	if err != nil {
+		return err
+	}
+	return handler.LoadAof()
+}
+
+// Close gracefully stops aof persistence procedure, buffered commands are written and fsynced.
+// It is safe to call Close more than once
 func (handler *Handler) Close() {
+	handler.closeMu.Lock()
+	if handler.closing {
+		handler.closeMu.Unlock()
+		return
+	}
+	handler.closing = true
+	handler.closeMu.Unlock()
+	// aofFile won't be replaced after all rewrites finished
+	handler.rewriteWg.Wait()
 	if handler.aofFile != nil {
 		close(handler.aofChan)
 		<-handler.aofFinished // wait for aof finished
+		if handler.stopFsync != nil {
+			close(handler.stopFsync)
+		}
+		// fsync even if appendfsync is no, nothing should be lost after shutdown
+		if err := handler.aofFile.Sync(); err != nil {
+			logger.Warn(err)
+		}
 		err := handler.aofFile.Close()
 		if err != nil {
 			logger.Warn(err)
@@ -0,0 +1,171 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ErrTruncated means aof file ends in the middle of a command, usually caused by a crash while writing
+var ErrTruncated = errors.New("aof file is truncated")
+
+// FormatError means aof file contains content which is not a valid command
+type FormatError struct {
+	// Offset is the position where the broken command begins
+	Offset int64
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("bad aof format at offset %d: %s", e.Offset, e.Reason)
+}
+
+// CheckResult describes result of aof checking
+type CheckResult struct {
+	// Commands is count of complete commands
+	Commands int
+	// ValidSize is length of the prefix which only contains complete commands
+	ValidSize int64
+	// Err is ErrTruncated, *FormatError, other io error or nil if aof is intact
+	Err error
+	// Snapshot means the file is a base file in snapshot format, which cannot be repaired by truncating
+	Snapshot bool
+}
+
+type aofScanner struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func (s *aofScanner) readLine() ([]byte, error) {
+	line, err := s.reader.ReadBytes('\n')
+	s.offset += int64(len(line))
+	if err == io.EOF && len(line) > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New("line must end with CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (s *aofScanner) readLength(line []byte, prefix byte) (int64, error) {
+	if len(line) == 0 || line[0] != prefix {
+		return 0, fmt.Errorf("expect '%c', got %q", prefix, line)
+	}
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("illegal length %q", line)
+	}
+	return n, nil
+}
+
+// readCommand reads a whole multi bulk, returns io.EOF only if nothing has been read
+func (s *aofScanner) readCommand() error {
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	argc, err := s.readLength(line, '*')
+	if err != nil {
+		return err
+	}
+	if argc == 0 {
+		return errors.New("empty command")
+	}
+	for i := int64(0); i < argc; i++ {
+		line, err = s.readLine()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		bulkLen, err := s.readLength(line, '$')
+		if err != nil {
+			return err
+		}
+		n, err := s.reader.Discard(int(bulkLen))
+		s.offset += int64(n)
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		line, err = s.readLine()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) != 0 {
+			return errors.New("bulk string longer than declared")
+		}
+	}
+	return nil
+}
+
+// Check scans aof content and finds out the prefix consisting of complete commands
+func Check(reader io.Reader) *CheckResult {
+	scanner := &aofScanner{
+		reader: bufio.NewReader(reader),
+	}
+	result := &CheckResult{}
+	for {
+		err := scanner.readCommand()
+		if err == io.EOF {
+			return result
+		}
+		if err == io.ErrUnexpectedEOF {
+			result.Err = ErrTruncated
+			return result
+		}
+		if err != nil {
+			if _, isPathErr := err.(*os.PathError); isPathErr {
+				result.Err = err
+			} else {
+				result.Err = &FormatError{
+					Offset: result.ValidSize,
+					Reason: err.Error(),
+				}
+			}
+			return result
+		}
+		result.Commands++
+		result.ValidSize = scanner.offset
+	}
+}
+
+// CheckFile checks the given aof file, see Check. Base file in snapshot format is validated by its checksum
+func CheckFile(filename string) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	reader := bufio.NewReader(file)
+	if !isSnapshot(reader) {
+		return Check(reader), nil
+	}
+	result := &CheckResult{
+		Snapshot: true,
+	}
+	result.Err = loadSnapshot(reader, func(dbIndex int, cmdLine CmdLine) {
+		result.Commands++
+	})
+	if result.Err == nil {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		result.ValidSize = info.Size()
+	}
+	return result, nil
+}
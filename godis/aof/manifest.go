@@ -0,0 +1,174 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// aof is made up of a base file and incremental files, the manifest records them in loading order.
+// the base file is a snapshot of the dataset when last rewrite started, it is optional before first rewrite
+// incremental files hold commands executed after the base.
+// format of manifest lines: file <name> seq <seq> type <b|i>
+
+const (
+	baseFileType = 'b'
+	incrFileType = 'i'
+
+	manifestSuffix = ".manifest"
+)
+
+type aofFileInfo struct {
+	name     string
+	seq      int
+	fileType byte
+}
+
+type manifest struct {
+	base  *aofFileInfo
+	incrs []*aofFileInfo
+}
+
+func getManifestName(baseName string) string {
+	return baseName + manifestSuffix
+}
+
+func getBaseFileName(baseName string, seq int, snapshot bool) string {
+	if snapshot {
+		return baseName + "." + strconv.Itoa(seq) + ".base.rdb"
+	}
+	return baseName + "." + strconv.Itoa(seq) + ".base.aof"
+}
+
+func getIncrFileName(baseName string, seq int) string {
+	return baseName + "." + strconv.Itoa(seq) + ".incr.aof"
+}
+
+// files returns all files in loading order
+func (m *manifest) files() []*aofFileInfo {
+	files := make([]*aofFileInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *manifest) nextBaseSeq() int {
+	if m.base == nil {
+		return 1
+	}
+	return m.base.seq + 1
+}
+
+func (m *manifest) nextIncrSeq() int {
+	if len(m.incrs) == 0 {
+		return 1
+	}
+	return m.incrs[len(m.incrs)-1].seq + 1
+}
+
+func (m *manifest) clone() *manifest {
+	return &manifest{
+		base:  m.base,
+		incrs: append([]*aofFileInfo{}, m.incrs...),
+	}
+}
+
+func (m *manifest) encode() []byte {
+	buf := &bytes.Buffer{}
+	for _, file := range m.files() {
+		buf.WriteString(fmt.Sprintf("file %s seq %d type %c\n", file.name, file.seq, file.fileType))
+	}
+	return buf.Bytes()
+}
+
+func parseManifest(reader io.Reader) (*manifest, error) {
+	m := &manifest{}
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid manifest line %d: %s", lineNum, line)
+		}
+		file := &aofFileInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				file.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.Atoi(fields[i+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid seq at manifest line %d: %s", lineNum, line)
+				}
+				file.seq = seq
+			case "type":
+				file.fileType = fields[i+1][0]
+			}
+		}
+		if file.name == "" || strings.ContainsAny(file.name, `/\`) {
+			return nil, fmt.Errorf("invalid file name at manifest line %d: %s", lineNum, line)
+		}
+		switch file.fileType {
+		case baseFileType:
+			if m.base != nil {
+				return nil, fmt.Errorf("found more than one base file in manifest")
+			}
+			m.base = file
+		case incrFileType:
+			m.incrs = append(m.incrs, file)
+		default:
+			return nil, fmt.Errorf("unknown file type at manifest line %d: %s", lineNum, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeManifest replaces manifest file atomically
+func writeManifest(dir string, baseName string, m *manifest) error {
+	tmpFile, err := ioutil.TempFile(dir, "temp-"+getManifestName(baseName)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(m.encode())
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filepath.Join(dir, getManifestName(baseName)))
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes renaming in dir durable, it is not supported on every platform so errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
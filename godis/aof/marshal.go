@@ -1,10 +1,15 @@
 package aof
 
 import (
+	"godis/datastruct/bloom"
 	"godis/datastruct/dict"
+	"godis/datastruct/fencedlock"
+	"godis/datastruct/jsontree"
 	List "godis/datastruct/list"
 	"godis/datastruct/set"
+	"godis/datastruct/sketch"
 	SortedSet "godis/datastruct/sortedset"
+	"godis/datastruct/timeseries"
 	"godis/interface/database"
 	"godis/redis/reply"
 	"strconv"
@@ -28,6 +33,22 @@ func EntityToCmd(key string, entity *database.DataEntity) *reply.MultiBulkReply
 		cmd = hashToCmd(key, val)
 	case *SortedSet.SortedSet:
 		cmd = zSetToCmd(key, val)
+	case *bloom.ScalableFilter:
+		cmd = loadChunkToCmd(bfLoadChunkCmd, key, val.Marshal())
+	case *bloom.CuckooFilter:
+		cmd = loadChunkToCmd(cfLoadChunkCmd, key, val.Marshal())
+	case *sketch.CountMinSketch:
+		cmd = loadChunkToCmd(cmsLoadChunkCmd, key, val.Marshal())
+	case *sketch.TopK:
+		cmd = loadChunkToCmd(topKLoadChunkCmd, key, val.Marshal())
+	case *jsontree.Document:
+		cmd = reply.MakeMultiBulkReply([][]byte{jsonSetCmd, []byte(key), jsonRootPath, jsontree.Marshal(val.Root)})
+	case *timeseries.Series:
+		cmd = loadChunkToCmd(tsLoadChunkCmd, key, val.Marshal())
+	case *fencedlock.Lock:
+		cmd = reply.MakeMultiBulkReply([][]byte{lockLoadCmd, []byte(key), val.Marshal()})
+	case database.CustomValue:
+		cmd = reply.MakeMultiBulkReply([][]byte{loadValueCmd, []byte(key), []byte(val.TypeName()), val.Marshal()})
 	}
 	return cmd
 }
@@ -105,6 +126,25 @@ func zSetToCmd(key string, zset *SortedSet.SortedSet) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(args)
 }
 
+var (
+	bfLoadChunkCmd   = []byte("BF.LOADCHUNK")
+	cfLoadChunkCmd   = []byte("CF.LOADCHUNK")
+	cmsLoadChunkCmd  = []byte("CMS.LOADCHUNK")
+	topKLoadChunkCmd = []byte("TOPK.LOADCHUNK")
+	firstChunk       = []byte("1")
+	jsonSetCmd       = []byte("JSON.SET")
+	jsonRootPath     = []byte("$")
+	tsLoadChunkCmd   = []byte("TS.LOADCHUNK")
+	lockLoadCmd      = []byte("LOCK.LOAD")
+	// loadValueCmd restores values of custom types: MODULE.LOADVALUE key type data
+	loadValueCmd = []byte("MODULE.LOADVALUE")
+)
+
+// loadChunkToCmd restores filter, sketch or time series in one chunk
+func loadChunkToCmd(cmdName []byte, key string, data []byte) *reply.MultiBulkReply {
+	return reply.MakeMultiBulkReply([][]byte{cmdName, []byte(key), firstChunk, data})
+}
+
 var pExpireAtBytes = []byte("PEXPIREAT")
 
 // MakeExpireCmd generates command line to set expiration for the given key
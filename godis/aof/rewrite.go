@@ -1,84 +1,201 @@
 package aof
 
 import (
+	"errors"
 	"godis/config"
 	"godis/interface/database"
 	"godis/lib/logger"
 	"godis/lib/utils"
 	"godis/redis/reply"
-	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+// ErrRewriting means another rewrite is in progress
+var ErrRewriting = errors.New("Background append only file rewriting already in progress")
+
 func (handler *Handler) newRewriteHandler() *Handler {
 	h := &Handler{}
-	h.aofFilename = handler.aofFilename
+	h.dir = handler.dir
+	h.baseName = handler.baseName
 	h.db = handler.tmpDBMaker()
 	return h
 }
 
 // RewriteCtx holds context of an AOF rewriting procedure
 type RewriteCtx struct {
-	tmpFile  *os.File
-	fileSize int64
-	dbIdx    int // selected db index when startRewrite
+	// files to merge into new base file, they won't change during rewriting
+	files   []*aofFileInfo
+	tmpFile *os.File
+	// incremental files whose seq is greater than lastIncrSeq are written after rewrite started
+	lastIncrSeq int
+	baseSeq     int
+	snapshot    bool
+}
+
+func (handler *Handler) beginRewrite() bool {
+	handler.closeMu.Lock()
+	defer handler.closeMu.Unlock()
+	if handler.closing {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&handler.rewriting, 0, 1) {
+		return false
+	}
+	handler.rewriteWg.Add(1)
+	return true
+}
+
+func (handler *Handler) endRewrite() {
+	atomic.StoreInt32(&handler.rewriting, 0)
+	handler.rewriteWg.Done()
+}
+
+// IsRewriting returns whether a rewrite is in progress
+func (handler *Handler) IsRewriting() bool {
+	return atomic.LoadInt32(&handler.rewriting) == 1
+}
+
+// Rewrite carries out AOF rewrite and blocks until it finished
+func (handler *Handler) Rewrite() error {
+	if !handler.beginRewrite() {
+		return ErrRewriting
+	}
+	defer handler.endRewrite()
+	return handler.rewrite()
+}
+
+// BackgroundRewrite starts AOF rewrite in another goroutine
+func (handler *Handler) BackgroundRewrite() error {
+	if !handler.beginRewrite() {
+		return ErrRewriting
+	}
+	go func() {
+		defer handler.endRewrite()
+		err := handler.rewrite()
+		if err != nil {
+			logger.Error("aof rewrite failed: " + err.Error())
+		}
+	}()
+	return nil
 }
 
-// Rewrite carries out AOF rewrite
-func (handler *Handler) Rewrite() {
+func (handler *Handler) rewrite() error {
 	ctx, err := handler.StartRewrite()
 	if err != nil {
-		logger.Warn(err)
-		return
+		return err
 	}
 	err = handler.DoRewrite(ctx)
 	if err != nil {
-		logger.Error(err)
-		return
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
+		return err
 	}
+	return handler.FinishRewrite(ctx)
+}
 
-	handler.FinishRewrite(ctx)
+// NeedRewrite tells whether aof has grown enough to trigger auto rewrite,
+// see auto-aof-rewrite-percentage and auto-aof-rewrite-min-size
+func (handler *Handler) NeedRewrite() bool {
+	percentage := int64(config.Properties().AutoAofRewritePercentage)
+	if percentage <= 0 || handler.IsRewriting() {
+		return false
+	}
+	size := atomic.LoadInt64(&handler.currentSize)
+	if size < int64(config.Properties().AutoAofRewriteMinSize) {
+		return false
+	}
+	base := atomic.LoadInt64(&handler.baseSize)
+	if base == 0 {
+		base = 1
+	}
+	return (size-base)*100/base >= percentage
 }
 
-// DoRewrite actually rewrite aof file
+// DoRewrite loads files to merge and writes the dataset into a new base file
 // makes DoRewrite public for testing only, please use Rewrite instead
 func (handler *Handler) DoRewrite(ctx *RewriteCtx) error {
 	tmpFile := ctx.tmpFile
 
-	// load aof tmpFile
+	// load aof files
 	tmpAof := handler.newRewriteHandler()
-	tmpAof.LoadAof(int(ctx.fileSize))
+	for _, file := range ctx.files {
+		_, err := tmpAof.loadFile(filepath.Join(handler.dir, file.name))
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if ctx.snapshot {
+		err = writeSnapshot(tmpFile, tmpAof.db)
+	} else {
+		err = writeAof(tmpFile, tmpAof.db)
+	}
+	if err != nil {
+		return err
+	}
+	return tmpFile.Sync()
+}
+
+func writeSnapshot(file *os.File, db database.EmbedDB) error {
+	writer := newSnapshotWriter(file)
+	for i := 0; i < config.Properties().Databases; i++ {
+		writer.selectDB(i)
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			writer.writeEntity(key, entity, expiration)
+			return writer.err == nil
+		})
+		for _, cmdLine := range db.GetIndexDefs(i) {
+			writer.writeCommand(cmdLine)
+		}
+	}
+	return writer.finish()
+}
 
-	// rewrite aof tmpFile
-	for i := 0; i < config.Properties.Databases; i++ {
+func writeAof(file *os.File, db database.EmbedDB) error {
+	var err error
+	for i := 0; i < config.Properties().Databases; i++ {
 		// select db
 		data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(i))).ToBytes()
-		_, err := tmpFile.Write(data)
+		_, err = file.Write(data)
 		if err != nil {
 			return err
 		}
 		// dump db
-		tmpAof.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
 			cmd := EntityToCmd(key, entity)
 			if cmd != nil {
-				_, _ = tmpFile.Write(cmd.ToBytes())
+				_, err = file.Write(cmd.ToBytes())
 			}
-			if expiration != nil {
+			if expiration != nil && err == nil {
 				cmd := MakeExpireCmd(key, *expiration)
 				if cmd != nil {
-					_, _ = tmpFile.Write(cmd.ToBytes())
+					_, err = file.Write(cmd.ToBytes())
 				}
 			}
-			return true
+			return err == nil
 		})
+		if err != nil {
+			return err
+		}
+		// indexes are rebuilt from data loaded before
+		for _, cmdLine := range db.GetIndexDefs(i) {
+			_, err = file.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-// StartRewrite prepares rewrite procedure
+// StartRewrite switches appending to a new incremental file,
+// so that existing files stay unchanged while they are being merged
 func (handler *Handler) StartRewrite() (*RewriteCtx, error) {
 	handler.pausingAof.Lock() // pausing aof
 	defer handler.pausingAof.Unlock()
@@ -89,73 +206,102 @@ func (handler *Handler) StartRewrite() (*RewriteCtx, error) {
 		return nil, err
 	}
 
-	// get current aof file size
-	fileInfo, _ := os.Stat(handler.aofFilename)
-	filesize := fileInfo.Size()
+	ctx := &RewriteCtx{
+		files:       handler.manifest.files(),
+		lastIncrSeq: handler.manifest.nextIncrSeq() - 1,
+		baseSeq:     handler.manifest.nextBaseSeq(),
+		snapshot:    config.Properties().AofUseRdbPreamble,
+	}
+
+	// open new incremental file, it must begin with current db of aof
+	incr := &aofFileInfo{
+		name:     getIncrFileName(handler.baseName, ctx.lastIncrSeq+1),
+		seq:      ctx.lastIncrSeq + 1,
+		fileType: incrFileType,
+	}
+	incrFilename := filepath.Join(handler.dir, incr.name)
+	incrFile, err := os.OpenFile(incrFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(handler.currentDB))).ToBytes()
+	_, err = incrFile.Write(data)
+	if err != nil {
+		_ = incrFile.Close()
+		return nil, err
+	}
+	// new incremental file must be recorded before any write, otherwise it would be lost after crash
+	m := handler.manifest.clone()
+	m.incrs = append(m.incrs, incr)
+	err = writeManifest(handler.dir, handler.baseName, m)
+	if err != nil {
+		_ = incrFile.Close()
+		_ = os.Remove(incrFilename)
+		return nil, err
+	}
+	_ = handler.aofFile.Close()
+	handler.aofFile = incrFile
+	handler.aofFilename = incrFilename
+	handler.manifest = m
+	atomic.AddInt64(&handler.currentSize, int64(len(data)))
 
-	// create tmp file
-	file, err := ioutil.TempFile("", "*.aof")
+	// create tmp file in the same dir, so it can be renamed to base file atomically
+	ctx.tmpFile, err = ioutil.TempFile(handler.dir, "temp-rewrite-*.aof")
 	if err != nil {
 		logger.Warn("tmp file create failed")
 		return nil, err
 	}
-	return &RewriteCtx{
-		tmpFile:  file,
-		fileSize: filesize,
-		dbIdx:    handler.currentDB,
-	}, nil
+	return ctx, nil
 }
 
-// FinishRewrite finish rewrite procedure
-func (handler *Handler) FinishRewrite(ctx *RewriteCtx) {
+// FinishRewrite replaces merged files by new base file
+func (handler *Handler) FinishRewrite(ctx *RewriteCtx) error {
 	handler.pausingAof.Lock() // pausing aof
 	defer handler.pausingAof.Unlock()
 
 	tmpFile := ctx.tmpFile
-	// write commands executed during rewriting to tmp file
-	src, err := os.Open(handler.aofFilename)
+	err := tmpFile.Close()
 	if err != nil {
-		logger.Error("open aofFilename failed: " + err.Error())
-		return
+		_ = os.Remove(tmpFile.Name())
+		return err
 	}
-	defer func() {
-		_ = src.Close()
-	}()
-	_, err = src.Seek(ctx.fileSize, 0)
+	base := &aofFileInfo{
+		name:     getBaseFileName(handler.baseName, ctx.baseSeq, ctx.snapshot),
+		seq:      ctx.baseSeq,
+		fileType: baseFileType,
+	}
+	err = os.Rename(tmpFile.Name(), filepath.Join(handler.dir, base.name))
 	if err != nil {
-		logger.Error("seek failed: " + err.Error())
-		return
+		_ = os.Remove(tmpFile.Name())
+		return err
 	}
 
-	// sync tmpFile's db index with online aofFile
-	data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(ctx.dbIdx))).ToBytes()
-	_, err = tmpFile.Write(data)
-	if err != nil {
-		logger.Error("tmp file rewrite failed: " + err.Error())
-		return
+	m := &manifest{
+		base: base,
 	}
-	// copy data
-	_, err = io.Copy(tmpFile, src)
-	if err != nil {
-		logger.Error("copy aof filed failed: " + err.Error())
-		return
+	for _, incr := range handler.manifest.incrs {
+		if incr.seq > ctx.lastIncrSeq {
+			m.incrs = append(m.incrs, incr)
+		}
 	}
-
-	// replace current aof file by tmp file
-	_ = handler.aofFile.Close()
-	_ = os.Rename(tmpFile.Name(), handler.aofFilename)
-
-	// reopen aof file for further write
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	err = writeManifest(handler.dir, handler.baseName, m)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	handler.aofFile = aofFile
+	handler.manifest = m
 
-	// reset selected db 重新写入一次 select 指令保证 aof 中的数据库与 handler.currentDB 一致
-	data = reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(handler.currentDB))).ToBytes()
-	_, err = handler.aofFile.Write(data)
-	if err != nil {
-		panic(err)
+	// merged files are useless now
+	for _, file := range ctx.files {
+		if file.name == base.name {
+			continue
+		}
+		err = os.Remove(filepath.Join(handler.dir, file.name))
+		if err != nil {
+			logger.Warn("remove " + file.name + " failed: " + err.Error())
+		}
 	}
+	size := handler.getAofSize()
+	atomic.StoreInt64(&handler.currentSize, size)
+	atomic.StoreInt64(&handler.baseSize, size)
+	return nil
 }
@@ -0,0 +1,467 @@
+package aof
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"godis/datastruct/bloom"
+	"godis/datastruct/dict"
+	"godis/datastruct/fencedlock"
+	"godis/datastruct/jsontree"
+	List "godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sketch"
+	SortedSet "godis/datastruct/sortedset"
+	"godis/datastruct/timeseries"
+	"godis/interface/database"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// snapshot is a compact binary format of the whole dataset, it may be used as aof base file (like rdb preamble of redis)
+// layout: magic, version, records..., opEOF, crc64 of all previous bytes
+// record: opSelectDB uvarint(index) | [opExpireMs int64(unix ms)] type key value | opCommand uvarint(argc) args...
+// strings are encoded as uvarint(len) + bytes, collections as uvarint(count) + elements,
+// filters, sketches, time series and locks are encoded as strings of their serialized form, JSON documents as compact JSON text,
+// values of custom types as type name and serialized form
+
+const (
+	snapshotMagic   = "GODIS-SNAPSHOT"
+	snapshotVersion = 1
+)
+
+const (
+	typeString byte = iota
+	typeList
+	typeSet
+	typeHash
+	typeZSet
+	typeBloom
+	typeCuckoo
+	typeCountMinSketch
+	typeTopK
+	typeJSON
+	typeTimeSeries
+	typeCustom
+	typeLock
+
+	// opCommand is a command executed as it is, such as index definitions
+	opCommand  byte = 0xFB
+	opExpireMs byte = 0xFC
+	opSelectDB byte = 0xFE
+	opEOF      byte = 0xFF
+)
+
+// same as max length of bulk string in redis protocol, prevents huge allocation caused by broken file
+const maxSnapshotStringLen = 512 << 20
+
+var errSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+type snapshotWriter struct {
+	writer *bufio.Writer
+	crc    hash.Hash64
+	buf    [binary.MaxVarintLen64]byte
+	err    error
+}
+
+func newSnapshotWriter(writer io.Writer) *snapshotWriter {
+	crc := crc64.New(crcTable)
+	w := &snapshotWriter{
+		writer: bufio.NewWriter(io.MultiWriter(writer, crc)),
+		crc:    crc,
+	}
+	w.write([]byte(snapshotMagic))
+	w.writeByte(snapshotVersion)
+	return w
+}
+
+func (w *snapshotWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.writer.Write(data)
+}
+
+func (w *snapshotWriter) writeByte(b byte) {
+	if w.err != nil {
+		return
+	}
+	w.err = w.writer.WriteByte(b)
+}
+
+func (w *snapshotWriter) writeUvarint(n uint64) {
+	size := binary.PutUvarint(w.buf[:], n)
+	w.write(w.buf[:size])
+}
+
+func (w *snapshotWriter) writeString(s []byte) {
+	w.writeUvarint(uint64(len(s)))
+	w.write(s)
+}
+
+func (w *snapshotWriter) selectDB(dbIndex int) {
+	w.writeByte(opSelectDB)
+	w.writeUvarint(uint64(dbIndex))
+}
+
+func (w *snapshotWriter) writeCommand(cmdLine CmdLine) {
+	w.writeByte(opCommand)
+	w.writeUvarint(uint64(len(cmdLine)))
+	for _, arg := range cmdLine {
+		w.writeString(arg)
+	}
+}
+
+func (w *snapshotWriter) writeEntity(key string, entity *database.DataEntity, expiration *time.Time) {
+	if expiration != nil {
+		w.writeByte(opExpireMs)
+		binary.LittleEndian.PutUint64(w.buf[:8], uint64(expiration.UnixNano()/1e6))
+		w.write(w.buf[:8])
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		w.writeByte(typeString)
+		w.writeString([]byte(key))
+		w.writeString(val)
+	case *List.LinkedList:
+		w.writeByte(typeList)
+		w.writeString([]byte(key))
+		w.writeUvarint(uint64(val.Len()))
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			w.writeString(bytes)
+			return true
+		})
+	case *set.Set:
+		w.writeByte(typeSet)
+		w.writeString([]byte(key))
+		w.writeUvarint(uint64(val.Len()))
+		val.ForEach(func(member string) bool {
+			w.writeString([]byte(member))
+			return true
+		})
+	case dict.Dict:
+		w.writeByte(typeHash)
+		w.writeString([]byte(key))
+		w.writeUvarint(uint64(val.Len()))
+		val.ForEach(func(field string, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			w.writeString([]byte(field))
+			w.writeString(bytes)
+			return true
+		})
+	case *SortedSet.SortedSet:
+		w.writeByte(typeZSet)
+		w.writeString([]byte(key))
+		w.writeUvarint(uint64(val.Len()))
+		val.ForEach(0, val.Len(), true, func(element *SortedSet.Element) bool {
+			w.writeString([]byte(element.Member))
+			binary.LittleEndian.PutUint64(w.buf[:8], math.Float64bits(element.Score))
+			w.write(w.buf[:8])
+			return true
+		})
+	case *bloom.ScalableFilter:
+		w.writeByte(typeBloom)
+		w.writeString([]byte(key))
+		w.writeString(val.Marshal())
+	case *bloom.CuckooFilter:
+		w.writeByte(typeCuckoo)
+		w.writeString([]byte(key))
+		w.writeString(val.Marshal())
+	case *sketch.CountMinSketch:
+		w.writeByte(typeCountMinSketch)
+		w.writeString([]byte(key))
+		w.writeString(val.Marshal())
+	case *sketch.TopK:
+		w.writeByte(typeTopK)
+		w.writeString([]byte(key))
+		w.writeString(val.Marshal())
+	case *jsontree.Document:
+		w.writeByte(typeJSON)
+		w.writeString([]byte(key))
+		w.writeString(jsontree.Marshal(val.Root))
+	case *timeseries.Series:
+		w.writeByte(typeTimeSeries)
+		w.writeString([]byte(key))
+		w.writeString(val.Marshal())
+	case *fencedlock.Lock:
+		w.writeByte(typeLock)
+		w.writeString([]byte(key))
+		w.writeString(val.Marshal())
+	case database.CustomValue:
+		w.writeByte(typeCustom)
+		w.writeString([]byte(key))
+		w.writeString([]byte(val.TypeName()))
+		w.writeString(val.Marshal())
+	}
+}
+
+// finish writes tail of snapshot and flushes buffer
+func (w *snapshotWriter) finish() error {
+	w.writeByte(opEOF)
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	// checksum covers all bytes before it
+	binary.LittleEndian.PutUint64(w.buf[:8], w.crc.Sum64())
+	_, err := w.writer.Write(w.buf[:8])
+	if err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+type snapshotReader struct {
+	reader *bufio.Reader
+	crc    hash.Hash64
+}
+
+// ReadByte implements io.ByteReader for binary.ReadUvarint
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	_, _ = r.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (r *snapshotReader) readFull(n uint64) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r.reader, buf)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	_, _ = r.crc.Write(buf)
+	return buf, nil
+}
+
+func (r *snapshotReader) readString() ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotStringLen {
+		return nil, fmt.Errorf("illegal string length %d in snapshot", n)
+	}
+	return r.readFull(n)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// isSnapshot tells whether the content is in snapshot format without consuming it
+func isSnapshot(reader *bufio.Reader) bool {
+	header, _ := reader.Peek(len(snapshotMagic))
+	return string(header) == snapshotMagic
+}
+
+// loadSnapshot decodes snapshot into commands and calls exec
+func loadSnapshot(reader *bufio.Reader, exec func(dbIndex int, cmdLine CmdLine)) error {
+	r := &snapshotReader{
+		reader: reader,
+		crc:    crc64.New(crcTable),
+	}
+	header, err := r.readFull(uint64(len(snapshotMagic) + 1))
+	if err != nil {
+		return err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return errors.New("not a snapshot")
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header[len(snapshotMagic)])
+	}
+	dbIndex := 0
+	var expireAt []byte
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			expected := r.crc.Sum64()
+			sum, err := r.readFull(8)
+			if err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint64(sum) != expected {
+				return errSnapshotChecksum
+			}
+			return nil
+		case opSelectDB:
+			index, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+		case opCommand:
+			cmdLine, err := r.readCommand()
+			if err != nil {
+				return err
+			}
+			exec(dbIndex, cmdLine)
+		case opExpireMs:
+			buf, err := r.readFull(8)
+			if err != nil {
+				return err
+			}
+			expireAt = []byte(strconv.FormatUint(binary.LittleEndian.Uint64(buf), 10))
+		default:
+			cmdLine, err := r.readEntity(op)
+			if err != nil {
+				return err
+			}
+			exec(dbIndex, cmdLine)
+			if expireAt != nil {
+				exec(dbIndex, CmdLine{pExpireAtBytes, cmdLine[1], expireAt})
+				expireAt = nil
+			}
+		}
+	}
+}
+
+func (r *snapshotReader) readCommand() (CmdLine, error) {
+	argc, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if argc == 0 || argc > maxSnapshotStringLen {
+		return nil, fmt.Errorf("illegal command length %d in snapshot", argc)
+	}
+	cmdLine := make(CmdLine, 0, argc)
+	for i := uint64(0); i < argc; i++ {
+		arg, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		cmdLine = append(cmdLine, arg)
+	}
+	return cmdLine, nil
+}
+
+func (r *snapshotReader) readCustomValue() (CmdLine, error) {
+	cmdLine := CmdLine{loadValueCmd}
+	// key, type name and data
+	for i := 0; i < 3; i++ {
+		arg, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		cmdLine = append(cmdLine, arg)
+	}
+	return cmdLine, nil
+}
+
+func (r *snapshotReader) readEntity(valueType byte) (CmdLine, error) {
+	var cmdName []byte
+	// elements read from one collection
+	var elementsPerItem int
+	// argument between key and value of types stored as a single string
+	var valueArg []byte
+	switch valueType {
+	case typeString:
+		cmdName = setCmd
+	case typeList:
+		cmdName = rPushAllCmd
+		elementsPerItem = 1
+	case typeSet:
+		cmdName = sAddCmd
+		elementsPerItem = 1
+	case typeHash:
+		cmdName = hMSetCmd
+		elementsPerItem = 2
+	case typeZSet:
+		cmdName = zAddCmd
+		elementsPerItem = 2
+	case typeBloom:
+		cmdName = bfLoadChunkCmd
+		valueArg = firstChunk
+	case typeCuckoo:
+		cmdName = cfLoadChunkCmd
+		valueArg = firstChunk
+	case typeCountMinSketch:
+		cmdName = cmsLoadChunkCmd
+		valueArg = firstChunk
+	case typeTopK:
+		cmdName = topKLoadChunkCmd
+		valueArg = firstChunk
+	case typeJSON:
+		cmdName = jsonSetCmd
+		valueArg = jsonRootPath
+	case typeTimeSeries:
+		cmdName = tsLoadChunkCmd
+		valueArg = firstChunk
+	case typeLock:
+		cmdName = lockLoadCmd
+	case typeCustom:
+		return r.readCustomValue()
+	default:
+		return nil, fmt.Errorf("unknown value type %d in snapshot", valueType)
+	}
+	key, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+	if valueType == typeString || valueType == typeLock || valueArg != nil {
+		val, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if valueArg != nil {
+			return CmdLine{cmdName, key, valueArg, val}, nil
+		}
+		return CmdLine{cmdName, key, val}, nil
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	capacity := size * uint64(elementsPerItem)
+	if capacity > 1024 {
+		// size may be broken, let append grow the slice
+		capacity = 1024
+	}
+	cmdLine := make(CmdLine, 0, 2+capacity)
+	cmdLine = append(cmdLine, cmdName, key)
+	for i := uint64(0); i < size; i++ {
+		val, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if valueType != typeZSet {
+			cmdLine = append(cmdLine, val)
+			if valueType == typeHash {
+				field, err := r.readString()
+				if err != nil {
+					return nil, err
+				}
+				cmdLine = append(cmdLine, field)
+			}
+			continue
+		}
+		// ZADD key score member
+		buf, err := r.readFull(8)
+		if err != nil {
+			return nil, err
+		}
+		score := math.Float64frombits(binary.LittleEndian.Uint64(buf))
+		cmdLine = append(cmdLine, []byte(strconv.FormatFloat(score, 'f', -1, 64)), val)
+	}
+	return cmdLine, nil
+}
@@ -2,9 +2,8 @@
 package cluster
 
 import (
-	"context"
+	"crypto/tls"
 	"fmt"
-	"github.com/jolestar/go-commons-pool/v2"
 	"godis/config"
 	database2 "godis/database"
 	"godis/datastruct/dict"
@@ -13,10 +12,13 @@ import (
 	"godis/lib/consistenthash"
 	"godis/lib/idgenerator"
 	"godis/lib/logger"
+	"godis/lib/tlsutil"
+	"godis/redis/client"
 	"godis/redis/reply"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Cluster represents a node of godis cluster
@@ -26,10 +28,10 @@ type Cluster struct {
 
 	nodes          []string
 	peerPicker     *consistenthash.Map
-	peerConnection map[string]*pool.ObjectPool
+	peerConnection map[string]*client.Pool
 
 	db           database.EmbedDB
-	transactions *dict.SimpleDict // id -> Transaction
+	transactions *dict.ConcurrentDict // id -> Transaction, accessed by connections and timewheel tasks
 
 	idGenerator *idgenerator.IDGenerator
 }
@@ -37,6 +39,8 @@ type Cluster struct {
 const (
 	replicas = 4
 	lockSize = 64
+	// peers are usually in the same network
+	peerDialTimeout = time.Second
 )
 
 // if only one node involved in a transaction, just execute the command don't apply tcc procedure
@@ -45,30 +49,45 @@ var allowFastTransaction = true
 // MakeCluster creates and starts a node of cluster
 func MakeCluster() *Cluster {
 	cluster := &Cluster{
-		self: config.Properties.Self,
+		self: config.Properties().Self,
 
 		db:             database2.NewStandaloneServer(),
-		transactions:   dict.MakeSimple(),
+		transactions:   dict.MakeConcurrent(16),
 		peerPicker:     consistenthash.New(replicas, nil),
-		peerConnection: make(map[string]*pool.ObjectPool),
+		peerConnection: make(map[string]*client.Pool),
 
-		idGenerator: idgenerator.MakeGenerator(config.Properties.Self),
+		idGenerator: idgenerator.MakeGenerator(config.Properties().Self),
 	}
 	contains := make(map[string]struct{})
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	for _, peer := range config.Properties.Peers {
+	nodes := make([]string, 0, len(config.Properties().Peers)+1)
+	for _, peer := range config.Properties().Peers {
 		if _, ok := contains[peer]; ok {
 			continue
 		}
 		contains[peer] = struct{}{}
 		nodes = append(nodes, peer)
 	}
-	nodes = append(nodes, config.Properties.Self)
+	nodes = append(nodes, config.Properties().Self)
 	cluster.peerPicker.AddNode(nodes...)
-	ctx := context.Background()
-	for _, peer := range config.Properties.Peers {
-		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
-			Peer: peer,
+	var tlsConfig *tls.Config
+	if config.Properties().TLSCluster {
+		// node certificate is used as client certificate too
+		var err error
+		tlsConfig, err = tlsutil.MakeClientConfig(config.Properties().TLSCertFile,
+			config.Properties().TLSKeyFile, config.Properties().TLSCACertFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, peer := range config.Properties().Peers {
+		cluster.peerConnection[peer] = client.NewPool(&client.Options{
+			Addr: peer,
+			// all peers of cluster should use the same password
+			Password:  config.Properties().RequirePass,
+			TLSConfig: tlsConfig,
+			// commands are relayed while client is waiting, unreachable peer should fail fast instead of retrying
+			DialTimeout: peerDialTimeout,
+			MaxRetries:  -1,
 		})
 	}
 	cluster.nodes = nodes
@@ -78,18 +97,24 @@ func MakeCluster() *Cluster {
 // CmdFunc represents the handler of a redis command
 type CmdFunc func(cluster *Cluster, c redis.Connection, cmdAndArgs [][]byte) redis.Reply
 
-// Close stops current node of cluster
+// Close stops current node of cluster.
+// It is invoked after all clients are closed, so no commit will arrive
+// and transactions prepared but not committed are rolled back
 func (cluster *Cluster) Close() {
+	cluster.abortPreparedTransactions()
+	for _, p := range cluster.peerConnection {
+		_ = p.Close()
+	}
 	cluster.db.Close()
 }
 
 var router = makeRouter()
 
 func isAuthenticated(c redis.Connection) bool {
-	if config.Properties.RequirePass == "" {
+	if config.Properties().RequirePass == "" {
 		return true
 	}
-	return c.GetPassword() == config.Properties.RequirePass
+	return c.GetPassword() == config.Properties().RequirePass
 }
 
 // Exec executes command on cluster
@@ -180,7 +205,7 @@ func execSelect(c redis.Connection, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply("ERR invalid DB index")
 	}
-	if dbIndex >= config.Properties.Databases {
+	if dbIndex >= config.Properties().Databases {
 		return reply.MakeErrReply("ERR DB index is out of range")
 	}
 	c.SelectDB(dbIndex)
@@ -2,38 +2,12 @@ package cluster
 
 import (
 	"context"
-	"errors"
 	"godis/interface/redis"
 	"godis/lib/utils"
-	"godis/redis/client"
 	"godis/redis/reply"
 	"strconv"
 )
 
-func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
-	factory, ok := cluster.peerConnection[peer]
-	if !ok {
-		return nil, errors.New("connection factory not found")
-	}
-	raw, err := factory.BorrowObject(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	conn, ok := raw.(*client.Client)
-	if !ok {
-		return nil, errors.New("connection factory make wrong type")
-	}
-	return conn, nil
-}
-
-func (cluster *Cluster) returnPeerClient(peer string, peerClient *client.Client) error {
-	connectionFactory, ok := cluster.peerConnection[peer]
-	if !ok {
-		return errors.New("connection factory not found")
-	}
-	return connectionFactory.ReturnObject(context.Background(), peerClient)
-}
-
 // relay relays command to peer
 // select db by c.GetDBIndex()
 // cannot call Prepare, Commit, execRollback of self node
@@ -42,15 +16,17 @@ func (cluster *Cluster) relay(peer string, c redis.Connection, args [][]byte) re
 		// to self db
 		return cluster.db.Exec(c, args)
 	}
-	peerClient, err := cluster.getPeerClient(peer)
+	peerPool, ok := cluster.peerConnection[peer]
+	if !ok {
+		return reply.MakeErrReply("connection pool not found")
+	}
+	// error reply of relayed command is kept by pipeline and returned as it is
+	results, err := peerPool.Pipeline(context.Background(),
+		utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())), args)
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
-	defer func() {
-		_ = cluster.returnPeerClient(peer, peerClient)
-	}()
-	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
-	return peerClient.Send(args)
+	return results[1]
 }
 
 // broadcast broadcasts command to all node in cluster
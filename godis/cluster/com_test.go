@@ -22,9 +22,9 @@ func TestExec(t *testing.T) {
 
 func TestAuth(t *testing.T) {
 	passwd := utils.RandString(10)
-	config.Properties.RequirePass = passwd
+	_ = config.Set([][2]string{{"requirepass", passwd}})
 	defer func() {
-		config.Properties.RequirePass = ""
+		_ = config.Set([][2]string{{"requirepass", ""}})
 	}()
 	conn := &connection.FakeConn{}
 	ret := testCluster.Exec(conn, toArgs("GET", "a"))
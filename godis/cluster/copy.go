@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strings"
+)
+
+// Copy copies a key, the origin and the destination must within the same node
+func Copy(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'copy' command")
+	}
+	src := string(args[1])
+	dest := string(args[2])
+
+	srcPeer := cluster.peerPicker.PickNode(src)
+	destPeer := cluster.peerPicker.PickNode(dest)
+
+	if srcPeer != destPeer {
+		return reply.MakeErrReply("ERR copy must within one slot in cluster mode")
+	}
+	return cluster.relay(srcPeer, c, args)
+}
+
+// Memory relays MEMORY USAGE to the node holding the key, other subcommands report the local node
+func Memory(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 || strings.ToUpper(string(args[1])) != "USAGE" {
+		return cluster.db.Exec(c, args)
+	}
+	key := string(args[2])
+	peer := cluster.peerPicker.PickNode(key)
+	return cluster.relay(peer, c, args)
+}
+
+// Object relays OBJECT subcommand to the node holding the key
+func Object(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		// subcommands without key, such as OBJECT HELP
+		return cluster.db.Exec(c, args)
+	}
+	key := string(args[2])
+	peer := cluster.peerPicker.PickNode(key)
+	return cluster.relay(peer, c, args)
+}
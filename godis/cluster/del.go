@@ -4,6 +4,7 @@ import (
 	"godis/interface/redis"
 	"godis/redis/reply"
 	"strconv"
+	"strings"
 )
 
 // Del atomically removes given writeKeys from cluster, writeKeys can be distributed on any node
@@ -12,6 +13,7 @@ func Del(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 	if len(args) < 2 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'del' command")
 	}
+	cmdName := strings.ToUpper(string(args[0])) // DEL or UNLINK
 	keys := make([]string, len(args)-1)
 	for i := 1; i < len(args); i++ {
 		keys[i-1] = string(args[i])
@@ -19,7 +21,7 @@ func Del(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 	groupMap := cluster.groupBy(keys)
 	if len(groupMap) == 1 && allowFastTransaction { // do fast
 		for peer, group := range groupMap { // only one peerKeys
-			return cluster.relay(peer, c, makeArgs("DEL", group...))
+			return cluster.relay(peer, c, makeArgs(cmdName, group...))
 		}
 	}
 	// prepare
@@ -28,7 +30,7 @@ func Del(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 	txIDStr := strconv.FormatInt(txID, 10)
 	rollback := false
 	for peer, peerKeys := range groupMap {
-		peerArgs := []string{txIDStr, "DEL"}
+		peerArgs := []string{txIDStr, cmdName}
 		peerArgs = append(peerArgs, peerKeys...)
 		var resp redis.Reply
 		if peer == cluster.self {
@@ -7,6 +7,8 @@ import (
 
 // FlushDB removes all data in current database
 func FlushDB(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	// prepared transactions would write their keys back after flush, so abort them first
+	cluster.abortPreparedTransactions()
 	replies := cluster.broadcast(c, args)
 	var errReply reply.ErrorReply
 	for _, v := range replies {
@@ -25,3 +27,8 @@ func FlushDB(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 func FlushAll(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 	return FlushDB(cluster, c, args)
 }
+
+// SwapDB swaps two databases on every node of cluster
+func SwapDB(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return FlushDB(cluster, c, args)
+}
@@ -138,6 +138,13 @@ func execRelayedMulti(cluster *Cluster, conn redis.Connection, cmdLine CmdLine)
 	return encodeMultiRawReply(resultMBR)
 }
 
+func execUnWatch(cluster *Cluster, conn redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("unwatch")
+	}
+	return database.UnWatch(conn)
+}
+
 func execWatch(cluster *Cluster, conn redis.Connection, args [][]byte) redis.Reply {
 	if len(args) < 2 {
 		return reply.MakeArgNumErrReply("watch")
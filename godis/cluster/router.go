@@ -13,6 +13,7 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["commit"] = execCommit
 	routerMap["rollback"] = execRollback
 	routerMap["del"] = Del
+	routerMap["unlink"] = Del
 
 	routerMap["expire"] = defaultFunc
 	routerMap["expireat"] = defaultFunc
@@ -25,6 +26,12 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["type"] = defaultFunc
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = RenameNx
+	routerMap["copy"] = Copy
+	routerMap["move"] = defaultFunc
+	routerMap["touch"] = defaultFunc
+	routerMap["dump"] = defaultFunc
+	routerMap["restore"] = defaultFunc
+	routerMap["object"] = Object
 
 	routerMap["set"] = defaultFunc
 	routerMap["setnx"] = defaultFunc
@@ -102,6 +109,70 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["geohash"] = defaultFunc
 	routerMap["georadius"] = defaultFunc
 	routerMap["georadiusbymember"] = defaultFunc
+	routerMap["geosearch"] = defaultFunc
+	routerMap["geosearchstore"] = defaultFunc
+
+	routerMap["bf.reserve"] = defaultFunc
+	routerMap["bf.add"] = defaultFunc
+	routerMap["bf.madd"] = defaultFunc
+	routerMap["bf.exists"] = defaultFunc
+	routerMap["bf.mexists"] = defaultFunc
+	routerMap["bf.info"] = defaultFunc
+	routerMap["bf.scandump"] = defaultFunc
+	routerMap["bf.loadchunk"] = defaultFunc
+	routerMap["cf.reserve"] = defaultFunc
+	routerMap["cf.add"] = defaultFunc
+	routerMap["cf.addnx"] = defaultFunc
+	routerMap["cf.exists"] = defaultFunc
+	routerMap["cf.mexists"] = defaultFunc
+	routerMap["cf.count"] = defaultFunc
+	routerMap["cf.del"] = defaultFunc
+	routerMap["cf.info"] = defaultFunc
+	routerMap["cf.scandump"] = defaultFunc
+	routerMap["cf.loadchunk"] = defaultFunc
+	routerMap["cms.initbydim"] = defaultFunc
+	routerMap["cms.initbyprob"] = defaultFunc
+	routerMap["cms.incrby"] = defaultFunc
+	routerMap["cms.query"] = defaultFunc
+	routerMap["cms.merge"] = defaultFunc
+	routerMap["cms.info"] = defaultFunc
+	routerMap["cms.loadchunk"] = defaultFunc
+	routerMap["topk.reserve"] = defaultFunc
+	routerMap["topk.add"] = defaultFunc
+	routerMap["topk.incrby"] = defaultFunc
+	routerMap["topk.query"] = defaultFunc
+	routerMap["topk.list"] = defaultFunc
+	routerMap["topk.info"] = defaultFunc
+	routerMap["topk.loadchunk"] = defaultFunc
+
+	routerMap["json.set"] = defaultFunc
+	routerMap["json.get"] = defaultFunc
+	routerMap["json.mget"] = defaultFunc
+	routerMap["json.del"] = defaultFunc
+	routerMap["json.forget"] = defaultFunc
+	routerMap["json.type"] = defaultFunc
+	routerMap["json.arrappend"] = defaultFunc
+	routerMap["json.arrlen"] = defaultFunc
+	routerMap["json.numincrby"] = defaultFunc
+	routerMap["json.strappend"] = defaultFunc
+	routerMap["json.objkeys"] = defaultFunc
+
+	routerMap["ts.create"] = defaultFunc
+	routerMap["ts.add"] = defaultFunc
+	routerMap["ts.madd"] = defaultFunc
+	routerMap["ts.range"] = defaultFunc
+	routerMap["ts.revrange"] = defaultFunc
+	routerMap["ts.get"] = defaultFunc
+	routerMap["ts.createrule"] = defaultFunc
+	routerMap["ts.deleterule"] = defaultFunc
+	routerMap["ts.info"] = defaultFunc
+	routerMap["ts.loadchunk"] = defaultFunc
+
+	routerMap["cl.throttle"] = defaultFunc
+	routerMap["lock.acquire"] = defaultFunc
+	routerMap["lock.renew"] = defaultFunc
+	routerMap["lock.release"] = defaultFunc
+	routerMap["lock.info"] = defaultFunc
 
 	routerMap["publish"] = Publish
 	routerMap[relayPublish] = onRelayedPublish
@@ -110,9 +181,19 @@ func makeRouter() map[string]CmdFunc {
 
 	routerMap["flushdb"] = FlushDB
 	routerMap["flushall"] = FlushAll
+	routerMap["swapdb"] = SwapDB
 	routerMap[relayMulti] = execRelayedMulti
 	routerMap["getver"] = defaultFunc
 	routerMap["watch"] = execWatch
+	routerMap["unwatch"] = execUnWatch
+
+	routerMap["config"] = execLocal
+	routerMap["slowlog"] = execLocal
+	routerMap["command"] = execLocal
+	routerMap["memory"] = Memory
+	routerMap["shutdown"] = execLocal
+	// keys relayed to other nodes are not tracked, cached values of them won't be invalidated
+	routerMap["client"] = execLocal
 
 	return routerMap
 }
@@ -123,3 +204,8 @@ func defaultFunc(cluster *Cluster, c redis.Connection, args [][]byte) redis.Repl
 	peer := cluster.peerPicker.PickNode(key)
 	return cluster.relay(peer, c, args)
 }
+
+// execLocal executes command on current node, such as commands managing the server
+func execLocal(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.db.Exec(c, args)
+}
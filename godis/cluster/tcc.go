@@ -86,9 +86,9 @@ func (tx *Transaction) prepare() error {
 	tx.status = preparedStatus
 	taskKey := genTaskKey(tx.id)
 	timewheel.Delay(maxLockTime, taskKey, func() {
-		if tx.status == preparedStatus { // rollback transaction uncommitted until expire
+		// rollback transaction uncommitted until expire
+		if tx.abortIfPrepared() {
 			logger.Info("abort transaction: " + tx.id)
-			_ = tx.rollback()
 		}
 	})
 	return nil
@@ -105,13 +105,44 @@ func (tx *Transaction) rollback() error {
 	if tx.status == rolledBackStatus { // no need to rollback a rolled-back transaction
 		return nil
 	}
+	tx.undo()
+	return nil
+}
+
+// undo writes back the undo log, invoker should hold tx.mu
+func (tx *Transaction) undo() {
 	tx.lockKeys()
 	for _, cmdLine := range tx.undoLog {
 		tx.cluster.db.ExecWithLock(tx.conn, cmdLine)
 	}
 	tx.unLockKeys()
 	tx.status = rolledBackStatus
-	return nil
+}
+
+// abortIfPrepared rolls back the transaction if it is still waiting for commit,
+// status is checked under tx.mu so that a committed transaction is never undone
+func (tx *Transaction) abortIfPrepared() bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return false
+	}
+	tx.undo()
+	return true
+}
+
+// abortPreparedTransactions rolls back transactions waiting for commit from coordinator
+func (cluster *Cluster) abortPreparedTransactions() {
+	var txs []*Transaction
+	cluster.transactions.ForEach(func(key string, val interface{}) bool {
+		txs = append(txs, val.(*Transaction))
+		return true
+	})
+	for _, tx := range txs {
+		if tx.abortIfPrepared() {
+			logger.Info("abort transaction: " + tx.id)
+		}
+	}
 }
 
 // cmdLine: Prepare id cmdName args...
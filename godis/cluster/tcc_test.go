@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestRollback(t *testing.T) {
@@ -45,3 +46,90 @@ func TestRollback(t *testing.T) {
 	ret = testCluster.Exec(conn, toArgs("GET", "a"))
 	asserts.AssertBulkReply(t, ret, "a")
 }
+
+func TestAbortPreparedTransactions(t *testing.T) {
+	conn := new(connection.FakeConn)
+	FlushAll(testCluster, conn, toArgs("FLUSHALL"))
+	txID := rand.Int63()
+	txIDStr := strconv.FormatInt(txID, 10)
+	testCluster.Exec(conn, toArgs("SET", "a", "a"))
+	ret := execPrepare(testCluster, conn, makeArgs("Prepare", txIDStr, "DEL", "a"))
+	asserts.AssertNotError(t, ret)
+	testCluster.abortPreparedTransactions()
+	raw, ok := testCluster.transactions.Get(txIDStr)
+	if !ok {
+		t.Error("transaction not found")
+		return
+	}
+	if raw.(*Transaction).status != rolledBackStatus {
+		t.Error("prepared transaction should be rolled back")
+	}
+	// keys locked by transaction are released
+	ret = testCluster.Exec(conn, toArgs("SET", "a", "b"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testCluster.Exec(conn, toArgs("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "b")
+}
+
+func TestFlushDBAbortsPreparedTransactions(t *testing.T) {
+	conn := new(connection.FakeConn)
+	FlushAll(testCluster, conn, toArgs("FLUSHALL"))
+	txID := rand.Int63()
+	txIDStr := strconv.FormatInt(txID, 10)
+	testCluster.Exec(conn, toArgs("SET", "a", "a"))
+	ret := execPrepare(testCluster, conn, makeArgs("Prepare", txIDStr, "DEL", "a"))
+	asserts.AssertNotError(t, ret)
+
+	done := make(chan struct{})
+	go func() {
+		FlushDB(testCluster, conn, toArgs("FLUSHDB"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(maxLockTime / 2):
+		t.Error("flushdb should not wait for prepared transaction")
+		<-done
+	}
+	raw, _ := testCluster.transactions.Get(txIDStr)
+	if raw.(*Transaction).status != rolledBackStatus {
+		t.Error("prepared transaction should be rolled back")
+	}
+	// undo log of transaction is not written back after flush
+	ret = testCluster.Exec(conn, toArgs("GET", "a"))
+	asserts.AssertNullBulk(t, ret)
+	ret = testCluster.Exec(conn, toArgs("SET", "a", "b"))
+	asserts.AssertStatusReply(t, ret, "OK")
+}
+
+func TestAbortCommittingTransaction(t *testing.T) {
+	conn := new(connection.FakeConn)
+	FlushAll(testCluster, conn, toArgs("FLUSHALL"))
+	txID := rand.Int63()
+	txIDStr := strconv.FormatInt(txID, 10)
+	testCluster.Exec(conn, toArgs("SET", "a", "a"))
+	ret := execPrepare(testCluster, conn, makeArgs("Prepare", txIDStr, "DEL", "a"))
+	asserts.AssertNotError(t, ret)
+	raw, _ := testCluster.transactions.Get(txIDStr)
+	tx := raw.(*Transaction)
+
+	// abort lands while commit is holding the transaction
+	tx.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		testCluster.abortPreparedTransactions()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	testCluster.db.ExecWithLock(conn, tx.cmdLine)
+	tx.unLockKeys()
+	tx.status = committedStatus
+	tx.mu.Unlock()
+	<-done
+
+	if tx.status != committedStatus {
+		t.Error("committed transaction should not be rolled back")
+	}
+	ret = testCluster.Exec(conn, toArgs("GET", "a"))
+	asserts.AssertNullBulk(t, ret)
+}
@@ -8,11 +8,10 @@ import (
 var testCluster = MakeTestCluster(nil)
 
 func MakeTestCluster(peers []string) *Cluster {
-	if config.Properties == nil {
-		config.Properties = &config.ServerProperties{}
-	}
-	config.Properties.Self = "127.0.0.1:6399"
-	config.Properties.Peers = peers
+	props := *config.Properties()
+	props.Self = "127.0.0.1:6399"
+	props.Peers = peers
+	config.SetProperties(&props)
 	return MakeCluster()
 }
 
@@ -0,0 +1,152 @@
+// godis-analyze-aof loads an aof file offline and reports the biggest keys and memory usage grouped by key prefix.
+// For multi part aof, give the manifest file to load the base file and incremental files in order.
+// Sizes are estimated in the same way as MEMORY USAGE
+package main
+
+import (
+	"flag"
+	"fmt"
+	"godis/aof"
+	"godis/config"
+	"godis/database"
+	idatabase "godis/interface/database"
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	top       = flag.Int("top", 20, "number of biggest keys and prefixes to report")
+	delimiter = flag.String("delimiter", ":", "delimiter of key segments")
+	depth     = flag.Int("depth", 1, "number of key segments used as prefix")
+	samples   = flag.Int("samples", 0, "number of elements sampled from collections, 0 means all elements")
+	databases = flag.Int("databases", 16, "number of databases")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <file.aof | file.aof.manifest>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+type keyInfo struct {
+	dbIndex int
+	key     string
+	typ     string
+	size    int64
+}
+
+type prefixInfo struct {
+	prefix string
+	keys   int
+	size   int64
+}
+
+// getPrefix returns the first depth segments of key, keys without delimiter are grouped by themselves
+func getPrefix(key string) string {
+	segments := strings.SplitN(key, *delimiter, *depth+1)
+	if len(segments) <= *depth {
+		return key
+	}
+	return strings.Join(segments[:*depth], *delimiter) + *delimiter + "*"
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 || *top <= 0 || *depth <= 0 || *databases <= 0 {
+		usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	if _, err := os.Stat(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	props := *config.Properties()
+	props.Databases = *databases
+	config.SetProperties(&props)
+	db := database.MakeBasicMultiDB()
+	if err := aof.LoadFile(db, filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var keys []*keyInfo
+	prefixes := make(map[string]*prefixInfo)
+	var total int64
+	conn := &connection.FakeConn{}
+	for i := 0; i < *databases; i++ {
+		conn.SelectDB(i)
+		db.ForEach(i, func(key string, entity *idatabase.DataEntity, expiration *time.Time) bool {
+			if expiration != nil && expiration.Before(time.Now()) {
+				return true
+			}
+			info := &keyInfo{
+				dbIndex: i,
+				key:     key,
+				size:    database.EstimateKeySize(key, entity, expiration, *samples),
+			}
+			if typ, ok := db.Exec(conn, utils.ToCmdLine("TYPE", key)).(*reply.StatusReply); ok {
+				info.typ = typ.Status
+			}
+			keys = append(keys, info)
+			prefix := getPrefix(key)
+			p, ok := prefixes[prefix]
+			if !ok {
+				p = &prefixInfo{prefix: prefix}
+				prefixes[prefix] = p
+			}
+			p.keys++
+			p.size += info.size
+			total += info.size
+			return true
+		})
+	}
+	fmt.Printf("Analyzed %d keys, estimated %d bytes in total\n", len(keys), total)
+	if len(keys) == 0 {
+		return
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].size != keys[j].size {
+			return keys[i].size > keys[j].size
+		}
+		return keys[i].key < keys[j].key
+	})
+	if len(keys) > *top {
+		keys = keys[:*top]
+	}
+	fmt.Println("\nBiggest keys:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DB\tTYPE\tBYTES\tKEY")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%q\n", k.dbIndex, k.typ, k.size, k.key)
+	}
+	_ = w.Flush()
+
+	sorted := make([]*prefixInfo, 0, len(prefixes))
+	for _, p := range prefixes {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].size != sorted[j].size {
+			return sorted[i].size > sorted[j].size
+		}
+		return sorted[i].prefix < sorted[j].prefix
+	})
+	if len(sorted) > *top {
+		sorted = sorted[:*top]
+	}
+	fmt.Println("\nBiggest prefixes:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tKEYS\tBYTES\tPERCENT")
+	for _, p := range sorted {
+		fmt.Fprintf(w, "%q\t%d\t%d\t%.2f%%\n", p.prefix, p.keys, p.size, float64(p.size)*100/float64(total))
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type benchConfig struct {
+	clients  int
+	requests int
+	pipeline int
+	keyspace int
+	value    []byte
+}
+
+// randKey returns a random key in keyspace, or a constant key if keyspace is not set like redis-benchmark does
+func (cfg *benchConfig) randKey(r *rand.Rand, prefix string) []byte {
+	if cfg.keyspace <= 0 {
+		return []byte(prefix + "__rand_int__")
+	}
+	return []byte(fmt.Sprintf("%s%012d", prefix, r.Intn(cfg.keyspace)))
+}
+
+// workload generates commands of a test
+type workload struct {
+	name  string
+	title string
+	cmd   func(cfg *benchConfig, r *rand.Rand) [][]byte
+	// setup commands are executed once before test
+	setup func(cfg *benchConfig) [][][]byte
+}
+
+func bytesLine(args ...string) [][]byte {
+	line := make([][]byte, len(args))
+	for i, arg := range args {
+		line[i] = []byte(arg)
+	}
+	return line
+}
+
+var workloads = []*workload{
+	{name: "ping", title: "PING", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return bytesLine("PING")
+	}},
+	{name: "set", title: "SET", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("SET"), cfg.randKey(r, "key:"), cfg.value}
+	}},
+	{name: "get", title: "GET", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("GET"), cfg.randKey(r, "key:")}
+	}},
+	{name: "incr", title: "INCR", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("INCR"), cfg.randKey(r, "counter:")}
+	}},
+	{name: "lpush", title: "LPUSH", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("LPUSH"), []byte("mylist"), cfg.value}
+	}},
+	{name: "rpush", title: "RPUSH", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("RPUSH"), []byte("mylist"), cfg.value}
+	}},
+	{name: "lpop", title: "LPOP", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return bytesLine("LPOP", "mylist")
+	}},
+	{name: "rpop", title: "RPOP", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return bytesLine("RPOP", "mylist")
+	}},
+	{name: "sadd", title: "SADD", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("SADD"), []byte("myset"), cfg.randKey(r, "element:")}
+	}},
+	{name: "hset", title: "HSET", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return [][]byte{[]byte("HSET"), []byte("myhash"), cfg.randKey(r, "element:"), cfg.value}
+	}},
+	{name: "zadd", title: "ZADD", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		score := strconv.Itoa(r.Intn(1000000))
+		return [][]byte{[]byte("ZADD"), []byte("myzset"), []byte(score), cfg.randKey(r, "element:")}
+	}},
+	{name: "lrange_100", title: "LRANGE_100 (first 100 elements)", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		return bytesLine("LRANGE", "mylist", "0", "99")
+	}, setup: func(cfg *benchConfig) [][][]byte {
+		cmdLines := make([][][]byte, 100)
+		for i := range cmdLines {
+			cmdLines[i] = [][]byte{[]byte("LPUSH"), []byte("mylist"), cfg.value}
+		}
+		return cmdLines
+	}},
+	{name: "mset", title: "MSET (10 keys)", cmd: func(cfg *benchConfig, r *rand.Rand) [][]byte {
+		line := make([][]byte, 0, 21)
+		line = append(line, []byte("MSET"))
+		for i := 0; i < 10; i++ {
+			line = append(line, cfg.randKey(r, "key:"), cfg.value)
+		}
+		return line
+	}},
+}
+
+var defaultTests = []string{"ping", "set", "get", "incr", "lpush", "rpush", "lpop", "rpop", "sadd", "hset", "zadd", "lrange_100", "mset"}
+
+func findWorkload(name string) *workload {
+	for _, w := range workloads {
+		if w.name == name {
+			return w
+		}
+	}
+	return nil
+}
+
+type benchResult struct {
+	completed  int
+	elapsed    time.Duration
+	errors     int
+	firstError string
+	// latencies of all requests in ascending order, requests in a pipeline have the same latency
+	latencies []time.Duration
+}
+
+func (result *benchResult) min() time.Duration {
+	if len(result.latencies) == 0 {
+		return 0
+	}
+	return result.latencies[0]
+}
+
+func (result *benchResult) max() time.Duration {
+	if len(result.latencies) == 0 {
+		return 0
+	}
+	return result.latencies[len(result.latencies)-1]
+}
+
+func (result *benchResult) avg() time.Duration {
+	if len(result.latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range result.latencies {
+		total += d
+	}
+	return total / time.Duration(len(result.latencies))
+}
+
+// percentile returns the latency which p percent of requests are not slower than
+func (result *benchResult) percentile(p float64) time.Duration {
+	n := len(result.latencies)
+	if n == 0 {
+		return 0
+	}
+	index := int(p/100*float64(n)+0.5) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= n {
+		index = n - 1
+	}
+	return result.latencies[index]
+}
+
+// worker is a client sending commands of a test
+type worker struct {
+	latencies  []time.Duration
+	errors     int
+	firstError string
+	err        error
+}
+
+// run executes requests of w by cfg.clients goroutines, each goroutine takes at most cfg.pipeline requests at a time
+func run(target pipeliner, cfg *benchConfig, w *workload) (*benchResult, error) {
+	ctx := context.Background()
+	if w.setup != nil {
+		if _, err := target.Pipeline(ctx, w.setup(cfg)...); err != nil {
+			return nil, err
+		}
+	}
+	var issued int64
+	var failed int32
+	workers := make([]*worker, cfg.clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		wk := &worker{
+			latencies: make([]time.Duration, 0, cfg.requests/cfg.clients+cfg.pipeline),
+		}
+		workers[i] = wk
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			cmdLines := make([][][]byte, 0, cfg.pipeline)
+			for atomic.LoadInt32(&failed) == 0 {
+				end := atomic.AddInt64(&issued, int64(cfg.pipeline))
+				begin := end - int64(cfg.pipeline)
+				if begin >= int64(cfg.requests) {
+					return
+				}
+				if end > int64(cfg.requests) {
+					end = int64(cfg.requests)
+				}
+				cmdLines = cmdLines[:0]
+				for j := begin; j < end; j++ {
+					cmdLines = append(cmdLines, w.cmd(cfg, r))
+				}
+				sent := time.Now()
+				replies, err := target.Pipeline(ctx, cmdLines...)
+				if err != nil {
+					wk.err = err
+					atomic.StoreInt32(&failed, 1)
+					return
+				}
+				d := time.Since(sent)
+				for _, result := range replies {
+					wk.latencies = append(wk.latencies, d)
+					if msg, ok := isError(result); ok {
+						if wk.errors == 0 {
+							wk.firstError = msg
+						}
+						wk.errors++
+					}
+				}
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+	result := &benchResult{
+		elapsed: time.Since(start),
+	}
+	for _, wk := range workers {
+		if wk.err != nil {
+			return nil, wk.err
+		}
+		result.latencies = append(result.latencies, wk.latencies...)
+		if wk.errors > 0 && result.errors == 0 {
+			result.firstError = wk.firstError
+		}
+		result.errors += wk.errors
+	}
+	result.completed = len(result.latencies)
+	if result.completed == 0 {
+		return nil, errors.New("no request completed")
+	}
+	sort.Slice(result.latencies, func(i, j int) bool {
+		return result.latencies[i] < result.latencies[j]
+	})
+	return result, nil
+}
@@ -0,0 +1,170 @@
+// godis-benchmark measures throughput and latency of godis (or redis) like redis-benchmark does.
+// It runs workloads by N concurrent clients, and sends commands to the node owning the key if cluster nodes are given
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"godis/interface/redis"
+	"godis/lib/tlsutil"
+	"godis/redis/client"
+	"godis/redis/reply"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	host      = flag.String("h", "127.0.0.1", "server hostname")
+	port      = flag.Int("p", 6399, "server port")
+	password  = flag.String("a", "", "password for godis auth")
+	dbIndex   = flag.Int("dbnum", 0, "select the specified db number")
+	clusterTo = flag.String("cluster", "", "comma separated addresses of cluster nodes, commands are sent to the node owning the key")
+	useTLS    = flag.Bool("tls", false, "establish a secure TLS connection")
+	caCert    = flag.String("cacert", "", "CA certificate file to verify server with")
+	cert      = flag.String("cert", "", "client certificate to authenticate with")
+	key       = flag.String("key", "", "private key file to authenticate with")
+
+	clients  = flag.Int("c", 50, "number of parallel connections")
+	requests = flag.Int("n", 100000, "total number of requests of each test")
+	dataSize = flag.Int("d", 3, "data size of SET/GET value in bytes")
+	pipeline = flag.Int("P", 1, "pipeline <numreq> requests")
+	keyspace = flag.Int("r", 0, "use random keys in range [0, keyspace) instead of a constant key")
+	tests    = flag.String("t", strings.Join(defaultTests, ","), "comma separated list of tests to run")
+	quiet    = flag.Bool("q", false, "quiet, just show query/sec values")
+	csv      = flag.Bool("csv", false, "output in CSV format")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-h <host>] [-p <port>] [-c <clients>] [-n <requests>] [-t <tests>] ...\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// pipeliner is implemented by client.Pool and client.ClusterClient
+type pipeliner interface {
+	Pipeline(ctx context.Context, cmdLines ...[][]byte) ([]redis.Reply, error)
+	Close() error
+}
+
+func makeOptions() (*client.Options, error) {
+	opts := &client.Options{
+		Addr:     net.JoinHostPort(*host, strconv.Itoa(*port)),
+		Password: *password,
+		DB:       *dbIndex,
+		PoolSize: *clients,
+	}
+	if *useTLS {
+		tlsConfig, err := tlsutil.MakeClientConfig(*cert, *key, *caCert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = *host
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+func makeTarget(opts *client.Options) pipeliner {
+	if *clusterTo == "" {
+		return client.NewPool(opts)
+	}
+	addrs := strings.Split(*clusterTo, ",")
+	for i := range addrs {
+		addrs[i] = strings.TrimSpace(addrs[i])
+	}
+	return client.NewClusterClient(addrs, opts)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *clients <= 0 || *requests <= 0 || *pipeline <= 0 || *dataSize < 0 {
+		usage()
+		os.Exit(1)
+	}
+	opts, err := makeOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	target := makeTarget(opts)
+	defer target.Close()
+	// fail fast if server is unreachable
+	if _, err := target.Pipeline(context.Background(), [][]byte{[]byte("PING")}); err != nil {
+		fmt.Fprintln(os.Stderr, "could not connect to godis: "+err.Error())
+		os.Exit(1)
+	}
+
+	cfg := &benchConfig{
+		clients:  *clients,
+		requests: *requests,
+		pipeline: *pipeline,
+		keyspace: *keyspace,
+		value:    []byte(strings.Repeat("x", *dataSize)),
+	}
+	if *csv {
+		fmt.Println(`"test","rps","avg_latency_ms","min_latency_ms","p50_latency_ms","p95_latency_ms","p99_latency_ms","max_latency_ms"`)
+	}
+	for _, name := range strings.Split(*tests, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		w := findWorkload(name)
+		if w == nil {
+			fmt.Fprintln(os.Stderr, "unknown test: "+name)
+			os.Exit(1)
+		}
+		result, err := run(target, cfg, w)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", w.title, err)
+			os.Exit(1)
+		}
+		report(cfg, w, result)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func report(cfg *benchConfig, w *workload, result *benchResult) {
+	rps := float64(result.completed) / result.elapsed.Seconds()
+	p50 := result.percentile(50)
+	switch {
+	case *csv:
+		fmt.Printf("\"%s\",\"%.2f\",\"%.3f\",\"%.3f\",\"%.3f\",\"%.3f\",\"%.3f\",\"%.3f\"\n",
+			w.title, rps, ms(result.avg()), ms(result.min()), ms(p50),
+			ms(result.percentile(95)), ms(result.percentile(99)), ms(result.max()))
+	case *quiet:
+		fmt.Printf("%s: %.2f requests per second, p50=%.3f msec\n", w.title, rps, ms(p50))
+	default:
+		fmt.Printf("====== %s ======\n", w.title)
+		fmt.Printf("  %d requests completed in %.2f seconds\n", result.completed, result.elapsed.Seconds())
+		fmt.Printf("  %d parallel clients\n", cfg.clients)
+		fmt.Printf("  %d bytes payload\n", len(cfg.value))
+		fmt.Printf("  pipeline depth: %d\n", cfg.pipeline)
+		if result.errors > 0 {
+			fmt.Printf("  %d error replies, the first is: %s\n", result.errors, result.firstError)
+		}
+		fmt.Println()
+		fmt.Println("Summary:")
+		fmt.Printf("  throughput summary: %.2f requests per second\n", rps)
+		fmt.Println("  latency summary (msec):")
+		fmt.Printf("  %9s %9s %9s %9s %9s %9s\n", "avg", "min", "p50", "p95", "p99", "max")
+		fmt.Printf("  %9.3f %9.3f %9.3f %9.3f %9.3f %9.3f\n\n",
+			ms(result.avg()), ms(result.min()), ms(p50),
+			ms(result.percentile(95)), ms(result.percentile(99)), ms(result.max()))
+	}
+}
+
+// isError tells whether r is an error reply
+func isError(r redis.Reply) (string, bool) {
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		return errReply.Error(), true
+	}
+	return "", false
+}
@@ -0,0 +1,62 @@
+// godis-check-aof validates an aof file and repairs it by trimming the broken tail.
+// For multi part aof, check the base file and incremental files listed in manifest one by one
+package main
+
+import (
+	"flag"
+	"fmt"
+	"godis/aof"
+	"os"
+)
+
+var fix = flag.Bool("fix", false, "trim aof file to its last complete command")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [--fix] <file.aof>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	info, err := os.Stat(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	result, err := aof.CheckFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	size := info.Size()
+	fmt.Printf("AOF analyzed: size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+		size, result.ValidSize, result.Commands, size-result.ValidSize)
+	if result.Err == nil {
+		fmt.Println("AOF is valid")
+		return
+	}
+	fmt.Println(result.Err)
+	if result.Snapshot {
+		fmt.Println("snapshot base file cannot be repaired")
+		os.Exit(1)
+	}
+	if _, isFormatErr := result.Err.(*aof.FormatError); !isFormatErr && result.Err != aof.ErrTruncated {
+		os.Exit(1)
+	}
+	if !*fix {
+		fmt.Println("AOF is not valid, use the --fix option to try fixing it")
+		os.Exit(1)
+	}
+	err = os.Truncate(filename, result.ValidSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to truncate AOF: "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Successfully truncated AOF")
+}
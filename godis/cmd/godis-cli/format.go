@@ -0,0 +1,138 @@
+package main
+
+import (
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// formatReply formats reply like redis-cli does, raw format is easier for scripts to handle
+func formatReply(r redis.Reply, raw bool) string {
+	if raw {
+		return formatRaw(r)
+	}
+	return formatTTY(r, "")
+}
+
+// quote returns printable representation of s in double quotes
+func quote(s []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if c < 0x20 || c >= 0x7f {
+				sb.WriteString("\\x")
+				sb.WriteString(strconv.FormatInt(int64(c>>4), 16))
+				sb.WriteString(strconv.FormatInt(int64(c&0xf), 16))
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// elements returns elements of multi bulk reply, nil if r is not an array
+func elements(r redis.Reply) []redis.Reply {
+	switch r := r.(type) {
+	case *reply.MultiBulkReply:
+		result := make([]redis.Reply, len(r.Args))
+		for i, arg := range r.Args {
+			if arg == nil {
+				result[i] = reply.MakeNullBulkReply()
+			} else {
+				result[i] = reply.MakeBulkReply(arg)
+			}
+		}
+		return result
+	case *reply.MultiRawReply:
+		return r.Replies
+	case *reply.EmptyMultiBulkReply:
+		return []redis.Reply{}
+	}
+	return nil
+}
+
+// formatTTY formats reply for human, lines of nested array are indented by indent
+func formatTTY(r redis.Reply, indent string) string {
+	switch r := r.(type) {
+	case *reply.BulkReply:
+		return quote(r.Arg)
+	case *reply.NullBulkReply:
+		return "(nil)"
+	case *reply.IntReply:
+		return "(integer) " + strconv.FormatInt(r.Code, 10)
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.OkReply:
+		return "OK"
+	case *reply.PongReply:
+		return "PONG"
+	case reply.ErrorReply:
+		return "(error) " + r.Error()
+	}
+	list := elements(r)
+	if list == nil {
+		return strings.TrimRight(string(r.ToBytes()), "\r\n")
+	}
+	if len(list) == 0 {
+		return "(empty array)"
+	}
+	width := len(strconv.Itoa(len(list)))
+	var sb strings.Builder
+	for i, element := range list {
+		index := strconv.Itoa(i + 1)
+		prefix := strings.Repeat(" ", width-len(index)) + index + ") "
+		if i > 0 {
+			sb.WriteString("\n" + indent)
+		}
+		sb.WriteString(prefix)
+		sb.WriteString(formatTTY(element, indent+strings.Repeat(" ", len(prefix))))
+	}
+	return sb.String()
+}
+
+// formatRaw prints values without quoting and type hints, elements of array are printed line by line
+func formatRaw(r redis.Reply) string {
+	switch r := r.(type) {
+	case *reply.BulkReply:
+		return string(r.Arg)
+	case *reply.NullBulkReply:
+		return ""
+	case *reply.IntReply:
+		return strconv.FormatInt(r.Code, 10)
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.OkReply:
+		return "OK"
+	case *reply.PongReply:
+		return "PONG"
+	case reply.ErrorReply:
+		return r.Error()
+	}
+	list := elements(r)
+	if list == nil {
+		return strings.TrimRight(string(r.ToBytes()), "\r\n")
+	}
+	lines := make([]string, len(list))
+	for i, element := range list {
+		lines[i] = formatRaw(element)
+	}
+	return strings.Join(lines, "\n")
+}
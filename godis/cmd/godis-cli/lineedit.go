@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when user presses Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads lines from terminal with history and completion of command names.
+// If stdin is not a terminal, lines are read as is
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool
+
+	history     []string
+	historyFile string
+	commands    []string
+}
+
+func newLineEditor(commands []string, historyFile string) *lineEditor {
+	e := &lineEditor{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		terminal:    isTerminal(int(os.Stdin.Fd())) && isTerminal(int(os.Stdout.Fd())),
+		historyFile: historyFile,
+		commands:    commands,
+	}
+	e.loadHistory()
+	return e
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// addHistory appends line to history file, password in AUTH should not be saved
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	if fields := strings.Fields(line); len(fields) > 0 && strings.EqualFold(fields[0], "auth") {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(e.historyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, _ = file.WriteString(strings.Join(e.history, "\n") + "\n")
+}
+
+// readLine returns io.EOF on Ctrl-D of an empty line or end of stdin, and errInterrupted on Ctrl-C
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !e.terminal {
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	state := &editState{
+		editor:     e,
+		prompt:     prompt,
+		historyPos: len(e.history),
+	}
+	state.refresh()
+	return state.loop()
+}
+
+// editState is the line being edited
+type editState struct {
+	editor *lineEditor
+	prompt string
+	buf    []rune
+	pos    int // cursor position in buf
+	// historyPos is index of the history shown, len(history) means the new line
+	historyPos int
+	// saved is the new line before browsing history
+	saved []rune
+}
+
+func (s *editState) loop() (string, error) {
+	for {
+		r, _, err := s.editor.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(s.editor.out, "\n")
+			return string(s.buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(s.editor.out, "^C\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(s.buf) == 0 {
+				fmt.Fprint(s.editor.out, "\n")
+				return "", io.EOF
+			}
+			s.delete()
+		case 127, 8: // Backspace
+			if s.pos > 0 {
+				s.pos--
+				s.delete()
+			}
+		case '\t':
+			s.complete()
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 5: // Ctrl-E
+			s.pos = len(s.buf)
+		case 2: // Ctrl-B
+			s.left()
+		case 6: // Ctrl-F
+			s.right()
+		case 11: // Ctrl-K
+			s.buf = s.buf[:s.pos]
+		case 21: // Ctrl-U
+			s.buf = append([]rune{}, s.buf[s.pos:]...)
+			s.pos = 0
+		case 23: // Ctrl-W
+			s.deleteWord()
+		case 12: // Ctrl-L
+			fmt.Fprint(s.editor.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			s.browseHistory(-1)
+		case 14: // Ctrl-N
+			s.browseHistory(1)
+		case 27: // escape sequence
+			s.escape()
+		default:
+			if unicode.IsPrint(r) {
+				s.insert(r)
+			}
+		}
+		s.refresh()
+	}
+}
+
+func (s *editState) escape() {
+	in := s.editor.in
+	b, err := in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+	b, err = in.ReadByte()
+	if err != nil {
+		return
+	}
+	switch b {
+	case 'A':
+		s.browseHistory(-1)
+	case 'B':
+		s.browseHistory(1)
+	case 'C':
+		s.right()
+	case 'D':
+		s.left()
+	case 'H':
+		s.pos = 0
+	case 'F':
+		s.pos = len(s.buf)
+	case '3': // Delete: ESC [ 3 ~
+		if next, _ := in.ReadByte(); next == '~' {
+			s.delete()
+		}
+	default:
+		// skip parameters of unknown sequence
+		for b >= '0' && b <= '9' || b == ';' {
+			if b, err = in.ReadByte(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *editState) insert(r rune) {
+	s.buf = append(s.buf, 0)
+	copy(s.buf[s.pos+1:], s.buf[s.pos:])
+	s.buf[s.pos] = r
+	s.pos++
+}
+
+// delete removes the rune under cursor
+func (s *editState) delete() {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+	}
+}
+
+func (s *editState) deleteWord() {
+	end := s.pos
+	for s.pos > 0 && s.buf[s.pos-1] == ' ' {
+		s.pos--
+	}
+	for s.pos > 0 && s.buf[s.pos-1] != ' ' {
+		s.pos--
+	}
+	s.buf = append(s.buf[:s.pos], s.buf[end:]...)
+}
+
+func (s *editState) left() {
+	if s.pos > 0 {
+		s.pos--
+	}
+}
+
+func (s *editState) right() {
+	if s.pos < len(s.buf) {
+		s.pos++
+	}
+}
+
+// browseHistory moves to the previous line of history if dir is -1, or the next line if dir is 1
+func (s *editState) browseHistory(dir int) {
+	history := s.editor.history
+	next := s.historyPos + dir
+	if next < 0 || next > len(history) {
+		return
+	}
+	if s.historyPos == len(history) {
+		s.saved = append([]rune{}, s.buf...)
+	}
+	s.historyPos = next
+	if next == len(history) {
+		s.buf = append([]rune{}, s.saved...)
+	} else {
+		s.buf = []rune(history[next])
+	}
+	s.pos = len(s.buf)
+}
+
+// complete completes command name in the first word, candidates are printed if there are more than one
+func (s *editState) complete() {
+	line := string(s.buf[:s.pos])
+	if strings.ContainsAny(strings.TrimLeft(line, " "), " ") {
+		return
+	}
+	prefix := strings.ToLower(strings.TrimLeft(line, " "))
+	var candidates []string
+	for _, name := range s.editor.commands {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		common += " "
+	} else if len(common) == len(prefix) {
+		fmt.Fprint(s.editor.out, "\n"+strings.Join(candidates, "  ")+"\n")
+		return
+	}
+	// keep the letter case user typed
+	if isUpper(prefix, line) {
+		common = strings.ToUpper(common)
+	}
+	rest := s.buf[s.pos:]
+	s.buf = append([]rune(common), rest...)
+	s.pos = utf8.RuneCountInString(common)
+}
+
+func isUpper(prefix string, typed string) bool {
+	return prefix != "" && strings.TrimLeft(typed, " ") == strings.ToUpper(prefix)
+}
+
+// refresh redraws prompt and line, then moves cursor to pos
+func (s *editState) refresh() {
+	out := "\r" + s.prompt + string(s.buf) + "\x1b[K\r"
+	if width := utf8.RuneCountInString(s.prompt) + s.pos; width > 0 {
+		out += fmt.Sprintf("\x1b[%dC", width)
+	}
+	fmt.Fprint(s.editor.out, out)
+}
@@ -0,0 +1,260 @@
+// godis-cli is the command line interface of godis, it runs commands interactively or from arguments.
+// It has modes for mass insertion, scanning keys, finding big keys and measuring latency as well
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"godis/interface/redis"
+	"godis/lib/tlsutil"
+	"godis/lib/utils"
+	"godis/redis/client"
+	"godis/redis/parser"
+	"godis/redis/reply"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	host      = flag.String("h", "127.0.0.1", "server hostname")
+	port      = flag.Int("p", 6399, "server port")
+	password  = flag.String("a", "", "password to use when connecting to the server")
+	dbIndex   = flag.Int("n", 0, "database number")
+	useTLS    = flag.Bool("tls", false, "establish a secure TLS connection")
+	caCert    = flag.String("cacert", "", "CA certificate file to verify server with")
+	cert      = flag.String("cert", "", "client certificate to authenticate with")
+	key       = flag.String("key", "", "private key file to authenticate with")
+	rawOutput = flag.Bool("raw", false, "use raw formatting for replies (default when stdout is not a tty)")
+	noRaw     = flag.Bool("no-raw", false, "force formatted output even if stdout is not a tty")
+
+	pipeMode    = flag.Bool("pipe", false, "transfer commands in redis protocol or inline format from stdin to server")
+	scanMode    = flag.Bool("scan", false, "list all keys")
+	pattern     = flag.String("pattern", "*", "keys pattern of --scan and --bigkeys")
+	bigkeysMode = flag.Bool("bigkeys", false, "sample keys looking for keys with many elements")
+	latencyMode = flag.Bool("latency", false, "continuously sample latency by PING")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] [cmd [arg [arg ...]]]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// cli holds a connection which is established again after lost
+type cli struct {
+	opts *client.Options
+	conn *client.Conn
+	raw  bool
+}
+
+func (c *cli) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := client.Dial(context.Background(), c.opts)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// do sends a command and returns its reply, error reply is returned as reply rather than error
+func (c *cli) do(cmdLine [][]byte) (redis.Reply, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	results, err := c.conn.Pipeline(context.Background(), cmdLine)
+	if err != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return results[0], nil
+}
+
+func (c *cli) addr() string {
+	return c.opts.Addr
+}
+
+func (c *cli) prompt() string {
+	p := c.addr()
+	if c.conn == nil {
+		p = "not connected"
+	} else if c.opts.DB != 0 {
+		p += "[" + strconv.Itoa(c.opts.DB) + "]"
+	}
+	return p + "> "
+}
+
+func (c *cli) print(r redis.Reply) {
+	fmt.Println(formatReply(r, c.raw))
+}
+
+// execute runs a command, the connection is kept in sync with AUTH and SELECT so that it can be established again
+func (c *cli) execute(cmdLine [][]byte) error {
+	result, err := c.do(cmdLine)
+	if err != nil {
+		return fmt.Errorf("could not connect to godis at %s: %v", c.addr(), err)
+	}
+	c.print(result)
+	if reply.IsErrorReply(result) {
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "select":
+		if index, err := strconv.Atoi(string(cmdLine[1])); err == nil {
+			c.opts.DB = index
+		}
+	case "auth":
+		c.opts.Password = string(cmdLine[len(cmdLine)-1])
+	case "subscribe", "psubscribe", "monitor":
+		return c.receiveForever()
+	}
+	return nil
+}
+
+// receiveForever prints messages after SUBSCRIBE or MONITOR until connection lost or interrupted
+func (c *cli) receiveForever() error {
+	if !c.raw {
+		fmt.Println("Reading messages... (press Ctrl-C to quit)")
+	}
+	for {
+		result, err := c.conn.Receive(context.Background())
+		if err != nil {
+			_ = c.conn.Close()
+			c.conn = nil
+			return err
+		}
+		c.print(result)
+	}
+}
+
+// localCommands are handled by godis-cli itself
+var localCommands = []string{"quit", "exit", "clear"}
+
+// commandNames fetches names of commands supported by server for completion
+func (c *cli) commandNames() ([]string, bool) {
+	result, err := c.do(utils.ToCmdLine("COMMAND", "LIST"))
+	if err != nil {
+		return nil, false
+	}
+	multiBulk, ok := result.(*reply.MultiBulkReply)
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, len(multiBulk.Args))
+	for i, name := range multiBulk.Args {
+		names[i] = strings.ToLower(string(name))
+	}
+	return names, true
+}
+
+func (c *cli) repl() {
+	historyFile := os.Getenv("GODISCLI_HISTFILE")
+	if historyFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			historyFile = filepath.Join(home, ".godiscli_history")
+		}
+	}
+	editor := newLineEditor(localCommands, historyFile)
+	if err := c.connect(); err != nil {
+		fmt.Printf("Could not connect to godis at %s: %v\n", c.addr(), err)
+	}
+	loaded := false
+	for {
+		if !loaded && c.conn != nil {
+			// retried after reconnecting or AUTH if server refused to list commands
+			if names, ok := c.commandNames(); ok {
+				editor.commands = append(names, localCommands...)
+				loaded = true
+			}
+		}
+		line, err := editor.readLine(c.prompt())
+		if err == errInterrupted || err == io.EOF {
+			return
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		args, err := parser.SplitArgs([]byte(line))
+		if err != nil {
+			fmt.Println("Invalid argument(s)")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		editor.addHistory(strings.TrimSpace(line))
+		cmdName := strings.ToLower(string(args[0]))
+		if cmdName == "quit" || cmdName == "exit" {
+			return
+		}
+		if cmdName == "clear" {
+			fmt.Print("\x1b[H\x1b[2J")
+			continue
+		}
+		if err := c.execute(args); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func makeOptions() (*client.Options, error) {
+	opts := &client.Options{
+		Addr:     net.JoinHostPort(*host, strconv.Itoa(*port)),
+		Password: *password,
+		DB:       *dbIndex,
+		// some commands such as KEYS may take a long time
+		ReadTimeout: -1,
+		MaxRetries:  -1,
+	}
+	if *useTLS {
+		tlsConfig, err := tlsutil.MakeClientConfig(*cert, *key, *caCert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = *host
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	opts, err := makeOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	c := &cli{
+		opts: opts,
+		raw:  *rawOutput || (!*noRaw && !isTerminal(int(os.Stdout.Fd()))),
+	}
+	switch {
+	case *pipeMode:
+		err = c.pipe(os.Stdin)
+	case *scanMode:
+		err = c.scan(*pattern, func(key string) {
+			fmt.Println(key)
+		})
+	case *bigkeysMode:
+		err = c.bigkeys(*pattern)
+	case *latencyMode:
+		err = c.latency()
+	case flag.NArg() > 0:
+		err = c.execute(utils.ToCmdLine(flag.Args()...))
+	default:
+		c.repl()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/parser"
+	"godis/redis/reply"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	pipeBatchSize    = 1000
+	bigkeysBatchSize = 100
+	scanCount        = "100"
+	latencyInterval  = 10 * time.Millisecond
+)
+
+// pipe sends commands read from r in batches, commands could be in redis protocol or inline format
+func (c *cli) pipe(r io.Reader) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	p := parser.NewParser(r)
+	var replies, errCount int
+	batch := make([][][]byte, 0, pipeBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := c.conn.Pipeline(context.Background(), batch...)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			replies++
+			if errReply, ok := result.(reply.ErrorReply); ok {
+				errCount++
+				fmt.Fprintln(os.Stderr, errReply.Error())
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		payload, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		cmd, ok := payload.(*reply.MultiBulkReply)
+		if !ok || len(cmd.Args) == 0 {
+			return errors.New("invalid command: " + strings.TrimSpace(string(payload.ToBytes())))
+		}
+		// arguments are reused by parser
+		cmdLine := make([][]byte, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			cmdLine[i] = append([]byte{}, arg...)
+		}
+		batch = append(batch, cmdLine)
+		if len(batch) == pipeBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("All data transferred. Waiting for the last reply...")
+	if err := flush(); err != nil {
+		return err
+	}
+	fmt.Println("Last reply received from server.")
+	fmt.Printf("errors: %d, replies: %d\n", errCount, replies)
+	if errCount > 0 {
+		return fmt.Errorf("%d commands failed", errCount)
+	}
+	return nil
+}
+
+// scan calls fn with every key matching pattern, KEYS is used if server does not support SCAN
+func (c *cli) scan(pattern string, fn func(key string)) error {
+	cursor := "0"
+	for {
+		result, err := c.do(utils.ToCmdLine("SCAN", cursor, "MATCH", pattern, "COUNT", scanCount))
+		if err != nil {
+			return err
+		}
+		if errReply, ok := result.(reply.ErrorReply); ok {
+			if cursor == "0" && strings.Contains(strings.ToLower(errReply.Error()), "unknown command") {
+				return c.keys(pattern, fn)
+			}
+			return errors.New(errReply.Error())
+		}
+		list := elements(result)
+		if len(list) != 2 {
+			return errors.New("unexpected reply of scan: " + formatRaw(result))
+		}
+		cursor = formatRaw(list[0])
+		for _, element := range elements(list[1]) {
+			fn(formatRaw(element))
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func (c *cli) keys(pattern string, fn func(key string)) error {
+	result, err := c.do(utils.ToCmdLine("KEYS", pattern))
+	if err != nil {
+		return err
+	}
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return errors.New(errReply.Error())
+	}
+	for _, element := range elements(result) {
+		fn(formatRaw(element))
+	}
+	return nil
+}
+
+// typeStat is statistic of keys of a type for bigkeys
+type typeStat struct {
+	name     string
+	unit     string
+	sizeCmd  string
+	count    int
+	total    int64
+	biggest  string
+	maxSize  int64
+	hasFound bool
+}
+
+func makeTypeStats() map[string]*typeStat {
+	return map[string]*typeStat{
+		"string": {name: "strings", unit: "bytes", sizeCmd: "STRLEN"},
+		"list":   {name: "lists", unit: "items", sizeCmd: "LLEN"},
+		"set":    {name: "sets", unit: "members", sizeCmd: "SCARD"},
+		"hash":   {name: "hashs", unit: "fields", sizeCmd: "HLEN"},
+		"zset":   {name: "zsets", unit: "members", sizeCmd: "ZCARD"},
+	}
+}
+
+// pipeline sends cmdLines in one round trip
+func (c *cli) pipeline(cmdLines [][][]byte) ([]redis.Reply, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	results, err := c.conn.Pipeline(context.Background(), cmdLines...)
+	if err != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return results, nil
+}
+
+// bigkeys finds the biggest key of each type, size of string is its length and size of others is number of elements
+func (c *cli) bigkeys(pattern string) error {
+	var keys []string
+	err := c.scan(pattern, func(key string) {
+		keys = append(keys, key)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("# Scanning the entire keyspace to find biggest keys as well as")
+	fmt.Println("# average sizes per key type.")
+	fmt.Println()
+	stats := makeTypeStats()
+	var sampled, totalKeyLen int
+	for start := 0; start < len(keys); start += bigkeysBatchSize {
+		end := start + bigkeysBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+		cmdLines := make([][][]byte, len(batch))
+		for i, key := range batch {
+			cmdLines[i] = utils.ToCmdLine("TYPE", key)
+		}
+		types, err := c.pipeline(cmdLines)
+		if err != nil {
+			return err
+		}
+		cmdLines = cmdLines[:0]
+		var sampledKeys []string
+		var sampledStats []*typeStat
+		for i, key := range batch {
+			stat := stats[formatRaw(types[i])]
+			if stat == nil {
+				// key has expired or its type is not sampled
+				continue
+			}
+			cmdLines = append(cmdLines, utils.ToCmdLine(stat.sizeCmd, key))
+			sampledKeys = append(sampledKeys, key)
+			sampledStats = append(sampledStats, stat)
+		}
+		sizes, err := c.pipeline(cmdLines)
+		if err != nil {
+			return err
+		}
+		for i, key := range sampledKeys {
+			size, ok := sizes[i].(*reply.IntReply)
+			if !ok {
+				continue
+			}
+			stat := sampledStats[i]
+			sampled++
+			totalKeyLen += len(key)
+			stat.count++
+			stat.total += size.Code
+			if !stat.hasFound || size.Code > stat.maxSize {
+				stat.hasFound = true
+				stat.maxSize = size.Code
+				stat.biggest = key
+				percent := float64(end) * 100 / float64(len(keys))
+				fmt.Printf("[%05.2f%%] Biggest %-6s found so far '%s' with %d %s\n",
+					percent, strings.TrimSuffix(stat.name, "s"), quote([]byte(key)), size.Code, stat.unit)
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("-------- summary -------")
+	fmt.Println()
+	fmt.Printf("Sampled %d keys in the keyspace!\n", sampled)
+	avgKeyLen := 0.0
+	if sampled > 0 {
+		avgKeyLen = float64(totalKeyLen) / float64(sampled)
+	}
+	fmt.Printf("Total key length in bytes is %d (avg len %.2f)\n", totalKeyLen, avgKeyLen)
+	fmt.Println()
+	typeNames := make([]string, 0, len(stats))
+	for typeName := range stats {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+	for _, typeName := range typeNames {
+		stat := stats[typeName]
+		if stat.hasFound {
+			fmt.Printf("Biggest %6s found '%s' has %d %s\n",
+				typeName, quote([]byte(stat.biggest)), stat.maxSize, stat.unit)
+		}
+	}
+	fmt.Println()
+	for _, typeName := range typeNames {
+		stat := stats[typeName]
+		var percent, avg float64
+		if sampled > 0 {
+			percent = float64(stat.count) * 100 / float64(sampled)
+		}
+		if stat.count > 0 {
+			avg = float64(stat.total) / float64(stat.count)
+		}
+		fmt.Printf("%d %s with %d %s (%05.2f%% of keys, avg size %.2f)\n",
+			stat.count, stat.name, stat.total, stat.unit, percent, avg)
+	}
+	return nil
+}
+
+// latency sends PING continuously and prints min, max and average latency in milliseconds until interrupted
+func (c *cli) latency() error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	var min, max, total time.Duration
+	var count int
+	lastPrint := time.Now()
+	format := func() string {
+		avg := float64(total.Microseconds()) / float64(count) / 1000
+		return fmt.Sprintf("min: %.2f, max: %.2f, avg: %.2f (%d samples)",
+			float64(min.Microseconds())/1000, float64(max.Microseconds())/1000, avg, count)
+	}
+	ping := utils.ToCmdLine("PING")
+	for {
+		start := time.Now()
+		result, err := c.do(ping)
+		if err != nil {
+			return err
+		}
+		if errReply, ok := result.(reply.ErrorReply); ok {
+			return errors.New(errReply.Error())
+		}
+		d := time.Since(start)
+		if count == 0 || d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		total += d
+		count++
+		if !c.raw {
+			fmt.Print("\x1b[0G\x1b[2K" + format())
+		} else if time.Since(lastPrint) >= time.Second {
+			fmt.Println(format())
+			lastPrint = time.Now()
+		}
+		select {
+		case <-interrupt:
+			if c.raw {
+				fmt.Println(format())
+			} else {
+				fmt.Println()
+			}
+			return nil
+		case <-time.After(latencyInterval):
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// godis-copy copies keys matching the given pattern from one godis (or redis) instance to another by DUMP and RESTORE
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"godis/lib/utils"
+	"godis/redis/client"
+	"godis/redis/reply"
+	"os"
+	"strconv"
+)
+
+var (
+	srcAddr     = flag.String("src", "127.0.0.1:6399", "address of source instance")
+	destAddr    = flag.String("dest", "", "address of destination instance")
+	srcPass     = flag.String("src-pass", "", "password of source instance")
+	destPass    = flag.String("dest-pass", "", "password of destination instance")
+	srcDB       = flag.Int("src-db", 0, "database index of source instance")
+	destDB      = flag.Int("dest-db", 0, "database index of destination instance")
+	pattern     = flag.String("pattern", "*", "copy keys matching the pattern")
+	replaceKeys = flag.Bool("replace", false, "replace existing keys in destination")
+)
+
+func connect(addr string, password string, dbIndex int) (*client.Conn, error) {
+	c, err := client.Dial(context.Background(), &client.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       dbIndex,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect %s failed: %v", addr, err)
+	}
+	return c, nil
+}
+
+// copyKey returns false if the key does not exist in source any more
+func copyKey(src *client.Conn, dest *client.Conn, key string) (bool, error) {
+	ctx := context.Background()
+	ret, err := src.Do(ctx, utils.ToCmdLine("PTTL", key))
+	if err != nil {
+		return false, fmt.Errorf("pttl failed: %v", err)
+	}
+	ttlReply, ok := ret.(*reply.IntReply)
+	if !ok {
+		return false, fmt.Errorf("pttl failed: %s", ret.ToBytes())
+	}
+	if ttlReply.Code == -2 {
+		return false, nil
+	}
+	ttl := ttlReply.Code
+	if ttl < 0 {
+		ttl = 0
+	}
+	ret, err = src.Do(ctx, utils.ToCmdLine("DUMP", key))
+	if err != nil {
+		return false, fmt.Errorf("dump failed: %v", err)
+	}
+	payload, ok := ret.(*reply.BulkReply)
+	if !ok {
+		return false, fmt.Errorf("dump failed: %s", ret.ToBytes())
+	}
+	args := utils.ToCmdLine3("RESTORE", []byte(key), []byte(strconv.FormatInt(ttl, 10)), payload.Arg)
+	if *replaceKeys {
+		args = append(args, []byte("REPLACE"))
+	}
+	_, err = dest.Do(ctx, args)
+	if err != nil {
+		return false, fmt.Errorf("restore failed: %v", err)
+	}
+	return true, nil
+}
+
+func main() {
+	flag.Parse()
+	if *destAddr == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	src, err := connect(*srcAddr, *srcPass, *srcDB)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer src.Close()
+	dest, err := connect(*destAddr, *destPass, *destDB)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer dest.Close()
+
+	ret, err := src.Do(context.Background(), utils.ToCmdLine("KEYS", *pattern))
+	if err != nil {
+		fmt.Printf("scan keys failed: %v\n", err)
+		os.Exit(1)
+	}
+	var keys [][]byte
+	switch ret := ret.(type) {
+	case *reply.MultiBulkReply:
+		keys = ret.Args
+	case *reply.EmptyMultiBulkReply:
+	default:
+		fmt.Printf("scan keys failed: %s\n", ret.ToBytes())
+		os.Exit(1)
+	}
+	copied := 0
+	for _, key := range keys {
+		ok, err := copyKey(src, dest, string(key))
+		if err != nil {
+			fmt.Printf("copy %s: %v\n", key, err)
+			continue
+		}
+		if ok {
+			copied++
+		}
+	}
+	fmt.Printf("%d of %d keys copied\n", copied, len(keys))
+}
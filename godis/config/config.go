@@ -5,101 +5,159 @@ import (
 	"godis/lib/logger"
 	"io"
 	"os"
-	"reflect"
-	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-// ServerProperties defines global config properties
+// ServerProperties defines global config properties.
+// Fields tagged with `mutable:"yes"` could be changed at runtime by CONFIG SET or reload,
+// `default` gives the value used when the option is absent in config file,
+// `enum` lists all acceptable values separated by comma,
+// `range` gives inclusive bounds of integers as "min,max", either of them could be omitted,
+// slice options are comma separated unless tagged with `repeated:"yes"`, which takes an entry per line
 type ServerProperties struct {
 	Bind           string `cfg:"bind"`
 	Port           int    `cfg:"port"`
 	AppendOnly     bool   `cfg:"appendOnly"`
 	AppendFilename string `cfg:"appendFilename"`
-	MaxClients     int    `cfg:"maxclients"`
-	RequirePass    string `cfg:"requirepass"`
+	MaxClients     int    `cfg:"maxclients" mutable:"yes" range:"0,"`
+	RequirePass    string `cfg:"requirepass" mutable:"yes"`
 	Databases      int    `cfg:"databases"`
+	// directory of multi part aof files, default is the directory of appendFilename
+	AppendDirname string `cfg:"appenddirname"`
+	// always, everysec or no
+	AppendFsync string `cfg:"appendfsync" mutable:"yes" default:"everysec" enum:"always,everysec,no"`
+	// load the valid prefix of an aof file which ends in the middle of a command
+	AofLoadTruncated bool `cfg:"aof-load-truncated" mutable:"yes"`
+	// write base file of aof in snapshot format, which is smaller and faster to load
+	AofUseRdbPreamble bool `cfg:"aof-use-rdb-preamble" mutable:"yes"`
+	// rewrite aof when it grows by the percentage since last rewrite and is larger than min size(in bytes)
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage" mutable:"yes"`
+	AutoAofRewriteMinSize    int `cfg:"auto-aof-rewrite-min-size" mutable:"yes"`
+	// frequency of background tasks such as active expiration
+	Hz int `cfg:"hz" mutable:"yes" default:"10" range:"1,500"`
+	// 1~10, greater effort expires keys faster at the cost of more cpu
+	ActiveExpireEffort int `cfg:"active-expire-effort" mutable:"yes" default:"1" range:"1,10"`
+	// release values in background when deleted by DEL, FLUSHDB/FLUSHALL or expiration
+	LazyfreeLazyUserDel   bool `cfg:"lazyfree-lazy-user-del" mutable:"yes"`
+	LazyfreeLazyUserFlush bool `cfg:"lazyfree-lazy-user-flush" mutable:"yes"`
+	LazyfreeLazyExpire    bool `cfg:"lazyfree-lazy-expire" mutable:"yes"`
+	// values with more elements than threshold will be freed in background
+	LazyfreeThreshold int `cfg:"lazyfree-threshold" mutable:"yes"`
+	// commands slower than it(in microseconds) are logged, negative value disables slowlog
+	SlowlogLogSlowerThan int `cfg:"slowlog-log-slower-than" mutable:"yes" default:"10000"`
+	// max number of entries kept in slowlog
+	SlowlogMaxLen int `cfg:"slowlog-max-len" mutable:"yes" default:"128" range:"0,"`
+	// seconds to wait for running commands and MULTI blocks to finish during shutdown
+	ShutdownTimeout int `cfg:"shutdown-timeout" mutable:"yes" default:"10" range:"0,"`
+	// modules loaded at startup, each line is a module name or plugin path followed by space separated arguments
+	LoadModules []string `cfg:"loadmodule" repeated:"yes"`
+
+	// serve tls on tls-port if it is not 0
+	TLSPort        int    `cfg:"tls-port"`
+	TLSCertFile    string `cfg:"tls-cert-file"`
+	TLSKeyFile     string `cfg:"tls-key-file"`
+	TLSCACertFile  string `cfg:"tls-ca-cert-file"`
+	TLSAuthClients bool   `cfg:"tls-auth-clients"`
+	// connect to peers by tls, then peers should be tls addresses
+	TLSCluster bool `cfg:"tls-cluster"`
+	// path of unix domain socket, and its permission in octal such as 700
+	UnixSocket     string `cfg:"unixsocket"`
+	UnixSocketPerm string `cfg:"unixsocketperm"`
 
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
 }
 
-// Properties holds global config properties
-var Properties *ServerProperties
+// properties holds *ServerProperties, CONFIG SET and reloading replace it as a whole instead of modifying it,
+// so readers always see a consistent snapshot
+var properties atomic.Value
 
 func init() {
 	// default config
-	Properties = &ServerProperties{
+	SetProperties(&ServerProperties{
 		Bind:       "127.0.0.1",
 		Port:       6379,
 		AppendOnly: false,
+	})
+}
+
+// Properties returns global config properties, the returned value is shared and should not be modified
+func Properties() *ServerProperties {
+	return properties.Load().(*ServerProperties)
+}
+
+// SetProperties replaces global config properties
+func SetProperties(props *ServerProperties) {
+	properties.Store(props)
+}
+
+// parse reads config file, unknown options are ignored with a warning,
+// invalid values are reported by *OptionError
+func parse(src io.Reader) (*ServerProperties, error) {
+	config := MakeDefaultProperties()
+	lines, err := readConfigLines(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if line.key == "" {
+			continue
+		}
+		opt := optionMap[line.key]
+		if opt == nil {
+			logger.Warn("unknown config option: " + line.key)
+			continue
+		}
+		err = opt.set(config, line.value)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return config, nil
 }
 
-func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+// configLine is a line of config file, key is empty for comments and blank lines
+type configLine struct {
+	raw   string
+	key   string
+	value string
+}
 
-	// read config file
-	rawMap := make(map[string]string)
+func readConfigLines(src io.Reader) ([]*configLine, error) {
+	var lines []*configLine
 	scanner := bufio.NewScanner(src)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 0 && line[0] == '#' {
+		line := &configLine{
+			raw: scanner.Text(),
+		}
+		lines = append(lines, line)
+		if len(line.raw) > 0 && line.raw[0] == '#' {
 			continue
 		}
-		pivot := strings.IndexAny(line, " ")
-		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
-			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+		pivot := strings.IndexAny(line.raw, " ")
+		if pivot > 0 && pivot < len(line.raw)-1 { // separator found
+			line.key = strings.ToLower(line.raw[0:pivot])
+			line.value = strings.Trim(line.raw[pivot+1:], " ")
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
-
-	// parse format
-	t := reflect.TypeOf(config)
-	v := reflect.ValueOf(config)
-	n := t.Elem().NumField()
-	for i := 0; i < n; i++ {
-		field := t.Elem().Field(i)
-		fieldVal := v.Elem().Field(i)
-		key, ok := field.Tag.Lookup("cfg")
-		if !ok {
-			key = field.Name
-		}
-		value, ok := rawMap[strings.ToLower(key)]
-		if ok {
-			// fill config
-			switch field.Type.Kind() {
-			case reflect.String:
-				fieldVal.SetString(value)
-			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
-				}
-			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
-			case reflect.Slice:
-				if field.Type.Elem().Kind() == reflect.String {
-					slice := strings.Split(value, ",")
-					fieldVal.Set(reflect.ValueOf(slice))
-				}
-			}
-		}
-	}
-	return config
+	return lines, nil
 }
 
-// SetupConfig read config file and store properties into Properties
+// SetupConfig read config file and publish the properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
 	if err != nil {
 		panic(err)
 	}
 	defer file.Close()
-	Properties = parse(file)
+	props, err := parse(file)
+	if err != nil {
+		logger.Fatal("bad config file " + configFilename + ": " + err.Error())
+	}
+	SetProperties(props)
+	configFile = configFilename
 }
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -10,9 +14,9 @@ func TestParse(t *testing.T) {
 		"port 6399\n" +
 		"appendonly yes\n" +
 		"peers a,b"
-	p := parse(strings.NewReader(src))
-	if p == nil {
-		t.Error("cannot get result")
+	p, err := parse(strings.NewReader(src))
+	if err != nil {
+		t.Error(err)
 		return
 	}
 	if p.Bind != "0.0.0.0" {
@@ -28,3 +32,194 @@ func TestParse(t *testing.T) {
 		t.Error("list parse failed")
 	}
 }
+
+func TestParseInvalid(t *testing.T) {
+	_, err := parse(strings.NewReader("port abc\n"))
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expect invalid value error, actual %v", err)
+	}
+	_, err = parse(strings.NewReader("appendonly true\n"))
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expect invalid value error, actual %v", err)
+	}
+	_, err = parse(strings.NewReader("appendfsync sometimes\n"))
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expect invalid value error, actual %v", err)
+	}
+	// unknown options are ignored
+	p, err := parse(strings.NewReader("unknown-option 1\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if p.AppendFsync != "everysec" || p.SlowlogMaxLen != 128 {
+		t.Error("default values are not set")
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	SetProperties(MakeDefaultProperties())
+	err := Set([][2]string{{"maxclients", "100"}, {"appendfsync", "ALWAYS"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result := Get("max*")
+	if len(result) != 1 || result[0][0] != "maxclients" || result[0][1] != "100" {
+		t.Errorf("wrong result: %v", result)
+	}
+	result = Get("appendfsync")
+	if len(result) != 1 || result[0][1] != "always" {
+		t.Errorf("wrong result: %v", result)
+	}
+
+	// nothing changes if any pair is invalid
+	err = Set([][2]string{{"maxclients", "200"}, {"hz", "abc"}})
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expect invalid value error, actual %v", err)
+	}
+	for _, pair := range [][2]string{{"hz", "0"}, {"hz", "2000000000"}, {"slowlog-max-len", "-1"}, {"shutdown-timeout", "-1"}} {
+		err = Set([][2]string{{"maxclients", "200"}, pair})
+		if !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("expect out of range error for %s %s, actual %v", pair[0], pair[1], err)
+		}
+	}
+	err = Set([][2]string{{"maxclients", "200"}, {"port", "1234"}})
+	if !errors.Is(err, ErrImmutableOption) {
+		t.Errorf("expect immutable error, actual %v", err)
+	}
+	err = Set([][2]string{{"no-such-option", "1"}})
+	if !errors.Is(err, ErrUnknownOption) {
+		t.Errorf("expect unknown option error, actual %v", err)
+	}
+	if Properties().MaxClients != 100 {
+		t.Error("config changed by failed set")
+	}
+}
+
+func TestRewriteAndReload(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	filename := filepath.Join(tmpDir, "redis.conf")
+	src := "# comment\n" +
+		"bind 0.0.0.0\n" +
+		"maxclients 10\n" +
+		"# another comment\n" +
+		"maxclients 20\n" +
+		"requirepass abc\n"
+	err = ioutil.WriteFile(filename, []byte(src), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		configFile = ""
+	}()
+	SetupConfig(filename)
+	if Properties().MaxClients != 20 {
+		t.Error("the last line should take effect")
+	}
+	err = Set([][2]string{{"maxclients", "30"}, {"requirepass", ""}, {"hz", "20"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = Rewrite()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "# comment\n" +
+		"bind 0.0.0.0\n" +
+		"maxclients 30\n" +
+		"# another comment\n" +
+		"hz 20\n"
+	if string(content) != expected {
+		t.Errorf("wrong rewritten file: %s", content)
+	}
+
+	src = "bind 127.0.0.1\n" +
+		"maxclients 40\n"
+	err = ioutil.WriteFile(filename, []byte(src), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = Reload()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if Properties().MaxClients != 40 || Properties().Hz != 10 {
+		t.Error("mutable options should be reloaded")
+	}
+	if Properties().Bind != "0.0.0.0" {
+		t.Error("immutable options should not be reloaded")
+	}
+}
+
+func TestRepeatedOption(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	filename := filepath.Join(tmpDir, "redis.conf")
+	src := "loadmodule a x,y\n" +
+		"port 6399\n" +
+		"loadmodule /path/b.so\n"
+	err = ioutil.WriteFile(filename, []byte(src), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		configFile = ""
+	}()
+	SetupConfig(filename)
+	modules := Properties().LoadModules
+	if len(modules) != 2 || modules[0] != "a x,y" || modules[1] != "/path/b.so" {
+		t.Errorf("each line should be an entry: %q", modules)
+	}
+	result := Get("loadmodule")
+	if len(result) != 2 || result[0][1] != "a x,y" || result[1][1] != "/path/b.so" {
+		t.Errorf("wrong result: %v", result)
+	}
+
+	err = Rewrite()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "loadmodule a x,y\n" +
+		"loadmodule /path/b.so\n" +
+		"port 6399\n"
+	if string(content) != expected {
+		t.Errorf("wrong rewritten file: %s", content)
+	}
+
+	SetProperties(MakeDefaultProperties())
+	result = Get("loadmodule")
+	if len(result) != 1 || result[0][1] != "" {
+		t.Errorf("wrong result: %v", result)
+	}
+}
@@ -0,0 +1,385 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"godis/lib/logger"
+	"godis/lib/wildcard"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownOption means no option has the given name
+	ErrUnknownOption = errors.New("unknown option")
+	// ErrInvalidValue means the value cannot be parsed or is not acceptable
+	ErrInvalidValue = errors.New("invalid argument")
+	// ErrImmutableOption means the option cannot be changed at runtime
+	ErrImmutableOption = errors.New("can't set immutable config")
+	// ErrNoConfigFile means the server is started without config file, so it cannot be rewritten or reloaded
+	ErrNoConfigFile = errors.New("the server is running without a config file")
+)
+
+// OptionError describes which option caused the error, use errors.Is to check its kind
+type OptionError struct {
+	Name  string
+	Value string
+	// Err is one of ErrUnknownOption, ErrInvalidValue and ErrImmutableOption
+	Err error
+	// Reason explains why the value is invalid
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	switch e.Err {
+	case ErrInvalidValue:
+		return fmt.Sprintf("invalid argument '%s' for '%s': %s", e.Value, e.Name, e.Reason)
+	default:
+		return fmt.Sprintf("%s '%s'", e.Err.Error(), e.Name)
+	}
+}
+
+// Unwrap returns kind of the error
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// option describes a field of ServerProperties
+type option struct {
+	name         string
+	index        int
+	kind         reflect.Kind
+	mutable      bool
+	defaultValue string
+	enum         []string
+	// inclusive bounds of integer options
+	min, max int64
+	// repeated slice option appends an entry for each line instead of splitting value by comma
+	repeated bool
+}
+
+var (
+	options   []*option
+	optionMap map[string]*option
+	// configFile is path of the file properties loaded from
+	configFile string
+	// mu serializes CONFIG SET, REWRITE and reloading
+	mu sync.Mutex
+)
+
+func init() {
+	optionMap = make(map[string]*option)
+	t := reflect.TypeOf(ServerProperties{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("cfg")
+		if !ok {
+			name = field.Name
+		}
+		opt := &option{
+			name:         strings.ToLower(name),
+			index:        i,
+			kind:         field.Type.Kind(),
+			mutable:      field.Tag.Get("mutable") == "yes",
+			defaultValue: field.Tag.Get("default"),
+			repeated:     field.Tag.Get("repeated") == "yes",
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			opt.enum = strings.Split(enum, ",")
+		}
+		opt.min, opt.max = math.MinInt64, math.MaxInt64
+		if bounds := field.Tag.Get("range"); bounds != "" {
+			pair := strings.SplitN(bounds, ",", 2)
+			if pair[0] != "" {
+				opt.min, _ = strconv.ParseInt(pair[0], 10, 64)
+			}
+			if len(pair) == 2 && pair[1] != "" {
+				opt.max, _ = strconv.ParseInt(pair[1], 10, 64)
+			}
+		}
+		options = append(options, opt)
+		optionMap[opt.name] = opt
+	}
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].name < options[j].name
+	})
+}
+
+// MakeDefaultProperties returns properties filled with `default` tags
+func MakeDefaultProperties() *ServerProperties {
+	props := &ServerProperties{}
+	for _, opt := range options {
+		if opt.defaultValue != "" {
+			if err := opt.set(props, opt.defaultValue); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return props
+}
+
+func (opt *option) invalid(value string, reason string) error {
+	return &OptionError{
+		Name:   opt.name,
+		Value:  value,
+		Err:    ErrInvalidValue,
+		Reason: reason,
+	}
+}
+
+// set parses value and stores it into props
+func (opt *option) set(props *ServerProperties, value string) error {
+	fieldVal := reflect.ValueOf(props).Elem().Field(opt.index)
+	switch opt.kind {
+	case reflect.String:
+		if len(opt.enum) > 0 {
+			matched := false
+			for _, candidate := range opt.enum {
+				if strings.EqualFold(candidate, value) {
+					value = candidate
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return opt.invalid(value, "argument must be one of "+strings.Join(opt.enum, ", "))
+			}
+		}
+		fieldVal.SetString(value)
+	case reflect.Int:
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return opt.invalid(value, "argument couldn't be parsed into an integer")
+		}
+		if intValue < opt.min || intValue > opt.max {
+			return opt.invalid(value, fmt.Sprintf("argument must be between %d and %d inclusive", opt.min, opt.max))
+		}
+		fieldVal.SetInt(intValue)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			fieldVal.SetBool(true)
+		case "no":
+			fieldVal.SetBool(false)
+		default:
+			return opt.invalid(value, "argument must be 'yes' or 'no'")
+		}
+	case reflect.Slice:
+		if opt.repeated {
+			if value != "" {
+				fieldVal.Set(reflect.Append(fieldVal, reflect.ValueOf(value)))
+			}
+			return nil
+		}
+		var slice []string
+		if value != "" {
+			slice = strings.Split(value, ",")
+		}
+		fieldVal.Set(reflect.ValueOf(slice))
+	}
+	return nil
+}
+
+// setValues replaces value of the option by values returned by getValues
+func (opt *option) setValues(props *ServerProperties, values []string) error {
+	if opt.repeated {
+		reflect.ValueOf(props).Elem().Field(opt.index).Set(reflect.ValueOf([]string(nil)))
+	}
+	for _, value := range values {
+		if err := opt.set(props, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getValues returns a value for each line of the option in config file, repeated option may have none
+func (opt *option) getValues(props *ServerProperties) []string {
+	if opt.repeated {
+		return reflect.ValueOf(props).Elem().Field(opt.index).Interface().([]string)
+	}
+	return []string{opt.get(props)}
+}
+
+// get formats value of the option in the same way as config file
+func (opt *option) get(props *ServerProperties) string {
+	fieldVal := reflect.ValueOf(props).Elem().Field(opt.index)
+	switch opt.kind {
+	case reflect.Int:
+		return strconv.FormatInt(fieldVal.Int(), 10)
+	case reflect.Bool:
+		if fieldVal.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		if opt.repeated {
+			// entries never contain line break
+			return strings.Join(fieldVal.Interface().([]string), "\n")
+		}
+		return strings.Join(fieldVal.Interface().([]string), ",")
+	default:
+		return fieldVal.String()
+	}
+}
+
+// Get returns names and values of options matching pattern, sorted by name.
+// Repeated option has a pair for each entry, or a pair with empty value if it has none
+func Get(pattern string) [][2]string {
+	props := Properties()
+	p := wildcard.CompilePattern(strings.ToLower(pattern))
+	var result [][2]string
+	for _, opt := range options {
+		if !p.IsMatch(opt.name) {
+			continue
+		}
+		values := opt.getValues(props)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		for _, value := range values {
+			result = append(result, [2]string{opt.name, value})
+		}
+	}
+	return result
+}
+
+// Set changes mutable options at runtime.
+// All pairs are validated before applying, so either all of them take effect or none of them does
+func Set(pairs [][2]string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	// copy on write, readers holding the old properties are not affected
+	props := *Properties()
+	for _, pair := range pairs {
+		name := strings.ToLower(pair[0])
+		opt := optionMap[name]
+		if opt == nil {
+			return &OptionError{Name: pair[0], Value: pair[1], Err: ErrUnknownOption}
+		}
+		if !opt.mutable {
+			return &OptionError{Name: name, Value: pair[1], Err: ErrImmutableOption}
+		}
+		if err := opt.set(&props, pair[1]); err != nil {
+			return err
+		}
+	}
+	SetProperties(&props)
+	return nil
+}
+
+// Rewrite writes current properties back to config file.
+// Comments and unknown lines are kept, the first line of each option is updated in place,
+// duplicated lines are removed and options not in file are appended if they are not default
+func Rewrite() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if configFile == "" {
+		return ErrNoConfigFile
+	}
+	file, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	lines, err := readConfigLines(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+
+	props := Properties()
+	defaults := MakeDefaultProperties()
+	buf := &bytes.Buffer{}
+	written := make(map[string]bool)
+	for _, line := range lines {
+		opt := optionMap[line.key]
+		if opt == nil {
+			buf.WriteString(line.raw)
+			buf.WriteByte('\n')
+			continue
+		}
+		if written[opt.name] {
+			continue
+		}
+		written[opt.name] = true
+		writeOption(buf, opt, props)
+	}
+	for _, opt := range options {
+		if written[opt.name] {
+			continue
+		}
+		if opt.get(props) != opt.get(defaults) {
+			writeOption(buf, opt, props)
+		}
+	}
+
+	// write a temp file then rename it, config file will never be half written
+	tmpFile, err := ioutil.TempFile(filepath.Dir(configFile), filepath.Base(configFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(buf.Bytes())
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), configFile)
+}
+
+// writeOption writes a line for each non-empty value of the option
+func writeOption(buf *bytes.Buffer, opt *option, props *ServerProperties) {
+	for _, value := range opt.getValues(props) {
+		if value != "" {
+			buf.WriteString(opt.name + " " + value + "\n")
+		}
+	}
+}
+
+// Reload reads config file again and applies changed mutable options,
+// changes of immutable options are ignored with a warning since they require restart
+func Reload() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if configFile == "" {
+		return ErrNoConfigFile
+	}
+	file, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	loaded, err := parse(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	props := *Properties()
+	for _, opt := range options {
+		value := opt.get(loaded)
+		if value == opt.get(&props) {
+			continue
+		}
+		if !opt.mutable {
+			logger.Warn("config " + opt.name + " changed, it takes effect after restart")
+			continue
+		}
+		_ = opt.setValues(&props, opt.getValues(loaded))
+		logger.Info("config " + opt.name + " reloaded: " + value)
+	}
+	SetProperties(&props)
+	return nil
+}
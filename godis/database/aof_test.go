@@ -1,6 +1,8 @@
 package database
 
 import (
+	"bytes"
+	"godis/aof"
 	"godis/config"
 	"godis/interface/database"
 	"godis/interface/redis"
@@ -12,6 +14,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -46,6 +49,51 @@ func makeTestData(db database.DB, dbIndex int, prefix string, size int) {
 		cursor++
 		db.Exec(conn, utils.ToCmdLine("ZADD", key, "10", key))
 	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("BF.ADD", key, key))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("CF.ADD", key, key))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", key, "10", "3"))
+		db.Exec(conn, utils.ToCmdLine("CMS.INCRBY", key, key, "3"))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("TOPK.RESERVE", key, "2"))
+		db.Exec(conn, utils.ToCmdLine("TOPK.INCRBY", key, key, "3"))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$", `{"key":"`+key+`","n":[1,2.5]}`))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("TS.ADD", key, "1000", "1.5", "LABELS", "key", key))
+		db.Exec(conn, utils.ToCmdLine("TS.ADD", key, "2000", "3"))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, key, "10000000"))
+		db.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key, key))
+		db.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, key, "10000000"))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "0", "1", "10000"))
+	}
 }
 
 func validateTestData(t *testing.T, db database.DB, dbIndex int, prefix string, size int) {
@@ -92,6 +140,59 @@ func validateTestData(t *testing.T, db database.DB, dbIndex int, prefix string,
 		ret = db.Exec(conn, utils.ToCmdLine("ZRANGE", key, "0", "-1"))
 		asserts.AssertMultiBulkReply(t, ret, []string{key})
 	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("BF.EXISTS", key, key))
+		asserts.AssertIntReply(t, ret, 1)
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("CF.COUNT", key, key))
+		asserts.AssertIntReply(t, ret, 1)
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("CMS.QUERY", key, key))
+		assertRawReplies(t, ret, ":3\r\n")
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("TOPK.LIST", key, "WITHCOUNT"))
+		assertRawReplies(t, ret, "$"+strconv.Itoa(len(key))+"\r\n"+key+"\r\n", ":3\r\n")
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("JSON.GET", key))
+		asserts.AssertBulkReply(t, ret, `{"key":"`+key+`","n":[1,2.5]}`)
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "-", "+"))
+		assertRawReplies(t, ret, "*2\r\n:1000\r\n+1.5\r\n", "*2\r\n:2000\r\n+3\r\n")
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, key, "10000000"))
+		asserts.AssertIntReply(t, ret, 2)
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		// still limited since the replayed request
+		ret = db.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "0", "1", "10000"))
+		if raw, ok := ret.(*reply.MultiRawReply); !ok || len(raw.Replies) != 5 {
+			t.Errorf("unexpected throttle result %q", ret.ToBytes())
+		} else {
+			asserts.AssertIntReply(t, raw.Replies[0], 1)
+		}
+	}
 }
 
 func TestAof(t *testing.T) {
@@ -102,12 +203,12 @@ func TestAof(t *testing.T) {
 	}
 	aofFilename := path.Join(tmpDir, "a.aof")
 	defer func() {
-		_ = os.Remove(aofFilename)
+		_ = os.RemoveAll(tmpDir)
 	}()
-	config.Properties = &config.ServerProperties{
+	config.SetProperties(&config.ServerProperties{
 		AppendOnly:     true,
 		AppendFilename: aofFilename,
-	}
+	})
 	dbNum := 4
 	size := 10
 	var prefixes []string
@@ -127,19 +228,19 @@ func TestAof(t *testing.T) {
 }
 
 func TestRewriteAOF(t *testing.T) {
-	tmpFile, err := ioutil.TempFile("", "*.aof")
+	tmpDir, err := ioutil.TempDir("", "godis")
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	aofFilename := tmpFile.Name()
+	aofFilename := path.Join(tmpDir, "a.aof")
 	defer func() {
-		_ = os.Remove(aofFilename)
+		_ = os.RemoveAll(tmpDir)
 	}()
-	config.Properties = &config.ServerProperties{
+	config.SetProperties(&config.ServerProperties{
 		AppendOnly:     true,
 		AppendFilename: aofFilename,
-	}
+	})
 	aofWriteDB := NewStandaloneServer()
 	size := 1
 	dbNum := 4
@@ -163,19 +264,19 @@ func TestRewriteAOF(t *testing.T) {
 
 // TestRewriteAOF2 tests execute commands during rewrite procedure
 func TestRewriteAOF2(t *testing.T) {
-	tmpFile, err := ioutil.TempFile("", "*.aof")
+	tmpDir, err := ioutil.TempDir("", "godis")
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	aofFilename := tmpFile.Name()
+	aofFilename := path.Join(tmpDir, "a.aof")
 	defer func() {
-		_ = os.Remove(aofFilename)
+		_ = os.RemoveAll(tmpDir)
 	}()
-	config.Properties = &config.ServerProperties{
+	config.SetProperties(&config.ServerProperties{
 		AppendOnly:     true,
 		AppendFilename: aofFilename,
-	}
+	})
 	aofWriteDB := NewStandaloneServer()
 	dbNum := 4
 	conn := &connection.FakeConn{}
@@ -213,3 +314,339 @@ func TestRewriteAOF2(t *testing.T) {
 	}
 	aofReadDB.Close()
 }
+
+func TestAofFsyncAlways(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofFilename := path.Join(tmpDir, "a.aof")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+	})
+	aofWriteDB := NewStandaloneServer()
+	size := 100
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := &connection.FakeConn{}
+			for j := 0; j < size; j++ {
+				key := strconv.Itoa(i) + ":" + strconv.Itoa(j)
+				aofWriteDB.Exec(conn, utils.ToCmdLine("SET", key, key))
+			}
+		}(i)
+	}
+	wg.Wait()
+	// commands have reached file before Exec returns
+	result, err := aof.CheckFile(aofFilename + ".1.incr.aof")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Err != nil || result.Commands != 4*size {
+		t.Errorf("expect %d commands, actually %d, err: %v", 4*size, result.Commands, result.Err)
+	}
+	aofWriteDB.Close()
+}
+
+func TestAofLoadTruncated(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofFilename := path.Join(tmpDir, "a.aof")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+	})
+	aofWriteDB := NewStandaloneServer()
+	prefix := utils.RandString(8)
+	makeTestData(aofWriteDB, 0, prefix, 10)
+	aofWriteDB.Close()
+	incrFilename := aofFilename + ".1.incr.aof"
+	info, _ := os.Stat(incrFilename)
+	validSize := info.Size()
+	// simulate crash during writing
+	file, err := os.OpenFile(incrFilename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = file.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$3\r\nab"))
+	_ = file.Close()
+
+	result, err := aof.CheckFile(incrFilename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Err != aof.ErrTruncated || result.ValidSize != validSize {
+		t.Errorf("expect truncated at %d, actually %d, err: %v", validSize, result.ValidSize, result.Err)
+	}
+
+	// refuse to load
+	func() {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Error("expect loading truncated aof failed")
+			}
+		}()
+		NewStandaloneServer()
+	}()
+
+	_ = config.Set([][2]string{{"aof-load-truncated", "yes"}})
+	aofReadDB := NewStandaloneServer()
+	validateTestData(t, aofReadDB, 0, prefix, 10)
+	aofReadDB.Close()
+	info, _ = os.Stat(incrFilename)
+	if info.Size() < validSize {
+		t.Error("aof is trimmed too much")
+	}
+	result, _ = aof.CheckFile(incrFilename)
+	if result.Err != nil {
+		t.Errorf("aof is still broken: %v", result.Err)
+	}
+}
+
+func TestCheckAofFormatError(t *testing.T) {
+	data := []byte("*1\r\n$4\r\nPING\r\n+OK\r\n*1\r\n$4\r\nPING\r\n")
+	result := aof.Check(bytes.NewReader(data))
+	formatErr, ok := result.Err.(*aof.FormatError)
+	if !ok {
+		t.Errorf("expect format error, actually %v", result.Err)
+		return
+	}
+	if result.Commands != 1 || formatErr.Offset != 14 || result.ValidSize != 14 {
+		t.Errorf("wrong check result: %d commands, offset %d", result.Commands, formatErr.Offset)
+	}
+}
+
+func TestRewriteAOFWithSnapshot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofFilename := path.Join(tmpDir, "a.aof")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:        true,
+		AppendFilename:    aofFilename,
+		AofUseRdbPreamble: true,
+	})
+	aofWriteDB := NewStandaloneServer()
+	size := 10
+	dbNum := 4
+	var prefixes []string
+	for i := 0; i < dbNum; i++ {
+		prefix := utils.RandString(8)
+		prefixes = append(prefixes, prefix)
+		makeTestData(aofWriteDB, i, prefix, size)
+	}
+	conn := &connection.FakeConn{}
+	conn.SelectDB(1)
+	aofWriteDB.Exec(conn, utils.ToCmdLine("ZADD", "z", "-inf", "a", "1.5", "b"))
+	ret := aofWriteDB.Exec(conn, utils.ToCmdLine("rewriteaof"))
+	asserts.AssertNotError(t, ret)
+	// write after rewrite goes to new incremental file
+	aofWriteDB.Exec(conn, utils.ToCmdLine("SET", "after", "rewrite"))
+	aofWriteDB.Close()
+
+	manifest, err := ioutil.ReadFile(aofFilename + ".manifest")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "file a.aof.1.base.rdb seq 1 type b\nfile a.aof.2.incr.aof seq 2 type i\n"
+	if string(manifest) != expected {
+		t.Errorf("wrong manifest: %s", manifest)
+	}
+	if _, err := os.Stat(aofFilename + ".1.incr.aof"); !os.IsNotExist(err) {
+		t.Error("merged incremental file should be removed")
+	}
+
+	aofReadDB := NewStandaloneServer()
+	for i := 0; i < dbNum; i++ {
+		validateTestData(t, aofReadDB, i, prefixes[i], size)
+	}
+	ret = aofReadDB.Exec(conn, utils.ToCmdLine("ZRANGE", "z", "0", "-1", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"a", "-Inf", "b", "1.5"})
+	ret = aofReadDB.Exec(conn, utils.ToCmdLine("GET", "after"))
+	asserts.AssertBulkReply(t, ret, "rewrite")
+	aofReadDB.Close()
+}
+
+func TestAofUpgrade(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofFilename := path.Join(tmpDir, "a.aof")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	// aof written by older version
+	data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", "2")).ToBytes()
+	data = append(data, reply.MakeMultiBulkReply(utils.ToCmdLine("SET", "a", "a")).ToBytes()...)
+	err = ioutil.WriteFile(aofFilename, data, 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendDirname:  path.Join(tmpDir, "appendonlydir"),
+	})
+	db := NewStandaloneServer()
+	conn := &connection.FakeConn{}
+	conn.SelectDB(2)
+	ret := db.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "a")
+	db.Exec(conn, utils.ToCmdLine("SET", "b", "b"))
+	db.Close()
+
+	manifest, err := ioutil.ReadFile(path.Join(tmpDir, "appendonlydir", "a.aof.manifest"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "file a.aof seq 1 type b\nfile a.aof.1.incr.aof seq 1 type i\n"
+	if string(manifest) != expected {
+		t.Errorf("wrong manifest: %s", manifest)
+	}
+	db = NewStandaloneServer()
+	ret = db.Exec(conn, utils.ToCmdLine("MGET", "a", "b"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"a", "b"})
+	db.Close()
+}
+
+func TestAutoRewriteAOF(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofFilename := path.Join(tmpDir, "a.aof")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:               true,
+		AppendFilename:           aofFilename,
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    1024,
+	})
+	db := NewStandaloneServer()
+	conn := &connection.FakeConn{}
+	// overwrite the same key, so rewritten aof is much smaller
+	for i := 0; i < 100; i++ {
+		db.Exec(conn, utils.ToCmdLine("SET", "a", strconv.Itoa(i)))
+	}
+	time.Sleep(time.Second)
+	db.Close()
+	if _, err := os.Stat(aofFilename + ".1.base.aof"); err != nil {
+		t.Error("expect aof rewritten automatically")
+	}
+	db = NewStandaloneServer()
+	ret := db.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "99")
+	db.Close()
+}
+
+func TestShutdownSave(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofFilename := path.Join(tmpDir, "a.aof")
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	config.SetProperties(&config.ServerProperties{
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncNo,
+	})
+	conn := &connection.FakeConn{}
+	db := NewStandaloneServer()
+	ret := db.Exec(conn, utils.ToCmdLine("SHUTDOWN", "SAVE"))
+	asserts.AssertErrReply(t, ret, "ERR Errors trying to SHUTDOWN. Check logs.")
+	db.Close()
+
+	props := *config.Properties()
+	props.AppendOnly = true
+	config.SetProperties(&props)
+	db = NewStandaloneServer()
+	prefix := utils.RandString(8)
+	makeTestData(db, 0, prefix, 10)
+	ret = db.Exec(conn, utils.ToCmdLine("SHUTDOWN", "SAVE"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	db.Exec(conn, utils.ToCmdLine("SET", "after", "save"))
+	// closing twice is safe
+	db.Close()
+	db.Close()
+
+	manifest, err := ioutil.ReadFile(aofFilename + ".manifest")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "file a.aof.1.base.aof seq 1 type b\nfile a.aof.2.incr.aof seq 2 type i\n"
+	if string(manifest) != expected {
+		t.Errorf("wrong manifest: %s", manifest)
+	}
+	db = NewStandaloneServer()
+	validateTestData(t, db, 0, prefix, 10)
+	ret = db.Exec(conn, utils.ToCmdLine("GET", "after"))
+	asserts.AssertBulkReply(t, ret, "save")
+	db.Close()
+}
+
+func TestAofAfterClose(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: path.Join(tmpDir, "a.aof"),
+	})
+	conn := &connection.FakeConn{}
+	db := NewStandaloneServer()
+	db.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	db.Close()
+	select {
+	case <-db.cronDone:
+	default:
+		t.Error("serverCron is still running after Close")
+	}
+	// writes after Close are dropped instead of sending on closed channel, it panics in background goroutines
+	db.aofHandler.AddAof(0, utils.ToCmdLine("SET", "b", "2"))
+
+	db = NewStandaloneServer()
+	ret := db.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "1")
+	ret = db.Exec(conn, utils.ToCmdLine("GET", "b"))
+	asserts.AssertNullBulk(t, ret)
+	db.Close()
+}
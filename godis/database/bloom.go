@@ -0,0 +1,493 @@
+package database
+
+import (
+	"godis/datastruct/bloom"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// default options of filters created by BF.ADD and CF.ADD, same as RedisBloom
+const (
+	defaultBloomErrorRate  = 0.01
+	defaultBloomCapacity   = 100
+	defaultBloomExpansion  = 2
+	defaultCuckooCapacity  = 1024
+	defaultCuckooExpansion = 1
+)
+
+func (db *DB) getAsBloomFilter(key string) (*bloom.ScalableFilter, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	filter, ok := entity.Data.(*bloom.ScalableFilter)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return filter, nil
+}
+
+func (db *DB) getOrInitBloomFilter(key string) (filter *bloom.ScalableFilter, inited bool, errReply reply.ErrorReply) {
+	filter, errReply = db.getAsBloomFilter(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	if filter == nil {
+		filter, _ = bloom.New(defaultBloomErrorRate, defaultBloomCapacity, defaultBloomExpansion, false)
+		db.PutEntity(key, &database.DataEntity{
+			Data: filter,
+		})
+		inited = true
+	}
+	return filter, inited, nil
+}
+
+func (db *DB) getAsCuckooFilter(key string) (*bloom.CuckooFilter, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	filter, ok := entity.Data.(*bloom.CuckooFilter)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return filter, nil
+}
+
+func (db *DB) getOrInitCuckooFilter(key string) (filter *bloom.CuckooFilter, inited bool, errReply reply.ErrorReply) {
+	filter, errReply = db.getAsCuckooFilter(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	if filter == nil {
+		filter, _ = bloom.NewCuckoo(defaultCuckooCapacity, bloom.DefaultBucketSize, bloom.DefaultMaxIterations, defaultCuckooExpansion)
+		db.PutEntity(key, &database.DataEntity{
+			Data: filter,
+		})
+		inited = true
+	}
+	return filter, inited, nil
+}
+
+func bloomErrReply(err error) redis.Reply {
+	return reply.MakeErrReply("ERR " + err.Error())
+}
+
+// execBFReserve creates an empty bloom filter: BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func execBFReserve(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR bad error rate")
+	}
+	capacity, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR bad capacity")
+	}
+	expansion := int64(defaultBloomExpansion)
+	nonScaling := false
+	expansionGiven := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			i++
+			expansion, err = strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil || expansion < 1 {
+				return reply.MakeErrReply("ERR expansion should be greater or equal to 1")
+			}
+			expansionGiven = true
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return reply.MakeErrReply("ERR (0 < error rate range < 1)")
+	}
+	if capacity <= 0 {
+		return reply.MakeErrReply("ERR (capacity should be larger than 0)")
+	}
+	if nonScaling && expansionGiven {
+		return reply.MakeErrReply("ERR Nonscaling filters cannot expand")
+	}
+	if _, exists := db.GetEntity(key); exists {
+		return reply.MakeErrReply("ERR item exists")
+	}
+	filter, err := bloom.New(errorRate, uint64(capacity), uint64(expansion), nonScaling)
+	if err != nil {
+		return bloomErrReply(err)
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: filter,
+	})
+	db.addAof(utils.ToCmdLine3("bf.reserve", args...))
+	return reply.MakeOkReply()
+}
+
+// execBFAdd adds an item into bloom filter, creates the filter if not exists
+func execBFAdd(db *DB, args [][]byte) redis.Reply {
+	result := execBFMAdd(db, args)
+	if multi, ok := result.(*reply.MultiRawReply); ok {
+		return multi.Replies[0]
+	}
+	return result
+}
+
+// execBFMAdd adds items into bloom filter, returns 1 for each item newly added and 0 for item may exist
+func execBFMAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, inited, errReply := db.getOrInitBloomFilter(key)
+	if errReply != nil {
+		return errReply
+	}
+	changed := inited
+	result := make([]redis.Reply, 0, len(args)-1)
+	for _, item := range args[1:] {
+		added, err := filter.Add(string(item))
+		if err != nil {
+			result = append(result, bloomErrReply(err))
+			continue
+		}
+		if added {
+			changed = true
+			result = append(result, reply.MakeIntReply(1))
+		} else {
+			result = append(result, reply.MakeIntReply(0))
+		}
+	}
+	if changed {
+		db.addAof(utils.ToCmdLine3("bf.madd", args...))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execBFExists tells whether item may exist in bloom filter
+func execBFExists(db *DB, args [][]byte) redis.Reply {
+	result := execBFMExists(db, args)
+	if multi, ok := result.(*reply.MultiRawReply); ok {
+		return multi.Replies[0]
+	}
+	return result
+}
+
+func execBFMExists(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsBloomFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		if filter != nil && filter.Exists(string(item)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execBFInfo returns options and usage of bloom filter: BF.INFO key [CAPACITY|SIZE|FILTERS|ITEMS|EXPANSION]
+func execBFInfo(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'bf.info' command")
+	}
+	filter, errReply := db.getAsBloomFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR not found")
+	}
+	info := []struct {
+		option string
+		name   string
+		value  int64
+	}{
+		{"CAPACITY", "Capacity", int64(filter.Capacity())},
+		{"SIZE", "Size", int64(filter.Size())},
+		{"FILTERS", "Number of filters", int64(filter.Layers())},
+		{"ITEMS", "Number of items inserted", int64(filter.Len())},
+		{"EXPANSION", "Expansion rate", int64(filter.Expansion())},
+	}
+	if len(args) == 2 {
+		option := strings.ToUpper(string(args[1]))
+		for _, field := range info {
+			if field.option == option {
+				return reply.MakeMultiRawReply([]redis.Reply{reply.MakeIntReply(field.value)})
+			}
+		}
+		return reply.MakeErrReply("ERR Invalid information value")
+	}
+	result := make([]redis.Reply, 0, 2*len(info))
+	for _, field := range info {
+		result = append(result, reply.MakeBulkReply([]byte(field.name)), reply.MakeIntReply(field.value))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// makeScanDumpReply returns the whole filter as one chunk: iterator 0 gets [1, data], then [0, nil] ends the dump
+func makeScanDumpReply(args [][]byte, marshal func() []byte) redis.Reply {
+	iter, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if iter != 0 {
+		return reply.MakeMultiRawReply([]redis.Reply{reply.MakeIntReply(0), reply.MakeNullBulkReply()})
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{reply.MakeIntReply(1), reply.MakeBulkReply(marshal())})
+}
+
+// execBFScanDump returns bloom filter in chunks to be restored by BF.LOADCHUNK
+func execBFScanDump(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsBloomFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR not found")
+	}
+	return makeScanDumpReply(args, filter.Marshal)
+}
+
+// execBFLoadChunk restores bloom filter dumped by BF.SCANDUMP, it is also used by aof rewrite
+func execBFLoadChunk(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if string(args[1]) != "1" {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	filter, err := bloom.Unmarshal(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: filter,
+	})
+	db.addAof(utils.ToCmdLine3("bf.loadchunk", args...))
+	return reply.MakeOkReply()
+}
+
+// execCFReserve creates an empty cuckoo filter:
+// CF.RESERVE key capacity [BUCKETSIZE bucketsize] [MAXITERATIONS maxiterations] [EXPANSION expansion]
+func execCFReserve(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	capacity, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || capacity <= 0 {
+		return reply.MakeErrReply("ERR Bad capacity")
+	}
+	bucketSize := int64(bloom.DefaultBucketSize)
+	maxIterations := int64(bloom.DefaultMaxIterations)
+	expansion := int64(defaultCuckooExpansion)
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		i++
+		value, err := strconv.ParseInt(string(args[i]), 10, 64)
+		switch option {
+		case "BUCKETSIZE":
+			if err != nil || value <= 0 || value > 255 {
+				return reply.MakeErrReply("ERR Bad bucket size")
+			}
+			bucketSize = value
+		case "MAXITERATIONS":
+			if err != nil || value <= 0 || value > 65535 {
+				return reply.MakeErrReply("ERR Bad max iterations")
+			}
+			maxIterations = value
+		case "EXPANSION":
+			if err != nil || value < 0 || value > 32768 {
+				return reply.MakeErrReply("ERR Bad expansion")
+			}
+			expansion = value
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if _, exists := db.GetEntity(key); exists {
+		return reply.MakeErrReply("ERR item exists")
+	}
+	filter, err := bloom.NewCuckoo(uint64(capacity), uint64(bucketSize), uint64(maxIterations), uint64(expansion))
+	if err != nil {
+		return bloomErrReply(err)
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: filter,
+	})
+	db.addAof(utils.ToCmdLine3("cf.reserve", args...))
+	return reply.MakeOkReply()
+}
+
+func cuckooAddErrReply(err error) redis.Reply {
+	if err == bloom.ErrCuckooFull {
+		return reply.MakeErrReply("ERR Filter is full")
+	}
+	return bloomErrReply(err)
+}
+
+// execCFAdd adds an item into cuckoo filter, the same item can be added more than once
+func execCFAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	// a new filter always has room for the first item
+	filter, _, errReply := db.getOrInitCuckooFilter(key)
+	if errReply != nil {
+		return errReply
+	}
+	if err := filter.Add(string(args[1])); err != nil {
+		return cuckooAddErrReply(err)
+	}
+	db.addAof(utils.ToCmdLine3("cf.add", args...))
+	return reply.MakeIntReply(1)
+}
+
+// execCFAddNX adds an item into cuckoo filter only if it does not exist
+func execCFAddNX(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsCuckooFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter != nil && filter.Exists(string(args[1])) {
+		return reply.MakeIntReply(0)
+	}
+	return execCFAdd(db, args)
+}
+
+// execCFExists tells whether item may exist in cuckoo filter
+func execCFExists(db *DB, args [][]byte) redis.Reply {
+	result := execCFMExists(db, args)
+	if multi, ok := result.(*reply.MultiRawReply); ok {
+		return multi.Replies[0]
+	}
+	return result
+}
+
+func execCFMExists(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsCuckooFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		if filter != nil && filter.Exists(string(item)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execCFCount returns the number of times the item may have been added
+func execCFCount(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsCuckooFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(filter.Count(string(args[1]))))
+}
+
+// execCFDel removes one occurrence of item from cuckoo filter
+func execCFDel(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsCuckooFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR Not found")
+	}
+	if !filter.Delete(string(args[1])) {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine3("cf.del", args...))
+	return reply.MakeIntReply(1)
+}
+
+// execCFInfo returns options and usage of cuckoo filter
+func execCFInfo(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsCuckooFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR Not found")
+	}
+	info := []struct {
+		name  string
+		value int64
+	}{
+		{"Size", int64(filter.Size())},
+		{"Number of buckets", int64(filter.Buckets())},
+		{"Number of filters", int64(filter.Layers())},
+		{"Number of items inserted", int64(filter.Len())},
+		{"Number of items deleted", int64(filter.Deleted())},
+		{"Bucket size", int64(filter.BucketSize())},
+		{"Expansion rate", int64(filter.Expansion())},
+		{"Max iterations", int64(filter.MaxIterations())},
+	}
+	result := make([]redis.Reply, 0, 2*len(info))
+	for _, field := range info {
+		result = append(result, reply.MakeBulkReply([]byte(field.name)), reply.MakeIntReply(field.value))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execCFScanDump returns cuckoo filter in chunks to be restored by CF.LOADCHUNK
+func execCFScanDump(db *DB, args [][]byte) redis.Reply {
+	filter, errReply := db.getAsCuckooFilter(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return reply.MakeErrReply("ERR Not found")
+	}
+	return makeScanDumpReply(args, filter.Marshal)
+}
+
+// execCFLoadChunk restores cuckoo filter dumped by CF.SCANDUMP, it is also used by aof rewrite
+func execCFLoadChunk(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if string(args[1]) != "1" {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	filter, err := bloom.UnmarshalCuckoo(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: filter,
+	})
+	db.addAof(utils.ToCmdLine3("cf.loadchunk", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("BF.Reserve", execBFReserve, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("BF.Add", execBFAdd, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("BF.MAdd", execBFMAdd, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("BF.Exists", execBFExists, readFirstKey, nil, 3)
+	RegisterCommand("BF.MExists", execBFMExists, readFirstKey, nil, -3)
+	RegisterCommand("BF.Info", execBFInfo, readFirstKey, nil, -2)
+	RegisterCommand("BF.ScanDump", execBFScanDump, readFirstKey, nil, 3)
+	RegisterCommand("BF.LoadChunk", execBFLoadChunk, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("CF.Reserve", execCFReserve, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("CF.Add", execCFAdd, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("CF.AddNX", execCFAddNX, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("CF.Exists", execCFExists, readFirstKey, nil, 3)
+	RegisterCommand("CF.MExists", execCFMExists, readFirstKey, nil, -3)
+	RegisterCommand("CF.Count", execCFCount, readFirstKey, nil, 3)
+	RegisterCommand("CF.Del", execCFDel, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("CF.Info", execCFInfo, readFirstKey, nil, 2)
+	RegisterCommand("CF.ScanDump", execCFScanDump, readFirstKey, nil, 3)
+	RegisterCommand("CF.LoadChunk", execCFLoadChunk, writeFirstKey, rollbackFirstKey, 4)
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// assertRawReplies checks actual is an array of replies in expected serialized form
+func assertRawReplies(t *testing.T, actual redis.Reply, expected ...string) {
+	t.Helper()
+	expectedBytes := "*" + strconv.Itoa(len(expected)) + "\r\n" + strings.Join(expected, "")
+	if string(actual.ToBytes()) != expectedBytes {
+		t.Errorf("expected %q, actually %q", expectedBytes, actual.ToBytes())
+	}
+}
+
+func assertRawRepliesSize(t *testing.T, actual redis.Reply, expected int) {
+	t.Helper()
+	multi, ok := actual.(*reply.MultiRawReply)
+	if !ok || len(multi.Replies) != expected {
+		t.Errorf("expected %d replies, actually %q", expected, actual.ToBytes())
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "0.01", "10", "EXPANSION", "3"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "0.01", "10"))
+	asserts.AssertErrReply(t, ret, "ERR item exists")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.ADD", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.ADD", key, "a"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.MADD", key, "a", "b", "c"))
+	assertRawReplies(t, ret, ":0\r\n", ":1\r\n", ":1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.EXISTS", key, "b"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.MEXISTS", key, "c", "d"))
+	assertRawReplies(t, ret, ":1\r\n", ":0\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "MBbloom--")
+
+	cmdLine := []string{"BF.MADD", key}
+	for i := 0; i < 30; i++ {
+		cmdLine = append(cmdLine, strconv.Itoa(i))
+	}
+	testServer.Exec(conn, utils.ToCmdLine(cmdLine...))
+	for _, item := range cmdLine[2:] {
+		ret = testServer.Exec(conn, utils.ToCmdLine("BF.EXISTS", key, item))
+		asserts.AssertIntReply(t, ret, 1)
+	}
+	// 10 + 30
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.INFO", key, "CAPACITY"))
+	assertRawReplies(t, ret, ":40\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.INFO", key, "FILTERS"))
+	assertRawReplies(t, ret, ":2\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.INFO", key))
+	assertRawRepliesSize(t, ret, 10)
+
+	// BF.ADD creates filter
+	key2 := utils.RandString(10)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.EXISTS", key2, "a"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.ADD", key2, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.INFO", key2, "CAPACITY"))
+	assertRawReplies(t, ret, ":100\r\n")
+}
+
+func TestBloomFilterErr(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "1.5", "10"))
+	asserts.AssertErrReply(t, ret, "ERR (0 < error rate range < 1)")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "0.1", "0"))
+	asserts.AssertErrReply(t, ret, "ERR (capacity should be larger than 0)")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "0.1", "10", "EXPANSION", "0"))
+	asserts.AssertErrReply(t, ret, "ERR expansion should be greater or equal to 1")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "0.1", "10", "EXPANSION", "2", "NONSCALING"))
+	asserts.AssertErrReply(t, ret, "ERR Nonscaling filters cannot expand")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.INFO", key))
+	asserts.AssertErrReply(t, ret, "ERR not found")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.RESERVE", key, "0.001", "2", "NONSCALING"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.MADD", key, "a", "b", "c"))
+	assertRawReplies(t, ret, ":1\r\n", ":1\r\n", "-ERR non scaling filter is full\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.INFO", key, "EXPANSION"))
+	assertRawReplies(t, ret, ":0\r\n")
+
+	testServer.Exec(conn, utils.ToCmdLine("SET", key+"str", "a"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.ADD", key+"str", "a"))
+	asserts.AssertErrReply(t, ret, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.EXISTS", key, "a"))
+	asserts.AssertErrReply(t, ret, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestCuckooFilter(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("CF.RESERVE", key, "4", "BUCKETSIZE", "2", "EXPANSION", "0"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "MBbloomCF")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.ADD", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.ADD", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.ADDNX", key, "a"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.COUNT", key, "a"))
+	asserts.AssertIntReply(t, ret, 2)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.DEL", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.EXISTS", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.DEL", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.DEL", key, "a"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.MEXISTS", key, "a", "b"))
+	assertRawReplies(t, ret, ":0\r\n", ":0\r\n")
+
+	// filter with 2 buckets could not expand
+	var errReply reply.ErrorReply
+	for i := 0; i < 10 && errReply == nil; i++ {
+		ret = testServer.Exec(conn, utils.ToCmdLine("CF.ADD", key, strconv.Itoa(i)))
+		errReply, _ = ret.(reply.ErrorReply)
+	}
+	asserts.AssertErrReply(t, ret, "ERR Filter is full")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.INFO", key))
+	assertRawRepliesSize(t, ret, 16)
+
+	key2 := utils.RandString(10)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.DEL", key2, "a"))
+	asserts.AssertErrReply(t, ret, "ERR Not found")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.RESERVE", key2, "0"))
+	asserts.AssertErrReply(t, ret, "ERR Bad capacity")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.RESERVE", key2, "10", "BUCKETSIZE", "256"))
+	asserts.AssertErrReply(t, ret, "ERR Bad bucket size")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.ADD", key2, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.RESERVE", key2, "10"))
+	asserts.AssertErrReply(t, ret, "ERR item exists")
+}
+
+func TestFilterDump(t *testing.T) {
+	conn := &connection.FakeConn{}
+	bf := utils.RandString(10)
+	cf := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("BF.MADD", bf, "a", "b"))
+	testServer.Exec(conn, utils.ToCmdLine("CF.ADD", cf, "a"))
+
+	// restore by chunks of scan dump
+	ret := testServer.Exec(conn, utils.ToCmdLine("BF.SCANDUMP", bf, "0"))
+	chunks, ok := ret.(*reply.MultiRawReply)
+	if !ok || len(chunks.Replies) != 2 {
+		t.Fatalf("unexpected scan dump reply %s", ret.ToBytes())
+	}
+	asserts.AssertIntReply(t, chunks.Replies[0], 1)
+	data := chunks.Replies[1].(*reply.BulkReply).Arg
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.SCANDUMP", bf, "1"))
+	assertRawReplies(t, ret, ":0\r\n", "$-1\r\n")
+	bf2 := utils.RandString(10)
+	ret = testServer.Exec(conn, [][]byte{[]byte("BF.LOADCHUNK"), []byte(bf2), []byte("1"), data})
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.MEXISTS", bf2, "a", "b"))
+	assertRawReplies(t, ret, ":1\r\n", ":1\r\n")
+	ret = testServer.Exec(conn, [][]byte{[]byte("BF.LOADCHUNK"), []byte(bf2), []byte("1"), data[1:]})
+	asserts.AssertErrReply(t, ret, "ERR received bad data")
+
+	// DUMP and COPY use the same serialization as aof rewrite
+	ret = testServer.Exec(conn, utils.ToCmdLine("DUMP", cf))
+	payload := ret.(*reply.BulkReply).Arg
+	cf2 := utils.RandString(10)
+	ret = testServer.Exec(conn, [][]byte{[]byte("RESTORE"), []byte(cf2), []byte("0"), payload})
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CF.EXISTS", cf2, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", cf2))
+	asserts.AssertStatusReply(t, ret, "MBbloomCF")
+	bf3 := utils.RandString(10)
+	ret = testServer.Exec(conn, utils.ToCmdLine("COPY", bf, bf3))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("BF.EXISTS", bf3, "b"))
+	asserts.AssertIntReply(t, ret, 1)
+}
+
+func TestUndoBloom(t *testing.T) {
+	key := utils.RandString(10)
+	execBFAdd(testDB, utils.ToCmdLine(key, "a"))
+	undoCmdLines := rollbackFirstKey(testDB, utils.ToCmdLine(key, "b"))
+	execBFAdd(testDB, utils.ToCmdLine(key, "b"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	ret := execBFMExists(testDB, utils.ToCmdLine(key, "a", "b"))
+	assertRawReplies(t, ret, ":1\r\n", ":0\r\n")
+}
@@ -2,15 +2,16 @@
 package database
 
 import (
+	"godis/config"
 	"godis/datastruct/dict"
 	"godis/datastruct/lock"
 	"godis/interface/database"
 	"godis/interface/redis"
-	"godis/lib/logger"
-	"godis/lib/timewheel"
+	"godis/lib/utils"
 	"godis/redis/reply"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,9 +34,21 @@ type DB struct {
 	// dict.Dict will ensure concurrent-safety of its method
 	// use this mutex for complicated command only, eg. rpush, incr ...
 	locker *lock.Locks
-	// stop all data access for execFlushDB
-	stopWorld sync.WaitGroup
+	// commands and background jobs accessing data hold the read lock,
+	// Flush, FlushAsync and SwapDB hold the write lock to replace data without in-flight commands
+	stopWorld sync.RWMutex
 	addAof    func(CmdLine)
+	// notifies clients caching modified keys, nil if tracking is not supported
+	tracking *tracker
+	// full-text indexes of hashes, see search.go
+	indexes *searchIndexes
+	// keyspace hooks are notified only if it is set, so that loading aof and basic DB don't notify them
+	keyspaceEvents bool
+
+	// statistics of lazy and active expiration, see expire.go
+	expiredKeys    int64
+	staleRatio     uint64 // math.Float64bits of estimated stale keys percentage
+	timeCapReached int64
 }
 
 // ExecFunc is interface for command executor
@@ -46,6 +59,10 @@ type ExecFunc func(db *DB, args [][]byte) redis.Reply
 // returns related write keys and read keys
 type PreFunc func(args [][]byte) ([]string, []string)
 
+// DataPreFunc returns write keys which can only be found by reading data, such as destination keys of compaction rules.
+// They are locked together with keys returned by PreFunc
+type DataPreFunc func(db *DB, args [][]byte) []string
+
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
@@ -61,6 +78,7 @@ func makeDB() *DB {
 		versionMap: dict.MakeConcurrent(dataDictSize),
 		locker:     lock.Make(lockerSize),
 		addAof:     func(line CmdLine) {},
+		indexes:    makeSearchIndexes(),
 	}
 	return db
 }
@@ -74,6 +92,7 @@ func makeBasicDB() *DB {
 		versionMap: dict.MakeSimple(),
 		locker:     lock.Make(1),
 		addAof:     func(line CmdLine) {},
+		indexes:    makeSearchIndexes(),
 	}
 	return db
 }
@@ -101,32 +120,56 @@ func (db *DB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 			return reply.MakeArgNumErrReply(cmdName)
 		}
 		return Watch(db, c, cmdLine[1:])
+	} else if cmdName == "unwatch" {
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(c)
 	}
 	if c != nil && c.InMultiState() {
-		EnqueueCmd(c, cmdLine)
-		return reply.MakeQueuedReply()
+		return EnqueueCmd(c, cmdLine)
+	}
+	if cmdName == "flushdb" {
+		// flushdb stops the world by itself, so it cannot hold the read lock like normal commands
+		return execFlushDB(db, cmdLine[1:])
 	}
 
-	return db.execNormalCommand(cmdLine)
+	return db.execNormalCommand(c, cmdLine)
 }
 
-func (db *DB) execNormalCommand(cmdLine [][]byte) redis.Reply {
+func (db *DB) execNormalCommand(c redis.Connection, cmdLine [][]byte) redis.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
 	}
+	if errReply := cmd.refuseInternal(c, cmdName); errReply != nil {
+		return errReply
+	}
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
 
 	prepare := cmd.prepare
 	write, read := prepare(cmdLine[1:])
+	var dataPrepare func() []string
+	if cmd.dataPrepare != nil {
+		dataPrepare = func() []string {
+			return cmd.dataPrepare(db, cmdLine[1:])
+		}
+	}
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+	locked := db.lockWithData(write, read, dataPrepare)
+	defer db.RWUnLocks(locked, read)
+	result := cmd.exec(db, cmdLine)
+	// keys are still locked, so readers cannot see the new value before version changed and caches invalidated
 	db.addVersion(write...)
-	db.RWLocks(write, read)
-	defer db.RWUnLocks(write, read)
-	fun := cmd.executor
-	return fun(db, cmdLine[1:])
+	db.tracking.recordRead(c, read)
+	if !reply.IsErrorReply(result) {
+		db.notifyKeyspace(cmdName, write)
+	}
+	return result
 }
 
 func validateArity(arity int, cmdArgs [][]byte) bool {
@@ -141,8 +184,6 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 
 // GetEntity returns DataEntity bind to given key
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
-	db.stopWorld.Wait()
-
 	raw, ok := db.data.Get(key)
 	if !ok {
 		return nil, false
@@ -156,34 +197,38 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 
 // PutEntity a DataEntity into DB
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	db.stopWorld.Wait()
-	return db.data.Put(key, entity)
+	result := db.data.Put(key, entity)
+	db.indexes.update(key, entity)
+	return result
 }
 
 // PutIfExists edit an existing DataEntity
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	db.stopWorld.Wait()
-	return db.data.PutIfExists(key, entity)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 {
+		db.indexes.update(key, entity)
+	}
+	return result
 }
 
 // PutIfAbsent insert an DataEntity only if the key not exists
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	db.stopWorld.Wait()
-	return db.data.PutIfAbsent(key, entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.indexes.update(key, entity)
+	}
+	return result
 }
 
 // Remove the given key from db
 func (db *DB) Remove(key string) {
-	db.stopWorld.Wait()
 	db.data.Remove(key)
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
+	db.indexes.unindex(key)
 }
 
 // Removes the given keys from db
 func (db *DB) Removes(keys ...string) (deleted int) {
-	db.stopWorld.Wait()
 	deleted = 0
 	for _, key := range keys {
 		_, exists := db.data.Get(key)
@@ -195,20 +240,20 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 	return deleted
 }
 
-// Flush clean database
+// Flush clean database, invoker should not hold the read lock of stopWorld
 func (db *DB) Flush() {
-	db.stopWorld.Add(1)
-	defer db.stopWorld.Done()
+	db.stopWorld.Lock()
+	defer db.stopWorld.Unlock()
 
 	db.data.Clear()
 	db.ttlMap.Clear()
-	db.locker = lock.Make(lockerSize)
-
+	db.indexes.clear()
+	// locker is kept, keys may be locked by prepared transactions of cluster
 }
 
 /* ---- Lock Function ----- */
 
-// RWLocks lock keys for writing and reading
+// RWLocks lock keys for writing and reading, invoker should hold the read lock of stopWorld
 func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
 	db.locker.RWLocks(writeKeys, readKeys)
 }
@@ -220,42 +265,19 @@ func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
 
 /* ---- TTL Functions ---- */
 
-func genExpireTask(key string) string {
-	return "expire:" + key
-}
-
 // Expire sets ttlCmd of key
+// expired keys are removed lazily by IsExpired or periodically by activeExpireCycle
 func (db *DB) Expire(key string, expireTime time.Time) {
-	db.stopWorld.Wait()
 	db.ttlMap.Put(key, expireTime)
-	taskKey := genExpireTask(key)
-	timewheel.At(expireTime, taskKey, func() {
-		keys := []string{key}
-		db.RWLocks(keys, nil)
-		defer db.RWUnLocks(keys, nil)
-		// check-lock-check, ttl may be updated during waiting lock
-		logger.Info("expire " + key)
-		rawExpireTime, ok := db.ttlMap.Get(key)
-		if !ok {
-			return
-		}
-		expireTime, _ := rawExpireTime.(time.Time)
-		expired := time.Now().After(expireTime)
-		if expired {
-			db.Remove(key)
-		}
-	})
 }
 
 // Persist cancel ttlCmd of key
 func (db *DB) Persist(key string) {
-	db.stopWorld.Wait()
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
 }
 
 // IsExpired check whether a key is expired
+// the expired key will be removed and a DEL command will be appended into aof
 func (db *DB) IsExpired(key string) bool {
 	rawExpireTime, ok := db.ttlMap.Get(key)
 	if !ok {
@@ -264,11 +286,58 @@ func (db *DB) IsExpired(key string) bool {
 	expireTime, _ := rawExpireTime.(time.Time)
 	expired := time.Now().After(expireTime)
 	if expired {
-		db.Remove(key)
+		// invoker may hold the read lock of key only, other readers may still be iterating the value
+		db.expireKey(key, false)
 	}
 	return expired
 }
 
+// expireKey removes an expired key and propagates the deletion
+// invoker should hold the lock of key. Value is released in background by lazyfree-lazy-expire only if free is true,
+// which requires the write lock of key, otherwise the reference is dropped and left to gc
+func (db *DB) expireKey(key string, free bool) {
+	if free && config.Properties().LazyfreeLazyExpire {
+		db.Unlink(key)
+	} else {
+		db.Remove(key)
+	}
+	db.addVersion(key)
+	db.addAof(utils.ToCmdLine("DEL", key))
+	db.notifyKeyspace("expired", []string{key})
+	atomic.AddInt64(&db.expiredKeys, 1)
+}
+
+// lockWithData locks keys together with write keys found by dataPrepare, returns all write keys locked.
+// Data may be changed before the keys locked, so dataPrepare is called again after locking,
+// and locking is retried if it finds more keys
+func (db *DB) lockWithData(write []string, read []string, dataPrepare func() []string) []string {
+	if dataPrepare == nil {
+		db.RWLocks(write, read)
+		return write
+	}
+	for {
+		locked := append(write[:len(write):len(write)], dataPrepare()...)
+		db.RWLocks(locked, read)
+		if containsAll(locked, dataPrepare()) {
+			return locked
+		}
+		db.RWUnLocks(locked, read)
+	}
+}
+
+func containsAll(keys []string, subset []string) bool {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	for _, key := range subset {
+		if _, ok := set[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 /* --- add version --- */
 
 func (db *DB) addVersion(keys ...string) {
@@ -276,6 +345,7 @@ func (db *DB) addVersion(keys ...string) {
 		versionCode := db.GetVersion(key)
 		db.versionMap.Put(key, versionCode+1)
 	}
+	db.tracking.invalidate(keys)
 }
 
 // GetVersion returns version code for given key
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"godis/aof"
+	"godis/datastruct/set"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/parser"
+	"godis/redis/reply"
+	"hash/crc64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * DUMP payload layout:
+ * | body: command line to rebuild the value, encoded in RESP, with an empty key | version: 2 bytes | checksum: 8 bytes |
+ * version and checksum are little endian, checksum is CRC-64/ECMA of body and version
+ */
+
+const (
+	dumpVersion       = 1
+	dumpTrailerLength = 2 + 8
+)
+
+var (
+	crc64Table = crc64.MakeTable(crc64.ECMA)
+
+	errBadDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+)
+
+// EncodeDumpPayload serializes the given entity into DUMP payload
+func EncodeDumpPayload(entity *database.DataEntity) []byte {
+	cmd := aof.EntityToCmd("", entity)
+	if cmd == nil {
+		return nil
+	}
+	return makeDumpPayload(cmd.ToBytes())
+}
+
+// makeDumpPayload appends version and checksum to body
+func makeDumpPayload(body []byte) []byte {
+	payload := make([]byte, len(body)+dumpTrailerLength)
+	copy(payload, body)
+	checksumPos := len(payload) - 8
+	binary.LittleEndian.PutUint16(payload[len(body):checksumPos], dumpVersion)
+	checksum := crc64.Checksum(payload[:checksumPos], crc64Table)
+	binary.LittleEndian.PutUint64(payload[checksumPos:], checksum)
+	return payload
+}
+
+// DecodeDumpPayload verifies the DUMP payload and returns command line to rebuild the value for the given key
+func DecodeDumpPayload(key string, payload []byte) (CmdLine, error) {
+	if len(payload) <= dumpTrailerLength {
+		return nil, errBadDumpPayload
+	}
+	checksumPos := len(payload) - 8
+	checksum := binary.LittleEndian.Uint64(payload[checksumPos:])
+	if crc64.Checksum(payload[:checksumPos], crc64Table) != checksum {
+		return nil, errBadDumpPayload
+	}
+	versionPos := checksumPos - 2
+	version := binary.LittleEndian.Uint16(payload[versionPos:checksumPos])
+	if version > dumpVersion {
+		return nil, errBadDumpPayload
+	}
+	raw, err := parser.ParseOne(payload[:versionPos])
+	if err != nil {
+		return nil, errBadDumpPayload
+	}
+	cmd, ok := raw.(*reply.MultiBulkReply)
+	if !ok || len(cmd.Args) < 3 {
+		return nil, errBadDumpPayload
+	}
+	cmdLine := make(CmdLine, len(cmd.Args))
+	copy(cmdLine, cmd.Args)
+	cmdLine[1] = []byte(key)
+	if !isRestoreCmdLine(key, cmdLine) {
+		return nil, errBadDumpPayload
+	}
+	return cmdLine, nil
+}
+
+// restoreCommands are commands generated by aof.EntityToCmd to rebuild a value
+var restoreCommands = set.Make(
+	"set", "rpush", "sadd", "hmset", "zadd", "json.set",
+	"bf.loadchunk", "cf.loadchunk", "cms.loadchunk", "topk.loadchunk", "ts.loadchunk",
+	"lock.load", "module.loadvalue",
+)
+
+// isRestoreCmdLine checks the payload only rebuilds the value of the given key,
+// since RESTORE executes it with the lock of the key only
+func isRestoreCmdLine(key string, cmdLine CmdLine) bool {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !restoreCommands.Has(cmdName) {
+		return false
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return false
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	for _, k := range append(write, read...) {
+		if k != key {
+			return false
+		}
+	}
+	return true
+}
+
+// execDump returns serialized value stored at key
+func execDump(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	payload := EncodeDumpPayload(entity)
+	if payload == nil {
+		return reply.MakeErrReply("ERR unsupported value type")
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+// execRestore creates a key using the value obtained by DUMP
+// usage: RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+func execRestore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace := false
+	absTTL := false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(string(arg)) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	cmdLine, err := DecodeDumpPayload(key, args[2])
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	_, exists := db.GetEntity(key)
+	if exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.Unix(0, ttl*int64(time.Millisecond))
+		} else {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		if expireAt.Before(time.Now()) {
+			// the key has expired, no need to restore it
+			if exists {
+				db.Remove(key)
+				db.addAof(utils.ToCmdLine("DEL", key))
+			}
+			return &reply.OkReply{}
+		}
+	}
+	// rebuild value in a scratch db, so that the existing value is kept if payload is broken
+	scratch := makeBasicDB()
+	result := scratch.execWithLock(cmdLine)
+	entity, ok := scratch.GetEntity(key)
+	if reply.IsErrorReply(result) || !ok {
+		return reply.MakeErrReply("ERR Bad data format")
+	}
+	if exists {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("DEL", key))
+	}
+	db.PutEntity(key, entity)
+	db.addAof(cmdLine)
+	if ttl > 0 {
+		db.Expire(key, expireAt)
+		db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
+	}
+	return &reply.OkReply{}
+}
+
+func init() {
+	RegisterCommand("Dump", execDump, readFirstKey, nil, 2)
+	RegisterCommand("Restore", execRestore, writeFirstKey, rollbackFirstKey, -4)
+}
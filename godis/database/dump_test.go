@@ -0,0 +1,112 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDumpRestore(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", src, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", src, "b", "2"))
+	result := testDB.Exec(nil, utils.ToCmdLine("dump", src))
+	bulk, ok := result.(*reply.BulkReply)
+	if !ok {
+		t.Errorf("expected bulk reply, actually %s", result.ToBytes())
+		return
+	}
+	payload := bulk.Arg
+
+	result = testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(dest), []byte("0"), payload))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("hget", dest, "b"))
+	asserts.AssertBulkReply(t, result, "2")
+	result = testDB.Exec(nil, utils.ToCmdLine("ttl", dest))
+	asserts.AssertIntReply(t, result, -1)
+
+	result = testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(dest), []byte("0"), payload))
+	asserts.AssertErrReply(t, result, "BUSYKEY Target key name already exists.")
+	result = testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(dest), []byte("100000"), payload, []byte("REPLACE")))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("ttl", dest))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actually %s", result.ToBytes())
+	}
+
+	// expired absolute ttl
+	expireAt := strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano()/1e6, 10)
+	result = testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(dest), []byte(expireAt), payload, []byte("REPLACE"), []byte("ABSTTL")))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", dest))
+	asserts.AssertIntReply(t, result, 0)
+
+	// corrupted payload
+	broken := make([]byte, len(payload))
+	copy(broken, payload)
+	broken[0] = '+'
+	result = testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(dest), []byte("0"), broken))
+	asserts.AssertErrReply(t, result, "ERR DUMP payload version or checksum are wrong")
+
+	// payload must not touch other keys
+	victim := utils.RandString(10)
+	for _, cmdLine := range [][][]byte{
+		utils.ToCmdLine("MSET", "", "v", victim, "pwned"),
+		utils.ToCmdLine("RPOPLPUSH", "", victim),
+	} {
+		forged := makeDumpPayload(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+		result = testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(dest), []byte("0"), forged, []byte("REPLACE")))
+		asserts.AssertErrReply(t, result, "ERR DUMP payload version or checksum are wrong")
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", victim))
+	asserts.AssertIntReply(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("dump", utils.RandString(10)))
+	asserts.AssertNullBulk(t, result)
+}
+
+func TestDumpPayload(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	cmdLines := [][][]byte{
+		utils.ToCmdLine("set", key, "value"),
+		utils.ToCmdLine("rpush", key, "a", "b", "c"),
+		utils.ToCmdLine("sadd", key, "a", "b", "c"),
+		utils.ToCmdLine("zadd", key, "1.5", "a", "2", "b"),
+	}
+	for _, cmdLine := range cmdLines {
+		testDB.Remove(key)
+		testDB.Exec(nil, cmdLine)
+		entity, _ := testDB.GetEntity(key)
+		payload := EncodeDumpPayload(entity)
+		decoded, err := DecodeDumpPayload("restored", payload)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(decoded[1]) != "restored" {
+			t.Errorf("wrong key: %s", decoded[1])
+		}
+	}
+}
+
+func TestRestoreReplaceBadData(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "old"))
+	for _, cmdLine := range [][][]byte{
+		utils.ToCmdLine("ts.loadchunk", "", "x", "bad"),
+		utils.ToCmdLine("module.loadvalue", "", "not-loaded", "1"),
+	} {
+		payload := makeDumpPayload(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+		result := testDB.Exec(nil, utils.ToCmdLine3("restore", []byte(key), []byte("0"), payload, []byte("REPLACE")))
+		asserts.AssertErrReply(t, result, "ERR Bad data format")
+		result = testDB.Exec(nil, utils.ToCmdLine("get", key))
+		asserts.AssertBulkReply(t, result, "old")
+	}
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"godis/config"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// parameters of active expiration, see activeExpireCycle in redis/src/expire.c
+const (
+	defaultHz = 10
+
+	activeExpireKeysPerLoop       = 20 // keys sampled per loop
+	activeExpireCycleSlowTimePerc = 25 // max percentage of cpu time per cycle
+	activeExpireAcceptableStale   = 10 // percentage of stale keys acceptable
+	defaultActiveExpireEffort     = 1
+	maxActiveExpireEffort         = 10
+)
+
+// expireEffort holds the sampling parameters derived from `active-expire-effort`
+type expireEffort struct {
+	keysPerLoop     int
+	acceptableStale int
+	timeLimit       time.Duration
+}
+
+// makeExpireEffort adjusts sampling parameters by config, greater effort means more cpu spent on expiring keys
+func makeExpireEffort(hz int, effort int) *expireEffort {
+	if hz <= 0 {
+		hz = defaultHz
+	}
+	if effort <= 0 {
+		effort = defaultActiveExpireEffort
+	} else if effort > maxActiveExpireEffort {
+		effort = maxActiveExpireEffort
+	}
+	effort-- // rescale from 1~10 to 0~9
+	timePerc := activeExpireCycleSlowTimePerc + 2*effort
+	return &expireEffort{
+		keysPerLoop:     activeExpireKeysPerLoop + activeExpireKeysPerLoop/4*effort,
+		acceptableStale: activeExpireAcceptableStale - effort,
+		timeLimit:       time.Second / time.Duration(hz) * time.Duration(timePerc) / 100,
+	}
+}
+
+// expireIfNeeded removes the given key if it has expired, returns whether the key is removed
+func (db *DB) expireIfNeeded(key string, now time.Time) bool {
+	keys := []string{key}
+	db.RWLocks(keys, nil)
+	defer db.RWUnLocks(keys, nil)
+	// check-lock-check, ttl may be updated during waiting lock
+	rawExpireTime, ok := db.ttlMap.Get(key)
+	if !ok {
+		return false
+	}
+	expireTime, _ := rawExpireTime.(time.Time)
+	if !now.After(expireTime) {
+		return false
+	}
+	db.expireKey(key, true)
+	return true
+}
+
+// activeExpireCycle samples keys with ttl and removes the expired ones.
+// It keeps sampling while the percentage of expired keys in the last loop is above acceptable stale,
+// and returns false if it stops because of the deadline
+func (db *DB) activeExpireCycle(effort *expireEffort, deadline time.Time) bool {
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+	sampled := 0
+	expired := 0
+	finished := true
+	for {
+		size := db.ttlMap.Len()
+		if size == 0 {
+			break
+		}
+		limit := effort.keysPerLoop
+		if limit > size {
+			limit = size
+		}
+		keys := db.ttlMap.RandomDistinctKeys(limit)
+		if len(keys) == 0 {
+			break
+		}
+		now := time.Now()
+		loopExpired := 0
+		for _, key := range keys {
+			if db.expireIfNeeded(key, now) {
+				loopExpired++
+			}
+		}
+		sampled += len(keys)
+		expired += loopExpired
+		if loopExpired*100/len(keys) <= effort.acceptableStale {
+			break
+		}
+		if time.Now().After(deadline) {
+			atomic.AddInt64(&db.timeCapReached, 1)
+			finished = false
+			break
+		}
+	}
+	if sampled > 0 {
+		// exponential moving average like redis
+		current := float64(expired) / float64(sampled)
+		previous := math.Float64frombits(atomic.LoadUint64(&db.staleRatio))
+		atomic.StoreUint64(&db.staleRatio, math.Float64bits(current*0.05+previous*0.95))
+	}
+	return finished
+}
+
+// ExpireStats records metrics of key expiration
+type ExpireStats struct {
+	ExpiredKeys    int64   // keys removed by lazy and active expiration
+	StaleRatio     float64 // estimated ratio of logically expired keys still in memory
+	TimeCapReached int64   // times of active expire cycle stopped by time limit
+}
+
+// GetExpireStats returns metrics of key expiration
+func (db *DB) GetExpireStats() *ExpireStats {
+	return &ExpireStats{
+		ExpiredKeys:    atomic.LoadInt64(&db.expiredKeys),
+		StaleRatio:     math.Float64frombits(atomic.LoadUint64(&db.staleRatio)),
+		TimeCapReached: atomic.LoadInt64(&db.timeCapReached),
+	}
+}
+
+/* ---- MultiDB ---- */
+
+// activeExpireCycle runs expire cycle over all databases within the time limit.
+// The database iteration starts from where the last cycle stopped, so every db gets its chance
+func (mdb *MultiDB) activeExpireCycle(effort *expireEffort) {
+	deadline := time.Now().Add(effort.timeLimit)
+	dbNum := len(mdb.dbSet)
+	for i := 0; i < dbNum; i++ {
+		cursor := atomic.AddInt64(&mdb.expireDBCursor, 1) - 1
+		db := mdb.dbSet[cursor%int64(dbNum)]
+		finished := db.activeExpireCycle(effort, deadline)
+		mdb.tracking.sendPending()
+		if !finished {
+			return
+		}
+	}
+}
+
+func getHz() int {
+	hz := config.Properties().Hz
+	if hz <= 0 {
+		hz = defaultHz
+	}
+	return hz
+}
+
+// serverCron runs background tasks periodically until Close,
+// hz and active-expire-effort are read every tick since they could be changed by CONFIG SET
+func (mdb *MultiDB) serverCron() {
+	hz := getHz()
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer func() {
+		ticker.Stop()
+		close(mdb.cronDone)
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			mdb.activeExpireCycle(makeExpireEffort(hz, config.Properties().ActiveExpireEffort))
+			if mdb.aofHandler != nil && mdb.aofHandler.NeedRewrite() {
+				_ = mdb.aofHandler.BackgroundRewrite()
+			}
+			if newHz := getHz(); newHz != hz {
+				hz = newHz
+				ticker.Stop()
+				ticker = time.NewTicker(time.Second / time.Duration(hz))
+			}
+		case <-mdb.stopCron:
+			return
+		}
+	}
+}
+
+// GetExpireStats returns metrics of key expiration of all databases
+func (mdb *MultiDB) GetExpireStats() *ExpireStats {
+	result := &ExpireStats{}
+	totalKeys := 0
+	for _, db := range mdb.dbSet {
+		stats := db.GetExpireStats()
+		result.ExpiredKeys += stats.ExpiredKeys
+		result.TimeCapReached += stats.TimeCapReached
+		// weighted by number of keys with ttl
+		db.stopWorld.RLock()
+		size := db.ttlMap.Len()
+		db.stopWorld.RUnlock()
+		result.StaleRatio += stats.StaleRatio * float64(size)
+		totalKeys += size
+	}
+	if totalKeys > 0 {
+		result.StaleRatio /= float64(totalKeys)
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	db := makeTestDB()
+	var deleted []string
+	db.addAof = func(line CmdLine) {
+		if strings.ToUpper(string(line[0])) == "DEL" {
+			deleted = append(deleted, string(line[1]))
+		}
+	}
+	size := 100
+	expireAt := strconv.FormatInt(time.Now().Add(-time.Second).UnixNano()/1e6, 10)
+	for i := 0; i < size; i++ {
+		key := "expired:" + strconv.Itoa(i)
+		db.Exec(nil, utils.ToCmdLine("SET", key, key))
+		db.Exec(nil, utils.ToCmdLine("PEXPIREAT", key, expireAt))
+	}
+	for i := 0; i < size; i++ {
+		key := "alive:" + strconv.Itoa(i)
+		db.Exec(nil, utils.ToCmdLine("SET", key, key, "EX", "1000"))
+	}
+
+	effort := makeExpireEffort(defaultHz, maxActiveExpireEffort)
+	for i := 0; i < 100 && db.ttlMap.Len() > size; i++ {
+		db.activeExpireCycle(effort, time.Now().Add(time.Second))
+	}
+	if db.data.Len() != size {
+		t.Errorf("expected %d keys remaining, actually %d", size, db.data.Len())
+	}
+	stats := db.GetExpireStats()
+	if stats.ExpiredKeys != int64(size) {
+		t.Errorf("expected %d expired keys, actually %d", size, stats.ExpiredKeys)
+	}
+	if stats.StaleRatio <= 0 {
+		t.Error("expected positive stale ratio")
+	}
+	if len(deleted) != size {
+		t.Errorf("expected %d DEL in aof, actually %d", size, len(deleted))
+	}
+	result := db.Exec(nil, utils.ToCmdLine("TTL", "alive:0"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actually %s", result.ToBytes())
+	}
+}
+
+func TestLazyExpire(t *testing.T) {
+	db := makeTestDB()
+	deleted := 0
+	db.addAof = func(line CmdLine) {
+		if strings.ToUpper(string(line[0])) == "DEL" {
+			deleted++
+		}
+	}
+	key := utils.RandString(10)
+	db.Exec(nil, utils.ToCmdLine("SET", key, key, "PX", "1"))
+	time.Sleep(10 * time.Millisecond)
+	result := db.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertNullBulk(t, result)
+	if db.data.Len() != 0 {
+		t.Error("expired key should be removed")
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 DEL in aof, actually %d", deleted)
+	}
+}
+
+func TestServerCronExpire(t *testing.T) {
+	mdb := NewStandaloneServer()
+	defer mdb.Close()
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	mdb.Exec(conn, utils.ToCmdLine("SET", key, key, "PX", "10"))
+	time.Sleep(500 * time.Millisecond)
+	if mdb.dbSet[0].data.Len() != 0 {
+		t.Error("expired key should be removed by active expire cycle")
+	}
+	result := mdb.Exec(conn, utils.ToCmdLine("INFO", "stats"))
+	bulk, ok := result.(*reply.BulkReply)
+	if !ok {
+		t.Errorf("expected bulk reply, actually %s", result.ToBytes())
+		return
+	}
+	if !strings.Contains(string(bulk.Arg), "expired_keys:1\r\n") {
+		t.Errorf("wrong stats: %s", bulk.Arg)
+	}
+}
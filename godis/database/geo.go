@@ -3,10 +3,12 @@ package database
 import (
 	"fmt"
 	"godis/datastruct/sortedset"
+	"godis/interface/database"
 	"godis/interface/redis"
 	"godis/lib/geohash"
 	"godis/lib/utils"
 	"godis/redis/reply"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -170,95 +172,438 @@ func execGeoHash(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeMultiBulkReply(strs)
 }
 
-// execGeoRadius returns members within max distance of given point
-func execGeoRadius(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) < 5 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'georadius' command")
+// geoSearchOption is parsed from arguments of GEORADIUS, GEORADIUSBYMEMBER, GEOSEARCH and GEOSEARCHSTORE
+type geoSearchOption struct {
+	// center of search, member is used if fromMember is set
+	fromMember bool
+	member     string
+	lat, lng   float64
+
+	// radius, width and height are in meters
+	byBox                 bool
+	radius, width, height float64
+	// unit is meters per unit of distance in reply
+	unit float64
+
+	sort                          int // 0: unsorted, 1: ASC, -1: DESC
+	count                         int64
+	any                           bool
+	withCoord, withDist, withHash bool
+
+	storeKey  string
+	storeDist bool
+}
+
+const (
+	geoRadiusCmd = iota
+	geoSearchCmd
+	geoSearchStoreCmd
+)
+
+type geoSearchResult struct {
+	member   string
+	score    float64
+	dist     float64
+	lat, lng float64
+}
+
+func parseGeoUnit(arg []byte) (float64, reply.ErrorReply) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
 	}
+	return 0, reply.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
 
-	key := string(args[0])
-	sortedSet, errReply := db.getAsSortedSet(key)
+func parseGeoFloat(arg []byte) (float64, reply.ErrorReply) {
+	val, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	return val, nil
+}
+
+func parseLngLat(lngArg, latArg []byte) (float64, float64, reply.ErrorReply) {
+	lng, errReply := parseGeoFloat(lngArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	lat, errReply := parseGeoFloat(latArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return 0, 0, reply.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %s,%s", lngArg, latArg))
+	}
+	return lng, lat, nil
+}
+
+// parseRadius parses `radius unit` into option
+func (option *geoSearchOption) parseRadius(radiusArg, unitArg []byte) reply.ErrorReply {
+	radius, errReply := parseGeoFloat(radiusArg)
 	if errReply != nil {
 		return errReply
 	}
-	if sortedSet == nil {
-		return &reply.NullBulkReply{}
+	if radius < 0 {
+		return reply.MakeErrReply("ERR radius cannot be negative")
+	}
+	option.unit, errReply = parseGeoUnit(unitArg)
+	if errReply != nil {
+		return errReply
 	}
+	option.radius = radius * option.unit
+	return nil
+}
 
-	lng, err := strconv.ParseFloat(string(args[1]), 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not a valid float")
+// parseGeoSearchOption parses optional arguments, GEOSEARCH and GEOSEARCHSTORE also specify center and shape by them
+func parseGeoSearchOption(option *geoSearchOption, args [][]byte, cmd int) reply.ErrorReply {
+	fromGiven, byGiven := 0, 0
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		remain := len(args) - i - 1
+		switch {
+		case arg == "asc":
+			option.sort = 1
+		case arg == "desc":
+			option.sort = -1
+		case arg == "any":
+			option.any = true
+		case arg == "count" && remain >= 1:
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			option.count = count
+			i++
+		case arg == "withcoord" && cmd != geoSearchStoreCmd:
+			option.withCoord = true
+		case arg == "withdist" && cmd != geoSearchStoreCmd:
+			option.withDist = true
+		case arg == "withhash" && cmd != geoSearchStoreCmd:
+			option.withHash = true
+		case arg == "store" && cmd == geoRadiusCmd && remain >= 1:
+			option.storeKey = string(args[i+1])
+			option.storeDist = false
+			i++
+		case arg == "storedist" && cmd == geoRadiusCmd && remain >= 1:
+			option.storeKey = string(args[i+1])
+			option.storeDist = true
+			i++
+		case arg == "storedist" && cmd == geoSearchStoreCmd:
+			option.storeDist = true
+		case arg == "frommember" && cmd != geoRadiusCmd && remain >= 1:
+			option.fromMember = true
+			option.member = string(args[i+1])
+			fromGiven++
+			i++
+		case arg == "fromlonlat" && cmd != geoRadiusCmd && remain >= 2:
+			lng, lat, errReply := parseLngLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			option.lng, option.lat = lng, lat
+			fromGiven++
+			i += 2
+		case arg == "byradius" && cmd != geoRadiusCmd && remain >= 2:
+			if errReply := option.parseRadius(args[i+1], args[i+2]); errReply != nil {
+				return errReply
+			}
+			byGiven++
+			i += 2
+		case arg == "bybox" && cmd != geoRadiusCmd && remain >= 3:
+			width, errReply := parseGeoFloat(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			height, errReply := parseGeoFloat(args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			if width < 0 || height < 0 {
+				return reply.MakeErrReply("ERR height or width cannot be negative")
+			}
+			option.unit, errReply = parseGeoUnit(args[i+3])
+			if errReply != nil {
+				return errReply
+			}
+			option.byBox = true
+			option.width = width * option.unit
+			option.height = height * option.unit
+			byGiven++
+			i += 3
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
 	}
-	lat, err := strconv.ParseFloat(string(args[2]), 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not a valid float")
+	if cmd != geoRadiusCmd {
+		if fromGiven != 1 {
+			return reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+		}
+		if byGiven != 1 {
+			return reply.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+		}
 	}
-	radius, err := strconv.ParseFloat(string(args[3]), 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not a valid float")
+	if option.any && option.count == 0 {
+		return reply.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	if option.storeKey != "" && (option.withCoord || option.withDist || option.withHash) {
+		return reply.MakeErrReply("ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORDS options")
 	}
-	unit := strings.ToLower(string(args[4]))
-	if unit == "m" {
-	} else if unit == "km" {
-		radius *= 1000
+	return nil
+}
+
+// geoSearch returns members within the shape of option, members are sorted by distance if required
+func geoSearch(sortedSet *sortedset.SortedSet, option *geoSearchOption) []*geoSearchResult {
+	var areas [][2]uint64
+	if option.byBox {
+		areas = geohash.GetAreasByBox(option.lat, option.lng, option.width, option.height)
 	} else {
-		return reply.MakeErrReply("ERR unsupported unit provided. please use m, km")
+		areas = geohash.GetAreasByRadius(option.lat, option.lng, option.radius)
 	}
-	return geoRadius0(sortedSet, lat, lng, radius)
+	results := make([]*geoSearchResult, 0)
+	// cells may overlap near poles, and a score may be rounded to border of next cell
+	found := make(map[string]struct{})
+	enough := false
+	for _, area := range areas {
+		lower := &sortedset.ScoreBorder{Value: float64(area[0])}
+		upper := &sortedset.ScoreBorder{Value: float64(area[1])}
+		sortedSet.ForEachByScore(lower, upper, 0, -1, false, func(elem *sortedset.Element) bool {
+			if _, ok := found[elem.Member]; ok {
+				return true
+			}
+			lat, lng := geohash.Decode(uint64(elem.Score))
+			var dist float64
+			if option.byBox {
+				var in bool
+				dist, in = geohash.InRectangle(option.lat, option.lng, option.width, option.height, lat, lng)
+				if !in {
+					return true
+				}
+			} else {
+				dist = geohash.Distance(option.lat, option.lng, lat, lng)
+				if dist > option.radius {
+					return true
+				}
+			}
+			found[elem.Member] = struct{}{}
+			results = append(results, &geoSearchResult{
+				member: elem.Member,
+				score:  elem.Score,
+				dist:   dist,
+				lat:    lat,
+				lng:    lng,
+			})
+			// with ANY, return as soon as enough matches found
+			enough = option.any && int64(len(results)) >= option.count
+			return !enough
+		})
+		if enough {
+			break
+		}
+	}
+
+	sortOrder := option.sort
+	if sortOrder == 0 && option.count > 0 && !option.any {
+		// the nearest members are required
+		sortOrder = 1
+	}
+	if sortOrder != 0 {
+		sort.Slice(results, func(i, j int) bool {
+			if results[i].dist != results[j].dist {
+				return (results[i].dist < results[j].dist) == (sortOrder > 0)
+			}
+			return results[i].member < results[j].member
+		})
+	}
+	if option.count > 0 && int64(len(results)) > option.count {
+		results = results[:option.count]
+	}
+	return results
 }
 
-// execGeoRadiusByMember returns members within max distance of given member's location
-func execGeoRadiusByMember(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) < 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'georadiusbymember' command")
+func formatGeoFloat(val float64) []byte {
+	return []byte(strconv.FormatFloat(val, 'f', -1, 64))
+}
+
+func makeGeoSearchReply(results []*geoSearchResult, option *geoSearchOption) redis.Reply {
+	if !option.withCoord && !option.withDist && !option.withHash {
+		members := make([][]byte, len(results))
+		for i, result := range results {
+			members[i] = []byte(result.member)
+		}
+		return reply.MakeMultiBulkReply(members)
+	}
+	replies := make([]redis.Reply, len(results))
+	for i, result := range results {
+		item := []redis.Reply{reply.MakeBulkReply([]byte(result.member))}
+		if option.withDist {
+			item = append(item, reply.MakeBulkReply([]byte(strconv.FormatFloat(result.dist/option.unit, 'f', 4, 64))))
+		}
+		if option.withHash {
+			// the first 52 bits like redis, so that it fits in integer reply
+			item = append(item, reply.MakeIntReply(int64(uint64(result.score)>>12)))
+		}
+		if option.withCoord {
+			item = append(item, reply.MakeMultiBulkReply([][]byte{
+				formatGeoFloat(result.lng), formatGeoFloat(result.lat),
+			}))
+		}
+		replies[i] = reply.MakeMultiRawReply(item)
 	}
+	return reply.MakeMultiRawReply(replies)
+}
 
-	key := string(args[0])
+// execGeoSearch0 searches members and replies or stores them, cmdLine is written into aof if anything stored
+func execGeoSearch0(db *DB, key string, option *geoSearchOption, cmdLine CmdLine) redis.Reply {
 	sortedSet, errReply := db.getAsSortedSet(key)
 	if errReply != nil {
 		return errReply
 	}
-	if sortedSet == nil {
-		return &reply.NullBulkReply{}
+	var results []*geoSearchResult
+	if sortedSet != nil {
+		if option.fromMember {
+			elem, ok := sortedSet.Get(option.member)
+			if !ok {
+				return reply.MakeErrReply("ERR could not decode requested zset member")
+			}
+			option.lat, option.lng = geohash.Decode(uint64(elem.Score))
+		}
+		results = geoSearch(sortedSet, option)
 	}
-
-	member := string(args[1])
-	elem, ok := sortedSet.Get(member)
-	if !ok {
-		return &reply.NullBulkReply{}
+	if option.storeKey == "" {
+		return makeGeoSearchReply(results, option)
 	}
-	lat, lng := geohash.Decode(uint64(elem.Score))
 
-	radius, err := strconv.ParseFloat(string(args[2]), 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not a valid float")
-	}
-	if len(args) > 3 {
-		unit := strings.ToLower(string(args[3]))
-		if unit == "m" {
-		} else if unit == "km" {
-			radius *= 1000
-		} else {
-			return reply.MakeErrReply("ERR unsupported unit provided. please use m, km")
+	if len(results) == 0 {
+		db.Remove(option.storeKey) // clean ttl and old value
+	} else {
+		dest := sortedset.Make()
+		for _, result := range results {
+			score := result.score
+			if option.storeDist {
+				score = result.dist / option.unit
+			}
+			dest.Add(result.member, score)
 		}
+		db.PutEntity(option.storeKey, &database.DataEntity{
+			Data: dest,
+		})
 	}
-	return geoRadius0(sortedSet, lat, lng, radius)
+	db.addAof(cmdLine)
+	return reply.MakeIntReply(int64(len(results)))
 }
 
-func geoRadius0(sortedSet *sortedset.SortedSet, lat float64, lng float64, radius float64) redis.Reply {
-	areas := geohash.GetNeighbours(lat, lng, radius)
-	members := make([][]byte, 0)
-	for _, area := range areas {
-		lower := &sortedset.ScoreBorder{Value: float64(area[0])}
-		upper := &sortedset.ScoreBorder{Value: float64(area[1])}
-		elements := sortedSet.RangeByScore(lower, upper, 0, -1, true)
-		for _, elem := range elements {
-			members = append(members, []byte(elem.Member))
+// execGeoRadius returns members within max distance of given point
+// GEORADIUS key longitude latitude radius m|km|ft|mi [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count [ANY]] [ASC|DESC]
+// [STORE key] [STOREDIST key]
+func execGeoRadius(db *DB, args [][]byte) redis.Reply {
+	option := &geoSearchOption{}
+	var errReply reply.ErrorReply
+	option.lng, option.lat, errReply = parseLngLat(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	if errReply = option.parseRadius(args[3], args[4]); errReply != nil {
+		return errReply
+	}
+	if errReply = parseGeoSearchOption(option, args[5:], geoRadiusCmd); errReply != nil {
+		return errReply
+	}
+	return execGeoSearch0(db, string(args[0]), option, utils.ToCmdLine3("georadius", args...))
+}
+
+// execGeoRadiusByMember returns members within max distance of given member's location
+// GEORADIUSBYMEMBER key member radius m|km|ft|mi [options of GEORADIUS]
+func execGeoRadiusByMember(db *DB, args [][]byte) redis.Reply {
+	option := &geoSearchOption{
+		fromMember: true,
+		member:     string(args[1]),
+	}
+	if errReply := option.parseRadius(args[2], args[3]); errReply != nil {
+		return errReply
+	}
+	if errReply := parseGeoSearchOption(option, args[4:], geoRadiusCmd); errReply != nil {
+		return errReply
+	}
+	return execGeoSearch0(db, string(args[0]), option, utils.ToCmdLine3("georadiusbymember", args...))
+}
+
+// execGeoSearch returns members within a circle or box
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *DB, args [][]byte) redis.Reply {
+	option := &geoSearchOption{}
+	if errReply := parseGeoSearchOption(option, args[1:], geoSearchCmd); errReply != nil {
+		return errReply
+	}
+	return execGeoSearch0(db, string(args[0]), option, nil)
+}
+
+// execGeoSearchStore stores result of GEOSEARCH in destination
+// GEOSEARCHSTORE destination source [options of GEOSEARCH without WITH*] [STOREDIST]
+func execGeoSearchStore(db *DB, args [][]byte) redis.Reply {
+	option := &geoSearchOption{
+		storeKey: string(args[0]),
+	}
+	if errReply := parseGeoSearchOption(option, args[2:], geoSearchStoreCmd); errReply != nil {
+		return errReply
+	}
+	return execGeoSearch0(db, string(args[1]), option, utils.ToCmdLine3("geosearchstore", args...))
+}
+
+// geoStoreKey returns destination of STORE or STOREDIST option of GEORADIUS and GEORADIUSBYMEMBER,
+// options begin at args[optionIndex]
+func geoStoreKey(args [][]byte, optionIndex int) string {
+	key := ""
+	for i := optionIndex; i < len(args)-1; i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "store" || arg == "storedist" {
+			key = string(args[i+1])
+			i++
 		}
 	}
-	return reply.MakeMultiBulkReply(members)
+	return key
+}
+
+func prepareGeoRadius(args [][]byte) ([]string, []string) {
+	if dest := geoStoreKey(args, 5); dest != "" {
+		return []string{dest}, []string{string(args[0])}
+	}
+	return readFirstKey(args)
+}
+
+func undoGeoRadius(db *DB, args [][]byte) []CmdLine {
+	if dest := geoStoreKey(args, 5); dest != "" {
+		return rollbackGivenKeys(db, dest)
+	}
+	return nil
+}
+
+func prepareGeoRadiusByMember(args [][]byte) ([]string, []string) {
+	if dest := geoStoreKey(args, 4); dest != "" {
+		return []string{dest}, []string{string(args[0])}
+	}
+	return readFirstKey(args)
+}
+
+func undoGeoRadiusByMember(db *DB, args [][]byte) []CmdLine {
+	if dest := geoStoreKey(args, 4); dest != "" {
+		return rollbackGivenKeys(db, dest)
+	}
+	return nil
+}
+
+func prepareGeoSearchStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
 }
 
 func init() {
@@ -266,6 +611,8 @@ func init() {
 	RegisterCommand("GeoPos", execGeoPos, readFirstKey, nil, -2)
 	RegisterCommand("GeoDist", execGeoDist, readFirstKey, nil, -4)
 	RegisterCommand("GeoHash", execGeoHash, readFirstKey, nil, -2)
-	RegisterCommand("GeoRadius", execGeoRadius, readFirstKey, nil, -6)
-	RegisterCommand("GeoRadiusByMember", execGeoRadiusByMember, readFirstKey, nil, -5)
+	RegisterCommand("GeoRadius", execGeoRadius, prepareGeoRadius, undoGeoRadius, -6)
+	RegisterCommand("GeoRadiusByMember", execGeoRadiusByMember, prepareGeoRadiusByMember, undoGeoRadiusByMember, -5)
+	RegisterCommand("GeoSearch", execGeoSearch, readFirstKey, nil, -7)
+	RegisterCommand("GeoSearchStore", execGeoSearchStore, prepareGeoSearchStore, rollbackFirstKey, -8)
 }
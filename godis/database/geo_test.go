@@ -101,3 +101,96 @@ func TestGeoDist(t *testing.T) {
 		t.Errorf("expected 166274, actual: %f", dist)
 	}
 }
+
+func TestGeoSearch(t *testing.T) {
+	execFlushDB(testDB, utils.ToCmdLine())
+	key := utils.RandString(10)
+	execGeoAdd(testDB, utils.ToCmdLine(key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1",
+		"17.241510", "38.788135", "edge2",
+	))
+	result := testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "byradius", "200", "km", "asc"))
+	asserts.AssertMultiBulkReply(t, result, []string{"Catania", "Palermo"})
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "bybox", "400", "400", "km", "desc"))
+	// edges have the same distance, members with the same distance are sorted lexicographically
+	asserts.AssertMultiBulkReply(t, result, []string{"edge1", "edge2", "Palermo", "Catania"})
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "frommember", "Palermo", "byradius", "200", "km", "count", "2"))
+	asserts.AssertMultiBulkReply(t, result, []string{"Palermo", "edge1"})
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "bybox", "400", "400", "km", "count", "1", "any"))
+	asserts.AssertMultiBulkReplySize(t, result, 1)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "byradius", "200", "km",
+		"asc", "withcoord", "withdist", "withhash"))
+	expected := "*2\r\n" +
+		"*4\r\n$7\r\nCatania\r\n$7\r\n56.4412\r\n:3476216502357864\r\n" +
+		"*2\r\n$17\r\n15.08726750034839\r\n$18\r\n37.502667924854904\r\n" +
+		"*4\r\n$7\r\nPalermo\r\n$8\r\n190.4425\r\n:3476004292229755\r\n" +
+		"*2\r\n$18\r\n13.361386698670685\r\n$17\r\n38.11555536696687\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.ToBytes())
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "byradius", "200", "km", "count", "0"))
+	asserts.AssertErrReply(t, result, "ERR COUNT must be > 0")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "byradius", "200", "km", "any"))
+	asserts.AssertErrReply(t, result, "ERR the ANY argument requires COUNT argument")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "frommember", "Palermo", "byradius", "200", "km"))
+	asserts.AssertErrReply(t, result, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "withdist", "asc"))
+	asserts.AssertErrReply(t, result, "ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "frommember", "Rome", "byradius", "200", "km"))
+	asserts.AssertErrReply(t, result, "ERR could not decode requested zset member")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "15", "37", "byradius", "200", "yd"))
+	asserts.AssertErrReply(t, result, "ERR unsupported unit provided. please use M, KM, FT, MI")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", utils.RandString(10), "fromlonlat", "15", "37", "byradius", "200", "km"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+}
+
+func TestGeoSearchAntimeridian(t *testing.T) {
+	execFlushDB(testDB, utils.ToCmdLine())
+	key := utils.RandString(10)
+	execGeoAdd(testDB, utils.ToCmdLine(key,
+		"179.9", "0", "east",
+		"-179.9", "0", "west",
+		"0", "89.9", "pole1",
+		"180", "89.9", "pole2",
+	))
+	result := testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "fromlonlat", "-179.99", "0", "bybox", "50", "50", "km", "asc"))
+	asserts.AssertMultiBulkReply(t, result, []string{"west", "east"})
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearch", key, "frommember", "pole1", "byradius", "30", "km", "asc"))
+	asserts.AssertMultiBulkReply(t, result, []string{"pole1", "pole2"})
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	execFlushDB(testDB, utils.ToCmdLine())
+	key := utils.RandString(10)
+	dest := utils.RandString(10)
+	execGeoAdd(testDB, utils.ToCmdLine(key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+	))
+	result := testDB.Exec(nil, utils.ToCmdLine("geosearchstore", dest, key, "fromlonlat", "15", "37", "byradius", "200", "km"))
+	asserts.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("geopos", dest, "Palermo"))
+	asserts.AssertNotError(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearchstore", dest, key, "fromlonlat", "15", "37", "byradius", "100", "km", "storedist"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("zscore", dest, "Catania"))
+	asserts.AssertBulkReply(t, result, "56.44120345978601")
+	result = testDB.Exec(nil, utils.ToCmdLine("geosearchstore", dest, key, "fromlonlat", "15", "37", "byradius", "200", "km", "withdist"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+
+	// empty result removes destination
+	result = testDB.Exec(nil, utils.ToCmdLine("georadius", key, "0", "0", "1", "km", "store", dest))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", dest))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("georadius", key, "15", "37", "200", "km", "count", "1", "storedist", dest))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("zrange", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"Catania"})
+	result = testDB.Exec(nil, utils.ToCmdLine("georadiusbymember", key, "Palermo", "200", "km", "withcoord", "store", dest))
+	asserts.AssertErrReply(t, result, "ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORDS options")
+}
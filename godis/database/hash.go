@@ -52,6 +52,7 @@ func execHSet(db *DB, args [][]byte) redis.Reply {
 	}
 
 	result := dict.Put(field, value)
+	db.indexHash(key, dict)
 	db.addAof(utils.ToCmdLine3("hset", args...))
 	return reply.MakeIntReply(int64(result))
 }
@@ -76,6 +77,7 @@ func execHSetNX(db *DB, args [][]byte) redis.Reply {
 
 	result := dict.PutIfAbsent(field, value)
 	if result > 0 {
+		db.indexHash(key, dict)
 		db.addAof(utils.ToCmdLine3("hsetnx", args...))
 
 	}
@@ -153,6 +155,8 @@ func execHDel(db *DB, args [][]byte) redis.Reply {
 	}
 	if dict.Len() == 0 {
 		db.Remove(key)
+	} else if deleted > 0 {
+		db.indexHash(key, dict)
 	}
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine3("hdel", args...))
@@ -212,6 +216,7 @@ func execHMSet(db *DB, args [][]byte) redis.Reply {
 		value := values[i]
 		dict.Put(field, value)
 	}
+	db.indexHash(key, dict)
 	db.addAof(utils.ToCmdLine3("hmset", args...))
 	return &reply.OkReply{}
 }
@@ -346,6 +351,7 @@ func execHIncrBy(db *DB, args [][]byte) redis.Reply {
 	value, exists := dict.Get(field)
 	if !exists {
 		dict.Put(field, args[2])
+		db.indexHash(key, dict)
 		db.addAof(utils.ToCmdLine3("hincrby", args...))
 		return reply.MakeBulkReply(args[2])
 	}
@@ -356,6 +362,7 @@ func execHIncrBy(db *DB, args [][]byte) redis.Reply {
 	val += delta
 	bytes := []byte(strconv.FormatInt(val, 10))
 	dict.Put(field, bytes)
+	db.indexHash(key, dict)
 	db.addAof(utils.ToCmdLine3("hincrby", args...))
 	return reply.MakeBulkReply(bytes)
 }
@@ -385,6 +392,7 @@ func execHIncrByFloat(db *DB, args [][]byte) redis.Reply {
 	value, exists := dict.Get(field)
 	if !exists {
 		dict.Put(field, args[2])
+		db.indexHash(key, dict)
 		return reply.MakeBulkReply(args[2])
 	}
 	val, err := decimal.NewFromString(string(value.([]byte)))
@@ -394,6 +402,7 @@ func execHIncrByFloat(db *DB, args [][]byte) redis.Reply {
 	result := val.Add(delta)
 	resultBytes := []byte(result.String())
 	dict.Put(field, resultBytes)
+	db.indexHash(key, dict)
 	db.addAof(utils.ToCmdLine3("hincrbyfloat", args...))
 	return reply.MakeBulkReply(resultBytes)
 }
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strings"
+)
+
+// infoSection generates content of a section in INFO reply
+type infoSection struct {
+	name   string
+	title  string
+	render func(mdb *MultiDB, buf *bytes.Buffer)
+}
+
+var infoSections = []*infoSection{
+	{name: "stats", title: "Stats", render: renderStatsInfo},
+	{name: "keyspace", title: "Keyspace", render: renderKeyspaceInfo},
+}
+
+func renderStatsInfo(mdb *MultiDB, buf *bytes.Buffer) {
+	stats := mdb.GetExpireStats()
+	buf.WriteString(fmt.Sprintf("expired_keys:%d\r\n", stats.ExpiredKeys))
+	buf.WriteString(fmt.Sprintf("expired_stale_perc:%.2f\r\n", stats.StaleRatio*100))
+	buf.WriteString(fmt.Sprintf("expired_time_cap_reached_count:%d\r\n", stats.TimeCapReached))
+	pending, freed := GetLazyfreeStats()
+	buf.WriteString(fmt.Sprintf("lazyfree_pending_objects:%d\r\n", pending))
+	buf.WriteString(fmt.Sprintf("lazyfreed_objects:%d\r\n", freed))
+}
+
+func renderKeyspaceInfo(mdb *MultiDB, buf *bytes.Buffer) {
+	for i, db := range mdb.dbSet {
+		db.stopWorld.RLock()
+		keys, expires := db.data.Len(), db.ttlMap.Len()
+		db.stopWorld.RUnlock()
+		if keys == 0 {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("db%d:keys=%d,expires=%d\r\n", i, keys, expires))
+	}
+}
+
+// execInfo returns information and statistics about the server
+// usage: INFO [section]
+func execInfo(mdb *MultiDB, args [][]byte) redis.Reply {
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("info")
+	}
+	section := "all"
+	if len(args) == 1 {
+		section = strings.ToLower(string(args[0]))
+	}
+	var buf bytes.Buffer
+	for _, s := range infoSections {
+		if section != "all" && section != "default" && section != s.name {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("# " + s.title + "\r\n")
+		s.render(mdb, &buf)
+	}
+	return reply.MakeBulkReply(buf.Bytes())
+}
@@ -0,0 +1,475 @@
+package database
+
+import (
+	"encoding/json"
+	"godis/datastruct/jsontree"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"strings"
+)
+
+// legacyRootPath is the default path of JSON commands
+const legacyRootPath = "."
+
+func (db *DB) getAsJSON(key string) (*jsontree.Document, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	doc, ok := entity.Data.(*jsontree.Document)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return doc, nil
+}
+
+func parseJSONPath(arg []byte) (*jsontree.Path, reply.ErrorReply) {
+	path, err := jsontree.ParsePath(string(arg))
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR " + err.Error() + " '" + string(arg) + "'")
+	}
+	return path, nil
+}
+
+func parseJSONValue(arg []byte) (interface{}, reply.ErrorReply) {
+	val, err := jsontree.Parse(arg)
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR invalid JSON: " + err.Error())
+	}
+	return val, nil
+}
+
+func makeJSONPathNotExistErr(path []byte) reply.ErrorReply {
+	return reply.MakeErrReply("ERR Path '" + string(path) + "' does not exist")
+}
+
+func makeJSONTypeErr(expected string, actual interface{}) reply.ErrorReply {
+	return reply.MakeErrReply("ERR wrong type of path value - expected " + expected + " but found " + jsontree.TypeOf(actual))
+}
+
+// jsonEachReply calls fn with each value matched by path and collects replies into an array.
+// A legacy path only affects the first value, and returns what fn returns.
+// fn returns false if the value is not of the expected type, then the reply of the value is nil for JSONPath
+func jsonEachReply(doc *jsontree.Document, pathArg []byte, expected string,
+	fn func(node *jsontree.Node) (redis.Reply, bool)) redis.Reply {
+	path, errReply := parseJSONPath(pathArg)
+	if errReply != nil {
+		return errReply
+	}
+	nodes := doc.Find(path)
+	if path.Legacy {
+		if len(nodes) == 0 {
+			return makeJSONPathNotExistErr(pathArg)
+		}
+		result, ok := fn(nodes[0])
+		if !ok {
+			return makeJSONTypeErr(expected, nodes[0].Value)
+		}
+		return result
+	}
+	result := make([]redis.Reply, len(nodes))
+	for i, node := range nodes {
+		r, ok := fn(node)
+		if !ok {
+			r = reply.MakeNullBulkReply()
+		}
+		result[i] = r
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execJSONSet sets JSON value at path: JSON.SET key path value [NX|XX]
+func execJSONSet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	nx, xx := false, false
+	if len(args) == 4 {
+		switch strings.ToUpper(string(args[3])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	} else if len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	val, errReply := parseJSONValue(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	doc, errReply := db.getAsJSON(key)
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil || path.IsRoot() {
+		if doc == nil && !path.IsRoot() {
+			return reply.MakeErrReply("ERR new objects must be created at the root")
+		}
+		if (doc == nil && xx) || (doc != nil && nx) {
+			return reply.MakeNullBulkReply()
+		}
+		db.PutEntity(key, &database.DataEntity{
+			Data: &jsontree.Document{Root: val},
+		})
+	} else if !doc.Set(path, val, nx, xx) {
+		return reply.MakeNullBulkReply()
+	}
+	db.addAof(utils.ToCmdLine3("json.set", args...))
+	return reply.MakeOkReply()
+}
+
+// findJSON returns values matched by path, values of JSONPath are wrapped in an array
+func findJSON(doc *jsontree.Document, pathArg []byte, asJSONPath bool) (interface{}, reply.ErrorReply) {
+	path, errReply := parseJSONPath(pathArg)
+	if errReply != nil {
+		return nil, errReply
+	}
+	nodes := doc.Find(path)
+	if !asJSONPath {
+		if len(nodes) == 0 {
+			return nil, makeJSONPathNotExistErr(pathArg)
+		}
+		return nodes[0].Value, nil
+	}
+	arr := &jsontree.Array{Items: make([]interface{}, len(nodes))}
+	for i, node := range nodes {
+		arr.Items[i] = node.Value
+	}
+	return arr, nil
+}
+
+// execJSONGet returns serialized values at paths: JSON.GET key [path ...].
+// Values of multiple paths are returned in an object keyed by path
+func execJSONGet(db *DB, args [][]byte) redis.Reply {
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeNullBulkReply()
+	}
+	paths := args[1:]
+	if len(paths) == 0 {
+		paths = [][]byte{[]byte(legacyRootPath)}
+	}
+	// values are wrapped in arrays if any of the paths is a JSONPath
+	asJSONPath := false
+	for _, p := range paths {
+		if strings.HasPrefix(string(p), "$") {
+			asJSONPath = true
+		}
+	}
+	if len(paths) == 1 {
+		val, errReply := findJSON(doc, paths[0], asJSONPath)
+		if errReply != nil {
+			return errReply
+		}
+		return reply.MakeBulkReply(jsontree.Marshal(val))
+	}
+	obj := jsontree.NewObject()
+	for _, p := range paths {
+		val, errReply := findJSON(doc, p, asJSONPath)
+		if errReply != nil {
+			return errReply
+		}
+		obj.Put(string(p), val)
+	}
+	return reply.MakeBulkReply(jsontree.Marshal(obj))
+}
+
+// execJSONMGet returns values at path of keys: JSON.MGET key [key ...] path
+func execJSONMGet(db *DB, args [][]byte) redis.Reply {
+	pathArg := args[len(args)-1]
+	if _, errReply := parseJSONPath(pathArg); errReply != nil {
+		return errReply
+	}
+	asJSONPath := strings.HasPrefix(string(pathArg), "$")
+	result := make([][]byte, len(args)-1)
+	for i, key := range args[:len(args)-1] {
+		doc, errReply := db.getAsJSON(string(key))
+		if errReply != nil || doc == nil {
+			continue
+		}
+		val, errReply := findJSON(doc, pathArg, asJSONPath)
+		if errReply != nil {
+			continue
+		}
+		result[i] = jsontree.Marshal(val)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func prepareJSONMGet(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = string(arg)
+	}
+	return nil, keys
+}
+
+// execJSONDel removes values at path, removing root deletes the key: JSON.DEL key [path]
+func execJSONDel(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'json.del' command")
+	}
+	key := string(args[0])
+	doc, errReply := db.getAsJSON(key)
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeIntReply(0)
+	}
+	pathArg := []byte(legacyRootPath)
+	if len(args) == 2 {
+		pathArg = args[1]
+	}
+	path, errReply := parseJSONPath(pathArg)
+	if errReply != nil {
+		return errReply
+	}
+	var removed int
+	if path.IsRoot() {
+		db.Remove(key)
+		removed = 1
+	} else {
+		removed = doc.Delete(path)
+	}
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine3("json.del", args...))
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// jsonOptionalPath returns the path argument at index of args or the default root path
+func jsonOptionalPath(args [][]byte, index int) []byte {
+	if len(args) > index {
+		return args[index]
+	}
+	return []byte(legacyRootPath)
+}
+
+// execJSONType returns type of values at path: JSON.TYPE key [path]
+func execJSONType(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'json.type' command")
+	}
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeNullBulkReply()
+	}
+	pathArg := jsonOptionalPath(args, 1)
+	legacy := !strings.HasPrefix(string(pathArg), "$")
+	return jsonEachReply(doc, pathArg, "", func(node *jsontree.Node) (redis.Reply, bool) {
+		if legacy {
+			return reply.MakeStatusReply(jsontree.TypeOf(node.Value)), true
+		}
+		return reply.MakeBulkReply([]byte(jsontree.TypeOf(node.Value))), true
+	})
+}
+
+// execJSONArrAppend appends values to arrays at path and returns their new lengths:
+// JSON.ARRAPPEND key path value [value ...]
+func execJSONArrAppend(db *DB, args [][]byte) redis.Reply {
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeErrReply("ERR could not perform this operation on a key that doesn't exist")
+	}
+	values := make([]interface{}, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		val, errReply := parseJSONValue(arg)
+		if errReply != nil {
+			return errReply
+		}
+		values = append(values, val)
+	}
+	changed := false
+	result := jsonEachReply(doc, args[1], "array", func(node *jsontree.Node) (redis.Reply, bool) {
+		arr, ok := node.Value.(*jsontree.Array)
+		if !ok {
+			return nil, false
+		}
+		for _, val := range values {
+			if changed {
+				val = jsontree.Clone(val)
+			}
+			arr.Items = append(arr.Items, val)
+			changed = true
+		}
+		return reply.MakeIntReply(int64(len(arr.Items))), true
+	})
+	if changed {
+		db.addAof(utils.ToCmdLine3("json.arrappend", args...))
+	}
+	return result
+}
+
+// execJSONArrLen returns lengths of arrays at path: JSON.ARRLEN key [path]
+func execJSONArrLen(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'json.arrlen' command")
+	}
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return jsonEachReply(doc, jsonOptionalPath(args, 1), "array", func(node *jsontree.Node) (redis.Reply, bool) {
+		arr, ok := node.Value.(*jsontree.Array)
+		if !ok {
+			return nil, false
+		}
+		return reply.MakeIntReply(int64(len(arr.Items))), true
+	})
+}
+
+// execJSONNumIncrBy increases numbers at path and returns new values: JSON.NUMINCRBY key path number
+func execJSONNumIncrBy(db *DB, args [][]byte) redis.Reply {
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeErrReply("ERR could not perform this operation on a key that doesn't exist")
+	}
+	val, errReply := parseJSONValue(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	increment, ok := val.(json.Number)
+	if !ok {
+		return reply.MakeErrReply("ERR expected a number but found " + jsontree.TypeOf(val))
+	}
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	nodes := doc.Find(path)
+	if path.Legacy {
+		if len(nodes) == 0 {
+			return makeJSONPathNotExistErr(args[1])
+		}
+		nodes = nodes[:1]
+	}
+	// compute all results before changing any value
+	results := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		n, ok := node.Value.(json.Number)
+		if !ok {
+			if path.Legacy {
+				return makeJSONTypeErr("number", node.Value)
+			}
+			continue
+		}
+		sum, err := jsontree.AddNumber(n, increment)
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		results[i] = sum
+	}
+	changed := false
+	for i, node := range nodes {
+		if results[i] != nil {
+			doc.Replace(node, results[i])
+			changed = true
+		}
+	}
+	if changed {
+		db.addAof(utils.ToCmdLine3("json.numincrby", args...))
+	}
+	if path.Legacy {
+		return reply.MakeBulkReply(jsontree.Marshal(results[0]))
+	}
+	return reply.MakeBulkReply(jsontree.Marshal(&jsontree.Array{Items: results}))
+}
+
+// execJSONStrAppend appends to strings at path and returns their new lengths: JSON.STRAPPEND key [path] value
+func execJSONStrAppend(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'json.strappend' command")
+	}
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeErrReply("ERR could not perform this operation on a key that doesn't exist")
+	}
+	val, errReply := parseJSONValue(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	suffix, ok := val.(string)
+	if !ok {
+		return reply.MakeErrReply("ERR expected a string but found " + jsontree.TypeOf(val))
+	}
+	pathArg := []byte(legacyRootPath)
+	if len(args) == 3 {
+		pathArg = args[1]
+	}
+	changed := false
+	result := jsonEachReply(doc, pathArg, "string", func(node *jsontree.Node) (redis.Reply, bool) {
+		s, ok := node.Value.(string)
+		if !ok {
+			return nil, false
+		}
+		s += suffix
+		doc.Replace(node, s)
+		changed = true
+		return reply.MakeIntReply(int64(len(s))), true
+	})
+	if changed {
+		db.addAof(utils.ToCmdLine3("json.strappend", args...))
+	}
+	return result
+}
+
+// execJSONObjKeys returns keys of objects at path: JSON.OBJKEYS key [path]
+func execJSONObjKeys(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'json.objkeys' command")
+	}
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return jsonEachReply(doc, jsonOptionalPath(args, 1), "object", func(node *jsontree.Node) (redis.Reply, bool) {
+		obj, ok := node.Value.(*jsontree.Object)
+		if !ok {
+			return nil, false
+		}
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(obj.Keys()...)), true
+	})
+}
+
+func init() {
+	RegisterCommand("JSON.Set", execJSONSet, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("JSON.Get", execJSONGet, readFirstKey, nil, -2)
+	RegisterCommand("JSON.MGet", execJSONMGet, prepareJSONMGet, nil, -3)
+	RegisterCommand("JSON.Del", execJSONDel, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("JSON.Forget", execJSONDel, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("JSON.Type", execJSONType, readFirstKey, nil, -2)
+	RegisterCommand("JSON.ArrAppend", execJSONArrAppend, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("JSON.ArrLen", execJSONArrLen, readFirstKey, nil, -2)
+	RegisterCommand("JSON.NumIncrBy", execJSONNumIncrBy, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("JSON.StrAppend", execJSONStrAppend, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("JSON.ObjKeys", execJSONObjKeys, readFirstKey, nil, -2)
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"testing"
+)
+
+func TestJSONSetGet(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, ".a", "1"))
+	asserts.AssertErrReply(t, ret, "ERR new objects must be created at the root")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$", `{"a":1,"b":{"a":"x","c":[1,2,3]}}`))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "ReJSON-RL")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$", "{}", "NX"))
+	asserts.AssertNullBulk(t, ret)
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key))
+	asserts.AssertBulkReply(t, ret, `{"a":1,"b":{"a":"x","c":[1,2,3]}}`)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, ".b.c[1]"))
+	asserts.AssertBulkReply(t, ret, "2")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, "$..a"))
+	asserts.AssertBulkReply(t, ret, `[1,"x"]`)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, "$.b.c[-2:]"))
+	asserts.AssertBulkReply(t, ret, `[2,3]`)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, "$.a", "$.b['a']"))
+	asserts.AssertBulkReply(t, ret, `{"$.a":[1],"$.b['a']":["x"]}`)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, ".missing"))
+	asserts.AssertErrReply(t, ret, "ERR Path '.missing' does not exist")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, "$.b["))
+	asserts.AssertErrReply(t, ret, "ERR invalid JSONPath '$.b['")
+
+	// set new key in objects, replace values matched
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$.b.d", `"<y>"`, "XX"))
+	asserts.AssertNullBulk(t, ret)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$.b.d", `"<y>"`, "NX"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$..a", "null"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key))
+	asserts.AssertBulkReply(t, ret, `{"a":null,"b":{"a":null,"c":[1,2,3],"d":"<y>"}}`)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$", "{"))
+	if !reply.IsErrorReply(ret) {
+		t.Errorf("expected error for invalid JSON, actually %s", ret.ToBytes())
+	}
+
+	key2 := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key2, ".", `{"b":{"c":[]}}`))
+	testServer.Exec(conn, utils.ToCmdLine("SET", key2+"s", "1"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.MGET", key, key2, key2+"s", "$.b.c"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"[[1,2,3]]", "[[]]", ""})
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key2+"s"))
+	asserts.AssertErrReply(t, ret, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestJSONModify(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$", `{"s":"ab","n":1,"f":1.5,"arr":[1],"o":{"s":"c","n":"x","arr":[]}}`))
+
+	ret := testServer.Exec(conn, utils.ToCmdLine("JSON.TYPE", key, ".n"))
+	asserts.AssertStatusReply(t, ret, "integer")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.TYPE", key, "$..n"))
+	assertRawReplies(t, ret, "$7\r\ninteger\r\n", "$6\r\nstring\r\n")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.NUMINCRBY", key, ".n", "2"))
+	asserts.AssertBulkReply(t, ret, "3")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.NUMINCRBY", key, "$..n", "1"))
+	asserts.AssertBulkReply(t, ret, "[4,null]")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.NUMINCRBY", key, ".f", "0.5"))
+	asserts.AssertBulkReply(t, ret, "2.0")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.NUMINCRBY", key, ".s", "1"))
+	asserts.AssertErrReply(t, ret, "ERR wrong type of path value - expected number but found string")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.NUMINCRBY", key, ".n", "9223372036854775807"))
+	asserts.AssertErrReply(t, ret, "ERR result is out of range")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.STRAPPEND", key, "$..s", `"d"`))
+	assertRawReplies(t, ret, ":3\r\n", ":2\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.STRAPPEND", key, ".n", `"d"`))
+	asserts.AssertErrReply(t, ret, "ERR wrong type of path value - expected string but found integer")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.ARRAPPEND", key, "$..arr", "2", `{"a":1}`))
+	assertRawReplies(t, ret, ":3\r\n", ":2\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.ARRLEN", key, "$..arr"))
+	assertRawReplies(t, ret, ":3\r\n", ":2\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.ARRLEN", key, "$.s"))
+	assertRawReplies(t, ret, "$-1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.OBJKEYS", key, ".o"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"s", "n", "arr"})
+
+	// values appended to different arrays must not share memory
+	testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$.arr[2].a", "2"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key, "$..arr"))
+	asserts.AssertBulkReply(t, ret, `[[1,2,{"a":2}],[2,{"a":1}]]`)
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.DEL", key, "$..arr[0]"))
+	asserts.AssertIntReply(t, ret, 2)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.FORGET", key, ".o"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key))
+	asserts.AssertBulkReply(t, ret, `{"s":"abd","n":4,"f":2.0,"arr":[2,{"a":2}]}`)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.DEL", key))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("EXISTS", key))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.ARRAPPEND", key, ".", "1"))
+	asserts.AssertErrReply(t, ret, "ERR could not perform this operation on a key that doesn't exist")
+}
+
+func TestJSONDump(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("JSON.SET", key, "$", `{"a":[1,"<b>",true,null]}`))
+	ret := testServer.Exec(conn, utils.ToCmdLine("DUMP", key))
+	payload := ret.(*reply.BulkReply).Arg
+	key2 := utils.RandString(10)
+	ret = testServer.Exec(conn, [][]byte{[]byte("RESTORE"), []byte(key2), []byte("0"), payload})
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("JSON.GET", key2))
+	asserts.AssertBulkReply(t, ret, `{"a":[1,"<b>",true,null]}`)
+}
+
+func TestUndoJSON(t *testing.T) {
+	key := utils.RandString(10)
+	execJSONSet(testDB, utils.ToCmdLine(key, "$", `{"a":[1]}`))
+	undoCmdLines := rollbackFirstKey(testDB, utils.ToCmdLine(key, "$.a", "2"))
+	execJSONArrAppend(testDB, utils.ToCmdLine(key, "$.a", "2"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	ret := execJSONGet(testDB, utils.ToCmdLine(key))
+	asserts.AssertBulkReply(t, ret, `{"a":[1]}`)
+}
@@ -2,15 +2,23 @@ package database
 
 import (
 	"godis/aof"
+	"godis/config"
+	"godis/datastruct/bloom"
 	"godis/datastruct/dict"
+	"godis/datastruct/fencedlock"
+	"godis/datastruct/jsontree"
 	"godis/datastruct/list"
 	"godis/datastruct/set"
+	"godis/datastruct/sketch"
 	"godis/datastruct/sortedset"
+	"godis/datastruct/timeseries"
+	"godis/interface/database"
 	"godis/interface/redis"
 	"godis/lib/utils"
 	"godis/lib/wildcard"
 	"godis/redis/reply"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,7 +29,26 @@ func execDel(db *DB, args [][]byte) redis.Reply {
 		keys[i] = string(v)
 	}
 
-	deleted := db.Removes(keys...)
+	var deleted int
+	if config.Properties().LazyfreeLazyUserDel {
+		deleted = db.Unlinks(keys...)
+	} else {
+		deleted = db.Removes(keys...)
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("del", args...))
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execUnlink removes keys from db and releases their values in background
+func execUnlink(db *DB, args [][]byte) redis.Reply {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+
+	deleted := db.Unlinks(keys...)
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine3("del", args...))
 	}
@@ -49,10 +76,37 @@ func execExists(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeIntReply(result)
 }
 
+// parseFlushMode parses the optional ASYNC|SYNC argument of FLUSHDB and FLUSHALL
+func parseFlushMode(args [][]byte) (async bool, errReply redis.Reply) {
+	if len(args) == 0 {
+		return config.Properties().LazyfreeLazyUserFlush, nil
+	}
+	if len(args) > 1 {
+		return false, &reply.SyntaxErrReply{}
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	}
+	return false, &reply.SyntaxErrReply{}
+}
+
 // execFlushDB removes all data in current db
+// usage: FLUSHDB [ASYNC|SYNC]
 func execFlushDB(db *DB, args [][]byte) redis.Reply {
-	db.Flush()
-	db.addAof(utils.ToCmdLine3("flushdb", args...))
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	if async {
+		db.FlushAsync()
+	} else {
+		db.Flush()
+	}
+	db.tracking.flush()
+	db.addAof(utils.ToCmdLine("flushdb"))
 	return &reply.OkReply{}
 }
 
@@ -63,7 +117,7 @@ func execType(db *DB, args [][]byte) redis.Reply {
 	if !exists {
 		return reply.MakeStatusReply("none")
 	}
-	switch entity.Data.(type) {
+	switch val := entity.Data.(type) {
 	case []byte:
 		return reply.MakeStatusReply("string")
 	case *list.LinkedList:
@@ -74,6 +128,22 @@ func execType(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeStatusReply("set")
 	case *sortedset.SortedSet:
 		return reply.MakeStatusReply("zset")
+	case *bloom.ScalableFilter:
+		return reply.MakeStatusReply("MBbloom--")
+	case *bloom.CuckooFilter:
+		return reply.MakeStatusReply("MBbloomCF")
+	case *sketch.CountMinSketch:
+		return reply.MakeStatusReply("CMSk-TYPE")
+	case *sketch.TopK:
+		return reply.MakeStatusReply("TopK-TYPE")
+	case *jsontree.Document:
+		return reply.MakeStatusReply("ReJSON-RL")
+	case *timeseries.Series:
+		return reply.MakeStatusReply("TSDB-TYPE")
+	case *fencedlock.Lock:
+		return reply.MakeStatusReply("fencedlock")
+	case database.CustomValue:
+		return reply.MakeStatusReply(val.TypeName())
 	}
 	return &reply.UnknownErrReply{}
 }
@@ -290,6 +360,95 @@ func execKeys(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+// execTouch alters the last access time of keys, returns the number of existing keys
+func execTouch(db *DB, args [][]byte) redis.Reply {
+	return execExists(db, args)
+}
+
+const randomKeyMaxTries = 100
+
+// execRandomKey returns a random key from db
+func execRandomKey(db *DB, args [][]byte) redis.Reply {
+	for i := 0; i < randomKeyMaxTries; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			return &reply.NullBulkReply{}
+		}
+		// key is not locked here, so skip expired keys instead of removing them
+		raw, hasTTL := db.ttlMap.Get(keys[0])
+		if hasTTL && time.Now().After(raw.(time.Time)) {
+			continue
+		}
+		return reply.MakeBulkReply([]byte(keys[0]))
+	}
+	return &reply.NullBulkReply{}
+}
+
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value associated with a <key>.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified <key>.",
+	"HELP",
+	"    Prints this help.",
+}
+
+// getEncoding returns the internal representation of the value
+func getEncoding(entity *database.DataEntity) string {
+	switch val := entity.Data.(type) {
+	case []byte:
+		if len(val) <= 20 {
+			if _, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(val) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case *list.LinkedList:
+		return "linkedlist"
+	case dict.Dict, *set.Set:
+		return "hashtable"
+	case *sortedset.SortedSet:
+		return "skiplist"
+	}
+	return "unknown"
+}
+
+// execObject inspects the internals of the value stored at key
+func execObject(db *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToUpper(string(args[0]))
+	if subCmd == "HELP" {
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(objectHelp...))
+	}
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	entity, exists := db.GetEntity(string(args[1]))
+	switch subCmd {
+	case "ENCODING":
+		if !exists {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply([]byte(getEncoding(entity)))
+	case "REFCOUNT":
+		if !exists {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+}
+
 func toTTLCmd(db *DB, key string) *reply.MultiBulkReply {
 	raw, exists := db.ttlMap.Get(key)
 	if !exists {
@@ -310,6 +469,7 @@ func undoExpire(db *DB, args [][]byte) []CmdLine {
 
 func init() {
 	RegisterCommand("Del", execDel, writeAllKeys, undoDel, -2)
+	RegisterCommand("Unlink", execUnlink, writeAllKeys, undoDel, -2)
 	RegisterCommand("Expire", execExpire, writeFirstKey, undoExpire, 3)
 	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, undoExpire, 3)
 	RegisterCommand("PExpire", execPExpire, writeFirstKey, undoExpire, 3)
@@ -323,4 +483,7 @@ func init() {
 	RegisterCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3)
 	RegisterCommand("FlushDB", execFlushDB, noPrepare, nil, -1)
 	RegisterCommand("Keys", execKeys, noPrepare, nil, 2)
+	RegisterCommand("Touch", execTouch, readAllKeys, nil, -2)
+	RegisterCommand("RandomKey", execRandomKey, noPrepare, nil, 1)
+	RegisterCommand("Object", execObject, prepareObject, nil, -2)
 }
@@ -3,6 +3,7 @@ package database
 import (
 	"fmt"
 	"godis/lib/utils"
+	"godis/redis/connection"
 	"godis/redis/reply"
 	"godis/redis/reply/asserts"
 	"strconv"
@@ -205,3 +206,127 @@ func TestKeys(t *testing.T) {
 	result = testDB.Exec(nil, utils.ToCmdLine("keys", "?:*"))
 	asserts.AssertMultiBulkReplySize(t, result, 2)
 }
+
+func TestTouch(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, key))
+	result := testDB.Exec(nil, utils.ToCmdLine("touch", key, utils.RandString(10)))
+	asserts.AssertIntReply(t, result, 1)
+}
+
+func TestRandomKey(t *testing.T) {
+	testDB.Flush()
+	result := testDB.Exec(nil, utils.ToCmdLine("randomkey"))
+	asserts.AssertNullBulk(t, result)
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, key))
+	result = testDB.Exec(nil, utils.ToCmdLine("randomkey"))
+	asserts.AssertBulkReply(t, result, key)
+}
+
+func TestObject(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "100"))
+	result := testDB.Exec(nil, utils.ToCmdLine("object", "encoding", key))
+	asserts.AssertBulkReply(t, result, "int")
+	testDB.Exec(nil, utils.ToCmdLine("set", key, utils.RandString(50)))
+	result = testDB.Exec(nil, utils.ToCmdLine("object", "encoding", key))
+	asserts.AssertBulkReply(t, result, "raw")
+	testDB.Remove(key)
+	testDB.Exec(nil, utils.ToCmdLine("zadd", key, "1", "a"))
+	result = testDB.Exec(nil, utils.ToCmdLine("object", "encoding", key))
+	asserts.AssertBulkReply(t, result, "skiplist")
+	result = testDB.Exec(nil, utils.ToCmdLine("object", "refcount", key))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("object", "encoding", utils.RandString(10)))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("object", "idle"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand or wrong number of arguments for 'idle'. Try OBJECT HELP.")
+}
+
+func TestCopy(t *testing.T) {
+	conn := &connection.FakeConn{}
+	testServer.Exec(conn, utils.ToCmdLine("FlushAll"))
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("rpush", src, "a", "b"))
+	testServer.Exec(conn, utils.ToCmdLine("expire", src, "1000"))
+
+	result := testServer.Exec(conn, utils.ToCmdLine("copy", src, dest))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(conn, utils.ToCmdLine("lrange", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+	result = testServer.Exec(conn, utils.ToCmdLine("ttl", dest))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actually %s", result.ToBytes())
+	}
+	// deep copy
+	testServer.Exec(conn, utils.ToCmdLine("rpush", src, "c"))
+	result = testServer.Exec(conn, utils.ToCmdLine("llen", dest))
+	asserts.AssertIntReply(t, result, 2)
+
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", src, dest))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", src, dest, "replace"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(conn, utils.ToCmdLine("llen", dest))
+	asserts.AssertIntReply(t, result, 3)
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", src, src))
+	asserts.AssertErrReply(t, result, "ERR source and destination objects are the same")
+
+	// copy to another db
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", src, src, "db", "1"))
+	asserts.AssertIntReply(t, result, 1)
+	conn.SelectDB(1)
+	result = testServer.Exec(conn, utils.ToCmdLine("lrange", src, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b", "c"})
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", src, src, "db", "100"))
+	asserts.AssertErrReply(t, result, "ERR DB index is out of range")
+}
+
+func TestMove(t *testing.T) {
+	conn := &connection.FakeConn{}
+	testServer.Exec(conn, utils.ToCmdLine("FlushAll"))
+	key := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("set", key, key, "EX", "1000"))
+	result := testServer.Exec(conn, utils.ToCmdLine("move", key, "1"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(conn, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("move", key, "1"))
+	asserts.AssertIntReply(t, result, 0)
+
+	conn.SelectDB(1)
+	result = testServer.Exec(conn, utils.ToCmdLine("get", key))
+	asserts.AssertBulkReply(t, result, key)
+	result = testServer.Exec(conn, utils.ToCmdLine("ttl", key))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl more than 0, actually %s", result.ToBytes())
+	}
+	// destination exists
+	testServer.Exec(conn, utils.ToCmdLine("select", "0"))
+	testServer.Exec(conn, utils.ToCmdLine("set", key, key))
+	conn.SelectDB(1)
+	result = testServer.Exec(conn, utils.ToCmdLine("move", key, "0"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("move", key, "1"))
+	asserts.AssertErrReply(t, result, "ERR source and destination objects are the same")
+}
+
+func TestSwapDB(t *testing.T) {
+	conn := &connection.FakeConn{}
+	testServer.Exec(conn, utils.ToCmdLine("FlushAll"))
+	key := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("set", key, key))
+	result := testServer.Exec(conn, utils.ToCmdLine("swapdb", "0", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 0)
+	conn.SelectDB(1)
+	result = testServer.Exec(conn, utils.ToCmdLine("get", key))
+	asserts.AssertBulkReply(t, result, key)
+	result = testServer.Exec(conn, utils.ToCmdLine("swapdb", "0", "100"))
+	asserts.AssertErrReply(t, result, "ERR invalid second DB index")
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/dict"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sortedset"
+	"godis/interface/database"
+	"godis/lib/logger"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// values containing more elements than this threshold will be freed in background
+	defaultLazyfreeThreshold = 64
+	lazyfreeQueueSize        = 1 << 10
+)
+
+var (
+	lazyfreeQueue = make(chan func(), lazyfreeQueueSize)
+	// objects waiting to be freed
+	lazyfreePendingObjects int64
+	// objects freed by background worker
+	lazyfreedObjects int64
+)
+
+var startLazyfreeOnce sync.Once
+
+// startLazyfreeWorker starts the background worker, it is called by server construction
+// so that tools using basic DBs only don't start it
+func startLazyfreeWorker() {
+	startLazyfreeOnce.Do(func() {
+		go lazyfreeWorker()
+	})
+}
+
+func lazyfreeWorker() {
+	for job := range lazyfreeQueue {
+		runLazyfreeJob(job)
+		atomic.AddInt64(&lazyfreePendingObjects, -1)
+		atomic.AddInt64(&lazyfreedObjects, 1)
+	}
+}
+
+func runLazyfreeJob(job func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(err)
+		}
+	}()
+	job()
+}
+
+// submitLazyfree hands job over to background worker.
+// Invoker may hold locks of keys, so the job runs synchronously instead of waiting if the queue is full
+func submitLazyfree(job func()) {
+	atomic.AddInt64(&lazyfreePendingObjects, 1)
+	select {
+	case lazyfreeQueue <- job:
+	default:
+		atomic.AddInt64(&lazyfreePendingObjects, -1)
+		runLazyfreeJob(job)
+	}
+}
+
+func getLazyfreeThreshold() int {
+	if config.Properties().LazyfreeThreshold > 0 {
+		return config.Properties().LazyfreeThreshold
+	}
+	return defaultLazyfreeThreshold
+}
+
+// getFreeEffort returns the amount of work to release the entity, it is the number of elements in the value
+func getFreeEffort(entity *database.DataEntity) int {
+	switch val := entity.Data.(type) {
+	case *list.LinkedList:
+		return val.Len()
+	case dict.Dict:
+		return val.Len()
+	case *set.Set:
+		return val.Len()
+	case *sortedset.SortedSet:
+		return int(val.Len())
+	}
+	return 1
+}
+
+// freeEntity releases elements of the entity one by one.
+// The entity must be unreachable from any db, otherwise concurrent readers may see a half cleared value
+func freeEntity(entity *database.DataEntity) {
+	switch val := entity.Data.(type) {
+	case *list.LinkedList:
+		for val.Len() > 0 {
+			val.RemoveLast()
+		}
+	case dict.Dict:
+		val.Clear()
+	case *set.Set:
+		val.ForEach(func(member string) bool {
+			val.Remove(member)
+			return true
+		})
+	case *sortedset.SortedSet:
+		val.RemoveByRank(0, val.Len())
+	}
+	entity.Data = nil
+}
+
+// freeEntityAsync releases entity in background if it is big enough
+func freeEntityAsync(entity *database.DataEntity) {
+	if getFreeEffort(entity) <= getLazyfreeThreshold() {
+		// small value is cheaper to be dropped right now, leave it to gc
+		return
+	}
+	submitLazyfree(func() {
+		freeEntity(entity)
+	})
+}
+
+// Unlink removes the given key from db and releases its value in background
+// invoker should hold the lock of key
+func (db *DB) Unlink(key string) bool {
+	raw, exists := db.data.Get(key)
+	if !exists {
+		return false
+	}
+	db.Remove(key)
+	entity, _ := raw.(*database.DataEntity)
+	if entity != nil {
+		freeEntityAsync(entity)
+	}
+	return true
+}
+
+// Unlinks removes the given keys from db and releases their values in background
+func (db *DB) Unlinks(keys ...string) (deleted int) {
+	for _, key := range keys {
+		if db.Unlink(key) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// FlushAsync detaches all data from db and releases them in background,
+// invoker should not hold the read lock of stopWorld
+func (db *DB) FlushAsync() {
+	db.stopWorld.Lock()
+	oldData := db.data
+	oldTTL := db.ttlMap
+	db.data = dict.MakeConcurrent(dataDictSize)
+	db.ttlMap = dict.MakeConcurrent(ttlDictSize)
+	db.indexes.clear()
+	db.stopWorld.Unlock()
+
+	// replies sent before flush may still refer to values in the old dict, so only drop the references instead of clearing values
+	submitLazyfree(func() {
+		oldData.Clear()
+		oldTTL.Clear()
+	})
+}
+
+// GetLazyfreeStats returns number of pending objects and number of objects freed in background
+func GetLazyfreeStats() (pending int64, freed int64) {
+	return atomic.LoadInt64(&lazyfreePendingObjects), atomic.LoadInt64(&lazyfreedObjects)
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"godis/config"
+	"godis/datastruct/set"
+	"godis/interface/database"
+	"godis/lib/utils"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func waitLazyfree(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if pending, _ := GetLazyfreeStats(); pending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("lazyfree timeout")
+}
+
+func TestUnlink(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	size := defaultLazyfreeThreshold * 2
+	members := make([]string, size)
+	for i := range members {
+		members[i] = strconv.Itoa(i)
+	}
+	testDB.Exec(nil, utils.ToCmdLine2("sadd", append([]string{key}, members...)...))
+	entity, _ := testDB.GetEntity(key)
+	_, freedBefore := GetLazyfreeStats()
+
+	result := testDB.Exec(nil, utils.ToCmdLine("unlink", key, utils.RandString(10)))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 0)
+
+	waitLazyfree(t)
+	if _, freed := GetLazyfreeStats(); freed != freedBefore+1 {
+		t.Errorf("expected 1 object freed in background, actually %d", freed-freedBefore)
+	}
+	if entity.Data != nil {
+		t.Error("value should be released")
+	}
+
+	// small value won't be sent to background
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key, "a"))
+	entity, _ = testDB.GetEntity(key)
+	result = testDB.Exec(nil, utils.ToCmdLine("unlink", key))
+	asserts.AssertIntReply(t, result, 1)
+	if entity.Data == nil {
+		t.Error("small value should be dropped directly")
+	}
+}
+
+func TestLazyUserDel(t *testing.T) {
+	_ = config.Set([][2]string{{"lazyfree-lazy-user-del", "yes"}})
+	defer func() {
+		_ = config.Set([][2]string{{"lazyfree-lazy-user-del", "no"}})
+	}()
+	testDB.Flush()
+	key := utils.RandString(10)
+	s := set.Make()
+	for i := 0; i <= defaultLazyfreeThreshold; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	entity := &database.DataEntity{Data: s}
+	testDB.PutEntity(key, entity)
+	result := testDB.Exec(nil, utils.ToCmdLine("del", key))
+	asserts.AssertIntReply(t, result, 1)
+	waitLazyfree(t)
+	if entity.Data != nil {
+		t.Error("value should be released")
+	}
+}
+
+func TestFlushDBAsync(t *testing.T) {
+	testDB.Flush()
+	for i := 0; i < 10; i++ {
+		key := utils.RandString(10)
+		testDB.Exec(nil, utils.ToCmdLine("set", key, key, "EX", "1000"))
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("flushdb", "async"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("keys", "*"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	if testDB.ttlMap.Len() != 0 {
+		t.Error("ttl should be flushed")
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("flushdb", "lazy"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	waitLazyfree(t)
+}
+
+func TestLazyfreeQueueFull(t *testing.T) {
+	waitLazyfree(t)
+	started := make(chan struct{})
+	block := make(chan struct{})
+	submitLazyfree(func() {
+		close(started)
+		<-block
+	})
+	<-started
+	// worker is blocked, so the queue will be full
+	for len(lazyfreeQueue) < lazyfreeQueueSize {
+		submitLazyfree(func() {})
+	}
+	done := false
+	submitLazyfree(func() {
+		done = true
+	})
+	if !done {
+		t.Error("job should run synchronously if the queue is full")
+	}
+	close(block)
+	waitLazyfree(t)
+}
+
+func TestLazyfreeLazyExpire(t *testing.T) {
+	_ = config.Set([][2]string{{"lazyfree-lazy-expire", "yes"}})
+	defer func() {
+		_ = config.Set([][2]string{{"lazyfree-lazy-expire", "no"}})
+	}()
+	testDB.Flush()
+	makeSet := func(key string) *database.DataEntity {
+		s := set.Make()
+		for i := 0; i <= defaultLazyfreeThreshold; i++ {
+			s.Add(strconv.Itoa(i))
+		}
+		entity := &database.DataEntity{Data: s}
+		testDB.PutEntity(key, entity)
+		testDB.Expire(key, time.Now().Add(-time.Second))
+		return entity
+	}
+
+	// readers holding the read lock may still be iterating the value expired lazily
+	key := utils.RandString(10)
+	entity := makeSet(key)
+	result := testDB.Exec(nil, utils.ToCmdLine("smembers", key))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	waitLazyfree(t)
+	if entity.Data == nil || entity.Data.(*set.Set).Len() != defaultLazyfreeThreshold+1 {
+		t.Error("value expired under read lock should not be cleared")
+	}
+
+	// active expire holds the write lock
+	key = utils.RandString(10)
+	entity = makeSet(key)
+	if !testDB.expireIfNeeded(key, time.Now()) {
+		t.Error("key should be expired")
+	}
+	waitLazyfree(t)
+	if entity.Data != nil {
+		t.Error("value should be released")
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"godis/datastruct/fencedlock"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strconv"
+	"time"
+)
+
+// Locks are leases with fencing tokens. A lock key never expires so that its fencing token keeps increasing
+// after the lease expired or released, the token restarts from 1 only if the key is deleted.
+// Changes are appended into aof as LOCK.LOAD with absolute deadline, so replaying aof restores the same lease
+
+func (db *DB) getAsLock(key string) (*fencedlock.Lock, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	l, ok := entity.Data.(*fencedlock.Lock)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return l, nil
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / 1e6
+}
+
+// maxLockTTL is 100 years in milliseconds, longer leases are refused so that deadline never overflows
+const maxLockTTL = int64(100 * 365 * 24 * time.Hour / time.Millisecond)
+
+func parseLockTTL(arg []byte) (int64, reply.ErrorReply) {
+	ttl, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || ttl <= 0 || ttl > maxLockTTL {
+		return 0, reply.MakeErrReply("ERR invalid lock ttl")
+	}
+	return ttl, nil
+}
+
+// parseLockToken refuses empty token, which means a free lock
+func parseLockToken(arg []byte) (string, reply.ErrorReply) {
+	if len(arg) == 0 {
+		return "", reply.MakeErrReply("ERR invalid lock token")
+	}
+	return string(arg), nil
+}
+
+func (db *DB) saveLock(key string, l *fencedlock.Lock) {
+	db.addAof(CmdLine{[]byte("LOCK.LOAD"), []byte(key), l.Marshal()})
+}
+
+// execLockAcquire takes the lock and returns its fencing token, or nil if it is held by another token
+// usage: LOCK.ACQUIRE key token ttl_ms
+func execLockAcquire(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	token, errReply := parseLockToken(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	ttl, errReply := parseLockTTL(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	l, errReply := db.getAsLock(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		l = fencedlock.Make()
+		db.PutEntity(key, &database.DataEntity{Data: l})
+	}
+	fence, ok := l.Acquire(token, nowMillis(), ttl)
+	if !ok {
+		return reply.MakeNullBulkReply()
+	}
+	db.saveLock(key, l)
+	return reply.MakeIntReply(fence)
+}
+
+// execLockRenew extends lease of the owner
+// usage: LOCK.RENEW key token ttl_ms
+func execLockRenew(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	token, errReply := parseLockToken(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	ttl, errReply := parseLockTTL(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	l, errReply := db.getAsLock(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil || !l.Renew(token, nowMillis(), ttl) {
+		return reply.MakeIntReply(0)
+	}
+	db.saveLock(key, l)
+	return reply.MakeIntReply(1)
+}
+
+// execLockRelease frees the lock if it is held by the given token
+// usage: LOCK.RELEASE key token
+func execLockRelease(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	token, errReply := parseLockToken(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	l, errReply := db.getAsLock(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil || !l.Release(token, nowMillis()) {
+		return reply.MakeIntReply(0)
+	}
+	db.saveLock(key, l)
+	return reply.MakeIntReply(1)
+}
+
+// execLockInfo returns owner, remaining lease in milliseconds (-1 if free) and latest fencing token
+// usage: LOCK.INFO key
+func execLockInfo(db *DB, args [][]byte) redis.Reply {
+	l, errReply := db.getAsLock(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return reply.MakeNullBulkReply()
+	}
+	now := nowMillis()
+	var owner redis.Reply = reply.MakeNullBulkReply()
+	ttl := int64(-1)
+	if l.Held(now) {
+		owner = reply.MakeBulkReply([]byte(l.Owner(now)))
+		ttl = l.Deadline() - now
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("owner")),
+		owner,
+		reply.MakeBulkReply([]byte("ttl")),
+		reply.MakeIntReply(ttl),
+		reply.MakeBulkReply([]byte("fence")),
+		reply.MakeIntReply(l.Fence()),
+	})
+}
+
+// execLockLoad restores lock serialized by aof: LOCK.LOAD key data, clients cannot call it
+func execLockLoad(db *DB, args [][]byte) redis.Reply {
+	l, err := fencedlock.Unmarshal(args[1])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	key := string(args[0])
+	db.PutEntity(key, &database.DataEntity{Data: l})
+	db.saveLock(key, l)
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("Lock.Acquire", execLockAcquire, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("Lock.Renew", execLockRenew, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("Lock.Release", execLockRelease, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("Lock.Info", execLockInfo, readFirstKey, nil, 2)
+	RegisterCommand("Lock.Load", execLockLoad, writeFirstKey, rollbackFirstKey, 3)
+	setAOFOnly("Lock.Load")
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("LOCK.INFO", key))
+	asserts.AssertNullBulk(t, ret)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "a", "100000"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "b", "100000"))
+	asserts.AssertNullBulk(t, ret)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "a", "100000"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "fencedlock")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RENEW", key, "b", "100000"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key, "b"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RENEW", key, "a", "200000"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.INFO", key))
+	raw, ok := ret.(*reply.MultiRawReply)
+	if !ok || len(raw.Replies) != 6 {
+		t.Fatalf("unexpected info %s", ret.ToBytes())
+	}
+	asserts.AssertBulkReply(t, raw.Replies[1], "a")
+	if ttl := raw.Replies[3].(*reply.IntReply).Code; ttl <= 100000 || ttl > 200000 {
+		t.Errorf("unexpected ttl %d", ttl)
+	}
+	asserts.AssertIntReply(t, raw.Replies[5], 1)
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.INFO", key))
+	assertRawReplies(t, ret, "$5\r\nowner\r\n", "$-1\r\n", "$3\r\nttl\r\n", ":-1\r\n", "$5\r\nfence\r\n", ":1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "b", "1"))
+	asserts.AssertIntReply(t, ret, 2)
+	time.Sleep(5 * time.Millisecond)
+	// lease of b expired
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key, "b"))
+	asserts.AssertIntReply(t, ret, 0)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "a", "100000"))
+	asserts.AssertIntReply(t, ret, 3)
+
+	// failed transaction restores the lock
+	testServer.Exec(conn, utils.ToCmdLine("SET", "str", "x"))
+	testServer.Exec(conn, utils.ToCmdLine("MULTI"))
+	testServer.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key, "a"))
+	testServer.Exec(conn, utils.ToCmdLine("INCR", "str"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("EXEC"))
+	asserts.AssertErrReply(t, ret, "EXECABORT Transaction discarded because of previous errors.")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "b", "100000"))
+	asserts.AssertNullBulk(t, ret)
+
+	// dump and restore keeps fencing token
+	ret = testServer.Exec(conn, utils.ToCmdLine("DUMP", key))
+	payload := ret.(*reply.BulkReply).Arg
+	key2 := key + "2"
+	ret = testServer.Exec(conn, utils.ToCmdLine("RESTORE", key2, "0", string(payload)))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key2, "a"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key2, "b", "100000"))
+	asserts.AssertIntReply(t, ret, 4)
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "a", "0"))
+	asserts.AssertErrReply(t, ret, "ERR invalid lock ttl")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", "str", "a", "1000"))
+	asserts.AssertErrReply(t, ret, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "a", "9223372036854775807"))
+	asserts.AssertErrReply(t, ret, "ERR invalid lock ttl")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.ACQUIRE", key, "", "1000"))
+	asserts.AssertErrReply(t, ret, "ERR invalid lock token")
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.RELEASE", key, ""))
+	asserts.AssertErrReply(t, ret, "ERR invalid lock token")
+
+	// fencing token could not be reset by clients
+	ret = testServer.Exec(conn, utils.ToCmdLine("LOCK.LOAD", key, string(payload)))
+	asserts.AssertErrReply(t, ret, "ERR command 'lock.load' is only used by aof")
+	ret = execLockLoad(testDB, utils.ToCmdLine(key, "\x80"))
+	asserts.AssertErrReply(t, ret, "ERR received bad data")
+}
@@ -0,0 +1,387 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"godis/datastruct/bloom"
+	"godis/datastruct/dict"
+	"godis/datastruct/fencedlock"
+	"godis/datastruct/jsontree"
+	"godis/datastruct/list"
+	"godis/datastruct/set"
+	"godis/datastruct/sketch"
+	"godis/datastruct/sortedset"
+	"godis/datastruct/timeseries"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Memory usage is estimated from sizes of Go structures on 64-bit platforms, it does not include
+// allocator fragmentation and garbage waiting for GC. Collections are estimated by sampling elements
+
+// sizes of Go runtime structures
+const (
+	pointerSize      = 8
+	stringHeaderSize = 16
+	sliceHeaderSize  = 24
+	interfaceSize    = 16
+	timeSize         = 24
+	// node of list.LinkedList: val, prev and next
+	listNodeSize = interfaceSize + 2*pointerSize
+	// sortedset.Element: member and score
+	zsetElementSize = stringHeaderSize + 8
+	// node of skiplist: element, backward and level slice, plus 4/3 levels in average
+	skiplistNodeSize = zsetElementSize + pointerSize + sliceHeaderSize + 4*(pointerSize+pointerSize+8)/3
+)
+
+// defaultMemorySamples is the number of elements sampled by MEMORY USAGE, same as redis
+const defaultMemorySamples = 5
+
+// mapEntrySize estimates memory used by an entry of map[string]T, including tophash and load factor of buckets
+func mapEntrySize(valueSize int) int {
+	return (stringHeaderSize + valueSize + 1) * 8 / 6
+}
+
+// bytesSize estimates memory used by an element of collections
+func bytesSize(val interface{}) int {
+	switch v := val.(type) {
+	case []byte:
+		return sliceHeaderSize + cap(v)
+	case string:
+		return len(v)
+	}
+	return 0
+}
+
+// estimateBySamples extrapolates total size of n elements from the first sampled ones, samples <= 0 means all
+func estimateBySamples(n int, samples int, forEach func(consumer func(size int) bool)) int {
+	if n == 0 {
+		return 0
+	}
+	total, count := 0, 0
+	forEach(func(size int) bool {
+		total += size
+		count++
+		return samples <= 0 || count < samples
+	})
+	if count == 0 {
+		return 0
+	}
+	return total * n / count
+}
+
+// EstimateValueSize returns estimated bytes used by value of entity, samples is the number of elements sampled
+// from collections, samples <= 0 means all elements
+func EstimateValueSize(entity *database.DataEntity, samples int) int64 {
+	size := interfaceSize
+	switch val := entity.Data.(type) {
+	case []byte:
+		size += sliceHeaderSize + cap(val)
+	case *list.LinkedList:
+		size += 3 * pointerSize
+		size += estimateBySamples(val.Len(), samples, func(consumer func(int) bool) {
+			val.ForEach(func(i int, v interface{}) bool {
+				return consumer(listNodeSize + bytesSize(v))
+			})
+		})
+	case dict.Dict:
+		size += pointerSize
+		size += estimateBySamples(val.Len(), samples, func(consumer func(int) bool) {
+			val.ForEach(func(field string, v interface{}) bool {
+				return consumer(mapEntrySize(interfaceSize) + len(field) + bytesSize(v))
+			})
+		})
+	case *set.Set:
+		size += 2 * pointerSize
+		size += estimateBySamples(val.Len(), samples, func(consumer func(int) bool) {
+			val.ForEach(func(member string) bool {
+				return consumer(mapEntrySize(interfaceSize) + len(member))
+			})
+		})
+	case *sortedset.SortedSet:
+		// the skiplist header has max levels
+		size += 3*pointerSize + skiplistNodeSize + 16*(pointerSize+pointerSize+8)
+		n := int(val.Len())
+		size += estimateBySamples(n, samples, func(consumer func(int) bool) {
+			val.ForEach(0, int64(n), false, func(element *sortedset.Element) bool {
+				return consumer(mapEntrySize(pointerSize) + zsetElementSize + skiplistNodeSize + len(element.Member))
+			})
+		})
+	case *bloom.ScalableFilter:
+		size += int(val.Size())
+	case *bloom.CuckooFilter:
+		size += int(val.Size())
+	case *sketch.CountMinSketch:
+		size += len(val.Marshal())
+	case *sketch.TopK:
+		size += len(val.Marshal())
+	case *jsontree.Document:
+		size += len(jsontree.Marshal(val.Root))
+	case *timeseries.Series:
+		size += val.MemoryUsage()
+	case *fencedlock.Lock:
+		size += len(val.Marshal())
+	case database.CustomValue:
+		size += len(val.Marshal())
+	}
+	return int64(size)
+}
+
+// EstimateKeySize returns estimated bytes used by a key, including its entry in keyspace, value and expiration
+func EstimateKeySize(key string, entity *database.DataEntity, expiration *time.Time, samples int) int64 {
+	size := int64(mapEntrySize(interfaceSize) + len(key))
+	size += EstimateValueSize(entity, samples)
+	if expiration != nil {
+		size += int64(mapEntrySize(interfaceSize) + timeSize)
+	}
+	return size
+}
+
+// execMemoryUsage estimates bytes used by key, or returns nil if key does not exist
+// usage: MEMORY USAGE key [SAMPLES count]
+func execMemoryUsage(db *DB, args [][]byte) redis.Reply {
+	samples := defaultMemorySamples
+	if len(args) == 3 && strings.ToUpper(string(args[1])) == "SAMPLES" {
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		samples = n
+	} else if len(args) != 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	var expiration *time.Time
+	if raw, ok := db.ttlMap.Get(key); ok {
+		expireTime, _ := raw.(time.Time)
+		expiration = &expireTime
+	}
+	return reply.MakeIntReply(EstimateKeySize(key, entity, expiration, samples))
+}
+
+// bigKey is a key with its estimated size
+type bigKey struct {
+	dbIndex int
+	key     string
+	size    int64
+}
+
+// dbMemoryStats is memory usage of a database
+type dbMemoryStats struct {
+	keys    int
+	expires int
+	bytes   int64
+}
+
+// memoryStats is estimated memory usage of dataset
+type memoryStats struct {
+	dbs   []dbMemoryStats
+	keys  int
+	bytes int64
+	// biggest keys, largest first
+	bigKeys []*bigKey
+}
+
+// maxBigKeys is the number of biggest keys kept by memory stats
+const maxBigKeys = 10
+
+// addBigKey keeps the biggest keys in order
+func (stats *memoryStats) addBigKey(k *bigKey) {
+	keys := stats.bigKeys
+	if len(keys) == maxBigKeys && keys[len(keys)-1].size >= k.size {
+		return
+	}
+	i := sort.Search(len(keys), func(i int) bool {
+		return keys[i].size < k.size
+	})
+	if len(keys) < maxBigKeys {
+		keys = append(keys, nil)
+	}
+	copy(keys[i+1:], keys[i:])
+	keys[i] = k
+	stats.bigKeys = keys
+}
+
+// getMemoryStats estimates memory usage of all keys by sampling, it takes O(N) time.
+// Every key is locked while its value being estimated
+func (mdb *MultiDB) getMemoryStats() *memoryStats {
+	stats := &memoryStats{
+		dbs: make([]dbMemoryStats, len(mdb.dbSet)),
+	}
+	for i, db := range mdb.dbSet {
+		var keys []string
+		db.stopWorld.RLock()
+		db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			keys = append(keys, key)
+			return true
+		})
+		dbStats := &stats.dbs[i]
+		for _, key := range keys {
+			db.RWLocks(nil, []string{key})
+			if entity, ok := db.GetEntity(key); ok {
+				var expiration *time.Time
+				if raw, ok := db.ttlMap.Get(key); ok {
+					expireTime, _ := raw.(time.Time)
+					expiration = &expireTime
+					dbStats.expires++
+				}
+				size := EstimateKeySize(key, entity, expiration, defaultMemorySamples)
+				dbStats.keys++
+				dbStats.bytes += size
+				stats.addBigKey(&bigKey{dbIndex: i, key: key, size: size})
+			}
+			db.RWUnLocks(nil, []string{key})
+		}
+		db.stopWorld.RUnlock()
+		stats.keys += dbStats.keys
+		stats.bytes += dbStats.bytes
+	}
+	return stats
+}
+
+// execMemoryStats returns memory usage of Go runtime and estimated usage of dataset
+// usage: MEMORY STATS
+func execMemoryStats(mdb *MultiDB) redis.Reply {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := mdb.getMemoryStats()
+	pending, _ := GetLazyfreeStats()
+	bytesPerKey := int64(0)
+	if stats.keys > 0 {
+		bytesPerKey = int64(m.HeapAlloc) / int64(stats.keys)
+	}
+	percentage := float64(0)
+	if m.HeapAlloc > 0 {
+		percentage = float64(stats.bytes) * 100 / float64(m.HeapAlloc)
+	}
+	result := []redis.Reply{
+		reply.MakeBulkReply([]byte("total.allocated")), reply.MakeIntReply(int64(m.HeapAlloc)),
+		reply.MakeBulkReply([]byte("heap.inuse")), reply.MakeIntReply(int64(m.HeapInuse)),
+		reply.MakeBulkReply([]byte("heap.idle")), reply.MakeIntReply(int64(m.HeapIdle - m.HeapReleased)),
+		reply.MakeBulkReply([]byte("sys")), reply.MakeIntReply(int64(m.Sys)),
+		reply.MakeBulkReply([]byte("gc.count")), reply.MakeIntReply(int64(m.NumGC)),
+		reply.MakeBulkReply([]byte("lazyfree.pending_objects")), reply.MakeIntReply(pending),
+	}
+	for i, dbStats := range stats.dbs {
+		if dbStats.keys == 0 {
+			continue
+		}
+		result = append(result,
+			reply.MakeBulkReply([]byte("db."+strconv.Itoa(i))),
+			reply.MakeMultiRawReply([]redis.Reply{
+				reply.MakeBulkReply([]byte("keys")), reply.MakeIntReply(int64(dbStats.keys)),
+				reply.MakeBulkReply([]byte("expires")), reply.MakeIntReply(int64(dbStats.expires)),
+				reply.MakeBulkReply([]byte("dataset.bytes")), reply.MakeIntReply(dbStats.bytes),
+			}),
+		)
+	}
+	result = append(result,
+		reply.MakeBulkReply([]byte("keys.count")), reply.MakeIntReply(int64(stats.keys)),
+		reply.MakeBulkReply([]byte("keys.bytes-per-key")), reply.MakeIntReply(bytesPerKey),
+		reply.MakeBulkReply([]byte("dataset.bytes")), reply.MakeIntReply(stats.bytes),
+		reply.MakeBulkReply([]byte("dataset.percentage")),
+		reply.MakeBulkReply([]byte(strconv.FormatFloat(percentage, 'f', 2, 64))),
+	)
+	return reply.MakeMultiRawReply(result)
+}
+
+// thresholds of MEMORY DOCTOR
+const (
+	// a key is reported if it takes more than 10% of dataset and 1MB
+	bigKeyMinBytes = 1 << 20
+	// idle heap more than 1.5 times of allocated heap and 64MB is reported
+	idleHeapMinBytes = 64 << 20
+	// lazy free backlog is reported if it is more than 1000 objects
+	lazyfreeMaxPending = 1000
+)
+
+// execMemoryDoctor reports memory problems found by MEMORY STATS
+// usage: MEMORY DOCTOR
+func execMemoryDoctor(mdb *MultiDB) redis.Reply {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := mdb.getMemoryStats()
+	if stats.keys == 0 {
+		return reply.MakeBulkReply([]byte("The instance is empty, there is nothing to diagnose."))
+	}
+	var buf bytes.Buffer
+	for _, k := range stats.bigKeys {
+		if k.size < bigKeyMinBytes || k.size*10 < stats.bytes {
+			break
+		}
+		buf.WriteString(fmt.Sprintf("* Big key: %q in db %d uses about %d bytes, %.1f%% of dataset. "+
+			"Consider splitting it, and deleting it by UNLINK.\n", k.key, k.dbIndex, k.size, float64(k.size)*100/float64(stats.bytes)))
+	}
+	idle := m.HeapIdle - m.HeapReleased
+	if idle > idleHeapMinBytes && float64(idle) > 1.5*float64(m.HeapAlloc) {
+		buf.WriteString(fmt.Sprintf("* High heap idle: %d bytes are free but not returned to OS, "+
+			"which usually follows deletion of many keys. Go runtime returns them gradually.\n", idle))
+	}
+	if pending, _ := GetLazyfreeStats(); pending > lazyfreeMaxPending {
+		buf.WriteString(fmt.Sprintf("* Lazy free backlog: %d objects are waiting to be freed in background.\n", pending))
+	}
+	if buf.Len() == 0 {
+		return reply.MakeBulkReply([]byte("No memory problems detected."))
+	}
+	return reply.MakeBulkReply(buf.Bytes())
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return estimated memory usage of the specified <key>. Elements of collections are sampled,",
+	"    default <count> is 5, 0 means all elements.",
+	"HELP",
+	"    Prints this help.",
+}
+
+// execMemory inspects memory usage
+func execMemory(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("memory")
+	}
+	subCmd := strings.ToUpper(string(args[0]))
+	switch subCmd {
+	case "USAGE":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply("memory|usage")
+		}
+		dbIndex := c.GetDBIndex()
+		if dbIndex >= len(mdb.dbSet) {
+			return reply.MakeErrReply("ERR DB index is out of range")
+		}
+		db := mdb.dbSet[dbIndex]
+		readKeys := []string{string(args[1])}
+		db.stopWorld.RLock()
+		defer db.stopWorld.RUnlock()
+		db.RWLocks(nil, readKeys)
+		defer db.RWUnLocks(nil, readKeys)
+		return execMemoryUsage(db, args[1:])
+	case "STATS", "DOCTOR", "HELP":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("memory|" + strings.ToLower(subCmd))
+		}
+		if subCmd == "STATS" {
+			return execMemoryStats(mdb)
+		} else if subCmd == "DOCTOR" {
+			return execMemoryDoctor(mdb)
+		}
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(memoryHelp...))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try MEMORY HELP.")
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"godis/aof"
+	"godis/config"
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func getMemoryUsage(t *testing.T, args ...string) int64 {
+	t.Helper()
+	ret := testServer.Exec(&connection.FakeConn{}, utils.ToCmdLine(append([]string{"MEMORY", "USAGE"}, args...)...))
+	intReply, ok := ret.(*reply.IntReply)
+	if !ok {
+		t.Fatalf("expected int reply, actually %q", ret.ToBytes())
+	}
+	return intReply.Code
+}
+
+func TestMemoryUsage(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("MEMORY", "USAGE", key))
+	asserts.AssertNullBulk(t, ret)
+
+	testServer.Exec(conn, utils.ToCmdLine("SET", key, strings.Repeat("a", 1000)))
+	size := getMemoryUsage(t, key)
+	if size < 1000 || size > 1200 {
+		t.Errorf("unexpected size of string: %d", size)
+	}
+	testServer.Exec(conn, utils.ToCmdLine("EXPIRE", key, "1000"))
+	if withTTL := getMemoryUsage(t, key); withTTL <= size {
+		t.Errorf("expiration is not counted: %d", withTTL)
+	}
+
+	// elements of the same size are estimated exactly by sampling
+	for _, cmd := range []string{"RPUSH", "SADD", "HSET", "ZADD"} {
+		key := utils.RandString(10)
+		var prev int64
+		for i := 0; i < 100; i++ {
+			member := strconv.Itoa(1000 + i)
+			switch cmd {
+			case "HSET", "ZADD":
+				testServer.Exec(conn, utils.ToCmdLine(cmd, key, member, member))
+			default:
+				testServer.Exec(conn, utils.ToCmdLine(cmd, key, member))
+			}
+			size := getMemoryUsage(t, key)
+			if size <= prev {
+				t.Errorf("%s: size does not grow with elements: %d -> %d", cmd, prev, size)
+				break
+			}
+			prev = size
+		}
+		if all := getMemoryUsage(t, key, "SAMPLES", "0"); all != prev {
+			t.Errorf("%s: sampled size %d, actual %d", cmd, prev, all)
+		}
+	}
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("MEMORY", "USAGE", key, "SAMPLES", "-1"))
+	asserts.AssertErrReply(t, ret, "ERR value is not an integer or out of range")
+	ret = testServer.Exec(conn, utils.ToCmdLine("MEMORY", "USAGE", key, "SAMPLES"))
+	asserts.AssertErrReply(t, ret, "Err syntax error")
+	ret = testServer.Exec(conn, utils.ToCmdLine("MEMORY", "PURGE"))
+	asserts.AssertErrReply(t, ret, "ERR unknown subcommand 'PURGE'. Try MEMORY HELP.")
+	ret = testServer.Exec(conn, utils.ToCmdLine("MEMORY"))
+	asserts.AssertErrReply(t, ret, "ERR wrong number of arguments for 'memory' command")
+}
+
+func TestMemoryStats(t *testing.T) {
+	config.SetProperties(&config.ServerProperties{
+		Databases: 16,
+	})
+	server := MakeBasicMultiDB()
+	conn := &connection.FakeConn{}
+	ret := server.Exec(conn, utils.ToCmdLine("MEMORY", "DOCTOR"))
+	asserts.AssertBulkReply(t, ret, "The instance is empty, there is nothing to diagnose.")
+
+	conn.SelectDB(2)
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "a", "EX", "1000"))
+	server.Exec(conn, utils.ToCmdLine("SET", "big", strings.Repeat("a", 2<<20)))
+	ret = server.Exec(conn, utils.ToCmdLine("MEMORY", "STATS"))
+	raw, ok := ret.(*reply.MultiRawReply)
+	if !ok {
+		t.Fatalf("unexpected stats %q", ret.ToBytes())
+	}
+	stats := make(map[string][]byte)
+	for i := 0; i+1 < len(raw.Replies); i += 2 {
+		name := string(raw.Replies[i].(*reply.BulkReply).Arg)
+		stats[name] = raw.Replies[i+1].ToBytes()
+	}
+	if string(stats["keys.count"]) != ":2\r\n" {
+		t.Errorf("unexpected keys.count %q", stats["keys.count"])
+	}
+	bigSize := getMemoryUsageOf(t, server, conn, "big")
+	totalSize := bigSize + getMemoryUsageOf(t, server, conn, "a")
+	if string(stats["dataset.bytes"]) != ":"+strconv.FormatInt(totalSize, 10)+"\r\n" {
+		t.Errorf("unexpected dataset.bytes %q, expect %d", stats["dataset.bytes"], totalSize)
+	}
+	expected := "*6\r\n$4\r\nkeys\r\n:2\r\n$7\r\nexpires\r\n:1\r\n$13\r\ndataset.bytes\r\n:" + strconv.FormatInt(totalSize, 10) + "\r\n"
+	if string(stats["db.2"]) != expected {
+		t.Errorf("unexpected db.2 %q", stats["db.2"])
+	}
+
+	ret = server.Exec(conn, utils.ToCmdLine("MEMORY", "DOCTOR"))
+	bulk, ok := ret.(*reply.BulkReply)
+	if !ok || !strings.Contains(string(bulk.Arg), `Big key: "big" in db 2`) {
+		t.Errorf("big key is not reported: %q", ret.ToBytes())
+	}
+}
+
+func getMemoryUsageOf(t *testing.T, server *MultiDB, conn *connection.FakeConn, key string) int64 {
+	t.Helper()
+	ret := server.Exec(conn, utils.ToCmdLine("MEMORY", "USAGE", key))
+	intReply, ok := ret.(*reply.IntReply)
+	if !ok {
+		t.Fatalf("expected int reply, actually %q", ret.ToBytes())
+	}
+	return intReply.Code
+}
+
+func TestLoadAofFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	aofFilename := path.Join(tmpDir, "a.aof")
+	config.SetProperties(&config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+	})
+	server := NewStandaloneServer()
+	makeTestData(server, 1, "", 2)
+	server.Exec(nil, utils.ToCmdLine("REWRITEAOF"))
+	makeTestData(server, 3, "", 2)
+	server.Close()
+
+	loaded := MakeBasicMultiDB()
+	if err := aof.LoadFile(loaded, aofFilename+".manifest"); err != nil {
+		t.Fatal(err)
+	}
+	validateTestData(t, loaded, 1, "", 2)
+	validateTestData(t, loaded, 3, "", 2)
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"errors"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Extension points for package godis/module, they should be used before the server started
+
+// RegisterModuleCommand registers a command like RegisterCommand, but it fails if the name is used.
+// Write keys returned by prepare are restored to roll back a failed MULTI if rollback is nil
+func RegisterModuleCommand(name string, executor ExecFunc, prepare PreFunc, rollback UndoFunc, arity int, flags int) error {
+	name = strings.ToLower(name)
+	if _, ok := cmdTable[name]; ok {
+		return errors.New("command " + name + " already exists")
+	}
+	for _, special := range specialCommands {
+		if special == name {
+			return errors.New("command " + name + " already exists")
+		}
+	}
+	if executor == nil || prepare == nil {
+		return errors.New("executor and prepare of command " + name + " are required")
+	}
+	if arity == 0 {
+		return errors.New("arity of command " + name + " should not be 0")
+	}
+	if rollback == nil {
+		rollback = func(db *DB, args [][]byte) []CmdLine {
+			write, _ := prepare(args)
+			return rollbackGivenKeys(db, write...)
+		}
+	}
+	RegisterCommand(name, executor, prepare, rollback, arity)
+	cmdTable[name].flags = flags
+	return nil
+}
+
+// UnregisterModuleCommand removes a command registered by RegisterModuleCommand, it rolls back a module failed to load
+func UnregisterModuleCommand(name string) {
+	delete(cmdTable, strings.ToLower(name))
+}
+
+// Propagate appends command line into aof of db.
+// Commands without FlagWrite use it to persist effects which cannot be replayed verbatim
+func (db *DB) Propagate(cmdLine CmdLine) {
+	db.addAof(cmdLine)
+}
+
+// Index returns index of db
+func (db *DB) Index() int {
+	return db.index
+}
+
+// CustomType is a value type registered by module, its values implement database.CustomValue
+type CustomType struct {
+	Name string
+	// Unmarshal rebuilds value from result of CustomValue.Marshal
+	Unmarshal func(data []byte) (database.CustomValue, error)
+}
+
+var (
+	customTypesMu sync.RWMutex
+	customTypes   = make(map[string]*CustomType)
+)
+
+// names returned by TYPE command for builtin types
+var builtinTypeNames = []string{
+	"none", "string", "list", "hash", "set", "zset",
+	"MBbloom--", "MBbloomCF", "CMSk-TYPE", "TopK-TYPE", "ReJSON-RL", "TSDB-TYPE", "fencedlock",
+}
+
+// RegisterCustomType registers a value type, its name should be unique
+func RegisterCustomType(t *CustomType) error {
+	if t.Name == "" || t.Unmarshal == nil {
+		return errors.New("name and unmarshal function of type are required")
+	}
+	for _, name := range builtinTypeNames {
+		if strings.EqualFold(name, t.Name) {
+			return errors.New("type " + t.Name + " already exists")
+		}
+	}
+	customTypesMu.Lock()
+	defer customTypesMu.Unlock()
+	if _, ok := customTypes[t.Name]; ok {
+		return errors.New("type " + t.Name + " already exists")
+	}
+	customTypes[t.Name] = t
+	return nil
+}
+
+// UnregisterCustomType removes a registered value type, it rolls back a module failed to load
+func UnregisterCustomType(name string) {
+	customTypesMu.Lock()
+	defer customTypesMu.Unlock()
+	delete(customTypes, name)
+}
+
+func getCustomType(name string) (*CustomType, bool) {
+	customTypesMu.RLock()
+	defer customTypesMu.RUnlock()
+	t, ok := customTypes[name]
+	return t, ok
+}
+
+// execLoadValue restores value of custom type, it is generated by aof rewrite and DUMP, clients cannot call it
+// usage: MODULE.LOADVALUE key type data
+func execLoadValue(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	t, ok := getCustomType(string(args[1]))
+	if !ok {
+		return reply.MakeErrReply("ERR unknown type " + string(args[1]) + ", is its module loaded?")
+	}
+	value, err := t.Unmarshal(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR Bad data format: " + err.Error())
+	}
+	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.addAof(CmdLine{[]byte("MODULE.LOADVALUE"), args[0], args[1], args[2]})
+	return reply.MakeOkReply()
+}
+
+// KeyspaceHook is notified of keys modified by succeeded commands and expiration.
+// event is name of the command in lower case, or "expired".
+// Hooks are called synchronously while keys are locked, so they must not execute commands on the keys
+type KeyspaceHook func(dbIndex int, event string, key string)
+
+// keyspaceHooks stores []KeyspaceHook, it is copied on write
+var keyspaceHooks atomic.Value
+
+var keyspaceHooksMu sync.Mutex
+
+// AddKeyspaceHook registers a hook receiving keyspace events of all databases
+func AddKeyspaceHook(hook KeyspaceHook) {
+	keyspaceHooksMu.Lock()
+	defer keyspaceHooksMu.Unlock()
+	hooks, _ := keyspaceHooks.Load().([]KeyspaceHook)
+	updated := make([]KeyspaceHook, len(hooks), len(hooks)+1)
+	copy(updated, hooks)
+	keyspaceHooks.Store(append(updated, hook))
+}
+
+func (db *DB) notifyKeyspace(event string, keys []string) {
+	if !db.keyspaceEvents || len(keys) == 0 {
+		return
+	}
+	hooks, _ := keyspaceHooks.Load().([]KeyspaceHook)
+	for _, hook := range hooks {
+		for _, key := range keys {
+			hook(db.index, event, key)
+		}
+	}
+}
+
+func init() {
+	RegisterCommand("Module.LoadValue", execLoadValue, writeFirstKey, rollbackFirstKey, 4)
+	setAOFOnly("Module.LoadValue")
+}
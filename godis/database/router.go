@@ -1,6 +1,10 @@
 package database
 
 import (
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"sort"
 	"strings"
 )
 
@@ -12,8 +16,20 @@ type command struct {
 	undo     UndoFunc
 	arity    int // allow number of args, arity < 0 means len(args) >= -arity
 	flags    int
+	// dataPrepare returns more write keys by reading data, nil for most commands
+	dataPrepare DataPreFunc
 }
 
+// flags of commands registered by modules, builtin commands have no flags except internal ones
+const (
+	// FlagWrite appends command line into aof verbatim once the command succeeds
+	FlagWrite = 1 << iota
+	// FlagNoMulti forbids the command in MULTI, commands locking keys by themselves should have it
+	FlagNoMulti
+	// flagAOFOnly marks internal commands generated by aof rewrite, they are refused from clients
+	flagAOFOnly
+)
+
 // RegisterCommand registers a new command
 // arity means allowed number of cmdArgs, arity < 0 means len(args) >= -arity.
 // for example: the arity of `get` is 2, `mget` is -2
@@ -26,3 +42,64 @@ func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, rollback U
 		arity:    arity,
 	}
 }
+
+// setAOFOnly marks a registered command as internal, only connections replaying aof could execute it
+func setAOFOnly(name string) {
+	cmdTable[strings.ToLower(name)].flags |= flagAOFOnly
+}
+
+// refuseInternal returns error reply if an internal command is not replayed from aof
+func (cmd *command) refuseInternal(c redis.Connection, cmdName string) redis.Reply {
+	if cmd.flags&flagAOFOnly != 0 && (c == nil || !c.IsReplaying()) {
+		return reply.MakeErrReply("ERR command '" + cmdName + "' is only used by aof")
+	}
+	return nil
+}
+
+// setDataPrepare sets DataPreFunc of a registered command
+func setDataPrepare(name string, dataPrepare DataPreFunc) {
+	cmdTable[strings.ToLower(name)].dataPrepare = dataPrepare
+}
+
+// exec runs the command, invoker should provide locks
+func (cmd *command) exec(db *DB, cmdLine [][]byte) redis.Reply {
+	result := cmd.executor(db, cmdLine[1:])
+	if cmd.flags&FlagWrite != 0 && !reply.IsErrorReply(result) {
+		db.addAof(cmdLine)
+	}
+	return result
+}
+
+// specialCommands are not in cmdTable, they are executed by MultiDB, DB or the connection handler directly
+var specialCommands = []string{
+	"auth", "select", "subscribe", "unsubscribe", "publish",
+	"bgrewriteaof", "rewriteaof", "flushall", "info", "config", "slowlog", "shutdown", "client",
+	"copy", "move", "swapdb", "monitor", "command", "memory",
+	"multi", "exec", "discard", "watch", "unwatch",
+}
+
+// CommandNames returns names of all supported commands in lower case and sorted
+func CommandNames() []string {
+	names := make([]string, 0, len(cmdTable)+len(specialCommands))
+	for name := range cmdTable {
+		names = append(names, name)
+	}
+	names = append(names, specialCommands...)
+	sort.Strings(names)
+	return names
+}
+
+// execCommand returns names of commands, so that clients such as godis-cli could complete them
+func execCommand(args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("command")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "count":
+		return reply.MakeIntReply(int64(len(cmdTable) + len(specialCommands)))
+	case "list":
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(CommandNames()...))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try COMMAND HELP.")
+}
@@ -0,0 +1,493 @@
+package database
+
+import (
+	Dict "godis/datastruct/dict"
+	"godis/datastruct/search"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultSearchLimit is the page size of FT.SEARCH without LIMIT
+const defaultSearchLimit = 10
+
+// searchIndexes holds full-text indexes of a DB.
+// Hashes are indexed by the data access functions and hash commands, so that indexes are consistent
+// with writes, deletes and expirations. Lock order is key lock then index lock
+type searchIndexes struct {
+	// number of indexes, skip locking if there is none
+	count int32
+
+	mu      sync.RWMutex
+	indexes map[string]*search.Index
+}
+
+func makeSearchIndexes() *searchIndexes {
+	return &searchIndexes{
+		indexes: make(map[string]*search.Index),
+	}
+}
+
+func (s *searchIndexes) get(name string) (*search.Index, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.indexes[name]
+	return idx, ok
+}
+
+// add registers idx, returns false if name is used
+func (s *searchIndexes) add(idx *search.Index) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.indexes[idx.Name]; ok {
+		return false
+	}
+	s.indexes[idx.Name] = idx
+	atomic.AddInt32(&s.count, 1)
+	return true
+}
+
+func (s *searchIndexes) remove(name string) (*search.Index, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, ok := s.indexes[name]
+	if ok {
+		delete(s.indexes, name)
+		atomic.AddInt32(&s.count, -1)
+	}
+	return idx, ok
+}
+
+// list returns indexes sorted by name
+func (s *searchIndexes) list() []*search.Index {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*search.Index, 0, len(s.indexes))
+	for _, idx := range s.indexes {
+		result = append(result, idx)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// update indexes the new value of key, values other than hash are removed from indexes
+func (s *searchIndexes) update(key string, entity *database.DataEntity) {
+	if s == nil || atomic.LoadInt32(&s.count) == 0 {
+		return
+	}
+	var dict Dict.Dict
+	if entity != nil {
+		dict, _ = entity.Data.(Dict.Dict)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, idx := range s.indexes {
+		if !idx.Match(key) {
+			continue
+		}
+		if dict == nil {
+			idx.Remove(key)
+		} else {
+			idx.Put(key, schemaFields(idx, dict))
+		}
+	}
+}
+
+func (s *searchIndexes) unindex(key string) {
+	s.update(key, nil)
+}
+
+// clear drops documents of all indexes but keeps their definitions
+func (s *searchIndexes) clear() {
+	if s == nil || atomic.LoadInt32(&s.count) == 0 {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, idx := range s.indexes {
+		idx.Clear()
+	}
+}
+
+// schemaFields copies values of fields in schema of idx from hash, other fields are not indexed
+func schemaFields(idx *search.Index, dict Dict.Dict) map[string][]byte {
+	fields := make(map[string][]byte, len(idx.Fields))
+	for _, f := range idx.Fields {
+		if val, ok := dict.Get(f.Name); ok {
+			fields[f.Name], _ = val.([]byte)
+		}
+	}
+	return fields
+}
+
+// indexHash refreshes indexes after hash of key modified in place
+func (db *DB) indexHash(key string, dict Dict.Dict) {
+	db.indexes.update(key, &database.DataEntity{Data: dict})
+}
+
+// hasExpired tells whether key has expired without removing it, so it can be called while index locked
+func (db *DB) hasExpired(key string) bool {
+	rawExpireTime, ok := db.ttlMap.Get(key)
+	if !ok {
+		return false
+	}
+	expireTime, _ := rawExpireTime.(time.Time)
+	return time.Now().After(expireTime)
+}
+
+// indexDefs returns FT.CREATE commands which recreate indexes of db
+func (db *DB) indexDefs() []CmdLine {
+	var cmds []CmdLine
+	for _, idx := range db.indexes.list() {
+		cmds = append(cmds, makeCreateIndexCmd(idx))
+	}
+	return cmds
+}
+
+func makeCreateIndexCmd(idx *search.Index) CmdLine {
+	cmd := utils.ToCmdLine("FT.CREATE", idx.Name, "ON", "HASH")
+	if len(idx.Prefixes) > 0 {
+		cmd = append(cmd, []byte("PREFIX"), []byte(strconv.Itoa(len(idx.Prefixes))))
+		for _, prefix := range idx.Prefixes {
+			cmd = append(cmd, []byte(prefix))
+		}
+	}
+	cmd = append(cmd, []byte("SCHEMA"))
+	for _, f := range idx.Fields {
+		cmd = append(cmd, []byte(f.Name), []byte(f.Type.String()))
+		switch f.Type {
+		case search.TypeText:
+			cmd = append(cmd, []byte("WEIGHT"), []byte(strconv.FormatFloat(f.Weight, 'f', -1, 64)))
+		case search.TypeTag:
+			cmd = append(cmd, []byte("SEPARATOR"), []byte{f.Separator})
+		}
+		if f.Sortable {
+			cmd = append(cmd, []byte("SORTABLE"))
+		}
+	}
+	return cmd
+}
+
+// parseSchema parses field definitions after SCHEMA
+func parseSchema(args [][]byte) ([]*search.Field, reply.ErrorReply) {
+	var fields []*search.Field
+	names := make(map[string]struct{})
+	for i := 0; i < len(args); {
+		if i+1 >= len(args) {
+			return nil, reply.MakeErrReply("ERR Field `" + string(args[i]) + "` does not have a type")
+		}
+		name := string(args[i])
+		fieldType, ok := search.ParseFieldType(string(args[i+1]))
+		if !ok {
+			return nil, reply.MakeErrReply("ERR Invalid field type for field `" + name + "`")
+		}
+		if _, ok := names[name]; ok {
+			return nil, reply.MakeErrReply("ERR Duplicate field in schema - " + name)
+		}
+		names[name] = struct{}{}
+		f := &search.Field{Name: name, Type: fieldType}
+		i += 2
+	options:
+		for i < len(args) {
+			option := strings.ToUpper(string(args[i]))
+			switch {
+			case option == "SORTABLE":
+				f.Sortable = true
+				i++
+			case option == "WEIGHT" && fieldType == search.TypeText && i+1 < len(args):
+				weight, err := strconv.ParseFloat(string(args[i+1]), 64)
+				if err != nil || weight <= 0 || math.IsInf(weight, 0) {
+					return nil, reply.MakeErrReply("ERR Bad arguments for WEIGHT: must be a positive number")
+				}
+				f.Weight = weight
+				i += 2
+			case option == "SEPARATOR" && fieldType == search.TypeTag && i+1 < len(args):
+				if len(args[i+1]) != 1 {
+					return nil, reply.MakeErrReply("ERR Tag separator must be a single character")
+				}
+				f.Separator = args[i+1][0]
+				i += 2
+			default:
+				break options
+			}
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, reply.MakeErrReply("ERR Fields arguments are missing")
+	}
+	return fields, nil
+}
+
+// execFTCreate creates an index and indexes existing hashes
+// usage: FT.CREATE index [ON HASH] [PREFIX count prefix ...] SCHEMA field TEXT|NUMERIC|TAG [WEIGHT w] [SEPARATOR c] [SORTABLE] ...
+func execFTCreate(db *DB, args [][]byte) redis.Reply {
+	name := string(args[0])
+	var prefixes []string
+	var fields []*search.Field
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "SCHEMA" {
+			var errReply reply.ErrorReply
+			fields, errReply = parseSchema(args[i+1:])
+			if errReply != nil {
+				return errReply
+			}
+			break
+		}
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		i++
+		switch option {
+		case "ON":
+			if strings.ToUpper(string(args[i])) != "HASH" {
+				return reply.MakeErrReply("ERR only HASH is supported")
+			}
+		case "PREFIX":
+			count, err := strconv.Atoi(string(args[i]))
+			if err != nil || count < 0 || i+count >= len(args) {
+				return reply.MakeErrReply("ERR Bad arguments for PREFIX")
+			}
+			for _, prefix := range args[i+1 : i+1+count] {
+				prefixes = append(prefixes, string(prefix))
+			}
+			i += count
+		default:
+			return reply.MakeErrReply("ERR Unknown argument `" + string(args[i-1]) + "`")
+		}
+	}
+	if fields == nil {
+		return reply.MakeErrReply("ERR No schema found")
+	}
+	idx := search.MakeIndex(name, prefixes, fields)
+	if !db.indexes.add(idx) {
+		return reply.MakeErrReply("ERR Index already exists")
+	}
+	// hashes written from now on are indexed by hooks, the existing ones are indexed under their locks
+	var keys []string
+	db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if _, ok := entity.Data.(Dict.Dict); ok && idx.Match(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		db.RWLocks(nil, []string{key})
+		if dict, _ := db.getAsDict(key); dict != nil {
+			idx.Put(key, schemaFields(idx, dict))
+		}
+		db.RWUnLocks(nil, []string{key})
+	}
+	db.addAof(utils.ToCmdLine3("ft.create", args...))
+	return reply.MakeOkReply()
+}
+
+func (db *DB) getIndex(name string) (*search.Index, reply.ErrorReply) {
+	idx, ok := db.indexes.get(name)
+	if !ok {
+		return nil, reply.MakeErrReply("ERR " + name + ": no such index")
+	}
+	return idx, nil
+}
+
+// execFTSearch searches documents of index
+// usage: FT.SEARCH index query [NOCONTENT] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+func execFTSearch(db *DB, args [][]byte) redis.Reply {
+	idx, errReply := db.getIndex(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	query, err := idx.ParseQuery(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	opts := &search.SearchOptions{Limit: defaultSearchLimit}
+	noContent := false
+	var returnFields []string
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "NOCONTENT":
+			noContent = true
+		case option == "RETURN" && i+1 < len(args):
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 0 || i+1+count >= len(args) {
+				return reply.MakeErrReply("ERR Bad arguments for RETURN")
+			}
+			returnFields = make([]string, 0, count)
+			for _, f := range args[i+2 : i+2+count] {
+				returnFields = append(returnFields, string(f))
+			}
+			i += 1 + count
+		case option == "SORTBY" && i+1 < len(args):
+			field := string(args[i+1])
+			if f, ok := idx.Field(field); !ok || !f.Sortable {
+				return reply.MakeErrReply("ERR Property `" + field + "` not loaded nor in schema")
+			}
+			opts.SortBy = field
+			i++
+			if i+1 < len(args) {
+				order := strings.ToUpper(string(args[i+1]))
+				if order == "ASC" || order == "DESC" {
+					opts.Desc = order == "DESC"
+					i++
+				}
+			}
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(string(args[i+1]))
+			limit, err2 := strconv.Atoi(string(args[i+2]))
+			if err1 != nil || err2 != nil || offset < 0 || limit < 0 {
+				return reply.MakeErrReply("ERR Bad arguments for LIMIT")
+			}
+			opts.Offset, opts.Limit = offset, limit
+			i += 2
+		default:
+			return reply.MakeErrReply("ERR Unknown argument `" + string(args[i]) + "`")
+		}
+	}
+	// expired keys are hidden until they are removed
+	opts.Filter = func(key string) bool {
+		return !db.hasExpired(key)
+	}
+	keys, total := idx.Search(query, opts)
+	result := []redis.Reply{reply.MakeIntReply(int64(total))}
+	for _, key := range keys {
+		result = append(result, reply.MakeBulkReply([]byte(key)))
+		if noContent {
+			continue
+		}
+		result = append(result, db.readDocument(key, returnFields))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// readDocument returns fields of hash as [field, value, ...], all fields are returned if names is nil
+func (db *DB) readDocument(key string, names []string) redis.Reply {
+	db.RWLocks(nil, []string{key})
+	defer db.RWUnLocks(nil, []string{key})
+	dict, _ := db.getAsDict(key)
+	var fields [][]byte
+	if dict == nil {
+		return reply.MakeMultiBulkReply(fields)
+	}
+	if names == nil {
+		dict.ForEach(func(field string, val interface{}) bool {
+			fields = append(fields, []byte(field), val.([]byte))
+			return true
+		})
+		return reply.MakeMultiBulkReply(fields)
+	}
+	for _, name := range names {
+		if val, ok := dict.Get(name); ok {
+			fields = append(fields, []byte(name), val.([]byte))
+		}
+	}
+	return reply.MakeMultiBulkReply(fields)
+}
+
+// execFTDropIndex drops index, DD deletes the indexed hashes as well
+// usage: FT.DROPINDEX index [DD]
+func execFTDropIndex(db *DB, args [][]byte) redis.Reply {
+	deleteDocs := false
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(args) == 2 {
+		if strings.ToUpper(string(args[1])) != "DD" {
+			return reply.MakeSyntaxErrReply()
+		}
+		deleteDocs = true
+	}
+	idx, ok := db.indexes.remove(string(args[0]))
+	if !ok {
+		return reply.MakeErrReply("ERR Unknown Index name")
+	}
+	if deleteDocs {
+		for _, key := range idx.Keys() {
+			db.RWLocks([]string{key}, nil)
+			// the key may be overwritten by other types since indexed
+			if dict, _ := db.getAsDict(key); dict != nil {
+				db.Remove(key)
+				db.addVersion(key)
+			}
+			db.RWUnLocks([]string{key}, nil)
+		}
+	}
+	db.addAof(utils.ToCmdLine3("ft.dropindex", args...))
+	return reply.MakeOkReply()
+}
+
+// execFTInfo returns definition and statistics of index
+func execFTInfo(db *DB, args [][]byte) redis.Reply {
+	idx, errReply := db.getIndex(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	attributes := make([]redis.Reply, 0, len(idx.Fields))
+	for _, f := range idx.Fields {
+		attr := utils.ToCmdLine("identifier", f.Name, "attribute", f.Name, "type", f.Type.String())
+		switch f.Type {
+		case search.TypeText:
+			attr = append(attr, []byte("WEIGHT"), []byte(strconv.FormatFloat(f.Weight, 'f', -1, 64)))
+		case search.TypeTag:
+			attr = append(attr, []byte("SEPARATOR"), []byte{f.Separator})
+		}
+		if f.Sortable {
+			attr = append(attr, []byte("SORTABLE"))
+		}
+		attributes = append(attributes, reply.MakeMultiBulkReply(attr))
+	}
+	prefixes := make([][]byte, len(idx.Prefixes))
+	for i, prefix := range idx.Prefixes {
+		prefixes[i] = []byte(prefix)
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("index_name")),
+		reply.MakeBulkReply([]byte(idx.Name)),
+		reply.MakeBulkReply([]byte("index_definition")),
+		reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("key_type")),
+			reply.MakeBulkReply([]byte("HASH")),
+			reply.MakeBulkReply([]byte("prefixes")),
+			reply.MakeMultiBulkReply(prefixes),
+		}),
+		reply.MakeBulkReply([]byte("attributes")),
+		reply.MakeMultiRawReply(attributes),
+		reply.MakeBulkReply([]byte("num_docs")),
+		reply.MakeIntReply(int64(idx.Len())),
+		reply.MakeBulkReply([]byte("num_terms")),
+		reply.MakeIntReply(int64(idx.TermCount())),
+		reply.MakeBulkReply([]byte("hash_indexing_failures")),
+		reply.MakeIntReply(int64(idx.Failures())),
+	})
+}
+
+// execFTList returns names of all indexes
+func execFTList(db *DB, args [][]byte) redis.Reply {
+	indexes := db.indexes.list()
+	names := make([][]byte, len(indexes))
+	for i, idx := range indexes {
+		names[i] = []byte(idx.Name)
+	}
+	return reply.MakeMultiBulkReply(names)
+}
+
+func init() {
+	// commands of index lock keys by themselves
+	RegisterCommand("FT.Create", execFTCreate, noPrepare, nil, -4)
+	RegisterCommand("FT.Search", execFTSearch, noPrepare, nil, -3)
+	RegisterCommand("FT.DropIndex", execFTDropIndex, noPrepare, nil, -2)
+	RegisterCommand("FT.Info", execFTInfo, noPrepare, nil, 2)
+	RegisterCommand("FT._List", execFTList, noPrepare, nil, 1)
+}
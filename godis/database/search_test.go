@@ -0,0 +1,147 @@
+package database
+
+import (
+	"godis/config"
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply/asserts"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFTSearch(t *testing.T) {
+	conn := &connection.FakeConn{}
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("HSET", "book:1", "title", "The Go Programming Language"))
+	testServer.Exec(conn, utils.ToCmdLine("HMSET", "book:1", "price", "35", "tags", "go,programming"))
+	testServer.Exec(conn, utils.ToCmdLine("HMSET", "other:1", "title", "go away"))
+	ret := testServer.Exec(conn, utils.ToCmdLine("FT.CREATE", "books", "ON", "HASH", "PREFIX", "1", "book:",
+		"SCHEMA", "title", "TEXT", "WEIGHT", "2", "SORTABLE", "price", "NUMERIC", "SORTABLE", "tags", "TAG"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.CREATE", "books", "SCHEMA", "title", "TEXT"))
+	asserts.AssertErrReply(t, ret, "ERR Index already exists")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.CREATE", "bad", "SCHEMA", "title", "VECTOR"))
+	asserts.AssertErrReply(t, ret, "ERR Invalid field type for field `title`")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT._LIST"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"books"})
+
+	// existing hash is indexed, new hashes are indexed as they are written
+	testServer.Exec(conn, utils.ToCmdLine("HSET", "book:2", "title", "Redis in Action"))
+	testServer.Exec(conn, utils.ToCmdLine("HSET", "book:2", "price", "20"))
+	testServer.Exec(conn, utils.ToCmdLine("HMSET", "book:3", "title", "Learning Go", "price", "50", "tags", "go"))
+	testServer.Exec(conn, utils.ToCmdLine("HINCRBY", "book:3", "price", "-10"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "go", "RETURN", "2", "title", "price"))
+	assertRawReplies(t, ret, ":2\r\n",
+		"$6\r\nbook:1\r\n", "*4\r\n$5\r\ntitle\r\n$27\r\nThe Go Programming Language\r\n$5\r\nprice\r\n$2\r\n35\r\n",
+		"$6\r\nbook:3\r\n", "*4\r\n$5\r\ntitle\r\n$11\r\nLearning Go\r\n$5\r\nprice\r\n$2\r\n40\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "@price:[30 +inf]", "NOCONTENT", "SORTBY", "price", "DESC"))
+	assertRawReplies(t, ret, ":2\r\n", "$6\r\nbook:3\r\n", "$6\r\nbook:1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "@tags:{go} -learn*", "RETURN", "1", "price"))
+	assertRawReplies(t, ret, ":1\r\n", "$6\r\nbook:1\r\n", "*2\r\n$5\r\nprice\r\n$2\r\n35\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "*", "NOCONTENT", "SORTBY", "price", "LIMIT", "1", "1"))
+	assertRawReplies(t, ret, ":3\r\n", "$6\r\nbook:1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "@title:redis | @price:[(35 40]", "NOCONTENT", "SORTBY", "title"))
+	assertRawReplies(t, ret, ":2\r\n", "$6\r\nbook:3\r\n", "$6\r\nbook:2\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "*", "SORTBY", "tags"))
+	asserts.AssertErrReply(t, ret, "ERR Property `tags` not loaded nor in schema")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "@author:x"))
+	asserts.AssertErrReply(t, ret, "ERR Unknown field `author`")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "(go"))
+	asserts.AssertErrReply(t, ret, "ERR Syntax error")
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "missing", "*"))
+	asserts.AssertErrReply(t, ret, "ERR missing: no such index")
+
+	// modifications, deletions and other types
+	testServer.Exec(conn, utils.ToCmdLine("HDEL", "book:1", "tags"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "@tags:{go}", "NOCONTENT"))
+	assertRawReplies(t, ret, ":1\r\n", "$6\r\nbook:3\r\n")
+	testServer.Exec(conn, utils.ToCmdLine("DEL", "book:3"))
+	testServer.Exec(conn, utils.ToCmdLine("SET", "book:2", "not a hash"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "*", "NOCONTENT"))
+	assertRawReplies(t, ret, ":1\r\n", "$6\r\nbook:1\r\n")
+	testServer.Exec(conn, utils.ToCmdLine("RENAME", "book:1", "book:9"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "books", "go", "NOCONTENT"))
+	assertRawReplies(t, ret, ":1\r\n", "$6\r\nbook:9\r\n")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.INFO", "books"))
+	assertRawRepliesSize(t, ret, 12)
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.DROPINDEX", "books", "DD"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("EXISTS", "book:9", "book:2", "other:1"))
+	asserts.AssertIntReply(t, ret, 2)
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.DROPINDEX", "books"))
+	asserts.AssertErrReply(t, ret, "ERR Unknown Index name")
+}
+
+func TestFTSearchExpire(t *testing.T) {
+	conn := &connection.FakeConn{}
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("FT.CREATE", "idx", "SCHEMA", "name", "TEXT"))
+	testServer.Exec(conn, utils.ToCmdLine("HSET", "a", "name", "alice"))
+	testServer.Exec(conn, utils.ToCmdLine("HSET", "b", "name", "alice"))
+	testServer.Exec(conn, utils.ToCmdLine("PEXPIRE", "a", "10"))
+	time.Sleep(20 * time.Millisecond)
+	ret := testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "idx", "alice", "NOCONTENT"))
+	assertRawReplies(t, ret, ":1\r\n", "$1\r\nb\r\n")
+	testServer.Exec(conn, utils.ToCmdLine("EXISTS", "a"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.INFO", "idx"))
+	if info := string(ret.ToBytes()); !strings.Contains(info, "$8\r\nnum_docs\r\n:1\r\n") {
+		t.Errorf("expired document should be removed: %q", info)
+	}
+
+	// flush keeps definitions
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHDB"))
+	testServer.Exec(conn, utils.ToCmdLine("HSET", "c", "name", "alice"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("FT.SEARCH", "idx", "alice", "NOCONTENT"))
+	assertRawReplies(t, ret, ":1\r\n", "$1\r\nc\r\n")
+	testServer.Exec(conn, utils.ToCmdLine("FT.DROPINDEX", "idx"))
+}
+
+func TestRewriteAOFWithIndex(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir("", "godis")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		config.SetProperties(&config.ServerProperties{
+			AppendOnly:        true,
+			AppendFilename:    path.Join(tmpDir, "a.aof"),
+			AofUseRdbPreamble: preamble,
+		})
+		aofWriteDB := NewStandaloneServer()
+		conn := &connection.FakeConn{}
+		conn.SelectDB(2)
+		aofWriteDB.Exec(conn, utils.ToCmdLine("HMSET", "u:1", "name", "alice", "age", "30"))
+		aofWriteDB.Exec(conn, utils.ToCmdLine("FT.CREATE", "users", "PREFIX", "1", "u:",
+			"SCHEMA", "name", "TEXT", "age", "NUMERIC", "SORTABLE"))
+		aofWriteDB.Exec(conn, utils.ToCmdLine("HMSET", "u:2", "name", "bob", "age", "20"))
+		aofWriteDB.Close()
+
+		// rewrite loads the aof into a temporary db and dumps its indexes after data
+		aofWriteDB = NewStandaloneServer()
+		ret := aofWriteDB.Exec(conn, utils.ToCmdLine("rewriteaof"))
+		asserts.AssertNotError(t, ret)
+		aofWriteDB.Exec(conn, utils.ToCmdLine("HMSET", "u:3", "name", "carol", "age", "25"))
+		aofWriteDB.Close()
+		base, _ := filepath.Glob(path.Join(tmpDir, "a.aof.*.base.*"))
+		if len(base) != 1 {
+			t.Errorf("expect one base file, actual %v", base)
+			return
+		}
+		if content, _ := ioutil.ReadFile(base[0]); !strings.Contains(string(content), "FT.CREATE") {
+			t.Errorf("index definition is not rewritten: %q", content)
+		}
+
+		aofReadDB := NewStandaloneServer()
+		ret = aofReadDB.Exec(conn, utils.ToCmdLine("FT.SEARCH", "users", "@age:[-inf +inf]", "NOCONTENT", "SORTBY", "age"))
+		assertRawReplies(t, ret, ":3\r\n", "$3\r\nu:2\r\n", "$3\r\nu:3\r\n", "$3\r\nu:1\r\n")
+		aofReadDB.Close()
+		_ = os.RemoveAll(tmpDir)
+	}
+}
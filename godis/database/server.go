@@ -13,6 +13,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,22 +25,41 @@ type MultiDB struct {
 	hub *pubsub.Hub
 	// handle aof persistence
 	aofHandler *aof.Handler
+
+	// stop background tasks in serverCron
+	stopCron     chan struct{}
+	stopCronOnce sync.Once
+	// closed when serverCron returned
+	cronDone chan struct{}
+	// next db to run active expire cycle, accessed atomically
+	expireDBCursor int64
+	// slow commands
+	slowlog slowlog
+	// client side caching
+	tracking *tracker
 }
 
 // NewStandaloneServer creates a standalone redis server, with multi database and all other funtions
 func NewStandaloneServer() *MultiDB {
 	mdb := &MultiDB{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16
+	if config.Properties().Databases == 0 {
+		props := *config.Properties()
+		props.Databases = 16
+		config.SetProperties(&props)
 	}
-	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	startLazyfreeWorker()
+	mdb.dbSet = make([]*DB, config.Properties().Databases)
 	for i := range mdb.dbSet {
 		singleDB := makeDB()
 		singleDB.index = i
 		mdb.dbSet[i] = singleDB
 	}
 	mdb.hub = pubsub.MakeHub()
-	if config.Properties.AppendOnly {
+	mdb.tracking = makeTracker()
+	for _, db := range mdb.dbSet {
+		db.tracking = mdb.tracking
+	}
+	if config.Properties().AppendOnly {
 		aofHandler, err := aof.NewAOFHandler(mdb, func() database.EmbedDB {
 			return MakeBasicMultiDB()
 		})
@@ -55,15 +75,23 @@ func NewStandaloneServer() *MultiDB {
 			}
 		}
 	}
+	// aof has been loaded
+	for _, db := range mdb.dbSet {
+		db.keyspaceEvents = true
+	}
+	mdb.stopCron = make(chan struct{})
+	mdb.cronDone = make(chan struct{})
+	go mdb.serverCron()
 	return mdb
 }
 
 // MakeBasicMultiDB create a MultiDB only with basic abilities for aof rewrite and other usages
 func MakeBasicMultiDB() *MultiDB {
 	mdb := &MultiDB{}
-	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	mdb.dbSet = make([]*DB, config.Properties().Databases)
 	for i := range mdb.dbSet {
 		mdb.dbSet[i] = makeBasicDB()
+		mdb.dbSet[i].index = i
 	}
 	return mdb
 }
@@ -77,7 +105,16 @@ func (mdb *MultiDB) Exec(c redis.Connection, cmdLine [][]byte) (result redis.Rep
 			result = &reply.UnknownErrReply{}
 		}
 	}()
+	start := time.Now()
+	result = mdb.exec(c, cmdLine)
+	mdb.slowlog.record(c, cmdLine, start, time.Since(start))
+	mdb.tracking.afterCommand(c, strings.ToLower(string(cmdLine[0])))
+	// all locks have been released
+	mdb.tracking.sendPending()
+	return result
+}
 
+func (mdb *MultiDB) exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	// authenticate
 	if cmdName == "auth" {
@@ -103,7 +140,36 @@ func (mdb *MultiDB) Exec(c redis.Connection, cmdLine [][]byte) (result redis.Rep
 	} else if cmdName == "rewriteaof" {
 		return RewriteAOF(mdb, cmdLine[1:])
 	} else if cmdName == "flushall" {
-		return mdb.flushAll()
+		return mdb.flushAll(cmdLine[1:])
+	} else if cmdName == "info" {
+		return execInfo(mdb, cmdLine[1:])
+	} else if cmdName == "config" {
+		return execConfig(cmdLine[1:])
+	} else if cmdName == "memory" {
+		return execMemory(mdb, c, cmdLine[1:])
+	} else if cmdName == "slowlog" {
+		return execSlowlog(&mdb.slowlog, cmdLine[1:])
+	} else if cmdName == "client" {
+		return execClient(mdb.tracking, c, cmdLine[1:])
+	} else if cmdName == "command" {
+		return execCommand(cmdLine[1:])
+	} else if cmdName == "shutdown" {
+		if c != nil && c.InMultiState() {
+			return reply.MakeErrReply("ERR Command not allowed inside a transaction")
+		}
+		return execShutdown(mdb, cmdLine[1:])
+	} else if cmdName == "copy" || cmdName == "move" || cmdName == "swapdb" {
+		if c != nil && c.InMultiState() {
+			return reply.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
+		}
+		switch cmdName {
+		case "copy":
+			return execCopy(mdb, c, cmdLine[1:])
+		case "move":
+			return execMove(mdb, c, cmdLine[1:])
+		default:
+			return execSwapDB(mdb, cmdLine[1:])
+		}
 	} else if cmdName == "select" {
 		if c != nil && c.InMultiState() {
 			return reply.MakeErrReply("cannot select database within multi")
@@ -127,10 +193,19 @@ func (mdb *MultiDB) Exec(c redis.Connection, cmdLine [][]byte) (result redis.Rep
 // AfterClientClose does some clean after client close connection
 func (mdb *MultiDB) AfterClientClose(c redis.Connection) {
 	pubsub.UnsubscribeAll(mdb.hub, c)
+	mdb.tracking.removeClient(c)
 }
 
 // Close graceful shutdown database
 func (mdb *MultiDB) Close() {
+	if mdb.stopCron != nil {
+		// Close may be invoked more than once during shutting down
+		mdb.stopCronOnce.Do(func() {
+			close(mdb.stopCron)
+		})
+		// running expire cycle may still append to aof
+		<-mdb.cronDone
+	}
 	if mdb.aofHandler != nil {
 		mdb.aofHandler.Close()
 	}
@@ -148,10 +223,199 @@ func execSelect(c redis.Connection, mdb *MultiDB, args [][]byte) redis.Reply {
 	return reply.MakeOkReply()
 }
 
-func (mdb *MultiDB) flushAll() redis.Reply {
+func (mdb *MultiDB) parseDBIndex(arg []byte) (int, redis.Reply) {
+	dbIndex, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if dbIndex < 0 || dbIndex >= len(mdb.dbSet) {
+		return 0, reply.MakeErrReply("ERR DB index is out of range")
+	}
+	return dbIndex, nil
+}
+
+// lockAcrossDB locks keys in two databases, db with smaller index is locked first to avoid dead lock
+func lockAcrossDB(db1 *DB, write1 []string, read1 []string, db2 *DB, write2 []string, read2 []string) func() {
+	if db1 == db2 {
+		writeKeys := append(append([]string{}, write1...), write2...)
+		readKeys := append(append([]string{}, read1...), read2...)
+		db1.stopWorld.RLock()
+		db1.RWLocks(writeKeys, readKeys)
+		return func() {
+			db1.RWUnLocks(writeKeys, readKeys)
+			db1.stopWorld.RUnlock()
+		}
+	}
+	if db1.index > db2.index {
+		db1, db2 = db2, db1
+		write1, write2 = write2, write1
+		read1, read2 = read2, read1
+	}
+	// stopWorld is locked in the same order as SwapDB does
+	db1.stopWorld.RLock()
+	db2.stopWorld.RLock()
+	db1.RWLocks(write1, read1)
+	db2.RWLocks(write2, read2)
+	return func() {
+		db2.RWUnLocks(write2, read2)
+		db1.RWUnLocks(write1, read1)
+		db2.stopWorld.RUnlock()
+		db1.stopWorld.RUnlock()
+	}
+}
+
+// execCopy copies the value stored at the source key to the destination key
+// usage: COPY source destination [DB destination-db] [REPLACE]
+func execCopy(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("copy")
+	}
+	src := string(args[0])
+	dest := string(args[1])
+	srcIndex := c.GetDBIndex()
+	destIndex := srcIndex
+	replace := false
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if arg == "DB" && i+1 < len(args) {
+			var errReply redis.Reply
+			destIndex, errReply = mdb.parseDBIndex(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			i++
+		} else if arg == "REPLACE" {
+			replace = true
+		} else {
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	if srcIndex == destIndex && src == dest {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+	srcDB := mdb.dbSet[srcIndex]
+	destDB := mdb.dbSet[destIndex]
+	unlock := lockAcrossDB(srcDB, nil, []string{src}, destDB, []string{dest}, nil)
+	defer unlock()
+
+	entity, exists := srcDB.GetEntity(src)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists = destDB.GetEntity(dest); exists {
+		if !replace {
+			return reply.MakeIntReply(0)
+		}
+		destDB.Remove(dest)
+		destDB.addAof(utils.ToCmdLine("DEL", dest))
+	}
+	// rebuild value by its serialized command, it is a deep copy and will be appended into aof by executor
+	destDB.addVersion(dest)
+	destDB.execWithLock(aof.EntityToCmd(dest, entity).Args)
+	if raw, hasTTL := srcDB.ttlMap.Get(src); hasTTL {
+		expireTime, _ := raw.(time.Time)
+		destDB.Expire(dest, expireTime)
+		destDB.addAof(aof.MakeExpireCmd(dest, expireTime).Args)
+	}
+	return reply.MakeIntReply(1)
+}
+
+// execMove moves a key from the selected database to the specified destination database
+// usage: MOVE key db
+func execMove(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("move")
+	}
+	key := string(args[0])
+	destIndex, errReply := mdb.parseDBIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	srcIndex := c.GetDBIndex()
+	if srcIndex == destIndex {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+	srcDB := mdb.dbSet[srcIndex]
+	destDB := mdb.dbSet[destIndex]
+	keys := []string{key}
+	unlock := lockAcrossDB(srcDB, keys, nil, destDB, keys, nil)
+	defer unlock()
+
+	entity, exists := srcDB.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists = destDB.GetEntity(key); exists {
+		return reply.MakeIntReply(0)
+	}
+	destDB.PutEntity(key, entity)
+	destDB.addVersion(key)
+	destDB.addAof(aof.EntityToCmd(key, entity).Args)
+	if raw, hasTTL := srcDB.ttlMap.Get(key); hasTTL {
+		expireTime, _ := raw.(time.Time)
+		destDB.Expire(key, expireTime)
+		destDB.addAof(aof.MakeExpireCmd(key, expireTime).Args)
+	}
+	srcDB.Remove(key)
+	srcDB.addVersion(key)
+	srcDB.addAof(utils.ToCmdLine("DEL", key))
+	return reply.MakeIntReply(1)
+}
+
+// execSwapDB swaps two databases, connections selecting one db will see data of the other one immediately
+// usage: SWAPDB index1 index2
+func execSwapDB(mdb *MultiDB, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("swapdb")
+	}
+	index1, errReply := mdb.parseDBIndex(args[0])
+	if errReply != nil {
+		return reply.MakeErrReply("ERR invalid first DB index")
+	}
+	index2, errReply := mdb.parseDBIndex(args[1])
+	if errReply != nil {
+		return reply.MakeErrReply("ERR invalid second DB index")
+	}
+	if index1 == index2 {
+		return reply.MakeOkReply()
+	}
+	if index1 > index2 {
+		index1, index2 = index2, index1
+	}
+	db1 := mdb.dbSet[index1]
+	db2 := mdb.dbSet[index2]
+	// wait for in-flight commands of both databases, db with smaller index is locked first like lockAcrossDB
+	db1.stopWorld.Lock()
+	db2.stopWorld.Lock()
+	db1.data, db2.data = db2.data, db1.data
+	db1.ttlMap, db2.ttlMap = db2.ttlMap, db1.ttlMap
+	db1.versionMap, db2.versionMap = db2.versionMap, db1.versionMap
+	// lockers stay with their db, keys locked by prepared transactions are unlocked on the same locker
+	// indexes follow their documents
+	db1.indexes, db2.indexes = db2.indexes, db1.indexes
+	db2.stopWorld.Unlock()
+	db1.stopWorld.Unlock()
+	// tracking table doesn't distinguish databases, values cached by clients may be swapped
+	mdb.tracking.flush()
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.AddAof(0, utils.ToCmdLine("SwapDB", strconv.Itoa(index1), strconv.Itoa(index2)))
+	}
+	return reply.MakeOkReply()
+}
+
+func (mdb *MultiDB) flushAll(args [][]byte) redis.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
 	for _, db := range mdb.dbSet {
-		db.Flush()
+		if async {
+			db.FlushAsync()
+		} else {
+			db.Flush()
+		}
 	}
+	mdb.tracking.flush()
 	if mdb.aofHandler != nil {
 		mdb.aofHandler.AddAof(0, utils.ToCmdLine("FlushAll"))
 	}
@@ -164,6 +428,8 @@ func (mdb *MultiDB) ForEach(dbIndex int, cb func(key string, data *database.Data
 		return
 	}
 	db := mdb.dbSet[dbIndex]
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
 	db.ForEach(cb)
 }
 
@@ -173,7 +439,9 @@ func (mdb *MultiDB) ExecMulti(conn redis.Connection, watching map[string]uint32,
 		return reply.MakeErrReply("ERR DB index is out of range")
 	}
 	db := mdb.dbSet[conn.GetDBIndex()]
-	return db.ExecMulti(conn, watching, cmdLines)
+	result := db.ExecMulti(conn, watching, cmdLines)
+	mdb.tracking.sendPending()
+	return result
 }
 
 // RWLocks lock keys for writing and reading
@@ -182,6 +450,8 @@ func (mdb *MultiDB) RWLocks(dbIndex int, writeKeys []string, readKeys []string)
 		panic("ERR DB index is out of range")
 	}
 	db := mdb.dbSet[dbIndex]
+	// only keys are locked, the world may be stopped by flush or swapdb while the locks are held.
+	// locker of db is never replaced, so the keys are always unlocked on the locker they were locked on
 	db.RWLocks(writeKeys, readKeys)
 }
 
@@ -192,6 +462,8 @@ func (mdb *MultiDB) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string
 	}
 	db := mdb.dbSet[dbIndex]
 	db.RWUnLocks(writeKeys, readKeys)
+	// invalidations caused by commands executed with the locks
+	mdb.tracking.sendPending()
 }
 
 // GetUndoLogs return rollback commands
@@ -200,26 +472,50 @@ func (mdb *MultiDB) GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine {
 		panic("ERR DB index is out of range")
 	}
 	db := mdb.dbSet[dbIndex]
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
 	return db.GetUndoLogs(cmdLine)
 }
 
+// GetIndexDefs returns FT.CREATE commands of indexes in the given database
+func (mdb *MultiDB) GetIndexDefs(dbIndex int) []CmdLine {
+	if dbIndex >= len(mdb.dbSet) {
+		return nil
+	}
+	return mdb.dbSet[dbIndex].indexDefs()
+}
+
 // ExecWithLock executes normal commands, invoker should provide locks
 func (mdb *MultiDB) ExecWithLock(conn redis.Connection, cmdLine [][]byte) redis.Reply {
 	if conn.GetDBIndex() >= len(mdb.dbSet) {
 		panic("ERR DB index is out of range")
 	}
 	db := mdb.dbSet[conn.GetDBIndex()]
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
 	return db.execWithLock(cmdLine)
 }
 
 // BGRewriteAOF asynchronously rewrites Append-Only-File
 func BGRewriteAOF(db *MultiDB, args [][]byte) redis.Reply {
-	go db.aofHandler.Rewrite()
+	if db.aofHandler == nil {
+		return reply.MakeErrReply("ERR append only file is disabled")
+	}
+	err := db.aofHandler.BackgroundRewrite()
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
 	return reply.MakeStatusReply("Background append only file rewriting started")
 }
 
 // RewriteAOF start Append-Only-File rewriting and blocked until it finished
 func RewriteAOF(db *MultiDB, args [][]byte) redis.Reply {
-	db.aofHandler.Rewrite()
+	if db.aofHandler == nil {
+		return reply.MakeErrReply("ERR append only file is disabled")
+	}
+	err := db.aofHandler.Rewrite()
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
 	return reply.MakeStatusReply("Background append only file rewriting started")
 }
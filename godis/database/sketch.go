@@ -0,0 +1,412 @@
+package database
+
+import (
+	"godis/datastruct/sketch"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// default options of TOPK.RESERVE, same as RedisBloom
+const (
+	defaultTopKWidth = 8
+	defaultTopKDepth = 7
+	defaultTopKDecay = 0.9
+	// maxTopKIncrement limits time of decaying in an increment
+	maxTopKIncrement = 100000
+)
+
+func (db *DB) getAsCountMinSketch(key string) (*sketch.CountMinSketch, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, reply.MakeErrReply("CMS: key does not exist")
+	}
+	cms, ok := entity.Data.(*sketch.CountMinSketch)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return cms, nil
+}
+
+func (db *DB) getAsTopK(key string) (*sketch.TopK, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, reply.MakeErrReply("TopK: key does not exist")
+	}
+	topK, ok := entity.Data.(*sketch.TopK)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return topK, nil
+}
+
+func parsePositive(arg []byte) (uint64, bool) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return uint64(n), true
+}
+
+// putSketch saves a new sketch and logs the command creating it
+func (db *DB) putSketch(cmdName string, args [][]byte, data interface{}) redis.Reply {
+	db.PutEntity(string(args[0]), &database.DataEntity{
+		Data: data,
+	})
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
+	return reply.MakeOkReply()
+}
+
+// execCMSInitByDim creates a count-min sketch: CMS.INITBYDIM key width depth
+func execCMSInitByDim(db *DB, args [][]byte) redis.Reply {
+	if _, exists := db.GetEntity(string(args[0])); exists {
+		return reply.MakeErrReply("CMS: key already exists")
+	}
+	width, ok := parsePositive(args[1])
+	if !ok {
+		return reply.MakeErrReply("CMS: invalid width")
+	}
+	depth, ok := parsePositive(args[2])
+	if !ok {
+		return reply.MakeErrReply("CMS: invalid depth")
+	}
+	cms, err := sketch.NewCountMinSketch(width, depth)
+	if err != nil {
+		return reply.MakeErrReply("CMS: " + err.Error())
+	}
+	return db.putSketch("cms.initbydim", args, cms)
+}
+
+// execCMSInitByProb creates a count-min sketch by error rate: CMS.INITBYPROB key error probability
+func execCMSInitByProb(db *DB, args [][]byte) redis.Reply {
+	if _, exists := db.GetEntity(string(args[0])); exists {
+		return reply.MakeErrReply("CMS: key already exists")
+	}
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return reply.MakeErrReply("CMS: invalid overestimation value")
+	}
+	probability, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || probability <= 0 || probability >= 1 {
+		return reply.MakeErrReply("CMS: invalid prob value")
+	}
+	cms, err := sketch.NewCountMinSketchByProb(errorRate, probability)
+	if err != nil {
+		return reply.MakeErrReply("CMS: " + err.Error())
+	}
+	return db.putSketch("cms.initbyprob", args, cms)
+}
+
+// execCMSIncrBy increases count of items: CMS.INCRBY key item increment [item increment ...]
+func execCMSIncrBy(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'cms.incrby' command")
+	}
+	cms, errReply := db.getAsCountMinSketch(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	increments := make([]uint64, 0, len(args)/2)
+	for i := 2; i < len(args); i += 2 {
+		increment, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil || increment < 0 {
+			return reply.MakeErrReply("CMS: Cannot parse number")
+		}
+		increments = append(increments, uint64(increment))
+	}
+	result := make([]redis.Reply, len(increments))
+	for i, increment := range increments {
+		result[i] = reply.MakeIntReply(int64(cms.IncrBy(string(args[2*i+1]), increment)))
+	}
+	db.addAof(utils.ToCmdLine3("cms.incrby", args...))
+	return reply.MakeMultiRawReply(result)
+}
+
+// execCMSQuery returns estimated count of items
+func execCMSQuery(db *DB, args [][]byte) redis.Reply {
+	cms, errReply := db.getAsCountMinSketch(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		result[i] = reply.MakeIntReply(int64(cms.Query(string(item))))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// parseCMSMerge returns source keys and weights of CMS.MERGE destination numkeys source [source ...] [WEIGHTS weight [weight ...]]
+func parseCMSMerge(args [][]byte) ([]string, []uint64, reply.ErrorReply) {
+	numKeys, ok := parsePositive(args[1])
+	if !ok {
+		return nil, nil, reply.MakeErrReply("CMS: invalid numkeys")
+	}
+	if uint64(len(args)-2) < numKeys {
+		return nil, nil, reply.MakeErrReply("CMS: wrong number of keys")
+	}
+	sources := make([]string, numKeys)
+	for i := range sources {
+		sources[i] = string(args[2+i])
+	}
+	rest := args[2+numKeys:]
+	if len(rest) == 0 {
+		return sources, nil, nil
+	}
+	if strings.ToUpper(string(rest[0])) != "WEIGHTS" || uint64(len(rest)-1) != numKeys {
+		return nil, nil, reply.MakeErrReply("CMS: wrong number of keys/weights")
+	}
+	weights := make([]uint64, numKeys)
+	for i := range weights {
+		weight, err := strconv.ParseInt(string(rest[1+i]), 10, 64)
+		if err != nil || weight < 0 {
+			return nil, nil, reply.MakeErrReply("CMS: invalid weight value")
+		}
+		weights[i] = uint64(weight)
+	}
+	return sources, weights, nil
+}
+
+// execCMSMerge sets destination to weighted sum of sources, all of them must have the same dimension
+func execCMSMerge(db *DB, args [][]byte) redis.Reply {
+	sourceKeys, weights, errReply := parseCMSMerge(args)
+	if errReply != nil {
+		return errReply
+	}
+	dest, errReply := db.getAsCountMinSketch(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	sources := make([]*sketch.CountMinSketch, len(sourceKeys))
+	for i, key := range sourceKeys {
+		sources[i], errReply = db.getAsCountMinSketch(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
+	if err := dest.Merge(sources, weights); err != nil {
+		return reply.MakeErrReply("CMS: " + err.Error())
+	}
+	db.addAof(utils.ToCmdLine3("cms.merge", args...))
+	return reply.MakeOkReply()
+}
+
+func prepareCMSMerge(args [][]byte) ([]string, []string) {
+	numKeys, ok := parsePositive(args[1])
+	if !ok || uint64(len(args)-2) < numKeys {
+		return []string{string(args[0])}, nil
+	}
+	sources := make([]string, numKeys)
+	for i := range sources {
+		sources[i] = string(args[2+i])
+	}
+	return []string{string(args[0])}, sources
+}
+
+// execCMSInfo returns dimension and total count of sketch
+func execCMSInfo(db *DB, args [][]byte) redis.Reply {
+	cms, errReply := db.getAsCountMinSketch(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("width")),
+		reply.MakeIntReply(int64(cms.Width())),
+		reply.MakeBulkReply([]byte("depth")),
+		reply.MakeIntReply(int64(cms.Depth())),
+		reply.MakeBulkReply([]byte("count")),
+		reply.MakeIntReply(int64(cms.Count())),
+	})
+}
+
+// execCMSLoadChunk restores count-min sketch, it is used by aof rewrite and DUMP
+func execCMSLoadChunk(db *DB, args [][]byte) redis.Reply {
+	if string(args[1]) != "1" {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	cms, err := sketch.UnmarshalCountMinSketch(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	return db.putSketch("cms.loadchunk", args, cms)
+}
+
+// execTopKReserve creates a top k sketch: TOPK.RESERVE key topk [width depth decay]
+func execTopKReserve(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 && len(args) != 5 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'topk.reserve' command")
+	}
+	if _, exists := db.GetEntity(string(args[0])); exists {
+		return reply.MakeErrReply("TopK: key already exists")
+	}
+	k, ok := parsePositive(args[1])
+	if !ok {
+		return reply.MakeErrReply("TopK: invalid k")
+	}
+	width, depth, decay := uint64(defaultTopKWidth), uint64(defaultTopKDepth), defaultTopKDecay
+	if len(args) == 5 {
+		if width, ok = parsePositive(args[2]); !ok {
+			return reply.MakeErrReply("TopK: invalid width")
+		}
+		if depth, ok = parsePositive(args[3]); !ok {
+			return reply.MakeErrReply("TopK: invalid depth")
+		}
+		var err error
+		decay, err = strconv.ParseFloat(string(args[4]), 64)
+		if err != nil || decay <= 0 || decay > 1 {
+			return reply.MakeErrReply("TopK: invalid decay value. must be '<= 1' & '> 0'")
+		}
+	}
+	topK, err := sketch.NewTopK(k, width, depth, decay)
+	if err != nil {
+		return reply.MakeErrReply("TopK: " + err.Error())
+	}
+	return db.putSketch("topk.reserve", args, topK)
+}
+
+// topKIncrBy increases items and returns items expelled from the list
+func topKIncrBy(topK *sketch.TopK, items [][]byte, increments []uint64) redis.Reply {
+	result := make([]redis.Reply, len(items))
+	for i, item := range items {
+		expelled, ok := topK.IncrBy(string(item), increments[i])
+		if ok {
+			result[i] = reply.MakeBulkReply([]byte(expelled))
+		} else {
+			result[i] = reply.MakeNullBulkReply()
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execTopKAdd adds items: TOPK.ADD key item [item ...]
+func execTopKAdd(db *DB, args [][]byte) redis.Reply {
+	topK, errReply := db.getAsTopK(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	increments := make([]uint64, len(args)-1)
+	for i := range increments {
+		increments[i] = 1
+	}
+	result := topKIncrBy(topK, args[1:], increments)
+	db.addAof(utils.ToCmdLine3("topk.add", args...))
+	return result
+}
+
+// execTopKIncrBy increases count of items: TOPK.INCRBY key item increment [item increment ...]
+func execTopKIncrBy(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'topk.incrby' command")
+	}
+	topK, errReply := db.getAsTopK(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	items := make([][]byte, 0, len(args)/2)
+	increments := make([]uint64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		increment, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || increment < 0 || increment > maxTopKIncrement {
+			return reply.MakeErrReply("TopK: increment must be an integer greater or equal to 0 and smaller or equal to " +
+				strconv.Itoa(maxTopKIncrement))
+		}
+		items = append(items, args[i])
+		increments = append(increments, uint64(increment))
+	}
+	result := topKIncrBy(topK, items, increments)
+	db.addAof(utils.ToCmdLine3("topk.incrby", args...))
+	return result
+}
+
+// execTopKQuery tells whether items are in the top k list
+func execTopKQuery(db *DB, args [][]byte) redis.Reply {
+	topK, errReply := db.getAsTopK(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		if topK.Query(string(item)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execTopKList returns the top k items in descending order of count: TOPK.LIST key [WITHCOUNT]
+func execTopKList(db *DB, args [][]byte) redis.Reply {
+	withCount := false
+	if len(args) == 2 && strings.ToUpper(string(args[1])) == "WITHCOUNT" {
+		withCount = true
+	} else if len(args) != 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	topK, errReply := db.getAsTopK(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	list := topK.List()
+	if !withCount {
+		result := make([][]byte, len(list))
+		for i, item := range list {
+			result[i] = []byte(item.Item)
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+	result := make([]redis.Reply, 0, 2*len(list))
+	for _, item := range list {
+		result = append(result, reply.MakeBulkReply([]byte(item.Item)), reply.MakeIntReply(int64(item.Count)))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execTopKInfo returns options of top k sketch
+func execTopKInfo(db *DB, args [][]byte) redis.Reply {
+	topK, errReply := db.getAsTopK(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("k")),
+		reply.MakeIntReply(int64(topK.K())),
+		reply.MakeBulkReply([]byte("width")),
+		reply.MakeIntReply(int64(topK.Width())),
+		reply.MakeBulkReply([]byte("depth")),
+		reply.MakeIntReply(int64(topK.Depth())),
+		reply.MakeBulkReply([]byte("decay")),
+		reply.MakeBulkReply([]byte(strconv.FormatFloat(topK.Decay(), 'f', -1, 64))),
+	})
+}
+
+// execTopKLoadChunk restores top k sketch, it is used by aof rewrite and DUMP
+func execTopKLoadChunk(db *DB, args [][]byte) redis.Reply {
+	if string(args[1]) != "1" {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	topK, err := sketch.UnmarshalTopK(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	return db.putSketch("topk.loadchunk", args, topK)
+}
+
+func init() {
+	RegisterCommand("CMS.InitByDim", execCMSInitByDim, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("CMS.InitByProb", execCMSInitByProb, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("CMS.IncrBy", execCMSIncrBy, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("CMS.Query", execCMSQuery, readFirstKey, nil, -3)
+	RegisterCommand("CMS.Merge", execCMSMerge, prepareCMSMerge, rollbackFirstKey, -4)
+	RegisterCommand("CMS.Info", execCMSInfo, readFirstKey, nil, 2)
+	RegisterCommand("CMS.LoadChunk", execCMSLoadChunk, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("TopK.Reserve", execTopKReserve, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("TopK.Add", execTopKAdd, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("TopK.IncrBy", execTopKIncrBy, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("TopK.Query", execTopKQuery, readFirstKey, nil, -3)
+	RegisterCommand("TopK.List", execTopKList, readFirstKey, nil, -2)
+	RegisterCommand("TopK.Info", execTopKInfo, readFirstKey, nil, 2)
+	RegisterCommand("TopK.LoadChunk", execTopKLoadChunk, writeFirstKey, rollbackFirstKey, 4)
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("CMS.INCRBY", key, "a", "1"))
+	asserts.AssertErrReply(t, ret, "CMS: key does not exist")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYPROB", key, "0.001", "0.01"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", key, "10", "10"))
+	asserts.AssertErrReply(t, ret, "CMS: key already exists")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "CMSk-TYPE")
+
+	// estimations are never less than exact counts
+	r := rand.New(rand.NewSource(1))
+	exact := make(map[string]int)
+	total := 0
+	for i := 0; i < 200; i++ {
+		item := strconv.Itoa(r.Intn(50))
+		increment := r.Intn(10) + 1
+		exact[item] += increment
+		total += increment
+		ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INCRBY", key, item, strconv.Itoa(increment)))
+		assertRawReplies(t, ret, ":"+strconv.Itoa(exact[item])+"\r\n")
+	}
+	for item, count := range exact {
+		ret = testServer.Exec(conn, utils.ToCmdLine("CMS.QUERY", key, item))
+		assertRawReplies(t, ret, ":"+strconv.Itoa(count)+"\r\n")
+	}
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INFO", key))
+	assertRawReplies(t, ret, "$5\r\nwidth\r\n", ":2000\r\n", "$5\r\ndepth\r\n", ":7\r\n",
+		"$5\r\ncount\r\n", ":"+strconv.Itoa(total)+"\r\n")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INCRBY", key, "a", "-1"))
+	asserts.AssertErrReply(t, ret, "CMS: Cannot parse number")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", key+"1", "0", "10"))
+	asserts.AssertErrReply(t, ret, "CMS: invalid width")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYPROB", key+"1", "0.1", "1"))
+	asserts.AssertErrReply(t, ret, "CMS: invalid prob value")
+}
+
+func TestCountMinSketchMerge(t *testing.T) {
+	conn := &connection.FakeConn{}
+	dest := utils.RandString(10)
+	src1 := utils.RandString(10)
+	src2 := utils.RandString(10)
+	for _, key := range []string{dest, src1, src2} {
+		testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", key, "100", "5"))
+	}
+	testServer.Exec(conn, utils.ToCmdLine("CMS.INCRBY", src1, "a", "3", "b", "1"))
+	testServer.Exec(conn, utils.ToCmdLine("CMS.INCRBY", src2, "a", "2"))
+	ret := testServer.Exec(conn, utils.ToCmdLine("CMS.MERGE", dest, "2", src1, src2))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.QUERY", dest, "a", "b", "c"))
+	assertRawReplies(t, ret, ":5\r\n", ":1\r\n", ":0\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.MERGE", dest, "2", dest, src2, "WEIGHTS", "2", "3"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.QUERY", dest, "a", "b"))
+	assertRawReplies(t, ret, ":16\r\n", ":2\r\n")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.MERGE", dest, "2", src1, src2, "WEIGHTS", "1"))
+	asserts.AssertErrReply(t, ret, "CMS: wrong number of keys/weights")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.MERGE", dest, "1", utils.RandString(10)))
+	asserts.AssertErrReply(t, ret, "CMS: key does not exist")
+	other := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", other, "10", "5"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("CMS.MERGE", dest, "1", other))
+	asserts.AssertErrReply(t, ret, "CMS: width/depth is not equal")
+}
+
+func TestTopK(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("TOPK.ADD", key, "a"))
+	asserts.AssertErrReply(t, ret, "TopK: key does not exist")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.RESERVE", key, "3", "50", "5", "0.9"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "TopK-TYPE")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.ADD", key, "a", "b", "c"))
+	assertRawReplies(t, ret, "$-1\r\n", "$-1\r\n", "$-1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.INCRBY", key, "a", "10", "b", "5", "c", "3"))
+	assertRawReplies(t, ret, "$-1\r\n", "$-1\r\n", "$-1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.INCRBY", key, "d", "20"))
+	assertRawReplies(t, ret, "$1\r\nc\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.QUERY", key, "a", "c", "d"))
+	assertRawReplies(t, ret, ":1\r\n", ":0\r\n", ":1\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.LIST", key))
+	asserts.AssertMultiBulkReply(t, ret, []string{"d", "a", "b"})
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.LIST", key, "WITHCOUNT"))
+	assertRawReplies(t, ret, "$1\r\nd\r\n", ":20\r\n", "$1\r\na\r\n", ":11\r\n", "$1\r\nb\r\n", ":6\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.INFO", key))
+	assertRawReplies(t, ret, "$1\r\nk\r\n", ":3\r\n", "$5\r\nwidth\r\n", ":50\r\n", "$5\r\ndepth\r\n", ":5\r\n",
+		"$5\r\ndecay\r\n", "$3\r\n0.9\r\n")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.RESERVE", key, "3"))
+	asserts.AssertErrReply(t, ret, "TopK: key already exists")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.RESERVE", key+"1", "3", "8", "7", "1.5"))
+	asserts.AssertErrReply(t, ret, "TopK: invalid decay value. must be '<= 1' & '> 0'")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.INCRBY", key, "a", "100001"))
+	asserts.AssertErrReply(t, ret, "TopK: increment must be an integer greater or equal to 0 and smaller or equal to 100000")
+}
+
+func TestSketchDump(t *testing.T) {
+	conn := &connection.FakeConn{}
+	cms := utils.RandString(10)
+	topK := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", cms, "10", "3"))
+	testServer.Exec(conn, utils.ToCmdLine("CMS.INCRBY", cms, "a", "7"))
+	testServer.Exec(conn, utils.ToCmdLine("TOPK.RESERVE", topK, "2"))
+	testServer.Exec(conn, utils.ToCmdLine("TOPK.INCRBY", topK, "a", "7", "b", "3"))
+
+	for _, key := range []string{cms, topK} {
+		ret := testServer.Exec(conn, utils.ToCmdLine("DUMP", key))
+		payload := ret.(*reply.BulkReply).Arg
+		ret = testServer.Exec(conn, [][]byte{[]byte("RESTORE"), []byte(key + "restored"), []byte("0"), payload})
+		asserts.AssertStatusReply(t, ret, "OK")
+	}
+	ret := testServer.Exec(conn, utils.ToCmdLine("CMS.QUERY", cms+"restored", "a"))
+	assertRawReplies(t, ret, ":7\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TOPK.LIST", topK+"restored", "WITHCOUNT"))
+	assertRawReplies(t, ret, "$1\r\na\r\n", ":7\r\n", "$1\r\nb\r\n", ":3\r\n")
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"godis/config"
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// arguments beyond it are replaced by a summary in slowlog
+	slowlogMaxArgc = 32
+	// long arguments are truncated in slowlog
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id        int64
+	timestamp int64 // unix time in seconds
+	duration  int64 // in microseconds
+	args      [][]byte
+	addr      string
+}
+
+// slowlog keeps the latest entries of slow commands, newest first
+type slowlog struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry
+	nextID  int64
+}
+
+// record adds cmdLine to slowlog if it runs longer than slowlog-log-slower-than
+func (log *slowlog) record(c redis.Connection, cmdLine [][]byte, start time.Time, duration time.Duration) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "auth" || cmdName == "slowlog" {
+		// never expose password, and reading slowlog should not flush it
+		return
+	}
+	props := config.Properties()
+	if props.SlowlogLogSlowerThan < 0 || props.SlowlogMaxLen <= 0 {
+		return
+	}
+	if duration.Microseconds() < int64(props.SlowlogLogSlowerThan) {
+		return
+	}
+	entry := &slowlogEntry{
+		timestamp: start.Unix(),
+		duration:  duration.Microseconds(),
+		args:      truncateSlowlogArgs(cmdLine),
+	}
+	if conn, ok := c.(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+		entry.addr = conn.RemoteAddr().String()
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	entry.id = log.nextID
+	log.nextID++
+	log.entries = append([]*slowlogEntry{entry}, log.entries...)
+	if len(log.entries) > props.SlowlogMaxLen {
+		log.entries = log.entries[:props.SlowlogMaxLen]
+	}
+}
+
+// truncateSlowlogArgs copies cmdLine, huge arguments are not kept in memory
+func truncateSlowlogArgs(cmdLine [][]byte) [][]byte {
+	argc := len(cmdLine)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([][]byte, argc)
+	for i := 0; i < argc; i++ {
+		arg := cmdLine[i]
+		if i == slowlogMaxArgc-1 && len(cmdLine) > slowlogMaxArgc {
+			args[i] = []byte("... (" + strconv.Itoa(len(cmdLine)-slowlogMaxArgc+1) + " more arguments)")
+		} else if len(arg) > slowlogMaxArgLen {
+			args[i] = []byte(string(arg[:slowlogMaxArgLen]) + "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)")
+		} else {
+			args[i] = append([]byte{}, arg...)
+		}
+	}
+	return args
+}
+
+// execSlowlog implements SLOWLOG GET [count] | LEN | RESET
+func execSlowlog(log *slowlog, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("slowlog")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "get":
+		count := 10
+		if len(args) == 2 {
+			var err error
+			count, err = strconv.Atoi(string(args[1]))
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+		} else if len(args) > 2 {
+			return reply.MakeArgNumErrReply("slowlog|get")
+		}
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		if count < 0 || count > len(log.entries) {
+			count = len(log.entries)
+		}
+		result := make([]redis.Reply, count)
+		for i, entry := range log.entries[:count] {
+			result[i] = reply.MakeMultiRawReply([]redis.Reply{
+				reply.MakeIntReply(entry.id),
+				reply.MakeIntReply(entry.timestamp),
+				reply.MakeIntReply(entry.duration),
+				reply.MakeMultiBulkReply(entry.args),
+				reply.MakeBulkReply([]byte(entry.addr)),
+				reply.MakeBulkReply([]byte{}), // client name is not supported
+			})
+		}
+		return reply.MakeMultiRawReply(result)
+	case "len":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("slowlog|len")
+		}
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		return reply.MakeIntReply(int64(len(log.entries)))
+	case "reset":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("slowlog|reset")
+		}
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		log.entries = nil
+		return reply.MakeOkReply()
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'")
+	}
+}
@@ -194,6 +194,24 @@ func TestZRangeByScore(t *testing.T) {
 	asserts.AssertMultiBulkReply(t, result, members[25:30])
 	result = testDB.Exec(nil, utils.ToCmdLine("ZRevRangeByScore", key, max, min, "LIMIT", "5", "5"))
 	asserts.AssertMultiBulkReply(t, result, reverse(members[31:36]))
+
+	// value of infinite border is not compared with the other border
+	min = "95"
+	max = "+inf"
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeByScore", key, min, max))
+	asserts.AssertMultiBulkReply(t, result, members[95:])
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRevRangeByScore", key, max, min))
+	asserts.AssertMultiBulkReply(t, result, reverse(members[95:]))
+
+	min = "-inf"
+	max = "(-1"
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeByScore", key, min, max))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+
+	min = "+inf"
+	max = "+inf"
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeByScore", key, min, max))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
 }
 
 func TestZRem(t *testing.T) {
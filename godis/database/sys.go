@@ -1,9 +1,12 @@
 package database
 
 import (
+	"errors"
 	"godis/config"
 	"godis/interface/redis"
+	"godis/lib/logger"
 	"godis/redis/reply"
+	"strings"
 )
 
 // Ping the server
@@ -22,22 +25,116 @@ func Auth(c redis.Connection, args [][]byte) redis.Reply {
 	if len(args) != 1 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'auth' command")
 	}
-	if config.Properties.RequirePass == "" {
+	if config.Properties().RequirePass == "" {
 		return reply.MakeErrReply("ERR Client sent AUTH, but no password is set")
 	}
 	passwd := string(args[0])
 	c.SetPassword(passwd)
-	if config.Properties.RequirePass != passwd {
+	if config.Properties().RequirePass != passwd {
 		return reply.MakeErrReply("ERR invalid password")
 	}
 	return &reply.OkReply{}
 }
 
 func isAuthenticated(c redis.Connection) bool {
-	if config.Properties.RequirePass == "" {
+	if config.Properties().RequirePass == "" {
 		return true
 	}
-	return c.GetPassword() == config.Properties.RequirePass
+	return c.GetPassword() == config.Properties().RequirePass
+}
+
+// execConfig implements CONFIG GET pattern | SET name value [name value ...] | REWRITE
+func execConfig(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("config")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "get":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("config|get")
+		}
+		pairs := config.Get(string(args[1]))
+		result := make([][]byte, 0, 2*len(pairs))
+		for _, pair := range pairs {
+			result = append(result, []byte(pair[0]), []byte(pair[1]))
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return reply.MakeArgNumErrReply("config|set")
+		}
+		pairs := make([][2]string, 0, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			pairs = append(pairs, [2]string{string(args[i]), string(args[i+1])})
+		}
+		err := config.Set(pairs)
+		if err != nil {
+			return makeConfigSetErrReply(err)
+		}
+		return reply.MakeOkReply()
+	case "rewrite":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("config|rewrite")
+		}
+		err := config.Rewrite()
+		if err == config.ErrNoConfigFile {
+			return reply.MakeErrReply("ERR The server is running without a config file")
+		} else if err != nil {
+			logger.Warn("config rewrite failed: " + err.Error())
+			return reply.MakeErrReply("ERR Rewriting config file: " + err.Error())
+		}
+		return reply.MakeOkReply()
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'")
+	}
+}
+
+func makeConfigSetErrReply(err error) redis.Reply {
+	var optErr *config.OptionError
+	if !errors.As(err, &optErr) {
+		return reply.MakeErrReply("ERR CONFIG SET failed: " + err.Error())
+	}
+	switch optErr.Err {
+	case config.ErrUnknownOption:
+		return reply.MakeErrReply("ERR Unknown option or number of arguments for CONFIG SET - '" + optErr.Name + "'")
+	case config.ErrImmutableOption:
+		return reply.MakeErrReply("ERR CONFIG SET failed (possibly related to argument '" + optErr.Name + "') - can't set immutable config")
+	default:
+		return reply.MakeErrReply("ERR Invalid argument '" + optErr.Value + "' for CONFIG SET '" + optErr.Name + "' - " + optErr.Reason)
+	}
+}
+
+// execShutdown prepares for SHUTDOWN [NOSAVE|SAVE], server stops after it returns ok.
+// SAVE compacts aof by rewriting before exit, aof is always flushed and fsynced when closing database
+func execShutdown(mdb *MultiDB, args [][]byte) redis.Reply {
+	save := false
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "save":
+			save = true
+		case "nosave":
+			save = false
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if len(args) > 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if save {
+		if mdb.aofHandler == nil {
+			logger.Warn("SHUTDOWN SAVE failed: append only file is disabled")
+			return reply.MakeErrReply("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+		err := mdb.aofHandler.Rewrite()
+		if err != nil {
+			logger.Warn("SHUTDOWN SAVE failed: " + err.Error())
+			return reply.MakeErrReply("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+	}
+	logger.Info("user requested shutdown...")
+	return reply.MakeOkReply()
 }
 
 func init() {
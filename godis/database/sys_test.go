@@ -1,9 +1,11 @@
 package database
 
 import (
+	"bytes"
 	"godis/config"
 	"godis/lib/utils"
 	"godis/redis/connection"
+	"godis/redis/reply"
 	"godis/redis/reply/asserts"
 	"testing"
 )
@@ -26,9 +28,9 @@ func TestAuth(t *testing.T) {
 	ret = testServer.Exec(c, utils.ToCmdLine("AUTH", passwd))
 	asserts.AssertErrReply(t, ret, "ERR Client sent AUTH, but no password is set")
 
-	config.Properties.RequirePass = passwd
+	_ = config.Set([][2]string{{"requirepass", passwd}})
 	defer func() {
-		config.Properties.RequirePass = ""
+		_ = config.Set([][2]string{{"requirepass", ""}})
 	}()
 	ret = testServer.Exec(c, utils.ToCmdLine("AUTH", passwd+"wrong"))
 	asserts.AssertErrReply(t, ret, "ERR invalid password")
@@ -38,3 +40,86 @@ func TestAuth(t *testing.T) {
 	asserts.AssertStatusReply(t, ret, "OK")
 
 }
+
+func TestConfig(t *testing.T) {
+	props := config.Properties()
+	defer func() {
+		config.SetProperties(props)
+	}()
+	c := &connection.FakeConn{}
+	ret := testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "maxclients", "100", "slowlog-max-len", "10"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "GET", "maxclients"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"maxclients", "100"})
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "GET", "slowlog-*"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"slowlog-log-slower-than", "0", "slowlog-max-len", "10"})
+
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "maxclients", "abc"))
+	asserts.AssertErrReply(t, ret, "ERR Invalid argument 'abc' for CONFIG SET 'maxclients' - argument couldn't be parsed into an integer")
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "port", "1234"))
+	asserts.AssertErrReply(t, ret, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config")
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "no-such-option", "1"))
+	asserts.AssertErrReply(t, ret, "ERR Unknown option or number of arguments for CONFIG SET - 'no-such-option'")
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "maxclients"))
+	asserts.AssertErrReply(t, ret, "ERR wrong number of arguments for 'config|set' command")
+
+	// requirepass takes effect immediately
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "requirepass", "abc"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(&connection.FakeConn{}, utils.ToCmdLine("PING"))
+	asserts.AssertErrReply(t, ret, "NOAUTH Authentication required")
+}
+
+func TestSlowlog(t *testing.T) {
+	props := config.Properties()
+	defer func() {
+		config.SetProperties(props)
+	}()
+	c := &connection.FakeConn{}
+	ret := testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "slowlog-log-slower-than", "0", "slowlog-max-len", "2"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(c, utils.ToCmdLine("SLOWLOG", "RESET"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(c, utils.ToCmdLine("SET", "slow", "1"))
+	testServer.Exec(c, utils.ToCmdLine("GET", "slow"))
+	ret = testServer.Exec(c, utils.ToCmdLine("SLOWLOG", "LEN"))
+	asserts.AssertIntReply(t, ret, 2)
+	ret = testServer.Exec(c, utils.ToCmdLine("SLOWLOG", "GET", "1"))
+	expected := "*1\r\n*6\r\n"
+	if s := string(ret.ToBytes()); len(s) < len(expected) || s[:len(expected)] != expected {
+		t.Errorf("wrong slowlog: %s", s)
+	}
+	if !bytes.Contains(ret.ToBytes(), []byte("$3\r\nGET\r\n$4\r\nslow\r\n")) {
+		t.Errorf("latest entry should be GET: %s", ret.ToBytes())
+	}
+
+	ret = testServer.Exec(c, utils.ToCmdLine("CONFIG", "SET", "slowlog-log-slower-than", "-1"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(c, utils.ToCmdLine("SLOWLOG", "RESET"))
+	testServer.Exec(c, utils.ToCmdLine("GET", "slow"))
+	ret = testServer.Exec(c, utils.ToCmdLine("SLOWLOG", "LEN"))
+	asserts.AssertIntReply(t, ret, 0)
+}
+
+func TestCommand(t *testing.T) {
+	c := &connection.FakeConn{}
+	ret := testServer.Exec(c, utils.ToCmdLine("COMMAND", "LIST"))
+	names, ok := ret.(*reply.MultiBulkReply)
+	if !ok {
+		t.Errorf("expect multi bulk reply, actual %s", ret.ToBytes())
+		return
+	}
+	found := make(map[string]bool)
+	for _, name := range names.Args {
+		found[string(name)] = true
+	}
+	for _, name := range []string{"get", "select", "multi", "command", "memory"} {
+		if !found[name] {
+			t.Errorf("command %s is not listed", name)
+		}
+	}
+	ret = testServer.Exec(c, utils.ToCmdLine("COMMAND", "COUNT"))
+	asserts.AssertIntReply(t, ret, len(names.Args))
+	ret = testServer.Exec(c, utils.ToCmdLine("COMMAND", "INFO"))
+	asserts.AssertErrReply(t, ret, "ERR unknown subcommand 'INFO'. Try COMMAND HELP.")
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"godis/aof"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strconv"
+	"time"
+)
+
+// CL.THROTTLE implements generic cell rate algorithm (GCRA) like redis-cell.
+// The key stores theoretical arrival time (TAT) in unix nanoseconds as a string, it expires when TAT is reached
+// because an absent key means the same as a TAT in the past.
+// Allowed requests are appended into aof as SET and PEXPIREAT, so replaying aof restores the same TAT
+
+// ceilSeconds converts duration to seconds rounding up
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// execThrottle checks whether quantity of requests are allowed and records them.
+// It returns [limited(0/1), limit, remaining, retry_after seconds (-1 if allowed), reset_after seconds]
+// usage: CL.THROTTLE key max_burst count period [quantity]
+func execThrottle(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 5 {
+		return reply.MakeArgNumErrReply("cl.throttle")
+	}
+	key := string(args[0])
+	var params [4]int64
+	params[3] = 1 // quantity
+	for i := 1; i < len(args); i++ {
+		n, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		params[i-1] = n
+	}
+	maxBurst, count, period, quantity := params[0], params[1], params[2], params[3]
+	if count <= 0 || period <= 0 {
+		return reply.MakeErrReply("ERR count and period must be positive")
+	}
+	if period > int64(1<<62/time.Second) {
+		return reply.MakeErrReply("ERR value is out of range")
+	}
+	emission := time.Duration(period) * time.Second / time.Duration(count)
+	if emission <= 0 || maxBurst >= int64(1<<62)/int64(emission) {
+		return reply.MakeErrReply("ERR value is out of range")
+	}
+	// delay variation tolerance, how much TAT may be ahead of now
+	tolerance := emission * time.Duration(maxBurst+1)
+	increment := emission * time.Duration(quantity)
+	if quantity > 0 && (increment/time.Duration(quantity) != emission || increment > 1<<62) {
+		return reply.MakeErrReply("ERR value is out of range")
+	}
+
+	now := time.Now().UnixNano()
+	tat := now
+	entity, exists := db.GetEntity(key)
+	if exists {
+		bytes, ok := entity.Data.([]byte)
+		if !ok {
+			return &reply.WrongTypeErrReply{}
+		}
+		stored, err := strconv.ParseInt(string(bytes), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if stored > now {
+			tat = stored
+		}
+	}
+	newTAT := tat + int64(increment)
+	limited := newTAT-int64(tolerance) > now
+	retryAfter := int64(-1)
+	var ttl time.Duration
+	if limited {
+		if increment <= tolerance {
+			retryAfter = ceilSeconds(time.Duration(newTAT - int64(tolerance) - now))
+		}
+		ttl = time.Duration(tat - now)
+	} else {
+		ttl = time.Duration(newTAT - now)
+		if increment > 0 {
+			value := []byte(strconv.FormatInt(newTAT, 10))
+			expireAt := time.Unix(0, newTAT)
+			db.PutEntity(key, &database.DataEntity{Data: value})
+			db.Expire(key, expireAt)
+			db.addAof(CmdLine{[]byte("SET"), args[0], value})
+			db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
+		}
+	}
+	remaining := int64(0)
+	if next := tolerance - ttl; next > 0 {
+		remaining = int64(next / emission)
+	}
+	limitedFlag := int64(0)
+	if limited {
+		limitedFlag = 1
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeIntReply(limitedFlag),
+		reply.MakeIntReply(maxBurst + 1),
+		reply.MakeIntReply(remaining),
+		reply.MakeIntReply(retryAfter),
+		reply.MakeIntReply(ceilSeconds(ttl)),
+	})
+}
+
+func init() {
+	RegisterCommand("CL.Throttle", execThrottle, writeFirstKey, rollbackFirstKey, -5)
+}
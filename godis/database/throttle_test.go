@@ -0,0 +1,56 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"testing"
+)
+
+func TestThrottle(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	// burst of 3 requests, then 1 request per 10 seconds
+	for i := 2; i >= 0; i-- {
+		ret := testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "6", "60"))
+		assertRawReplies(t, ret, ":0\r\n", ":3\r\n", ":"+strconv.Itoa(i)+"\r\n", ":-1\r\n", ":"+strconv.Itoa(30-i*10)+"\r\n")
+	}
+	ret := testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "6", "60"))
+	assertRawReplies(t, ret, ":1\r\n", ":3\r\n", ":0\r\n", ":10\r\n", ":30\r\n")
+	// quantity more than tolerance can never be allowed
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "6", "60", "4"))
+	assertRawReplies(t, ret, ":1\r\n", ":3\r\n", ":0\r\n", ":-1\r\n", ":30\r\n")
+	// quantity 0 peeks without consuming
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "6", "60", "0"))
+	assertRawReplies(t, ret, ":0\r\n", ":3\r\n", ":0\r\n", ":-1\r\n", ":30\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("PTTL", key))
+	if ttl, ok := ret.(*reply.IntReply); !ok || ttl.Code <= 29000 || ttl.Code > 30000 {
+		t.Errorf("unexpected ttl %s", ret.ToBytes())
+	}
+
+	// another key with its own limit
+	key2 := key + "2"
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key2, "0", "1", "1", "0"))
+	assertRawReplies(t, ret, ":0\r\n", ":1\r\n", ":1\r\n", ":-1\r\n", ":0\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("EXISTS", key2))
+	asserts.AssertIntReply(t, ret, 0)
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "0", "60"))
+	asserts.AssertErrReply(t, ret, "ERR count and period must be positive")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "-1", "6", "60"))
+	asserts.AssertErrReply(t, ret, "ERR value is not an integer or out of range")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "6", "60", "1", "1"))
+	asserts.AssertErrReply(t, ret, "ERR wrong number of arguments for 'cl.throttle' command")
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "9223372036854775807", "6", "60"))
+	asserts.AssertErrReply(t, ret, "ERR value is out of range")
+	testServer.Exec(conn, utils.ToCmdLine("SET", key, "x"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key, "2", "6", "60"))
+	asserts.AssertErrReply(t, ret, "ERR value is not an integer or out of range")
+	testServer.Exec(conn, utils.ToCmdLine("RPUSH", key2, "x"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("CL.THROTTLE", key2, "2", "6", "60"))
+	if _, ok := ret.(*reply.WrongTypeErrReply); !ok {
+		t.Errorf("expect wrong type error, actual %s", ret.ToBytes())
+	}
+}
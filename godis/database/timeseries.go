@@ -0,0 +1,671 @@
+package database
+
+import (
+	"godis/datastruct/timeseries"
+	"godis/interface/database"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limits of CHUNK_SIZE, same as RedisTimeSeries
+const (
+	minTSChunkSize = 48
+	maxTSChunkSize = 1048576
+)
+
+func (db *DB) getAsTimeSeries(key string) (*timeseries.Series, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	ts, ok := entity.Data.(*timeseries.Series)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return ts, nil
+}
+
+// getTimeSeries returns existing series or error reply
+func (db *DB) getTimeSeries(key string) (*timeseries.Series, reply.ErrorReply) {
+	ts, errReply := db.getAsTimeSeries(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if ts == nil {
+		return nil, reply.MakeErrReply("ERR TSDB: the key does not exist")
+	}
+	return ts, nil
+}
+
+// parseTSOptions parses options of a new series: [RETENTION ms] [CHUNK_SIZE size] [<policyOption> policy] [LABELS label value ...].
+// It returns whether duplicate policy is given
+func parseTSOptions(args [][]byte, policyOption string) (*timeseries.Options, bool, reply.ErrorReply) {
+	opts := &timeseries.Options{}
+	policyGiven := false
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "LABELS" {
+			rest := args[i+1:]
+			if len(rest)%2 != 0 {
+				return nil, false, reply.MakeErrReply("ERR TSDB: failed parsing labels")
+			}
+			for j := 0; j < len(rest); j += 2 {
+				opts.Labels = append(opts.Labels, timeseries.Label{Name: string(rest[j]), Value: string(rest[j+1])})
+			}
+			break
+		}
+		if i+1 >= len(args) {
+			return nil, false, reply.MakeSyntaxErrReply()
+		}
+		i++
+		switch option {
+		case "RETENTION":
+			retention, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil || retention < 0 {
+				return nil, false, reply.MakeErrReply("ERR TSDB: Couldn't parse RETENTION")
+			}
+			opts.Retention = retention
+		case "CHUNK_SIZE":
+			size, err := strconv.Atoi(string(args[i]))
+			if err != nil || size%8 != 0 || size < minTSChunkSize || size > maxTSChunkSize {
+				return nil, false, reply.MakeErrReply("ERR TSDB: CHUNK_SIZE value must be a multiple of 8 in the range [48 .. 1048576]")
+			}
+			opts.ChunkSize = size
+		case policyOption:
+			policy, ok := timeseries.ParseDuplicatePolicy(string(args[i]))
+			if !ok {
+				return nil, false, reply.MakeErrReply("ERR TSDB: Unknown DUPLICATE_POLICY")
+			}
+			opts.Policy = policy
+			policyGiven = true
+		default:
+			return nil, false, reply.MakeSyntaxErrReply()
+		}
+	}
+	return opts, policyGiven, nil
+}
+
+func parseTSTimestamp(arg []byte) (int64, reply.ErrorReply) {
+	if string(arg) == "*" {
+		return time.Now().UnixNano() / int64(time.Millisecond), nil
+	}
+	timestamp, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || timestamp < 0 {
+		return 0, reply.MakeErrReply("ERR TSDB: invalid timestamp")
+	}
+	return timestamp, nil
+}
+
+func parseTSValue(arg []byte) (float64, reply.ErrorReply) {
+	value, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, reply.MakeErrReply("ERR TSDB: invalid value")
+	}
+	return value, nil
+}
+
+func makeTSAddErr(err error) reply.ErrorReply {
+	switch err {
+	case timeseries.ErrDuplicate:
+		return reply.MakeErrReply("ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+	case timeseries.ErrTooOld:
+		return reply.MakeErrReply("ERR TSDB: Timestamp is older than retention")
+	}
+	return reply.MakeErrReply("ERR TSDB: " + err.Error())
+}
+
+// addSample adds sample into series and writes samples downsampled by its rules into destination series
+func (db *DB) addSample(key string, ts *timeseries.Series, s timeseries.Sample, policy timeseries.DuplicatePolicy) reply.ErrorReply {
+	compactions, err := ts.Add(s, policy)
+	if err != nil {
+		return makeTSAddErr(err)
+	}
+	for _, c := range compactions {
+		dest, _ := db.getAsTimeSeries(c.DestKey)
+		// destination may be removed or overwritten after the rule created
+		if dest == nil || dest.SourceKey() != key {
+			continue
+		}
+		// destination keys are locked by dataPrepare of TS.ADD and TS.MADD
+		_, _ = dest.Add(c.Sample, timeseries.PolicyLast)
+		db.addVersion(c.DestKey)
+	}
+	return nil
+}
+
+func formatTSValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', 15, 64)
+}
+
+// execTSCreate creates an empty series: TS.CREATE key [RETENTION ms] [CHUNK_SIZE size] [DUPLICATE_POLICY policy] [LABELS label value ...]
+func execTSCreate(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); exists {
+		return reply.MakeErrReply("ERR TSDB: key already exists")
+	}
+	opts, _, errReply := parseTSOptions(args[1:], "DUPLICATE_POLICY")
+	if errReply != nil {
+		return errReply
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: timeseries.New(*opts),
+	})
+	db.addAof(utils.ToCmdLine3("ts.create", args...))
+	return reply.MakeOkReply()
+}
+
+// execTSAdd appends a sample, series is created if not exists:
+// TS.ADD key timestamp value [RETENTION ms] [CHUNK_SIZE size] [ON_DUPLICATE policy] [LABELS label value ...]
+func execTSAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	timestamp, errReply := parseTSTimestamp(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := parseTSValue(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	opts, policyGiven, errReply := parseTSOptions(args[3:], "ON_DUPLICATE")
+	if errReply != nil {
+		return errReply
+	}
+	ts, errReply := db.getAsTimeSeries(key)
+	if errReply != nil {
+		return errReply
+	}
+	policy := opts.Policy
+	if ts == nil {
+		// ON_DUPLICATE only works for this sample
+		opts.Policy = timeseries.PolicyBlock
+		ts = timeseries.New(*opts)
+		db.PutEntity(key, &database.DataEntity{
+			Data: ts,
+		})
+	}
+	if !policyGiven {
+		policy = ts.Policy()
+	}
+	if errReply := db.addSample(key, ts, timeseries.Sample{Timestamp: timestamp, Value: value}, policy); errReply != nil {
+		return errReply
+	}
+	// timestamp * is replaced so that replaying aof gets the same result
+	cmdLine := utils.ToCmdLine3("ts.add", args...)
+	cmdLine[2] = []byte(strconv.FormatInt(timestamp, 10))
+	db.addAof(cmdLine)
+	return reply.MakeIntReply(timestamp)
+}
+
+// execTSMAdd appends samples to existing series: TS.MADD key timestamp value [key timestamp value ...]
+func execTSMAdd(db *DB, args [][]byte) redis.Reply {
+	if len(args)%3 != 0 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'ts.madd' command")
+	}
+	result := make([]redis.Reply, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		key := string(args[i])
+		timestamp, errReply := parseTSTimestamp(args[i+1])
+		if errReply != nil {
+			result = append(result, errReply)
+			continue
+		}
+		value, errReply := parseTSValue(args[i+2])
+		if errReply != nil {
+			result = append(result, errReply)
+			continue
+		}
+		ts, errReply := db.getTimeSeries(key)
+		if errReply != nil {
+			result = append(result, errReply)
+			continue
+		}
+		if errReply := db.addSample(key, ts, timeseries.Sample{Timestamp: timestamp, Value: value}, ts.Policy()); errReply != nil {
+			result = append(result, errReply)
+			continue
+		}
+		db.addAof(utils.ToCmdLine3("ts.add", args[i], []byte(strconv.FormatInt(timestamp, 10)), args[i+2]))
+		result = append(result, reply.MakeIntReply(timestamp))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func prepareTSMAdd(args [][]byte) ([]string, []string) {
+	keys := make([]string, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		keys = append(keys, string(args[i]))
+	}
+	return keys, nil
+}
+
+// getTSRuleDests returns destination keys of rules of the given series, samples added into series are written into them
+func (db *DB) getTSRuleDests(keys ...string) []string {
+	var dests []string
+	for _, key := range keys {
+		ts, _ := db.getAsTimeSeries(key)
+		if ts == nil {
+			continue
+		}
+		for _, rule := range ts.Rules() {
+			dests = append(dests, rule.DestKey)
+		}
+	}
+	return dests
+}
+
+func dataPrepareTSAdd(db *DB, args [][]byte) []string {
+	return db.getTSRuleDests(string(args[0]))
+}
+
+func dataPrepareTSMAdd(db *DB, args [][]byte) []string {
+	keys, _ := prepareTSMAdd(args)
+	return db.getTSRuleDests(keys...)
+}
+
+// rollbackTSKeys returns undo logs of series and destinations of their rules
+func rollbackTSKeys(db *DB, keys ...string) []CmdLine {
+	return rollbackGivenKeys(db, append(keys, db.getTSRuleDests(keys...)...)...)
+}
+
+func undoTSAdd(db *DB, args [][]byte) []CmdLine {
+	return rollbackTSKeys(db, string(args[0]))
+}
+
+func undoTSMAdd(db *DB, args [][]byte) []CmdLine {
+	keys, _ := prepareTSMAdd(args)
+	return rollbackTSKeys(db, keys...)
+}
+
+// tsRangeArgs are options of TS.RANGE and TS.MRANGE
+type tsRangeArgs struct {
+	from       int64
+	to         int64
+	count      int
+	hasAgg     bool
+	agg        timeseries.Aggregation
+	bucket     int64
+	filters    []*tsLabelFilter
+	withLabels bool
+}
+
+// parseTSRange parses fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration],
+// and [WITHLABELS] FILTER filter... if multi is true
+func parseTSRange(args [][]byte, multi bool) (*tsRangeArgs, reply.ErrorReply) {
+	r := &tsRangeArgs{count: -1}
+	var errReply reply.ErrorReply
+	if r.from, errReply = parseTSRangeBound(args[0], "fromTimestamp"); errReply != nil {
+		return nil, errReply
+	}
+	if r.to, errReply = parseTSRangeBound(args[1], "toTimestamp"); errReply != nil {
+		return nil, errReply
+	}
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "COUNT" && i+1 < len(args):
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || count <= 0 {
+				return nil, reply.MakeErrReply("ERR TSDB: Couldn't parse COUNT")
+			}
+			r.count = count
+			i++
+		case option == "AGGREGATION" && i+2 < len(args):
+			agg, ok := timeseries.ParseAggregation(string(args[i+1]))
+			if !ok {
+				return nil, reply.MakeErrReply("ERR TSDB: Unknown aggregation type")
+			}
+			bucket, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil || bucket <= 0 {
+				return nil, reply.MakeErrReply("ERR TSDB: bucketDuration must be greater than zero")
+			}
+			r.hasAgg, r.agg, r.bucket = true, agg, bucket
+			i += 2
+		case multi && option == "WITHLABELS":
+			r.withLabels = true
+		case multi && option == "FILTER":
+			filters, errReply := parseTSFilters(args[i+1:])
+			if errReply != nil {
+				return nil, errReply
+			}
+			r.filters = filters
+			i = len(args)
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	if multi && len(r.filters) == 0 {
+		return nil, reply.MakeErrReply("ERR TSDB: missing FILTER argument")
+	}
+	return r, nil
+}
+
+// parseTSRangeBound parses timestamp, - and + mean the earliest and the latest
+func parseTSRangeBound(arg []byte, name string) (int64, reply.ErrorReply) {
+	switch string(arg) {
+	case "-":
+		return 0, nil
+	case "+":
+		return math.MaxInt64, nil
+	}
+	timestamp, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || timestamp < 0 {
+		return 0, reply.MakeErrReply("ERR TSDB: wrong " + name)
+	}
+	return timestamp, nil
+}
+
+// samples returns samples of series within range, reversed if rev is true
+func (r *tsRangeArgs) samples(ts *timeseries.Series, rev bool) redis.Reply {
+	samples := ts.Range(r.from, r.to)
+	if r.hasAgg {
+		samples = timeseries.Aggregate(samples, r.agg, r.bucket)
+	}
+	if rev {
+		for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+			samples[i], samples[j] = samples[j], samples[i]
+		}
+	}
+	if r.count >= 0 && len(samples) > r.count {
+		samples = samples[:r.count]
+	}
+	result := make([]redis.Reply, len(samples))
+	for i, s := range samples {
+		result[i] = makeSampleReply(s)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func makeSampleReply(s timeseries.Sample) redis.Reply {
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeIntReply(s.Timestamp),
+		reply.MakeStatusReply(formatTSValue(s.Value)),
+	})
+}
+
+func execTSRangeOf(db *DB, args [][]byte, rev bool) redis.Reply {
+	ts, errReply := db.getTimeSeries(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	r, errReply := parseTSRange(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	return r.samples(ts, rev)
+}
+
+// execTSRange returns samples in range: TS.RANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration]
+func execTSRange(db *DB, args [][]byte) redis.Reply {
+	return execTSRangeOf(db, args, false)
+}
+
+// execTSRevRange returns samples in range in reverse order
+func execTSRevRange(db *DB, args [][]byte) redis.Reply {
+	return execTSRangeOf(db, args, true)
+}
+
+// execTSGet returns the last sample: TS.GET key
+func execTSGet(db *DB, args [][]byte) redis.Reply {
+	ts, errReply := db.getTimeSeries(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	s, ok := ts.Last()
+	if !ok {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return makeSampleReply(s)
+}
+
+// tsLabelFilter matches label=value, label!=value, label= (label not exists), label!= (label exists),
+// label=(value1,value2,...) and label!=(value1,value2,...)
+type tsLabelFilter struct {
+	name   string
+	negate bool
+	values []string
+}
+
+func parseTSFilters(args [][]byte) ([]*tsLabelFilter, reply.ErrorReply) {
+	filters := make([]*tsLabelFilter, 0, len(args))
+	hasMatcher := false
+	for _, arg := range args {
+		text := string(arg)
+		i := strings.IndexByte(text, '=')
+		if i <= 0 {
+			return nil, reply.MakeErrReply("ERR TSDB: failed parsing labels")
+		}
+		f := &tsLabelFilter{name: text[:i]}
+		if strings.HasSuffix(f.name, "!") {
+			f.negate = true
+			f.name = f.name[:len(f.name)-1]
+		}
+		value := text[i+1:]
+		if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+			f.values = strings.Split(value[1:len(value)-1], ",")
+		} else {
+			f.values = []string{value}
+		}
+		if f.name == "" {
+			return nil, reply.MakeErrReply("ERR TSDB: failed parsing labels")
+		}
+		if !f.negate && value != "" {
+			hasMatcher = true
+		}
+		filters = append(filters, f)
+	}
+	// like RedisTimeSeries, at least one filter selects a label value, so that all series are not scanned
+	if !hasMatcher {
+		return nil, reply.MakeErrReply("ERR TSDB: please provide at least one matcher")
+	}
+	return filters, nil
+}
+
+func (f *tsLabelFilter) match(ts *timeseries.Series) bool {
+	// a missing label is regarded as an empty value
+	value, _ := ts.Label(f.name)
+	found := false
+	for _, v := range f.values {
+		if v == value {
+			found = true
+			break
+		}
+	}
+	return found != f.negate
+}
+
+// execTSMRange returns samples of series matched by filters:
+// TS.MRANGE fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration] [WITHLABELS] FILTER filter...
+func execTSMRange(db *DB, args [][]byte) redis.Reply {
+	r, errReply := parseTSRange(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	type matched struct {
+		key string
+		ts  *timeseries.Series
+	}
+	var series []matched
+	now := time.Now()
+	db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		ts, ok := entity.Data.(*timeseries.Series)
+		if !ok || (expiration != nil && expiration.Before(now)) {
+			return true
+		}
+		for _, f := range r.filters {
+			if !f.match(ts) {
+				return true
+			}
+		}
+		series = append(series, matched{key: key, ts: ts})
+		return true
+	})
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].key < series[j].key
+	})
+	result := make([]redis.Reply, len(series))
+	for i, m := range series {
+		var labels []redis.Reply
+		if r.withLabels {
+			for _, l := range m.ts.Labels() {
+				labels = append(labels, reply.MakeMultiBulkReply(utils.ToCmdLine(l.Name, l.Value)))
+			}
+		}
+		result[i] = reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte(m.key)),
+			reply.MakeMultiRawReply(labels),
+			r.samples(m.ts, false),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execTSCreateRule downsamples source series into destination series:
+// TS.CREATERULE sourceKey destKey AGGREGATION aggregator bucketDuration
+func execTSCreateRule(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 5 || strings.ToUpper(string(args[2])) != "AGGREGATION" {
+		return reply.MakeSyntaxErrReply()
+	}
+	srcKey, destKey := string(args[0]), string(args[1])
+	if srcKey == destKey {
+		return reply.MakeErrReply("ERR TSDB: the source key and destination key should be different")
+	}
+	agg, ok := timeseries.ParseAggregation(string(args[3]))
+	if !ok {
+		return reply.MakeErrReply("ERR TSDB: Unknown aggregation type")
+	}
+	bucket, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil || bucket <= 0 {
+		return reply.MakeErrReply("ERR TSDB: bucketDuration must be greater than zero")
+	}
+	src, errReply := db.getTimeSeries(srcKey)
+	if errReply != nil {
+		return errReply
+	}
+	dest, errReply := db.getTimeSeries(destKey)
+	if errReply != nil {
+		return errReply
+	}
+	// rules are not chained, so that adding a sample writes at most one level of destinations
+	if db.hasTSSource(destKey, dest) {
+		return reply.MakeErrReply("ERR TSDB: the destination key already has a src rule")
+	}
+	if len(dest.Rules()) > 0 {
+		return reply.MakeErrReply("ERR TSDB: the destination key already has a dst rule")
+	}
+	if db.hasTSSource(srcKey, src) {
+		return reply.MakeErrReply("ERR TSDB: the source key already has a source rule")
+	}
+	src.CreateRule(destKey, agg, bucket)
+	dest.SetSourceKey(srcKey)
+	db.addAof(utils.ToCmdLine3("ts.createrule", args...))
+	return reply.MakeOkReply()
+}
+
+// hasTSSource tells whether series is the destination of an existing rule
+func (db *DB) hasTSSource(key string, ts *timeseries.Series) bool {
+	srcKey := ts.SourceKey()
+	if srcKey == "" {
+		return false
+	}
+	src, _ := db.getAsTimeSeries(srcKey)
+	return src != nil && src.HasRule(key)
+}
+
+// execTSDeleteRule removes a compaction rule: TS.DELETERULE sourceKey destKey
+func execTSDeleteRule(db *DB, args [][]byte) redis.Reply {
+	srcKey, destKey := string(args[0]), string(args[1])
+	src, errReply := db.getTimeSeries(srcKey)
+	if errReply != nil {
+		return errReply
+	}
+	if !src.DeleteRule(destKey) {
+		return reply.MakeErrReply("ERR TSDB: compaction rule does not exist")
+	}
+	dest, _ := db.getAsTimeSeries(destKey)
+	if dest != nil && dest.SourceKey() == srcKey {
+		dest.SetSourceKey("")
+	}
+	db.addAof(utils.ToCmdLine3("ts.deleterule", args...))
+	return reply.MakeOkReply()
+}
+
+func prepareTSRule(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+func undoTSRule(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+// execTSInfo returns information of series: TS.INFO key
+func execTSInfo(db *DB, args [][]byte) redis.Reply {
+	ts, errReply := db.getTimeSeries(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	first, _ := ts.First()
+	last, _ := ts.Last()
+	labels := make([]redis.Reply, 0, len(ts.Labels()))
+	for _, l := range ts.Labels() {
+		labels = append(labels, reply.MakeMultiBulkReply(utils.ToCmdLine(l.Name, l.Value)))
+	}
+	var sourceKey redis.Reply = reply.MakeNullBulkReply()
+	if key := ts.SourceKey(); key != "" {
+		sourceKey = reply.MakeBulkReply([]byte(key))
+	}
+	rules := make([]redis.Reply, 0)
+	for _, rule := range ts.Rules() {
+		rules = append(rules, reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte(rule.DestKey)),
+			reply.MakeIntReply(rule.BucketDuration),
+			reply.MakeStatusReply(strings.ToUpper(rule.Aggregation.String())),
+		}))
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("totalSamples")), reply.MakeIntReply(int64(ts.Len())),
+		reply.MakeBulkReply([]byte("memoryUsage")), reply.MakeIntReply(int64(ts.MemoryUsage())),
+		reply.MakeBulkReply([]byte("firstTimestamp")), reply.MakeIntReply(first.Timestamp),
+		reply.MakeBulkReply([]byte("lastTimestamp")), reply.MakeIntReply(last.Timestamp),
+		reply.MakeBulkReply([]byte("retentionTime")), reply.MakeIntReply(ts.Retention()),
+		reply.MakeBulkReply([]byte("chunkCount")), reply.MakeIntReply(int64(ts.ChunkCount())),
+		reply.MakeBulkReply([]byte("chunkSize")), reply.MakeIntReply(int64(ts.ChunkSize())),
+		reply.MakeBulkReply([]byte("duplicatePolicy")), reply.MakeBulkReply([]byte(ts.Policy().String())),
+		reply.MakeBulkReply([]byte("labels")), reply.MakeMultiRawReply(labels),
+		reply.MakeBulkReply([]byte("sourceKey")), sourceKey,
+		reply.MakeBulkReply([]byte("rules")), reply.MakeMultiRawReply(rules),
+	})
+}
+
+// execTSLoadChunk restores series serialized by aof rewrite: TS.LOADCHUNK key iterator data
+func execTSLoadChunk(db *DB, args [][]byte) redis.Reply {
+	ts, err := timeseries.Unmarshal(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR received bad data")
+	}
+	db.PutEntity(string(args[0]), &database.DataEntity{
+		Data: ts,
+	})
+	db.addAof(utils.ToCmdLine3("ts.loadchunk", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("TS.Create", execTSCreate, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("TS.Add", execTSAdd, writeFirstKey, undoTSAdd, -4)
+	RegisterCommand("TS.MAdd", execTSMAdd, prepareTSMAdd, undoTSMAdd, -4)
+	setDataPrepare("TS.Add", dataPrepareTSAdd)
+	setDataPrepare("TS.MAdd", dataPrepareTSMAdd)
+	RegisterCommand("TS.Range", execTSRange, readFirstKey, nil, -4)
+	RegisterCommand("TS.RevRange", execTSRevRange, readFirstKey, nil, -4)
+	RegisterCommand("TS.Get", execTSGet, readFirstKey, nil, 2)
+	RegisterCommand("TS.MRange", execTSMRange, noPrepare, nil, -5)
+	RegisterCommand("TS.CreateRule", execTSCreateRule, prepareTSRule, undoTSRule, -6)
+	RegisterCommand("TS.DeleteRule", execTSDeleteRule, prepareTSRule, undoTSRule, 3)
+	RegisterCommand("TS.Info", execTSInfo, readFirstKey, nil, 2)
+	RegisterCommand("TS.LoadChunk", execTSLoadChunk, writeFirstKey, rollbackFirstKey, 4)
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sampleReply(timestamp int, value string) string {
+	return "*2\r\n:" + strconv.Itoa(timestamp) + "\r\n+" + value + "\r\n"
+}
+
+func TestTimeSeries(t *testing.T) {
+	conn := &connection.FakeConn{}
+	key := utils.RandString(10)
+	ret := testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", key, "RETENTION", "1000", "DUPLICATE_POLICY", "SUM", "LABELS", "a", "1"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", key))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: key already exists")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, ret, "TSDB-TYPE")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.GET", key))
+	asserts.AssertMultiBulkReplySize(t, ret, 0)
+
+	for i := 1; i <= 10; i++ {
+		ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key, strconv.Itoa(i*100), strconv.Itoa(i)))
+		asserts.AssertIntReply(t, ret, i*100)
+	}
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key, "500", "0.5"))
+	asserts.AssertIntReply(t, ret, 500)
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key, "500", "1", "ON_DUPLICATE", "BLOCK"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.MADD", key, "1100", "11", key, "-1", "1", key+"x", "1", "1"))
+	assertRawReplies(t, ret, ":1100\r\n", "-ERR TSDB: invalid timestamp\r\n", "-ERR TSDB: the key does not exist\r\n")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.GET", key))
+	assertRawReplies(t, ret, ":1100\r\n", "+11\r\n")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "400", "600"))
+	assertRawReplies(t, ret, sampleReply(400, "4"), sampleReply(500, "5.5"), sampleReply(600, "6"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.REVRANGE", key, "-", "+", "COUNT", "2"))
+	assertRawReplies(t, ret, sampleReply(1100, "11"), sampleReply(1000, "10"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "-", "+", "AGGREGATION", "avg", "500"))
+	assertRawReplies(t, ret, sampleReply(0, "2.5"), sampleReply(500, "7.1"), sampleReply(1000, "10.5"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "-", "+", "AGGREGATION", "count", "500"))
+	assertRawReplies(t, ret, sampleReply(0, "4"), sampleReply(500, "5"), sampleReply(1000, "2"))
+	// samples older than retention are not returned
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key, "1500", "15"))
+	asserts.AssertIntReply(t, ret, 1500)
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "0", "600"))
+	assertRawReplies(t, ret, sampleReply(500, "5.5"), sampleReply(600, "6"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key, "400", "1"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: Timestamp is older than retention")
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "a", "+"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: wrong fromTimestamp")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", key, "-", "+", "AGGREGATION", "median", "10"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: Unknown aggregation type")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key, "1600", "NaN"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: invalid value")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", key+"1", "CHUNK_SIZE", "10"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: CHUNK_SIZE value must be a multiple of 8 in the range [48 .. 1048576]")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", key+"1", "LABELS", "a"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: failed parsing labels")
+
+	// series is created by TS.ADD
+	key2 := utils.RandString(10)
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.ADD", key2, "*", "1", "RETENTION", "10"))
+	if _, ok := ret.(*reply.IntReply); !ok {
+		t.Errorf("expected int reply, actually %s", ret.ToBytes())
+	}
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.INFO", key2))
+	assertRawRepliesSize(t, ret, 22)
+	info := ret.(*reply.MultiRawReply).Replies
+	asserts.AssertIntReply(t, info[1], 1)
+	asserts.AssertIntReply(t, info[9], 10)
+	asserts.AssertBulkReply(t, info[15], "block")
+}
+
+func TestTimeSeriesMRange(t *testing.T) {
+	conn := &connection.FakeConn{}
+	group := utils.RandString(10)
+	keys := []string{group + "1", group + "2", group + "3"}
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", keys[0], "LABELS", "group", group, "host", "a"))
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", keys[1], "LABELS", "group", group, "host", "b"))
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", keys[2], "LABELS", "group", group))
+	testServer.Exec(conn, utils.ToCmdLine("TS.MADD", keys[0], "10", "1", keys[1], "10", "2", keys[2], "10", "3"))
+	testServer.Exec(conn, utils.ToCmdLine("TS.MADD", keys[0], "20", "4", keys[1], "20", "5"))
+
+	ret := testServer.Exec(conn, utils.ToCmdLine("TS.MRANGE", "-", "+", "WITHLABELS", "FILTER", "group="+group, "host=(a,c)"))
+	assertRawReplies(t, ret, "*3\r\n$"+strconv.Itoa(len(keys[0]))+"\r\n"+keys[0]+"\r\n"+
+		"*2\r\n*2\r\n$5\r\ngroup\r\n$10\r\n"+group+"\r\n*2\r\n$4\r\nhost\r\n$1\r\na\r\n"+
+		"*2\r\n"+sampleReply(10, "1")+sampleReply(20, "4"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.MRANGE", "0", "15", "FILTER", "group="+group, "host!="))
+	assertRawRepliesSize(t, ret, 2)
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.MRANGE", "-", "+", "AGGREGATION", "sum", "100", "FILTER", "group="+group, "host="))
+	assertRawReplies(t, ret, "*3\r\n$"+strconv.Itoa(len(keys[2]))+"\r\n"+keys[2]+"\r\n*0\r\n*1\r\n"+sampleReply(0, "3"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.MRANGE", "-", "+", "FILTER", "host!=a"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: please provide at least one matcher")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.MRANGE", "-", "+", "COUNT", "1"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: missing FILTER argument")
+}
+
+func TestTimeSeriesRuleLock(t *testing.T) {
+	conn := &connection.FakeConn{}
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", src))
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", dest))
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATERULE", src, dest, "AGGREGATION", "sum", "10"))
+	testServer.Exec(conn, utils.ToCmdLine("TS.ADD", src, "1", "1"))
+
+	// closing a bucket writes destination, so TS.ADD and TS.MADD wait for its lock
+	for _, cmdLine := range [][][]byte{
+		utils.ToCmdLine("TS.ADD", src, "10", "1"),
+		utils.ToCmdLine("TS.MADD", src, "20", "1"),
+	} {
+		testServer.RWLocks(0, []string{dest}, nil)
+		done := make(chan struct{})
+		go func(cmdLine [][]byte) {
+			testServer.Exec(&connection.FakeConn{}, cmdLine)
+			close(done)
+		}(cmdLine)
+		select {
+		case <-done:
+			t.Errorf("%s should wait for lock of destination", cmdLine[0])
+		case <-time.After(100 * time.Millisecond):
+		}
+		testServer.RWUnLocks(0, []string{dest}, nil)
+		<-done
+	}
+	ret := testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", dest, "-", "+"))
+	assertRawReplies(t, ret, sampleReply(0, "1"), sampleReply(10, "1"))
+}
+
+func TestTimeSeriesRule(t *testing.T) {
+	conn := &connection.FakeConn{}
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", src, "DUPLICATE_POLICY", "LAST"))
+	testServer.Exec(conn, utils.ToCmdLine("TS.CREATE", dest))
+	ret := testServer.Exec(conn, utils.ToCmdLine("TS.CREATERULE", src, dest, "AGGREGATION", "max", "100"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.CREATERULE", src, dest, "AGGREGATION", "max", "100"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: the destination key already has a src rule")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.CREATERULE", dest, src, "AGGREGATION", "max", "100"))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: the destination key already has a dst rule")
+
+	for i, v := range []string{"1", "5", "2", "7", "3"} {
+		testServer.Exec(conn, utils.ToCmdLine("TS.ADD", src, strconv.Itoa(i*50), v))
+	}
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", dest, "-", "+"))
+	assertRawReplies(t, ret, sampleReply(0, "5"), sampleReply(100, "7"))
+	// updating a closed bucket writes destination again
+	testServer.Exec(conn, utils.ToCmdLine("TS.ADD", src, "50", "9"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", dest, "-", "+"))
+	assertRawReplies(t, ret, sampleReply(0, "9"), sampleReply(100, "7"))
+
+	// rule state survives DUMP and RESTORE
+	ret = testServer.Exec(conn, utils.ToCmdLine("DUMP", src))
+	payload := ret.(*reply.BulkReply).Arg
+	testServer.Exec(conn, utils.ToCmdLine("DEL", src))
+	ret = testServer.Exec(conn, [][]byte{[]byte("RESTORE"), []byte(src), []byte("0"), payload})
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(conn, utils.ToCmdLine("TS.ADD", src, "300", "1"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", dest, "-", "+"))
+	assertRawReplies(t, ret, sampleReply(0, "9"), sampleReply(100, "7"), sampleReply(200, "3"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.INFO", dest))
+	asserts.AssertBulkReply(t, ret.(*reply.MultiRawReply).Replies[19], src)
+
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.DELETERULE", src, dest))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.DELETERULE", src, dest))
+	asserts.AssertErrReply(t, ret, "ERR TSDB: compaction rule does not exist")
+	testServer.Exec(conn, utils.ToCmdLine("TS.ADD", src, "400", "1"))
+	ret = testServer.Exec(conn, utils.ToCmdLine("TS.RANGE", dest, "-", "+"))
+	assertRawRepliesSize(t, ret, 3)
+}
+
+func TestUndoTimeSeries(t *testing.T) {
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	execTSCreate(testDB, utils.ToCmdLine(src))
+	execTSCreate(testDB, utils.ToCmdLine(dest))
+	execTSCreateRule(testDB, utils.ToCmdLine(src, dest, "AGGREGATION", "sum", "10"))
+	execTSAdd(testDB, utils.ToCmdLine(src, "10", "1"))
+	undoCmdLines := undoTSAdd(testDB, utils.ToCmdLine(src, "20", "2"))
+	execTSAdd(testDB, utils.ToCmdLine(src, "20", "2"))
+	ret := execTSRange(testDB, utils.ToCmdLine(dest, "-", "+"))
+	assertRawReplies(t, ret, sampleReply(10, "1"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	ret = execTSRange(testDB, utils.ToCmdLine(src, "-", "+"))
+	assertRawReplies(t, ret, sampleReply(10, "1"))
+	ret = execTSRange(testDB, utils.ToCmdLine(dest, "-", "+"))
+	assertRawRepliesSize(t, ret, 0)
+}
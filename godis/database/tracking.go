@@ -0,0 +1,476 @@
+package database
+
+import (
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// invalidateChannel delivers invalidation messages, godis only speaks RESP2 so there is no push message
+const invalidateChannel = "__redis__:invalidate"
+
+const (
+	cachingDefault = iota
+	cachingYes
+	cachingNo
+)
+
+// trackingClient is the tracking state of a connection
+type trackingClient struct {
+	conn   redis.Connection
+	bcast  bool
+	optIn  bool
+	optOut bool
+	// redirect is id of the connection receiving invalidation messages, 0 means the client itself
+	redirect int64
+	prefixes []string
+	// caching is set by CLIENT CACHING and works for the next command only
+	caching int
+	// keys is the keys remembered in table for this client, so that they can be pruned once it stops tracking
+	keys map[string]struct{}
+}
+
+// tracker implements client side caching.
+// In default mode, keys read by clients are remembered and the clients will be notified once the keys are modified.
+// In broadcasting mode, clients are notified of every modified key matching their prefixes
+type tracker struct {
+	// number of tracking clients, skip locking if nobody is tracking
+	count int32
+
+	mu      sync.Mutex
+	clients map[redis.Connection]*trackingClient
+	// key -> clients which read the key in default mode
+	table map[string]map[redis.Connection]struct{}
+
+	// ids are assigned when CLIENT ID is called, since REDIRECT is the only use of them
+	nextID int64
+	ids    map[redis.Connection]int64
+	conns  map[int64]redis.Connection
+
+	// invalidations are found while keys are locked, they are queued and sent by sendPending after locks released,
+	// so that a slow receiver never blocks writers of the keys
+	pending      []pendingInvalidation
+	pendingCount int32
+}
+
+type pendingInvalidation struct {
+	target redis.Connection
+	msg    []byte
+}
+
+func makeTracker() *tracker {
+	return &tracker{
+		clients: make(map[redis.Connection]*trackingClient),
+		table:   make(map[string]map[redis.Connection]struct{}),
+		ids:     make(map[redis.Connection]int64),
+		conns:   make(map[int64]redis.Connection),
+	}
+}
+
+func (t *tracker) clientID(c redis.Connection) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := t.ids[c]
+	if !ok {
+		t.nextID++
+		id = t.nextID
+		t.ids[c] = id
+		t.conns[id] = c
+	}
+	return id
+}
+
+// recordRead remembers keys read by c, it should be called before read locks released
+// so that modifications after reading always send invalidation
+func (t *tracker) recordRead(c redis.Connection, keys []string) {
+	if t == nil || c == nil || len(keys) == 0 || atomic.LoadInt32(&t.count) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := t.clients[c]
+	if client == nil || client.bcast {
+		return
+	}
+	if (client.optIn && client.caching != cachingYes) || (client.optOut && client.caching == cachingNo) {
+		return
+	}
+	for _, key := range keys {
+		readers := t.table[key]
+		if readers == nil {
+			readers = make(map[redis.Connection]struct{})
+			t.table[key] = readers
+		}
+		readers[c] = struct{}{}
+		if client.keys == nil {
+			client.keys = make(map[string]struct{})
+		}
+		client.keys[key] = struct{}{}
+	}
+}
+
+// forgetKeys removes table entries of client, t.mu must be held
+func (t *tracker) forgetKeys(client *trackingClient) {
+	for key := range client.keys {
+		readers := t.table[key]
+		delete(readers, client.conn)
+		if len(readers) == 0 {
+			delete(t.table, key)
+		}
+	}
+	client.keys = nil
+}
+
+// invalidate queues notifications to clients tracking the modified keys
+func (t *tracker) invalidate(keys []string) {
+	if t == nil || len(keys) == 0 || atomic.LoadInt32(&t.count) == 0 {
+		return
+	}
+	t.mu.Lock()
+	targets := make(map[*trackingClient][][]byte)
+	for _, key := range keys {
+		for c := range t.table[key] {
+			if client := t.clients[c]; client != nil && !client.bcast {
+				targets[client] = append(targets[client], []byte(key))
+				delete(client.keys, key)
+			}
+		}
+		delete(t.table, key)
+		for _, client := range t.clients {
+			if client.bcast && client.matchPrefix(key) {
+				targets[client] = append(targets[client], []byte(key))
+			}
+		}
+	}
+	for client, modified := range targets {
+		if target := t.receiverOf(client); target != nil {
+			t.enqueue(target, makeInvalidateMsg(reply.MakeMultiBulkReply(modified)))
+		}
+	}
+	t.mu.Unlock()
+}
+
+// flush queues notifications to all tracking clients that every key is modified
+func (t *tracker) flush() {
+	if t == nil || atomic.LoadInt32(&t.count) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.table = make(map[string]map[redis.Connection]struct{})
+	msg := makeInvalidateMsg(reply.MakeNullBulkReply())
+	for _, client := range t.clients {
+		client.keys = nil
+		if target := t.receiverOf(client); target != nil {
+			t.enqueue(target, msg)
+		}
+	}
+}
+
+// enqueue adds a message to send, t.mu must be held
+func (t *tracker) enqueue(target redis.Connection, msg []byte) {
+	t.pending = append(t.pending, pendingInvalidation{target: target, msg: msg})
+	atomic.StoreInt32(&t.pendingCount, int32(len(t.pending)))
+}
+
+// sendPending writes queued invalidation messages, it must be called after key locks released
+func (t *tracker) sendPending() {
+	if t == nil || atomic.LoadInt32(&t.pendingCount) == 0 {
+		return
+	}
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	atomic.StoreInt32(&t.pendingCount, 0)
+	t.mu.Unlock()
+	for _, p := range pending {
+		_ = p.target.Write(p.msg)
+	}
+}
+
+// receiverOf returns the connection receiving invalidation messages of client,
+// RESP2 connection can only receive messages in subscribe mode. t.mu must be held
+func (t *tracker) receiverOf(client *trackingClient) redis.Connection {
+	target := client.conn
+	if client.redirect != 0 {
+		target = t.conns[client.redirect]
+	}
+	if target == nil || target.SubsCount() == 0 {
+		return nil
+	}
+	return target
+}
+
+func makeInvalidateMsg(keys redis.Reply) []byte {
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("message")),
+		reply.MakeBulkReply([]byte(invalidateChannel)),
+		keys,
+	}).ToBytes()
+}
+
+func (client *trackingClient) matchPrefix(key string) bool {
+	if len(client.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range client.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// afterCommand resets CLIENT CACHING after the next command, a transaction is regarded as one command
+func (t *tracker) afterCommand(c redis.Connection, cmdName string) {
+	if t == nil || c == nil || cmdName == "client" || c.InMultiState() || atomic.LoadInt32(&t.count) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if client := t.clients[c]; client != nil {
+		client.caching = cachingDefault
+	}
+}
+
+// removeClient cleans state of closed connection
+func (t *tracker) removeClient(c redis.Connection) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if client, ok := t.clients[c]; ok {
+		t.forgetKeys(client)
+		delete(t.clients, c)
+		atomic.AddInt32(&t.count, -1)
+	}
+	if id, ok := t.ids[c]; ok {
+		delete(t.ids, c)
+		delete(t.conns, id)
+	}
+}
+
+// execClient implements CLIENT ID | TRACKING | CACHING | GETREDIR | TRACKINGINFO
+func execClient(t *tracker, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	if c == nil {
+		return reply.MakeErrReply("ERR CLIENT is not available for this connection")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "id":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|id")
+		}
+		return reply.MakeIntReply(t.clientID(c))
+	case "tracking":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply("client|tracking")
+		}
+		return t.execTracking(c, args[1:])
+	case "caching":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|caching")
+		}
+		return t.execCaching(c, args[1])
+	case "getredir":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|getredir")
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		client := t.clients[c]
+		if client == nil {
+			return reply.MakeIntReply(-1)
+		}
+		return reply.MakeIntReply(client.redirect)
+	case "trackinginfo":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|trackinginfo")
+		}
+		return t.execTrackingInfo(c)
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'")
+	}
+}
+
+// execTracking implements CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT]
+func (t *tracker) execTracking(c redis.Connection, args [][]byte) redis.Reply {
+	mode := strings.ToLower(string(args[0]))
+	if mode != "on" && mode != "off" {
+		return &reply.SyntaxErrReply{}
+	}
+	option := &trackingClient{conn: c}
+	redirectGiven := false
+	for i := 1; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "bcast":
+			option.bcast = true
+		case arg == "optin":
+			option.optIn = true
+		case arg == "optout":
+			option.optOut = true
+		case arg == "redirect" && i+1 < len(args):
+			if redirectGiven {
+				return reply.MakeErrReply("ERR A client can only redirect to a single other client")
+			}
+			id, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			option.redirect = id
+			redirectGiven = true
+			i++
+		case arg == "prefix" && i+1 < len(args):
+			option.prefixes = append(option.prefixes, string(args[i+1]))
+			i++
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := t.clients[c]
+	if mode == "off" {
+		if client != nil {
+			t.forgetKeys(client)
+			delete(t.clients, c)
+			atomic.AddInt32(&t.count, -1)
+		}
+		return reply.MakeOkReply()
+	}
+
+	if len(option.prefixes) > 0 && !option.bcast {
+		return reply.MakeErrReply("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if option.optIn && option.optOut {
+		return reply.MakeErrReply("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if option.bcast && (option.optIn || option.optOut) {
+		return reply.MakeErrReply("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if option.redirect != 0 {
+		if _, ok := t.conns[option.redirect]; !ok {
+			return reply.MakeErrReply("ERR The client ID you want redirect to does not exist")
+		}
+	}
+	if client != nil {
+		if client.bcast != option.bcast {
+			return reply.MakeErrReply("ERR You can't switch BCAST mode on/off before disabling tracking " +
+				"for this client, and then re-enabling it with a different mode.")
+		}
+		if client.optIn != option.optIn || client.optOut != option.optOut {
+			return reply.MakeErrReply("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking " +
+				"for this client, and then re-enabling it with a different mode.")
+		}
+	}
+	// prefixes of a client must not overlap, otherwise a key would be notified twice
+	var prefixes []string
+	if client != nil {
+		prefixes = client.prefixes
+	}
+	for _, prefix := range option.prefixes {
+		duplicated := false
+		for _, existed := range prefixes {
+			if prefix == existed {
+				duplicated = true
+				break
+			}
+			if strings.HasPrefix(prefix, existed) || strings.HasPrefix(existed, prefix) {
+				return reply.MakeErrReply("ERR Prefix '" + prefix + "' overlaps with an existing prefix '" +
+					existed + "'. Prefixes for a single client must not overlap.")
+			}
+		}
+		if !duplicated {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	option.prefixes = prefixes
+	if client == nil {
+		atomic.AddInt32(&t.count, 1)
+	} else {
+		option.keys = client.keys
+	}
+	t.clients[c] = option
+	return reply.MakeOkReply()
+}
+
+// execCaching implements CLIENT CACHING YES|NO
+func (t *tracker) execCaching(c redis.Connection, arg []byte) redis.Reply {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := t.clients[c]
+	if client == nil || (!client.optIn && !client.optOut) {
+		return reply.MakeErrReply("ERR CLIENT CACHING can be called only when the client is in tracking mode " +
+			"with OPTIN or OPTOUT mode enabled")
+	}
+	switch strings.ToLower(string(arg)) {
+	case "yes":
+		if !client.optIn {
+			return reply.MakeErrReply("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		client.caching = cachingYes
+	case "no":
+		if !client.optOut {
+			return reply.MakeErrReply("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		client.caching = cachingNo
+	default:
+		return &reply.SyntaxErrReply{}
+	}
+	return reply.MakeOkReply()
+}
+
+func (t *tracker) execTrackingInfo(c redis.Connection) redis.Reply {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := t.clients[c]
+	var flags, prefixes [][]byte
+	redirect := int64(-1)
+	if client == nil {
+		flags = append(flags, []byte("off"))
+	} else {
+		flags = append(flags, []byte("on"))
+		if client.bcast {
+			flags = append(flags, []byte("bcast"))
+		}
+		if client.optIn {
+			flags = append(flags, []byte("optin"))
+		}
+		if client.optOut {
+			flags = append(flags, []byte("optout"))
+		}
+		if client.caching == cachingYes {
+			flags = append(flags, []byte("caching-yes"))
+		} else if client.caching == cachingNo {
+			flags = append(flags, []byte("caching-no"))
+		}
+		if _, ok := t.conns[client.redirect]; client.redirect != 0 && !ok {
+			flags = append(flags, []byte("broken_redirect"))
+		}
+		redirect = client.redirect
+		for _, prefix := range client.prefixes {
+			prefixes = append(prefixes, []byte(prefix))
+		}
+	}
+	prefixReply := redis.Reply(reply.MakeEmptyMultiBulkReply())
+	if len(prefixes) > 0 {
+		prefixReply = reply.MakeMultiBulkReply(prefixes)
+	}
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("flags")),
+		reply.MakeMultiBulkReply(flags),
+		reply.MakeBulkReply([]byte("redirect")),
+		reply.MakeIntReply(redirect),
+		reply.MakeBulkReply([]byte("prefixes")),
+		prefixReply,
+	})
+}
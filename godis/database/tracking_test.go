@@ -0,0 +1,252 @@
+package database
+
+import (
+	"bytes"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// subscribeInvalidation makes a connection receiving invalidation messages, returns it and its id
+func subscribeInvalidation(t *testing.T) (*connection.FakeConn, int64) {
+	receiver := &connection.FakeConn{}
+	ret := testServer.Exec(receiver, utils.ToCmdLine("CLIENT", "ID"))
+	intReply, ok := ret.(*reply.IntReply)
+	if !ok {
+		t.Fatalf("expected int reply, actually %s", ret.ToBytes())
+	}
+	testServer.Exec(receiver, utils.ToCmdLine("SUBSCRIBE", invalidateChannel))
+	receiver.Clean()
+	return receiver, intReply.Code
+}
+
+func expectNoInvalidation(t *testing.T, receiver *connection.FakeConn) {
+	if len(receiver.Bytes()) > 0 {
+		t.Errorf("expected no invalidation, actually %q", receiver.Bytes())
+	}
+}
+
+// expectInvalidation checks receiver got invalidation of keys, or of all keys if no key given
+func expectInvalidation(t *testing.T, receiver *connection.FakeConn, keys ...string) {
+	var keysReply redis.Reply = reply.MakeNullBulkReply()
+	if len(keys) > 0 {
+		keysReply = reply.MakeMultiBulkReply(utils.ToCmdLine(keys...))
+	}
+	expected := makeInvalidateMsg(keysReply)
+	if !bytes.Equal(receiver.Bytes(), expected) {
+		t.Errorf("expected invalidation %q, actually %q", expected, receiver.Bytes())
+	}
+	receiver.Clean()
+}
+
+func TestClientTracking(t *testing.T) {
+	receiver, id := subscribeInvalidation(t)
+	defer testServer.AfterClientClose(receiver)
+	c := &connection.FakeConn{}
+	defer testServer.AfterClientClose(c)
+	writer := &connection.FakeConn{}
+
+	key := utils.RandString(10)
+	ret := testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10)))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "GETREDIR"))
+	asserts.AssertIntReply(t, ret, int(id))
+
+	// keys not read are not tracked
+	testServer.Exec(writer, utils.ToCmdLine("SET", key, "1"))
+	expectNoInvalidation(t, receiver)
+
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	testServer.Exec(writer, utils.ToCmdLine("SET", key, "2"))
+	expectInvalidation(t, receiver, key)
+	// invalidation is sent only once until the key is read again
+	testServer.Exec(writer, utils.ToCmdLine("SET", key, "3"))
+	expectNoInvalidation(t, receiver)
+
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	testServer.Exec(writer, utils.ToCmdLine("FLUSHDB"))
+	expectInvalidation(t, receiver)
+
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "OFF"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	testServer.Exec(writer, utils.ToCmdLine("SET", key, "4"))
+	expectNoInvalidation(t, receiver)
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "GETREDIR"))
+	asserts.AssertIntReply(t, ret, -1)
+}
+
+func TestClientTrackingBcast(t *testing.T) {
+	receiver, id := subscribeInvalidation(t)
+	defer testServer.AfterClientClose(receiver)
+	c := &connection.FakeConn{}
+	defer testServer.AfterClientClose(c)
+	writer := &connection.FakeConn{}
+
+	ret := testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "REDIRECT", strconv.FormatInt(id, 10)))
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(writer, utils.ToCmdLine("SET", "user:1", "a"))
+	expectInvalidation(t, receiver, "user:1")
+	testServer.Exec(writer, utils.ToCmdLine("SET", "order:1", "a"))
+	expectNoInvalidation(t, receiver)
+	testServer.Exec(writer, utils.ToCmdLine("MSET", "user:2", "a", "user:3", "b"))
+	expectInvalidation(t, receiver, "user:2", "user:3")
+
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKINGINFO"))
+	expected := reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("flags")),
+		reply.MakeMultiBulkReply(utils.ToCmdLine("on", "bcast")),
+		reply.MakeBulkReply([]byte("redirect")),
+		reply.MakeIntReply(id),
+		reply.MakeBulkReply([]byte("prefixes")),
+		reply.MakeMultiBulkReply(utils.ToCmdLine("user:")),
+	})
+	if !bytes.Equal(ret.ToBytes(), expected.ToBytes()) {
+		t.Errorf("expected %q, actually %q", expected.ToBytes(), ret.ToBytes())
+	}
+
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:admin"))
+	asserts.AssertErrReply(t, ret, "ERR Prefix 'user:admin' overlaps with an existing prefix 'user:'. "+
+		"Prefixes for a single client must not overlap.")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON"))
+	asserts.AssertErrReply(t, ret, "ERR You can't switch BCAST mode on/off before disabling tracking "+
+		"for this client, and then re-enabling it with a different mode.")
+}
+
+func TestClientTrackingOptIn(t *testing.T) {
+	receiver, id := subscribeInvalidation(t)
+	defer testServer.AfterClientClose(receiver)
+	c := &connection.FakeConn{}
+	defer testServer.AfterClientClose(c)
+	writer := &connection.FakeConn{}
+
+	key1 := utils.RandString(10)
+	key2 := utils.RandString(10)
+	ret := testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "OPTIN", "REDIRECT", strconv.FormatInt(id, 10)))
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(c, utils.ToCmdLine("GET", key1))
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "CACHING", "YES"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	testServer.Exec(c, utils.ToCmdLine("GET", key2))
+	testServer.Exec(writer, utils.ToCmdLine("MSET", key1, "a", key2, "b"))
+	expectInvalidation(t, receiver, key2)
+
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "CACHING", "NO"))
+	asserts.AssertErrReply(t, ret, "ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+}
+
+// blockingConn blocks writing once blocking is set, like a receiver not reading its socket
+type blockingConn struct {
+	*connection.FakeConn
+	blocking int32
+	writing  chan struct{}
+	unblock  chan struct{}
+}
+
+func (c *blockingConn) Write(b []byte) error {
+	if atomic.LoadInt32(&c.blocking) == 1 {
+		close(c.writing)
+		<-c.unblock
+	}
+	return c.FakeConn.Write(b)
+}
+
+func TestClientTrackingSlowReceiver(t *testing.T) {
+	receiver := &blockingConn{
+		FakeConn: &connection.FakeConn{},
+		writing:  make(chan struct{}),
+		unblock:  make(chan struct{}),
+	}
+	defer testServer.AfterClientClose(receiver)
+	ret := testServer.Exec(receiver, utils.ToCmdLine("CLIENT", "ID"))
+	id := ret.(*reply.IntReply).Code
+	testServer.Exec(receiver, utils.ToCmdLine("SUBSCRIBE", invalidateChannel))
+	atomic.StoreInt32(&receiver.blocking, 1)
+	c := &connection.FakeConn{}
+	defer testServer.AfterClientClose(c)
+
+	key := utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10)))
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	written := make(chan struct{})
+	go func() {
+		testServer.Exec(&connection.FakeConn{}, utils.ToCmdLine("SET", key, "1"))
+		close(written)
+	}()
+	<-receiver.writing
+	// invalidation is sent after the key unlocked, so the key is accessible while receiver is blocked
+	read := make(chan redis.Reply, 1)
+	go func() {
+		read <- testServer.Exec(&connection.FakeConn{}, utils.ToCmdLine("GET", key))
+	}()
+	select {
+	case ret = <-read:
+		asserts.AssertBulkReply(t, ret, "1")
+	case <-time.After(time.Second):
+		t.Error("key is locked while sending invalidation")
+	}
+	close(receiver.unblock)
+	<-written
+}
+
+func TestClientTrackingErr(t *testing.T) {
+	c := &connection.FakeConn{}
+	defer testServer.AfterClientClose(c)
+	ret := testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "PREFIX", "a"))
+	asserts.AssertErrReply(t, ret, "ERR PREFIX option requires BCAST mode to be enabled")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "OPTIN", "OPTOUT"))
+	asserts.AssertErrReply(t, ret, "ERR You can't use both OPTIN and OPTOUT")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "BCAST", "OPTIN"))
+	asserts.AssertErrReply(t, ret, "ERR OPTIN and OPTOUT are not compatible with BCAST")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON", "REDIRECT", "100000"))
+	asserts.AssertErrReply(t, ret, "ERR The client ID you want redirect to does not exist")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "MAYBE"))
+	asserts.AssertErrReply(t, ret, "Err syntax error")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "CACHING", "YES"))
+	asserts.AssertErrReply(t, ret, "ERR CLIENT CACHING can be called only when the client is in tracking mode "+
+		"with OPTIN or OPTOUT mode enabled")
+	ret = testServer.Exec(c, utils.ToCmdLine("CLIENT", "NOSUCH"))
+	asserts.AssertErrReply(t, ret, "ERR unknown subcommand 'NOSUCH'")
+}
+
+func TestClientTrackingClose(t *testing.T) {
+	c := &connection.FakeConn{}
+	key := utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON"))
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	// keys read are kept after tracking options changed
+	testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON"))
+	testServer.tracking.mu.Lock()
+	_, tracked := testServer.tracking.table[key]
+	testServer.tracking.mu.Unlock()
+	if !tracked {
+		t.Error("key read should be tracked")
+	}
+
+	// entries of closed client are pruned even if the keys are never modified
+	testServer.AfterClientClose(c)
+	testServer.tracking.mu.Lock()
+	_, tracked = testServer.tracking.table[key]
+	testServer.tracking.mu.Unlock()
+	if tracked {
+		t.Error("key read by closed client should not be tracked")
+	}
+
+	c = &connection.FakeConn{}
+	defer testServer.AfterClientClose(c)
+	testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "ON"))
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	testServer.Exec(c, utils.ToCmdLine("CLIENT", "TRACKING", "OFF"))
+	testServer.tracking.mu.Lock()
+	_, tracked = testServer.tracking.table[key]
+	testServer.tracking.mu.Unlock()
+	if tracked {
+		t.Error("key read should not be tracked after tracking turned off")
+	}
+}
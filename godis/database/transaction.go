@@ -10,6 +10,10 @@ import (
 var forbiddenInMulti = set.Make(
 	"flushdb",
 	"flushall",
+	// index commands lock keys by themselves
+	"ft.create",
+	"ft.search",
+	"ft.dropindex",
 )
 
 // Watch set watching keys
@@ -22,6 +26,15 @@ func Watch(db *DB, conn redis.Connection, args [][]byte) redis.Reply {
 	return reply.MakeOkReply()
 }
 
+// UnWatch forgets all watching keys
+func UnWatch(conn redis.Connection) redis.Reply {
+	watching := conn.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return reply.MakeOkReply()
+}
+
 func execGetVersion(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	ver := db.GetVersion(key)
@@ -59,7 +72,10 @@ func EnqueueCmd(conn redis.Connection, cmdLine [][]byte) redis.Reply {
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
 	}
-	if forbiddenInMulti.Has(cmdName) {
+	if errReply := cmd.refuseInternal(conn, cmdName); errReply != nil {
+		return errReply
+	}
+	if forbiddenInMulti.Has(cmdName) || cmd.flags&FlagNoMulti != 0 {
 		return reply.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
 	}
 	if cmd.prepare == nil {
@@ -87,6 +103,7 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 	// prepare
 	writeKeys := make([]string, 0) // may contains duplicate
 	readKeys := make([]string, 0)
+	var dataCmdLines []CmdLine
 	for _, cmdLine := range cmdLines {
 		cmdName := strings.ToLower(string(cmdLine[0]))
 		cmd := cmdTable[cmdName]
@@ -94,15 +111,32 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 		write, read := prepare(cmdLine[1:])
 		writeKeys = append(writeKeys, write...)
 		readKeys = append(readKeys, read...)
+		if cmd.dataPrepare != nil {
+			dataCmdLines = append(dataCmdLines, cmdLine)
+		}
 	}
+	var dataPrepare func() []string
+	if len(dataCmdLines) > 0 {
+		dataPrepare = func() []string {
+			var keys []string
+			for _, cmdLine := range dataCmdLines {
+				cmd := cmdTable[strings.ToLower(string(cmdLine[0]))]
+				keys = append(keys, cmd.dataPrepare(db, cmdLine[1:])...)
+			}
+			return keys
+		}
+	}
+	cmdReadKeys := readKeys
 	// set watch
 	watchingKeys := make([]string, 0, len(watching))
 	for key := range watching {
 		watchingKeys = append(watchingKeys, key)
 	}
 	readKeys = append(readKeys, watchingKeys...)
-	db.RWLocks(writeKeys, readKeys)
-	defer db.RWUnLocks(writeKeys, readKeys)
+	db.stopWorld.RLock()
+	defer db.stopWorld.RUnlock()
+	locked := db.lockWithData(writeKeys, readKeys, dataPrepare)
+	defer db.RWUnLocks(locked, readKeys)
 
 	if isWatchingChanged(db, watching) { // watching keys changed, abort
 		return reply.MakeEmptyMultiBulkReply()
@@ -124,6 +158,11 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 	}
 	if !aborted { //success
 		db.addVersion(writeKeys...)
+		db.tracking.recordRead(conn, cmdReadKeys)
+		for _, cmdLine := range cmdLines {
+			write, _ := GetRelatedKeys(cmdLine)
+			db.notifyKeyspace(strings.ToLower(string(cmdLine[0])), write)
+		}
 		return reply.MakeMultiRawReply(results)
 	}
 	// undo if aborted
@@ -174,8 +213,7 @@ func (db *DB) execWithLock(cmdLine [][]byte) redis.Reply {
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
-	fun := cmd.executor
-	return fun(db, cmdLine[1:])
+	return cmd.exec(db, cmdLine)
 }
 
 // GetRelatedKeys analysis related keys
@@ -6,6 +6,7 @@ import (
 )
 
 func makeTestDB() *DB {
+	startLazyfreeWorker()
 	return &DB{
 		data:       dict.MakeConcurrent(dataDictSize),
 		versionMap: dict.MakeConcurrent(dataDictSize),
@@ -0,0 +1,295 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+const (
+	// tighteningRatio is the ratio of error rate of a new layer to the previous one,
+	// so that the compound error rate of all layers converges to at most 2 times of the given one
+	tighteningRatio = 0.5
+	// maxLayerBytes limits memory of a layer, it is the max length of bulk string so that filter could be dumped
+	maxLayerBytes = 512 << 20
+)
+
+var (
+	// ErrFull is returned when adding to a full non scaling filter
+	ErrFull = errors.New("non scaling filter is full")
+	// ErrTooLarge is returned when the filter needs a layer larger than maxLayerBytes
+	ErrTooLarge = errors.New("filter is too large")
+	// ErrCorrupted is returned when restoring a filter from broken data
+	ErrCorrupted = errors.New("corrupted filter data")
+)
+
+// layer is a classic bloom filter with fixed capacity
+type layer struct {
+	bits     []byte
+	size     uint64 // number of bits
+	hashes   uint64 // number of hash functions
+	capacity uint64
+	count    uint64
+}
+
+// ScalableFilter is a bloom filter which adds a larger and more accurate layer when the current one is full,
+// so that error rate is kept without knowing the number of items in advance. It is not concurrent safe
+type ScalableFilter struct {
+	errorRate  float64
+	expansion  uint64
+	nonScaling bool
+	layers     []*layer
+}
+
+// optimalLayer returns a layer holding capacity items with the given error rate
+func optimalLayer(capacity uint64, errorRate float64) (*layer, error) {
+	// m = -n*ln(p) / ln(2)^2, k = -log2(p)
+	bits := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if bits > maxLayerBytes*8 {
+		return nil, ErrTooLarge
+	}
+	size := uint64(bits)
+	if size < 64 {
+		size = 64
+	}
+	return &layer{
+		bits:     make([]byte, (size+7)/8),
+		size:     size,
+		hashes:   uint64(math.Ceil(-math.Log2(errorRate))),
+		capacity: capacity,
+	}, nil
+}
+
+// New creates a scalable filter whose first layer holds capacity items with errorRate.
+// A new layer has expansion times capacity of the previous one, no layer will be added if nonScaling is set
+func New(errorRate float64, capacity uint64, expansion uint64, nonScaling bool) (*ScalableFilter, error) {
+	if errorRate <= 0 || errorRate >= 1 || capacity == 0 {
+		return nil, errors.New("illegal error rate or capacity")
+	}
+	if expansion == 0 {
+		expansion = 1
+	}
+	l, err := optimalLayer(capacity, errorRate)
+	if err != nil {
+		return nil, err
+	}
+	return &ScalableFilter{
+		errorRate:  errorRate,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+		layers:     []*layer{l},
+	}, nil
+}
+
+// hash returns 2 independent hashes of item, the i-th hash function is h1 + i*h2 (Kirsch-Mitzenmacher)
+func hash(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+func (l *layer) has(h1, h2 uint64) bool {
+	for i := uint64(0); i < l.hashes; i++ {
+		pos := (h1 + i*h2) % l.size
+		if l.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *layer) add(h1, h2 uint64) {
+	for i := uint64(0); i < l.hashes; i++ {
+		pos := (h1 + i*h2) % l.size
+		l.bits[pos/8] |= 1 << (pos % 8)
+	}
+	l.count++
+}
+
+// Add puts item into filter, returns false if item may exist already
+func (f *ScalableFilter) Add(item string) (bool, error) {
+	h1, h2 := hash(item)
+	for _, l := range f.layers {
+		if l.has(h1, h2) {
+			return false, nil
+		}
+	}
+	last := f.layers[len(f.layers)-1]
+	if last.count >= last.capacity {
+		if f.nonScaling {
+			return false, ErrFull
+		}
+		if float64(last.capacity)*float64(f.expansion) > maxLayerBytes*8 {
+			return false, ErrTooLarge
+		}
+		errorRate := f.errorRate * math.Pow(tighteningRatio, float64(len(f.layers)))
+		l, err := optimalLayer(last.capacity*f.expansion, errorRate)
+		if err != nil {
+			return false, err
+		}
+		f.layers = append(f.layers, l)
+		last = l
+	}
+	last.add(h1, h2)
+	return true, nil
+}
+
+// Exists returns false if item is definitely not in filter
+func (f *ScalableFilter) Exists(item string) bool {
+	h1, h2 := hash(item)
+	for _, l := range f.layers {
+		if l.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Capacity returns the number of items the filter holds before adding a new layer
+func (f *ScalableFilter) Capacity() uint64 {
+	var capacity uint64
+	for _, l := range f.layers {
+		capacity += l.capacity
+	}
+	return capacity
+}
+
+// Len returns the number of items added
+func (f *ScalableFilter) Len() uint64 {
+	var count uint64
+	for _, l := range f.layers {
+		count += l.count
+	}
+	return count
+}
+
+// Size returns the number of bytes used by bits
+func (f *ScalableFilter) Size() uint64 {
+	var size uint64
+	for _, l := range f.layers {
+		size += uint64(len(l.bits))
+	}
+	return size
+}
+
+// Layers returns the number of layers
+func (f *ScalableFilter) Layers() int {
+	return len(f.layers)
+}
+
+// Expansion returns the growth factor of capacity, 0 for non scaling filter
+func (f *ScalableFilter) Expansion() uint64 {
+	if f.nonScaling {
+		return 0
+	}
+	return f.expansion
+}
+
+// ErrorRate returns the error rate given when the filter was created
+func (f *ScalableFilter) ErrorRate() float64 {
+	return f.errorRate
+}
+
+// Marshal encodes filter into bytes, it can be restored by Unmarshal
+// layout: uint64(error rate bits) uvarint(expansion) byte(non scaling) uvarint(layer count) layers...
+// layer: uvarint(size) uvarint(hashes) uvarint(capacity) uvarint(count) bits
+func (f *ScalableFilter) Marshal() []byte {
+	buf := make([]byte, 0, 16+f.Size()+uint64(len(f.layers))*4*binary.MaxVarintLen64)
+	var errorRate [8]byte
+	binary.LittleEndian.PutUint64(errorRate[:], math.Float64bits(f.errorRate))
+	buf = append(buf, errorRate[:]...)
+	buf = appendUvarint(buf, f.expansion)
+	if f.nonScaling {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(f.layers)))
+	for _, l := range f.layers {
+		buf = appendUvarint(buf, l.size)
+		buf = appendUvarint(buf, l.hashes)
+		buf = appendUvarint(buf, l.capacity)
+		buf = appendUvarint(buf, l.count)
+		buf = append(buf, l.bits...)
+	}
+	return buf
+}
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(tmp[:], n)
+	return append(buf, tmp[:size]...)
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.data = d.data[size:]
+	return n
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.err = ErrCorrupted
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+// Unmarshal restores filter encoded by Marshal
+func Unmarshal(data []byte) (*ScalableFilter, error) {
+	d := &decoder{data: data}
+	errorRate := d.bytes(8)
+	f := &ScalableFilter{
+		expansion: d.uvarint(),
+	}
+	flag := d.bytes(1)
+	n := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	f.errorRate = math.Float64frombits(binary.LittleEndian.Uint64(errorRate))
+	f.nonScaling = flag[0] != 0
+	if f.errorRate <= 0 || f.errorRate >= 1 || f.expansion == 0 || n == 0 || n > uint64(len(d.data)) {
+		return nil, ErrCorrupted
+	}
+	f.layers = make([]*layer, 0, n)
+	for i := uint64(0); i < n; i++ {
+		l := &layer{
+			size:     d.uvarint(),
+			hashes:   d.uvarint(),
+			capacity: d.uvarint(),
+			count:    d.uvarint(),
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		if l.size == 0 || l.size > maxLayerBytes*8 || l.hashes == 0 || l.hashes > 64 {
+			return nil, ErrCorrupted
+		}
+		l.bits = append([]byte(nil), d.bytes((l.size+7)/8)...)
+		f.layers = append(f.layers, l)
+	}
+	if d.err != nil || len(d.data) > 0 {
+		return nil, ErrCorrupted
+	}
+	return f, nil
+}
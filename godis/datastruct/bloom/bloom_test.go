@@ -0,0 +1,92 @@
+package bloom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestScalableFilter(t *testing.T) {
+	f, err := New(0.01, 100, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 1000
+	for i := 0; i < size; i++ {
+		if _, err := f.Add(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < size; i++ {
+		if !f.Exists(strconv.Itoa(i)) {
+			t.Errorf("expected %d exists", i)
+		}
+	}
+	// 100 + 200 + 400 + 800
+	if f.Layers() != 4 || f.Capacity() != 1500 {
+		t.Errorf("expected 4 layers with capacity 1500, actually %d layers with capacity %d", f.Layers(), f.Capacity())
+	}
+	if f.Len() > uint64(size) || f.Len() < uint64(size)*9/10 {
+		t.Errorf("unexpected number of items %d", f.Len())
+	}
+	falsePositive := 0
+	for i := size; i < size*11; i++ {
+		if f.Exists(strconv.Itoa(i)) {
+			falsePositive++
+		}
+	}
+	// compound error rate is no more than 2 times of the given
+	if float64(falsePositive)/float64(size*10) > 0.02 {
+		t.Errorf("too many false positives: %d", falsePositive)
+	}
+	added, _ := f.Add("0")
+	if added {
+		t.Error("expected existing item not added")
+	}
+}
+
+func TestNonScaling(t *testing.T) {
+	f, err := New(0.001, 10, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fullErr error
+	for i := 0; i < 20 && fullErr == nil; i++ {
+		_, fullErr = f.Add(strconv.Itoa(i))
+	}
+	if fullErr != ErrFull {
+		t.Errorf("expected ErrFull, actually %v", fullErr)
+	}
+	if f.Layers() != 1 || f.Expansion() != 0 {
+		t.Error("non scaling filter should not expand")
+	}
+	_, err = New(1e-9, 1<<40, 2, false)
+	if err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, actually %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f, _ := New(0.01, 10, 3, false)
+	for i := 0; i < 100; i++ {
+		_, _ = f.Add(strconv.Itoa(i))
+	}
+	data := f.Marshal()
+	f2, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f2.Marshal(), data) {
+		t.Error("restored filter is different")
+	}
+	for i := 0; i < 100; i++ {
+		if !f2.Exists(strconv.Itoa(i)) {
+			t.Errorf("expected %d exists", i)
+		}
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := Unmarshal(data[:i]); err == nil {
+			t.Errorf("expected error for truncated data of length %d", i)
+		}
+	}
+}
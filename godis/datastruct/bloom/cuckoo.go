@@ -0,0 +1,322 @@
+package bloom
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+const (
+	// DefaultBucketSize is the number of fingerprints in a bucket of cuckoo filter
+	DefaultBucketSize = 2
+	// DefaultMaxIterations is the number of relocations before cuckoo filter gives up inserting into a layer
+	DefaultMaxIterations = 20
+)
+
+// ErrCuckooFull is returned when inserting into a full cuckoo filter which could not expand
+var ErrCuckooFull = errors.New("filter is full")
+
+// cuckooLayer is a table of buckets, each bucket has bucketSize one byte fingerprints and 0 means empty
+type cuckooLayer struct {
+	numBuckets uint64 // power of 2
+	data       []byte
+}
+
+// CuckooFilter supports deleting items, which bloom filter does not.
+// An item is stored as a fingerprint in one of its 2 candidate buckets, a new layer is added when the last one is full.
+// It is not concurrent safe
+type CuckooFilter struct {
+	bucketSize    uint64
+	maxIterations uint64
+	// expansion is the growth factor of number of buckets, 0 means never expand
+	expansion uint64
+	items     uint64
+	deleted   uint64
+	layers    []*cuckooLayer
+}
+
+func nextPowerOf2(n uint64) uint64 {
+	p := uint64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// NewCuckoo creates a cuckoo filter with capacity at least, see DefaultBucketSize and DefaultMaxIterations.
+// Expansion is rounded up to power of 2, 0 makes a filter which never expands
+func NewCuckoo(capacity, bucketSize, maxIterations, expansion uint64) (*CuckooFilter, error) {
+	if capacity == 0 || bucketSize == 0 || bucketSize > 255 || maxIterations == 0 {
+		return nil, errors.New("illegal cuckoo filter options")
+	}
+	numBuckets := nextPowerOf2((capacity + bucketSize - 1) / bucketSize)
+	if numBuckets*bucketSize > maxLayerBytes {
+		return nil, ErrTooLarge
+	}
+	if expansion > 0 {
+		expansion = nextPowerOf2(expansion)
+	}
+	return &CuckooFilter{
+		bucketSize:    bucketSize,
+		maxIterations: maxIterations,
+		expansion:     expansion,
+		layers: []*cuckooLayer{{
+			numBuckets: numBuckets,
+			data:       make([]byte, numBuckets*bucketSize),
+		}},
+	}, nil
+}
+
+// fingerprint returns the fingerprint and the first candidate bucket (before masking) of item
+func fingerprint(item string) (byte, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum64()
+	return byte(sum%255 + 1), sum
+}
+
+// altIndex returns the other candidate bucket, altIndex(altIndex(i, fp), fp) == i
+func (l *cuckooLayer) altIndex(index uint64, fp byte) uint64 {
+	return (index ^ (uint64(fp) * 0x5bd1e995)) & (l.numBuckets - 1)
+}
+
+func (l *cuckooLayer) indexes(fp byte, h uint64) (uint64, uint64) {
+	i1 := h & (l.numBuckets - 1)
+	return i1, l.altIndex(i1, fp)
+}
+
+func (f *CuckooFilter) bucket(l *cuckooLayer, index uint64) []byte {
+	return l.data[index*f.bucketSize : (index+1)*f.bucketSize]
+}
+
+// insertToBucket puts fp into an empty slot of bucket
+func insertToBucket(bucket []byte, fp byte) bool {
+	for i, v := range bucket {
+		if v == 0 {
+			bucket[i] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func countInBucket(bucket []byte, fp byte) uint64 {
+	var n uint64
+	for _, v := range bucket {
+		if v == fp {
+			n++
+		}
+	}
+	return n
+}
+
+// kickInsert relocates fingerprints to make room for fp in the given layer,
+// relocations are undone if no room found after maxIterations
+func (f *CuckooFilter) kickInsert(l *cuckooLayer, fp byte, index uint64) bool {
+	type swap struct {
+		index, slot uint64
+	}
+	path := make([]swap, 0, f.maxIterations)
+	homeless := fp
+	for n := uint64(0); n < f.maxIterations; n++ {
+		// victim is chosen deterministically so that replaying aof builds the same filter
+		slot := (uint64(homeless) + n) % f.bucketSize
+		bucket := f.bucket(l, index)
+		homeless, bucket[slot] = bucket[slot], homeless
+		path = append(path, swap{index: index, slot: slot})
+		index = l.altIndex(index, homeless)
+		if insertToBucket(f.bucket(l, index), homeless) {
+			return true
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		bucket := f.bucket(l, path[i].index)
+		homeless, bucket[path[i].slot] = bucket[path[i].slot], homeless
+	}
+	return false
+}
+
+// Add inserts item into filter, the same item can be added more than once
+func (f *CuckooFilter) Add(item string) error {
+	fp, h := fingerprint(item)
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		l := f.layers[i]
+		i1, i2 := l.indexes(fp, h)
+		if insertToBucket(f.bucket(l, i1), fp) || insertToBucket(f.bucket(l, i2), fp) {
+			f.items++
+			return nil
+		}
+	}
+	last := f.layers[len(f.layers)-1]
+	i1, _ := last.indexes(fp, h)
+	if f.kickInsert(last, fp, i1) {
+		f.items++
+		return nil
+	}
+	if f.expansion == 0 {
+		return ErrCuckooFull
+	}
+	numBuckets := last.numBuckets * f.expansion
+	if numBuckets*f.bucketSize > maxLayerBytes || numBuckets < last.numBuckets {
+		return ErrTooLarge
+	}
+	l := &cuckooLayer{
+		numBuckets: numBuckets,
+		data:       make([]byte, numBuckets*f.bucketSize),
+	}
+	f.layers = append(f.layers, l)
+	i1, _ = l.indexes(fp, h)
+	insertToBucket(f.bucket(l, i1), fp)
+	f.items++
+	return nil
+}
+
+// Count returns the number of times the item may have been added
+func (f *CuckooFilter) Count(item string) uint64 {
+	fp, h := fingerprint(item)
+	var n uint64
+	for _, l := range f.layers {
+		i1, i2 := l.indexes(fp, h)
+		n += countInBucket(f.bucket(l, i1), fp)
+		if i2 != i1 {
+			n += countInBucket(f.bucket(l, i2), fp)
+		}
+	}
+	return n
+}
+
+// Exists returns false if item is definitely not in filter
+func (f *CuckooFilter) Exists(item string) bool {
+	fp, h := fingerprint(item)
+	for _, l := range f.layers {
+		i1, i2 := l.indexes(fp, h)
+		if countInBucket(f.bucket(l, i1), fp) > 0 || countInBucket(f.bucket(l, i2), fp) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes one occurrence of item, returns false if not found.
+// Deleting an item never added may remove another item sharing the fingerprint
+func (f *CuckooFilter) Delete(item string) bool {
+	fp, h := fingerprint(item)
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		l := f.layers[i]
+		i1, i2 := l.indexes(fp, h)
+		for _, index := range []uint64{i1, i2} {
+			bucket := f.bucket(l, index)
+			for slot, v := range bucket {
+				if v == fp {
+					bucket[slot] = 0
+					f.items--
+					f.deleted++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Len returns the number of items in filter
+func (f *CuckooFilter) Len() uint64 {
+	return f.items
+}
+
+// Deleted returns the number of items deleted
+func (f *CuckooFilter) Deleted() uint64 {
+	return f.deleted
+}
+
+// Size returns the number of bytes used by buckets
+func (f *CuckooFilter) Size() uint64 {
+	var size uint64
+	for _, l := range f.layers {
+		size += uint64(len(l.data))
+	}
+	return size
+}
+
+// Buckets returns the number of buckets of all layers
+func (f *CuckooFilter) Buckets() uint64 {
+	var n uint64
+	for _, l := range f.layers {
+		n += l.numBuckets
+	}
+	return n
+}
+
+// Layers returns the number of layers
+func (f *CuckooFilter) Layers() int {
+	return len(f.layers)
+}
+
+// BucketSize returns the number of fingerprints in a bucket
+func (f *CuckooFilter) BucketSize() uint64 {
+	return f.bucketSize
+}
+
+// MaxIterations returns the number of relocations before expanding
+func (f *CuckooFilter) MaxIterations() uint64 {
+	return f.maxIterations
+}
+
+// Expansion returns the growth factor of number of buckets
+func (f *CuckooFilter) Expansion() uint64 {
+	return f.expansion
+}
+
+// Marshal encodes filter into bytes, it can be restored by UnmarshalCuckoo
+// layout: uvarint(bucket size) uvarint(max iterations) uvarint(expansion) uvarint(items) uvarint(deleted)
+// uvarint(layer count) layers..., layer: uvarint(number of buckets) buckets
+func (f *CuckooFilter) Marshal() []byte {
+	buf := make([]byte, 0, f.Size()+uint64(6+len(f.layers))*8)
+	buf = appendUvarint(buf, f.bucketSize)
+	buf = appendUvarint(buf, f.maxIterations)
+	buf = appendUvarint(buf, f.expansion)
+	buf = appendUvarint(buf, f.items)
+	buf = appendUvarint(buf, f.deleted)
+	buf = appendUvarint(buf, uint64(len(f.layers)))
+	for _, l := range f.layers {
+		buf = appendUvarint(buf, l.numBuckets)
+		buf = append(buf, l.data...)
+	}
+	return buf
+}
+
+// UnmarshalCuckoo restores filter encoded by Marshal
+func UnmarshalCuckoo(data []byte) (*CuckooFilter, error) {
+	d := &decoder{data: data}
+	f := &CuckooFilter{
+		bucketSize:    d.uvarint(),
+		maxIterations: d.uvarint(),
+		expansion:     d.uvarint(),
+		items:         d.uvarint(),
+		deleted:       d.uvarint(),
+	}
+	n := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if f.bucketSize == 0 || f.bucketSize > 255 || f.maxIterations == 0 || n == 0 || n > uint64(len(d.data)) {
+		return nil, ErrCorrupted
+	}
+	f.layers = make([]*cuckooLayer, 0, n)
+	for i := uint64(0); i < n; i++ {
+		l := &cuckooLayer{
+			numBuckets: d.uvarint(),
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		if l.numBuckets == 0 || l.numBuckets&(l.numBuckets-1) != 0 || l.numBuckets*f.bucketSize > maxLayerBytes {
+			return nil, ErrCorrupted
+		}
+		l.data = append([]byte(nil), d.bytes(l.numBuckets*f.bucketSize)...)
+		f.layers = append(f.layers, l)
+	}
+	if d.err != nil || len(d.data) > 0 {
+		return nil, ErrCorrupted
+	}
+	return f, nil
+}
@@ -0,0 +1,114 @@
+package bloom
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	f, err := NewCuckoo(1000, DefaultBucketSize, DefaultMaxIterations, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 3000
+	for i := 0; i < size; i++ {
+		if err := f.Add(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.Len() != uint64(size) || f.Layers() < 2 {
+		t.Errorf("expected %d items in more than one layer, actually %d in %d", size, f.Len(), f.Layers())
+	}
+	for i := 0; i < size; i++ {
+		if !f.Exists(strconv.Itoa(i)) {
+			t.Errorf("expected %d exists", i)
+		}
+	}
+	for i := 0; i < size; i += 2 {
+		if !f.Delete(strconv.Itoa(i)) {
+			t.Errorf("expected %d deleted", i)
+		}
+	}
+	if f.Len() != uint64(size/2) || f.Deleted() != uint64(size/2) {
+		t.Errorf("unexpected counters %d %d", f.Len(), f.Deleted())
+	}
+	for i := 1; i < size; i += 2 {
+		if !f.Exists(strconv.Itoa(i)) {
+			t.Errorf("expected %d exists after deleting others", i)
+		}
+	}
+	falsePositive := 0
+	for i := size; i < size*2; i++ {
+		if f.Exists(strconv.Itoa(i)) {
+			falsePositive++
+		}
+	}
+	if falsePositive > size/10 {
+		t.Errorf("too many false positives: %d", falsePositive)
+	}
+}
+
+func TestCuckooCount(t *testing.T) {
+	f, _ := NewCuckoo(100, 4, DefaultMaxIterations, 1)
+	for i := 0; i < 3; i++ {
+		_ = f.Add("a")
+	}
+	if f.Count("a") != 3 {
+		t.Errorf("expected count 3, actually %d", f.Count("a"))
+	}
+	f.Delete("a")
+	if f.Count("a") != 2 {
+		t.Errorf("expected count 2, actually %d", f.Count("a"))
+	}
+	if f.Delete("b") {
+		t.Error("expected b not found")
+	}
+}
+
+func TestCuckooFull(t *testing.T) {
+	f, _ := NewCuckoo(8, DefaultBucketSize, DefaultMaxIterations, 0)
+	var err error
+	n := 0
+	for ; n < 100 && err == nil; n++ {
+		err = f.Add(strconv.Itoa(n))
+	}
+	if err != ErrCuckooFull {
+		t.Fatalf("expected ErrCuckooFull, actually %v", err)
+	}
+	// items kicked out during the failed insertion are restored
+	for i := 0; i < n-1; i++ {
+		if !f.Exists(strconv.Itoa(i)) {
+			t.Errorf("expected %d exists", i)
+		}
+	}
+	if f.Len() != uint64(n-1) {
+		t.Errorf("expected %d items, actually %d", n-1, f.Len())
+	}
+}
+
+func TestCuckooMarshal(t *testing.T) {
+	f, _ := NewCuckoo(16, DefaultBucketSize, DefaultMaxIterations, 2)
+	for i := 0; i < 100; i++ {
+		_ = f.Add(strconv.Itoa(i))
+	}
+	f.Delete("0")
+	data := f.Marshal()
+	f2, err := UnmarshalCuckoo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f2.Marshal(), data) {
+		t.Error("restored filter is different")
+	}
+	for i := 1; i < 100; i++ {
+		if !f2.Exists(strconv.Itoa(i)) {
+			t.Errorf("expected %d exists", i)
+		}
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := UnmarshalCuckoo(data[:i]); err == nil {
+			t.Errorf("expected error for truncated data of length %d", i)
+		}
+	}
+}
@@ -245,6 +245,10 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 
 	result := make([]string, limit)
 	for i := 0; i < limit; {
+		if dict.Len() == 0 {
+			// keys may be removed by other goroutines during sampling
+			return result[:i]
+		}
 		shard := dict.getShard(uint32(rand.Intn(shardCount)))
 		if shard == nil {
 			continue
@@ -267,7 +271,8 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 
 	shardCount := len(dict.table)
 	result := make(map[string]bool)
-	for len(result) < limit {
+	// keys may be removed by other goroutines during sampling
+	for len(result) < limit && len(result) < dict.Len() {
 		shardIndex := uint32(rand.Intn(shardCount))
 		shard := dict.getShard(shardIndex)
 		if shard == nil {
@@ -278,7 +283,7 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 			result[key] = true
 		}
 	}
-	arr := make([]string, limit)
+	arr := make([]string, len(result))
 	i := 0
 	for k := range result {
 		arr[i] = k
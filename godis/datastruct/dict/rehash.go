@@ -0,0 +1,582 @@
+package dict
+
+import (
+	"math/bits"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// initial size of hash table
+	rehashInitSize = 4
+	// shrink if less than 1/rehashMinFill of buckets used
+	rehashMinFill = 10
+	// a rehash step gives up after visiting this times of empty buckets
+	rehashEmptyVisits = 10
+)
+
+// RehashDict is thread safe map using sharding lock. Every shard is a redis style hash table,
+// it grows and shrinks by moving buckets into a new table step by step during writes, so there is no long pause.
+// ForEach allows the consumer to modify the dict, and Scan is a stateless iteration for SCAN command
+type RehashDict struct {
+	shards    []*rehashShard
+	shardBits uint
+	count     int32
+}
+
+type rehashEntry struct {
+	key  string
+	val  interface{}
+	next *rehashEntry
+}
+
+type hashTable struct {
+	buckets []*rehashEntry
+	used    int
+}
+
+func (table *hashTable) mask() uint64 {
+	return uint64(len(table.buckets) - 1)
+}
+
+type rehashShard struct {
+	mu sync.RWMutex
+	// tables[1] is used only during rehashing, entries are moved from tables[0] to tables[1]
+	tables [2]*hashTable
+	// rehashIdx is the next bucket of tables[0] to move, -1 if not rehashing
+	rehashIdx int
+	// number of running ForEach, rehash is paused so that entries do not move between tables
+	iterators int
+	keyBytes  int
+}
+
+// ShardStats describes memory usage of a shard
+type ShardStats struct {
+	Len       int
+	Buckets   int
+	Rehashing bool
+	// MaxChain is the length of the longest bucket
+	MaxChain int
+	// MemoryBytes is an estimate of memory used by buckets, entries and keys, values are not included
+	MemoryBytes int64
+}
+
+// ScanFunc receives entries found by Scan
+type ScanFunc func(key string, val interface{})
+
+// MakeRehash creates RehashDict with the given shard count
+func MakeRehash(shardCount int) *RehashDict {
+	shardCount = computeCapacity(shardCount)
+	d := &RehashDict{
+		shards:    make([]*rehashShard, shardCount),
+		shardBits: uint(bits.TrailingZeros(uint(shardCount))),
+	}
+	for i := range d.shards {
+		d.shards[i] = makeRehashShard()
+	}
+	return d
+}
+
+func makeRehashShard() *rehashShard {
+	return &rehashShard{
+		tables:    [2]*hashTable{makeHashTable(rehashInitSize)},
+		rehashIdx: -1,
+	}
+}
+
+func makeHashTable(size int) *hashTable {
+	return &hashTable{
+		buckets: make([]*rehashEntry, size),
+	}
+}
+
+const prime64 = uint64(1099511628211)
+
+func fnv64(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= prime64
+	}
+	return hash
+}
+
+// locate returns shard of key and hash code used in shard, shards use high bits and buckets use low bits
+func (dict *RehashDict) locate(key string) (*rehashShard, uint64) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	hashCode := fnv64(key)
+	if dict.shardBits == 0 {
+		return dict.shards[0], hashCode
+	}
+	return dict.shards[hashCode>>(64-dict.shardBits)], hashCode
+}
+
+func (shard *rehashShard) rehashing() bool {
+	return shard.rehashIdx >= 0
+}
+
+// find returns the entry of key and the pointer to it, shard.mu must be held
+func (shard *rehashShard) find(key string, hashCode uint64) (*rehashEntry, **rehashEntry, *hashTable) {
+	for i, table := range shard.tables {
+		if table == nil || (i == 1 && !shard.rehashing()) {
+			break
+		}
+		ptr := &table.buckets[hashCode&table.mask()]
+		for *ptr != nil {
+			if (*ptr).key == key {
+				return *ptr, ptr, table
+			}
+			ptr = &(*ptr).next
+		}
+	}
+	return nil, nil, nil
+}
+
+// rehashStep moves at most n buckets into the new table, shard.mu must be held
+func (shard *rehashShard) rehashStep(n int) {
+	if !shard.rehashing() || shard.iterators > 0 {
+		return
+	}
+	from, to := shard.tables[0], shard.tables[1]
+	emptyVisits := n * rehashEmptyVisits
+	for ; n > 0 && from.used > 0; n-- {
+		for from.buckets[shard.rehashIdx] == nil {
+			shard.rehashIdx++
+			emptyVisits--
+			if emptyVisits == 0 {
+				return
+			}
+		}
+		entry := from.buckets[shard.rehashIdx]
+		for entry != nil {
+			next := entry.next
+			index := fnv64(entry.key) & to.mask()
+			entry.next = to.buckets[index]
+			to.buckets[index] = entry
+			from.used--
+			to.used++
+			entry = next
+		}
+		from.buckets[shard.rehashIdx] = nil
+		shard.rehashIdx++
+	}
+	if from.used == 0 {
+		shard.tables[0] = to
+		shard.tables[1] = nil
+		shard.rehashIdx = -1
+	}
+}
+
+// resize starts rehashing to a table fitting size entries
+func (shard *rehashShard) resize(size int) {
+	newSize := rehashInitSize
+	for newSize < size {
+		newSize <<= 1
+	}
+	if newSize == len(shard.tables[0].buckets) {
+		return
+	}
+	shard.tables[1] = makeHashTable(newSize)
+	shard.rehashIdx = 0
+}
+
+// resizeIfNeeded grows the table if load factor reaches 1, shrinks it if load factor is less than 1/rehashMinFill
+func (shard *rehashShard) resizeIfNeeded() {
+	if shard.rehashing() {
+		return
+	}
+	table := shard.tables[0]
+	size := len(table.buckets)
+	if table.used >= size {
+		shard.resize(table.used * 2)
+	} else if size > rehashInitSize && table.used*rehashMinFill < size {
+		shard.resize(table.used)
+	}
+}
+
+// put inserts or updates entry, returns whether key is new. shard.mu must be held
+func (shard *rehashShard) put(key string, hashCode uint64, val interface{}, update, insert bool) (inserted bool, updated bool) {
+	shard.rehashStep(1)
+	if entry, _, _ := shard.find(key, hashCode); entry != nil {
+		if update {
+			entry.val = val
+		}
+		return false, update
+	}
+	if !insert {
+		return false, false
+	}
+	shard.resizeIfNeeded()
+	table := shard.tables[0]
+	if shard.rehashing() {
+		table = shard.tables[1]
+	}
+	index := hashCode & table.mask()
+	table.buckets[index] = &rehashEntry{
+		key:  key,
+		val:  val,
+		next: table.buckets[index],
+	}
+	table.used++
+	shard.keyBytes += len(key)
+	return true, false
+}
+
+// Get returns the binding value and whether the key is exist
+func (dict *RehashDict) Get(key string) (val interface{}, exists bool) {
+	shard, hashCode := dict.locate(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	entry, _, _ := shard.find(key, hashCode)
+	if entry == nil {
+		return nil, false
+	}
+	return entry.val, true
+}
+
+// Len returns the number of dict
+func (dict *RehashDict) Len() int {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	return int(atomic.LoadInt32(&dict.count))
+}
+
+// Put puts key value into dict and returns the number of new inserted key-value
+func (dict *RehashDict) Put(key string, val interface{}) (result int) {
+	shard, hashCode := dict.locate(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if inserted, _ := shard.put(key, hashCode, val, true, true); inserted {
+		atomic.AddInt32(&dict.count, 1)
+		return 1
+	}
+	return 0
+}
+
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
+func (dict *RehashDict) PutIfAbsent(key string, val interface{}) (result int) {
+	shard, hashCode := dict.locate(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if inserted, _ := shard.put(key, hashCode, val, false, true); inserted {
+		atomic.AddInt32(&dict.count, 1)
+		return 1
+	}
+	return 0
+}
+
+// PutIfExists puts value if the key is exist and returns the number of inserted key-value
+func (dict *RehashDict) PutIfExists(key string, val interface{}) (result int) {
+	shard, hashCode := dict.locate(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if _, updated := shard.put(key, hashCode, val, true, false); updated {
+		return 1
+	}
+	return 0
+}
+
+// Remove removes the key and return the number of deleted key-value
+func (dict *RehashDict) Remove(key string) (result int) {
+	shard, hashCode := dict.locate(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.rehashStep(1)
+	entry, ptr, table := shard.find(key, hashCode)
+	if entry == nil {
+		return 0
+	}
+	*ptr = entry.next
+	table.used--
+	shard.keyBytes -= len(key)
+	atomic.AddInt32(&dict.count, -1)
+	shard.resizeIfNeeded()
+	return 1
+}
+
+// Rehash resizes shards if needed and moves at most n buckets of every rehashing shard.
+// Like databasesCron of redis, it should be called periodically so that idle dict keeps proper size
+func (dict *RehashDict) Rehash(n int) {
+	for _, shard := range dict.shards {
+		shard.mu.Lock()
+		shard.resizeIfNeeded()
+		shard.rehashStep(n)
+		shard.mu.Unlock()
+	}
+}
+
+// bucketEntries copies entries of a bucket, returns false if index is out of table
+func (shard *rehashShard) bucketEntries(tableIndex int, index int, buf []rehashEntry) ([]rehashEntry, bool) {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	table := shard.tables[tableIndex]
+	if table == nil || (tableIndex == 1 && !shard.rehashing()) || index >= len(table.buckets) {
+		return buf, false
+	}
+	for entry := table.buckets[index]; entry != nil; entry = entry.next {
+		buf = append(buf, rehashEntry{key: entry.key, val: entry.val})
+	}
+	return buf, true
+}
+
+// ForEach traversal the dict, consumer is called without lock so it is able to modify dict.
+// Every entry existing during the whole traversal will be visited exactly once, new entries may not be visited
+func (dict *RehashDict) ForEach(consumer Consumer) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	var buf []rehashEntry
+	for _, shard := range dict.shards {
+		shard.mu.Lock()
+		shard.iterators++
+		shard.mu.Unlock()
+		stopped := false
+		for tableIndex := 0; tableIndex < 2 && !stopped; tableIndex++ {
+			for index := 0; !stopped; index++ {
+				var ok bool
+				buf, ok = shard.bucketEntries(tableIndex, index, buf[:0])
+				if !ok {
+					break
+				}
+				for _, entry := range buf {
+					if !consumer(entry.key, entry.val) {
+						stopped = true
+						break
+					}
+				}
+			}
+		}
+		shard.mu.Lock()
+		shard.iterators--
+		shard.mu.Unlock()
+		if stopped {
+			return
+		}
+	}
+}
+
+// Keys returns all keys in dict
+func (dict *RehashDict) Keys() []string {
+	keys := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// scan visits buckets of cursor like dictScan of redis, returns the next cursor, 0 means finished.
+// Buckets of both tables are visited during rehashing so that moved entries are not missed
+func (shard *rehashShard) scan(cursor uint64, fn ScanFunc) uint64 {
+	shard.mu.RLock()
+	// entries are copied, since values may be modified after lock released
+	var found []rehashEntry
+	collect := func(entry *rehashEntry) {
+		for ; entry != nil; entry = entry.next {
+			found = append(found, rehashEntry{key: entry.key, val: entry.val})
+		}
+	}
+	small := shard.tables[0]
+	if !shard.rehashing() {
+		mask := small.mask()
+		collect(small.buckets[cursor&mask])
+		cursor = nextCursor(cursor, mask)
+	} else {
+		large := shard.tables[1]
+		if len(small.buckets) > len(large.buckets) {
+			small, large = large, small
+		}
+		smallMask, largeMask := small.mask(), large.mask()
+		collect(small.buckets[cursor&smallMask])
+		// visit buckets of the larger table which are expansions of the bucket in the smaller table
+		for {
+			collect(large.buckets[cursor&largeMask])
+			cursor = nextCursor(cursor, largeMask)
+			if cursor&(smallMask^largeMask) == 0 {
+				break
+			}
+		}
+	}
+	shard.mu.RUnlock()
+	for _, entry := range found {
+		fn(entry.key, entry.val)
+	}
+	return cursor
+}
+
+// nextCursor increases the reversed bits of cursor, so that buckets visited are not visited again after resize
+func nextCursor(cursor uint64, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// Scan visits entries from cursor until at least count entries found or all entries visited, returns the next cursor.
+// Start with cursor 0, and the iteration finishes when 0 is returned.
+// Like SCAN of redis, every entry existing during the whole iteration will be visited, but may be visited more than once
+func (dict *RehashDict) Scan(cursor uint64, count int, fn ScanFunc) uint64 {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	// low bits of cursor is the shard index, the rest is cursor in shard
+	shardMask := uint64(len(dict.shards) - 1)
+	shardIndex := cursor & shardMask
+	shardCursor := cursor >> dict.shardBits
+	found := 0
+	counter := func(key string, val interface{}) {
+		found++
+		fn(key, val)
+	}
+	for {
+		shardCursor = dict.shards[shardIndex].scan(shardCursor, counter)
+		if shardCursor == 0 {
+			shardIndex++
+			if shardIndex == uint64(len(dict.shards)) {
+				return 0
+			}
+		}
+		if found >= count {
+			return shardCursor<<dict.shardBits | shardIndex
+		}
+	}
+}
+
+// randomKey returns a key randomly, or "" if shard is empty
+func (shard *rehashShard) randomKey() string {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	from, to := shard.tables[0], shard.tables[1]
+	if !shard.rehashing() {
+		to = nil
+	}
+	total := from.used
+	if to != nil {
+		total += to.used
+	}
+	if total == 0 {
+		return ""
+	}
+	var entry *rehashEntry
+	for entry == nil {
+		if to == nil {
+			entry = from.buckets[rand.Intn(len(from.buckets))]
+			continue
+		}
+		// buckets of tables[0] before rehashIdx are empty
+		index := shard.rehashIdx + rand.Intn(len(from.buckets)+len(to.buckets)-shard.rehashIdx)
+		if index < len(from.buckets) {
+			entry = from.buckets[index]
+		} else {
+			entry = to.buckets[index-len(from.buckets)]
+		}
+	}
+	length := 0
+	for e := entry; e != nil; e = e.next {
+		length++
+	}
+	for i := rand.Intn(length); i > 0; i-- {
+		entry = entry.next
+	}
+	return entry.key
+}
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+func (dict *RehashDict) RandomKeys(limit int) []string {
+	size := dict.Len()
+	if limit >= size {
+		return dict.Keys()
+	}
+	result := make([]string, limit)
+	for i := 0; i < limit; {
+		if dict.Len() == 0 {
+			// keys may be removed by other goroutines during sampling
+			return result[:i]
+		}
+		key := dict.shards[rand.Intn(len(dict.shards))].randomKey()
+		if key != "" {
+			result[i] = key
+			i++
+		}
+	}
+	return result
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (dict *RehashDict) RandomDistinctKeys(limit int) []string {
+	size := dict.Len()
+	if limit >= size {
+		return dict.Keys()
+	}
+	result := make(map[string]bool)
+	// keys may be removed by other goroutines during sampling
+	for len(result) < limit && len(result) < dict.Len() {
+		key := dict.shards[rand.Intn(len(dict.shards))].randomKey()
+		if key != "" {
+			result[key] = true
+		}
+	}
+	arr := make([]string, 0, len(result))
+	for k := range result {
+		arr = append(arr, k)
+	}
+	return arr
+}
+
+// Clear removes all keys in dict
+func (dict *RehashDict) Clear() {
+	for _, shard := range dict.shards {
+		shard.mu.Lock()
+		removed := shard.tables[0].used
+		if shard.rehashing() {
+			removed += shard.tables[1].used
+		}
+		fresh := makeRehashShard()
+		shard.tables = fresh.tables
+		shard.rehashIdx = fresh.rehashIdx
+		shard.keyBytes = 0
+		atomic.AddInt32(&dict.count, -int32(removed))
+		shard.mu.Unlock()
+	}
+}
+
+// size of a pointer and of rehashEntry, used to estimate memory
+const (
+	pointerSize = 8
+	entrySize   = 16 + 16 + pointerSize // key string header, val interface and next pointer
+)
+
+// ShardStats returns memory usage of every shard
+func (dict *RehashDict) ShardStats() []ShardStats {
+	result := make([]ShardStats, len(dict.shards))
+	for i, shard := range dict.shards {
+		shard.mu.RLock()
+		stats := ShardStats{
+			Rehashing: shard.rehashing(),
+		}
+		for j, table := range shard.tables {
+			if table == nil || (j == 1 && !shard.rehashing()) {
+				break
+			}
+			stats.Len += table.used
+			stats.Buckets += len(table.buckets)
+			for _, entry := range table.buckets {
+				chain := 0
+				for ; entry != nil; entry = entry.next {
+					chain++
+				}
+				if chain > stats.MaxChain {
+					stats.MaxChain = chain
+				}
+			}
+		}
+		stats.MemoryBytes = int64(stats.Buckets*pointerSize + stats.Len*entrySize + shard.keyBytes)
+		shard.mu.RUnlock()
+		result[i] = stats
+	}
+	return result
+}
@@ -0,0 +1,284 @@
+package dict
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRehashDict(t *testing.T) {
+	d := MakeRehash(1)
+	size := 10000
+	for i := 0; i < size; i++ {
+		key := "k" + strconv.Itoa(i)
+		if ret := d.Put(key, i); ret != 1 {
+			t.Errorf("expected 1 inserted, actually %d", ret)
+		}
+		if ret := d.PutIfAbsent(key, -1); ret != 0 {
+			t.Errorf("expected 0 inserted, actually %d", ret)
+		}
+	}
+	if d.Len() != size {
+		t.Errorf("expected %d keys, actually %d", size, d.Len())
+	}
+	for i := 0; i < size; i++ {
+		key := "k" + strconv.Itoa(i)
+		if val, ok := d.Get(key); !ok || val.(int) != i {
+			t.Errorf("expected %d, actually %v", i, val)
+		}
+	}
+	if ret := d.PutIfExists("k1", 100); ret != 1 {
+		t.Error("expected 1 updated")
+	}
+	if ret := d.PutIfExists("none", 100); ret != 0 {
+		t.Error("expected 0 updated")
+	}
+	d.Rehash(size)
+	buckets := 0
+	for _, stats := range d.ShardStats() {
+		buckets += stats.Buckets
+		if stats.Rehashing {
+			t.Error("expected rehash finished")
+		}
+	}
+	if buckets < size {
+		t.Errorf("expected grow to at least %d buckets, actually %d", size, buckets)
+	}
+
+	for i := 0; i < size-10; i++ {
+		if ret := d.Remove("k" + strconv.Itoa(i)); ret != 1 {
+			t.Errorf("expected 1 removed, actually %d", ret)
+		}
+	}
+	if ret := d.Remove("k0"); ret != 0 {
+		t.Error("expected 0 removed")
+	}
+	// shrinking may start before all keys removed
+	d.Rehash(size)
+	d.Rehash(size)
+	buckets = 0
+	for _, stats := range d.ShardStats() {
+		buckets += stats.Buckets
+	}
+	if d.Len() != 10 || buckets > 16*rehashInitSize*4 {
+		t.Errorf("expected shrink, actually %d keys in %d buckets", d.Len(), buckets)
+	}
+	for i := size - 10; i < size; i++ {
+		if _, ok := d.Get("k" + strconv.Itoa(i)); !ok {
+			t.Errorf("expected k%d exists", i)
+		}
+	}
+	d.Clear()
+	if d.Len() != 0 || len(d.Keys()) != 0 {
+		t.Error("expected empty dict")
+	}
+}
+
+func TestRehashDictScan(t *testing.T) {
+	d := MakeRehash(4)
+	for i := 0; i < 1000; i++ {
+		d.Put("stable"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	round := 0
+	for {
+		cursor = d.Scan(cursor, 10, func(key string, val interface{}) {
+			seen[key]++
+		})
+		// grow and shrink during scanning
+		if round < 200 {
+			for i := 0; i < 50; i++ {
+				d.Put("tmp"+strconv.Itoa(round*50+i), i)
+			}
+		} else {
+			for i := 0; i < 50; i++ {
+				d.Remove("tmp" + strconv.Itoa((round-200)*50+i))
+			}
+		}
+		round++
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if seen["stable"+strconv.Itoa(i)] == 0 {
+			t.Errorf("stable%d is not visited", i)
+		}
+	}
+}
+
+func TestRehashDictForEach(t *testing.T) {
+	d := MakeRehash(2)
+	size := 1000
+	for i := 0; i < size; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	// modify dict during traversal
+	visited := make(map[string]int)
+	i := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		visited[key]++
+		d.Remove(key)
+		d.Put("new"+strconv.Itoa(i), i)
+		i++
+		return true
+	})
+	for i := 0; i < size; i++ {
+		if count := visited["k"+strconv.Itoa(i)]; count != 1 {
+			t.Errorf("expected k%d visited once, actually %d", i, count)
+		}
+	}
+	for key, count := range visited {
+		if count != 1 {
+			t.Errorf("expected %s visited once, actually %d", key, count)
+		}
+	}
+	if d.Len() != size {
+		t.Errorf("expected %d keys, actually %d", size, d.Len())
+	}
+	count := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Errorf("expected traversal stopped at 10, actually %d", count)
+	}
+}
+
+func TestRehashDictRandomKeys(t *testing.T) {
+	d := MakeRehash(0)
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	if keys := d.RandomKeys(10); len(keys) != 10 {
+		t.Errorf("expected 10 keys, actually %d", len(keys))
+	}
+	keys := d.RandomDistinctKeys(50)
+	distinct := make(map[string]struct{})
+	for _, key := range keys {
+		distinct[key] = struct{}{}
+	}
+	if len(keys) != 50 || len(distinct) != 50 {
+		t.Errorf("expected 50 distinct keys, actually %d", len(distinct))
+	}
+}
+
+func TestRehashDictConcurrent(t *testing.T) {
+	d := MakeRehash(0)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := strconv.Itoa(g) + ":" + strconv.Itoa(i)
+				d.Put(key, i)
+				if val, ok := d.Get(key); !ok || val.(int) != i {
+					t.Errorf("expected %d, actually %v", i, val)
+				}
+				if i%2 == 0 {
+					d.Remove(key)
+				}
+			}
+			d.Scan(0, 100, func(key string, val interface{}) {})
+		}(g)
+	}
+	wg.Wait()
+	if d.Len() != 8*1000 {
+		t.Errorf("expected %d keys, actually %d", 8*1000, d.Len())
+	}
+}
+
+const benchKeyspace = 1000000
+
+var benchKeys = func() []string {
+	keys := make([]string, benchKeyspace)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func fill(d Dict) {
+	for _, key := range benchKeys {
+		d.Put(key, key)
+	}
+}
+
+func benchmarkPut(b *testing.B, makeDict func() Dict) {
+	d := makeDict()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchKeyspace == 0 {
+			d = makeDict()
+		}
+		d.Put(benchKeys[i%benchKeyspace], i)
+	}
+}
+
+func benchmarkGet(b *testing.B, d Dict) {
+	fill(d)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			d.Get(benchKeys[i%benchKeyspace])
+			i += 7
+		}
+	})
+}
+
+func benchmarkRemove(b *testing.B, d Dict) {
+	fill(d)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := benchKeys[i%benchKeyspace]
+		if d.Remove(key) == 0 {
+			d.Put(key, i)
+		}
+	}
+}
+
+func benchmarkForEach(b *testing.B, d Dict) {
+	fill(d)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.ForEach(func(key string, val interface{}) bool {
+			return true
+		})
+	}
+}
+
+func BenchmarkRehashDictPut(b *testing.B) {
+	benchmarkPut(b, func() Dict { return MakeRehash(1024) })
+}
+
+func BenchmarkConcurrentDictPut(b *testing.B) {
+	benchmarkPut(b, func() Dict { return MakeConcurrent(1024) })
+}
+
+func BenchmarkRehashDictGet(b *testing.B) {
+	benchmarkGet(b, MakeRehash(1024))
+}
+
+func BenchmarkConcurrentDictGet(b *testing.B) {
+	benchmarkGet(b, MakeConcurrent(1024))
+}
+
+func BenchmarkRehashDictRemove(b *testing.B) {
+	benchmarkRemove(b, MakeRehash(1024))
+}
+
+func BenchmarkConcurrentDictRemove(b *testing.B) {
+	benchmarkRemove(b, MakeConcurrent(1024))
+}
+
+func BenchmarkRehashDictForEach(b *testing.B) {
+	benchmarkForEach(b, MakeRehash(1024))
+}
+
+func BenchmarkConcurrentDictForEach(b *testing.B) {
+	benchmarkForEach(b, MakeConcurrent(1024))
+}
@@ -0,0 +1,108 @@
+// Package fencedlock implements a lease based lock with fencing tokens.
+// Every successful acquisition gets a token greater than all previous ones of the same lock,
+// so resources protected by the lock can reject writes from owners whose lease has expired.
+package fencedlock
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorrupted is returned when restoring a lock from broken data
+var ErrCorrupted = errors.New("corrupted lock data")
+
+// Lock is a lease owned by a token until deadline, times are unix milliseconds given by caller
+type Lock struct {
+	owner    string
+	deadline int64
+	fence    int64
+}
+
+// Make creates a free lock
+func Make() *Lock {
+	return &Lock{}
+}
+
+// Held returns whether the lease has not expired at now
+func (l *Lock) Held(now int64) bool {
+	return l.owner != "" && l.deadline > now
+}
+
+// Owner returns token holding the lock at now, or empty string if the lock is free
+func (l *Lock) Owner(now int64) string {
+	if !l.Held(now) {
+		return ""
+	}
+	return l.owner
+}
+
+// Deadline returns when the lease expires
+func (l *Lock) Deadline() int64 {
+	return l.deadline
+}
+
+// Fence returns fencing token of the latest acquisition, 0 if the lock has never been acquired
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Acquire takes the lock for token until now+ttl and returns the fencing token.
+// It extends the lease if token already holds the lock, without issuing a new fencing token.
+// It returns false if the lock is held by another token
+func (l *Lock) Acquire(token string, now, ttl int64) (int64, bool) {
+	if l.Held(now) {
+		if l.owner != token {
+			return 0, false
+		}
+		l.deadline = now + ttl
+		return l.fence, true
+	}
+	l.owner = token
+	l.deadline = now + ttl
+	l.fence++
+	return l.fence, true
+}
+
+// Renew extends lease of token to now+ttl, it returns false if token does not hold the lock
+func (l *Lock) Renew(token string, now, ttl int64) bool {
+	if !l.Held(now) || l.owner != token {
+		return false
+	}
+	l.deadline = now + ttl
+	return true
+}
+
+// Release frees the lock if token holds it. The fencing token is kept so that it keeps increasing
+func (l *Lock) Release(token string, now int64) bool {
+	if !l.Held(now) || l.owner != token {
+		return false
+	}
+	l.owner = ""
+	l.deadline = 0
+	return true
+}
+
+// Marshal serializes the lock: uvarint(fence) varint(deadline) owner
+func (l *Lock) Marshal() []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64, 2*binary.MaxVarintLen64+len(l.owner))
+	n := binary.PutUvarint(buf, uint64(l.fence))
+	n += binary.PutVarint(buf[n:], l.deadline)
+	return append(buf[:n], l.owner...)
+}
+
+// Unmarshal restores lock serialized by Marshal
+func Unmarshal(data []byte) (*Lock, error) {
+	fence, n := binary.Uvarint(data)
+	if n <= 0 || int64(fence) < 0 {
+		return nil, ErrCorrupted
+	}
+	deadline, m := binary.Varint(data[n:])
+	if m <= 0 {
+		return nil, ErrCorrupted
+	}
+	return &Lock{
+		owner:    string(data[n+m:]),
+		deadline: deadline,
+		fence:    int64(fence),
+	}, nil
+}
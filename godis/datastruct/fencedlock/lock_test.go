@@ -0,0 +1,47 @@
+package fencedlock
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	l := Make()
+	if fence, ok := l.Acquire("a", 1000, 100); !ok || fence != 1 {
+		t.Errorf("acquire free lock: %d %v", fence, ok)
+	}
+	if _, ok := l.Acquire("b", 1050, 100); ok {
+		t.Error("lock held by a is acquired by b")
+	}
+	// retry of owner keeps fencing token
+	if fence, ok := l.Acquire("a", 1050, 100); !ok || fence != 1 || l.Deadline() != 1150 {
+		t.Errorf("reacquire: %d %v %d", fence, ok, l.Deadline())
+	}
+	if l.Renew("b", 1100, 100) || !l.Renew("a", 1100, 100) || l.Deadline() != 1200 {
+		t.Error("renew failed")
+	}
+	// lease of a expired
+	if l.Owner(1200) != "" || l.Renew("a", 1200, 100) || l.Release("a", 1200) {
+		t.Error("expired lease is still held")
+	}
+	if fence, ok := l.Acquire("b", 1200, 100); !ok || fence != 2 {
+		t.Errorf("acquire expired lock: %d %v", fence, ok)
+	}
+	if l.Release("a", 1250) || !l.Release("b", 1250) || l.Held(1250) {
+		t.Error("release failed")
+	}
+	if fence, ok := l.Acquire("a", 1300, 100); !ok || fence != 3 {
+		t.Errorf("acquire released lock: %d %v", fence, ok)
+	}
+
+	restored, err := Unmarshal(l.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l, restored) {
+		t.Errorf("expect %+v, actual %+v", l, restored)
+	}
+	if _, err := Unmarshal([]byte{0x80}); err != ErrCorrupted {
+		t.Error("expect error for broken data")
+	}
+}
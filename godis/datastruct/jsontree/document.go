@@ -0,0 +1,114 @@
+package jsontree
+
+import "sort"
+
+// Document is a JSON value stored in a key. It is not concurrent safe
+type Document struct {
+	Root interface{}
+}
+
+// Find returns values matched by path in document order
+func (doc *Document) Find(path *Path) []*Node {
+	return path.eval(doc.Root)
+}
+
+// Replace sets value at the position of node
+func (doc *Document) Replace(node *Node, val interface{}) {
+	switch parent := node.parent.(type) {
+	case *Object:
+		parent.Put(node.key, val)
+	case *Array:
+		parent.Items[node.index] = val
+	default:
+		doc.Root = val
+	}
+	node.Value = val
+}
+
+// Set puts value at path, returns false if nothing set.
+// Values matched are replaced, or a new key is added to each matched object if the last step of path is a key.
+// With nx values are only added, with xx values are only replaced
+func (doc *Document) Set(path *Path, val interface{}, nx, xx bool) bool {
+	nodes := doc.Find(path)
+	if len(nodes) > 0 {
+		if nx {
+			return false
+		}
+		for i, node := range nodes {
+			if i > 0 {
+				val = Clone(val)
+			}
+			doc.Replace(node, val)
+		}
+		return true
+	}
+	if xx {
+		return false
+	}
+	parentPath, key, ok := path.parent()
+	if !ok {
+		return false
+	}
+	set := false
+	for _, node := range doc.Find(parentPath) {
+		obj, ok := node.Value.(*Object)
+		if !ok {
+			continue
+		}
+		if set {
+			val = Clone(val)
+		}
+		obj.Put(key, val)
+		set = true
+	}
+	return set
+}
+
+// Delete removes values matched by path except root, returns the number of values removed
+func (doc *Document) Delete(path *Path) int {
+	nodes := doc.Find(path)
+	// remove elements of array from back to front so that indexes of others are not changed
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].index > nodes[j].index
+	})
+	removed := 0
+	for _, node := range nodes {
+		switch parent := node.parent.(type) {
+		case *Object:
+			if parent.Remove(node.key) {
+				removed++
+			}
+		case *Array:
+			if node.index < len(parent.Items) && parent.Items[node.index] == node.Value {
+				parent.Items = append(parent.Items[:node.index], parent.Items[node.index+1:]...)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// Clone returns a deep copy of value
+func Clone(val interface{}) interface{} {
+	switch v := val.(type) {
+	case *Object:
+		obj := &Object{
+			keys:   make([]string, len(v.keys)),
+			fields: make(map[string]interface{}, len(v.fields)),
+		}
+		copy(obj.keys, v.keys)
+		for key, field := range v.fields {
+			obj.fields[key] = Clone(field)
+		}
+		return obj
+	case *Array:
+		arr := &Array{
+			Items: make([]interface{}, len(v.Items)),
+		}
+		for i, item := range v.Items {
+			arr.Items[i] = Clone(item)
+		}
+		return arr
+	}
+	return val
+}
@@ -0,0 +1,145 @@
+package jsontree
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testDoc = `{"store":{"book":[{"title":"a","price":8.95},{"title":"b","price":12,"isbn":"0-553"}],` +
+	`"bicycle":{"color":"red","price":19.95}},"empty":[],"name":"<godis>","ok":true,"none":null}`
+
+func mustParse(t *testing.T, text string) interface{} {
+	t.Helper()
+	val, err := Parse([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return val
+}
+
+func marshalNodes(nodes []*Node) string {
+	values := make([]string, len(nodes))
+	for i, node := range nodes {
+		values[i] = string(Marshal(node.Value))
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+func TestParse(t *testing.T) {
+	val := mustParse(t, testDoc)
+	// order of keys is kept and html characters are not escaped
+	if string(Marshal(val)) != testDoc {
+		t.Errorf("expected %s, actually %s", testDoc, Marshal(val))
+	}
+	for _, text := range []string{"", "{", `{"a":}`, "[1,]", "1 2", `{"a" 1}`} {
+		if _, err := Parse([]byte(text)); err == nil {
+			t.Errorf("expected error for %q", text)
+		}
+	}
+	types := map[string]string{
+		`{}`: "object", `[]`: "array", `"s"`: "string", `1`: "integer", `1.5`: "number", `false`: "boolean", `null`: "null",
+	}
+	for text, typ := range types {
+		if TypeOf(mustParse(t, text)) != typ {
+			t.Errorf("expected type of %s is %s", text, typ)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	doc := &Document{Root: mustParse(t, testDoc)}
+	cases := map[string]string{
+		"$":                           "[" + string(Marshal(doc.Root)) + "]",
+		"$.store.book[0].title":       `["a"]`,
+		"$['store']['bicycle'].color": `["red"]`,
+		"$.store.book[-1].price":      `[12]`,
+		"$.store.book[*].title":       `["a","b"]`,
+		"$.store.book[0:1].title":     `["a"]`,
+		"$..price":                    `[8.95,12,19.95]`,
+		"$.store.*.color":             `["red"]`,
+		"$..isbn":                     `["0-553"]`,
+		"$.nothing":                   `[]`,
+		"$.store.book[5]":             `[]`,
+		".store.bicycle.price":        `[19.95]`,
+		"store.book[1].title":         `["b"]`,
+	}
+	for text, expected := range cases {
+		path, err := ParsePath(text)
+		if err != nil {
+			t.Errorf("parse %s: %v", text, err)
+			continue
+		}
+		if actual := marshalNodes(doc.Find(path)); actual != expected {
+			t.Errorf("path %s: expected %s, actually %s", text, expected, actual)
+		}
+	}
+	for _, text := range []string{"$.", "$[", "$[?(@.a)]", "$['a'", "$a", "$.a[x]"} {
+		if _, err := ParsePath(text); err == nil {
+			t.Errorf("expected error for %s", text)
+		}
+	}
+	path, _ := ParsePath(".")
+	if !path.Legacy || !path.IsRoot() {
+		t.Error("expected legacy root path")
+	}
+}
+
+func TestSetAndDelete(t *testing.T) {
+	doc := &Document{Root: mustParse(t, testDoc)}
+	path, _ := ParsePath("$..price")
+	if !doc.Set(path, json.Number("1"), false, false) {
+		t.Error("expected set")
+	}
+	if actual := marshalNodes(doc.Find(path)); actual != "[1,1,1]" {
+		t.Errorf("unexpected prices %s", actual)
+	}
+	path, _ = ParsePath("$.store.book[*].year")
+	if doc.Set(path, json.Number("2000"), false, true) {
+		t.Error("xx should not add new key")
+	}
+	val := mustParse(t, `{"n":1}`)
+	if !doc.Set(path, val, true, false) {
+		t.Error("expected new keys added")
+	}
+	// added values are independent
+	doc.Find(path)[0].Value.(*Object).Put("n", json.Number("2"))
+	if actual := marshalNodes(doc.Find(path)); actual != `[{"n":2},{"n":1}]` {
+		t.Errorf("unexpected years %s", actual)
+	}
+	path, _ = ParsePath("$.a.b")
+	if doc.Set(path, json.Number("1"), false, false) {
+		t.Error("parent of new key should exist")
+	}
+
+	path, _ = ParsePath("$.store.book[*]")
+	if n := doc.Delete(path); n != 2 {
+		t.Errorf("expected 2 deleted, actually %d", n)
+	}
+	path, _ = ParsePath("$..color")
+	if n := doc.Delete(path); n != 1 {
+		t.Errorf("expected 1 deleted, actually %d", n)
+	}
+	expected := `{"store":{"book":[],"bicycle":{"price":1}},"empty":[],"name":"<godis>","ok":true,"none":null}`
+	if string(Marshal(doc.Root)) != expected {
+		t.Errorf("expected %s, actually %s", expected, Marshal(doc.Root))
+	}
+}
+
+func TestAddNumber(t *testing.T) {
+	cases := [][3]string{
+		{"1", "2", "3"},
+		{"1.5", "1.5", "3.0"},
+		{"1", "0.5", "1.5"},
+		{"-3", "1", "-2"},
+	}
+	for _, c := range cases {
+		sum, err := AddNumber(json.Number(c[0]), json.Number(c[1]))
+		if err != nil || string(sum) != c[2] {
+			t.Errorf("%s + %s: expected %s, actually %s %v", c[0], c[1], c[2], sum, err)
+		}
+	}
+	if _, err := AddNumber(json.Number("9223372036854775807"), json.Number("1")); err == nil {
+		t.Error("expected overflow")
+	}
+}
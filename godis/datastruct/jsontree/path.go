@@ -0,0 +1,278 @@
+package jsontree
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPath is returned when parsing unsupported or broken path
+var ErrInvalidPath = errors.New("invalid JSONPath")
+
+type stepKind int
+
+const (
+	stepKey stepKind = iota
+	stepIndex
+	stepWildcard
+	stepSlice
+)
+
+type step struct {
+	kind stepKind
+	// recursive step matches descendants at any depth, like ..name
+	recursive bool
+	key       string
+	index     int
+	// slice bounds, nil means open end
+	start, end *int
+}
+
+// Path is a parsed JSONPath. Supported syntax: $ .name ['name'] [index] [start:end] .* [*] and ..name for recursive descent.
+// Path not starting with $ is a legacy path of RedisJSON v1, such as . or .a.b[0], which selects a single value
+type Path struct {
+	Legacy bool
+	steps  []step
+}
+
+// ParsePath parses JSONPath or legacy path
+func ParsePath(text string) (*Path, error) {
+	p := &Path{}
+	var rest string
+	switch {
+	case strings.HasPrefix(text, "$"):
+		rest = text[1:]
+	case text == ".":
+		p.Legacy = true
+		return p, nil
+	case strings.HasPrefix(text, ".") || strings.HasPrefix(text, "["):
+		p.Legacy = true
+		rest = text
+	default:
+		p.Legacy = true
+		rest = "." + text
+	}
+	for len(rest) > 0 {
+		var s step
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, ".") {
+				s.recursive = true
+				rest = rest[1:]
+				if strings.HasPrefix(rest, "[") {
+					var err error
+					s, rest, err = parseBracket(rest, s)
+					if err != nil {
+						return nil, err
+					}
+					break
+				}
+			}
+			if strings.HasPrefix(rest, "*") {
+				s.kind = stepWildcard
+				rest = rest[1:]
+				break
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, ErrInvalidPath
+			}
+			s.kind = stepKey
+			s.key = rest[:end]
+			rest = rest[end:]
+		case '[':
+			var err error
+			s, rest, err = parseBracket(rest, s)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrInvalidPath
+		}
+		p.steps = append(p.steps, s)
+	}
+	return p, nil
+}
+
+// parseBracket parses [...] at the beginning of text, returns the step and the remaining text
+func parseBracket(text string, s step) (step, string, error) {
+	text = text[1:]
+	if len(text) > 0 && (text[0] == '\'' || text[0] == '"') {
+		quote := text[0]
+		end := strings.IndexByte(text[1:], quote)
+		if end < 0 || !strings.HasPrefix(text[end+2:], "]") {
+			return s, "", ErrInvalidPath
+		}
+		s.kind = stepKey
+		s.key = text[1 : end+1]
+		return s, text[end+3:], nil
+	}
+	end := strings.IndexByte(text, ']')
+	if end < 0 {
+		return s, "", ErrInvalidPath
+	}
+	content := strings.TrimSpace(text[:end])
+	text = text[end+1:]
+	if content == "*" {
+		s.kind = stepWildcard
+		return s, text, nil
+	}
+	if i := strings.IndexByte(content, ':'); i >= 0 {
+		s.kind = stepSlice
+		var err error
+		if s.start, err = parseBound(content[:i]); err != nil {
+			return s, "", err
+		}
+		if s.end, err = parseBound(content[i+1:]); err != nil {
+			return s, "", err
+		}
+		return s, text, nil
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil {
+		return s, "", ErrInvalidPath
+	}
+	s.kind = stepIndex
+	s.index = index
+	return s, text, nil
+}
+
+func parseBound(text string) (*int, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return nil, ErrInvalidPath
+	}
+	return &n, nil
+}
+
+// IsRoot tells whether path selects the root only
+func (p *Path) IsRoot() bool {
+	return len(p.steps) == 0
+}
+
+// parent returns path of the parent and the key of the last step,
+// ok is false if the last step is not a plain object key
+func (p *Path) parent() (*Path, string, bool) {
+	if len(p.steps) == 0 {
+		return nil, "", false
+	}
+	last := p.steps[len(p.steps)-1]
+	if last.kind != stepKey || last.recursive {
+		return nil, "", false
+	}
+	return &Path{Legacy: p.Legacy, steps: p.steps[:len(p.steps)-1]}, last.key, true
+}
+
+// Node is a value matched by path and its position in the document
+type Node struct {
+	Value  interface{}
+	parent interface{} // *Object, *Array or nil for root
+	key    string
+	index  int
+}
+
+func (p *Path) eval(root interface{}) []*Node {
+	nodes := []*Node{{Value: root}}
+	for _, s := range p.steps {
+		var next []*Node
+		for _, n := range nodes {
+			if !s.recursive {
+				next = s.apply(n, next)
+				continue
+			}
+			for _, d := range descendants(n, nil) {
+				next = s.apply(d, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// descendants returns n and all nodes below it in pre-order
+func descendants(n *Node, result []*Node) []*Node {
+	result = append(result, n)
+	for _, child := range children(n) {
+		result = descendants(child, result)
+	}
+	return result
+}
+
+// children returns values in object or array
+func children(n *Node) []*Node {
+	switch v := n.Value.(type) {
+	case *Object:
+		result := make([]*Node, len(v.keys))
+		for i, key := range v.keys {
+			result[i] = &Node{Value: v.fields[key], parent: v, key: key}
+		}
+		return result
+	case *Array:
+		result := make([]*Node, len(v.Items))
+		for i, item := range v.Items {
+			result[i] = &Node{Value: item, parent: v, index: i}
+		}
+		return result
+	}
+	return nil
+}
+
+func (s step) apply(n *Node, result []*Node) []*Node {
+	switch s.kind {
+	case stepKey:
+		if obj, ok := n.Value.(*Object); ok {
+			if val, ok := obj.fields[s.key]; ok {
+				result = append(result, &Node{Value: val, parent: obj, key: s.key})
+			}
+		}
+	case stepWildcard:
+		result = append(result, children(n)...)
+	case stepIndex:
+		if arr, ok := n.Value.(*Array); ok {
+			i := s.index
+			if i < 0 {
+				i += len(arr.Items)
+			}
+			if i >= 0 && i < len(arr.Items) {
+				result = append(result, &Node{Value: arr.Items[i], parent: arr, index: i})
+			}
+		}
+	case stepSlice:
+		if arr, ok := n.Value.(*Array); ok {
+			start, end := sliceBounds(s.start, s.end, len(arr.Items))
+			for i := start; i < end; i++ {
+				result = append(result, &Node{Value: arr.Items[i], parent: arr, index: i})
+			}
+		}
+	}
+	return result
+}
+
+// sliceBounds converts bounds like python slice into [start, end) within [0, size]
+func sliceBounds(startPtr, endPtr *int, size int) (int, int) {
+	clamp := func(i *int, def int) int {
+		if i == nil {
+			return def
+		}
+		v := *i
+		if v < 0 {
+			v += size
+		}
+		if v < 0 {
+			return 0
+		}
+		if v > size {
+			return size
+		}
+		return v
+	}
+	return clamp(startPtr, 0), clamp(endPtr, size)
+}
@@ -0,0 +1,222 @@
+package jsontree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// A JSON value is one of *Object, *Array, string, json.Number, bool and nil.
+// Containers are pointers so that they could be modified in place
+
+// Object is a JSON object which keeps the order of keys
+type Object struct {
+	keys   []string
+	fields map[string]interface{}
+}
+
+// Array is a JSON array
+type Array struct {
+	Items []interface{}
+}
+
+// NewObject creates an empty object
+func NewObject() *Object {
+	return &Object{
+		fields: make(map[string]interface{}),
+	}
+}
+
+// Get returns value of key
+func (obj *Object) Get(key string) (interface{}, bool) {
+	val, ok := obj.fields[key]
+	return val, ok
+}
+
+// Put sets value of key, a new key is appended to the end
+func (obj *Object) Put(key string, val interface{}) {
+	if _, ok := obj.fields[key]; !ok {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.fields[key] = val
+}
+
+// Remove deletes key, returns false if key not found
+func (obj *Object) Remove(key string) bool {
+	if _, ok := obj.fields[key]; !ok {
+		return false
+	}
+	delete(obj.fields, key)
+	for i, k := range obj.keys {
+		if k == key {
+			obj.keys = append(obj.keys[:i], obj.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Keys returns keys in order of insertion
+func (obj *Object) Keys() []string {
+	return obj.keys
+}
+
+// Len returns the number of keys
+func (obj *Object) Len() int {
+	return len(obj.keys)
+}
+
+// Parse decodes JSON text, numbers are kept as json.Number so that integers do not lose precision
+func Parse(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	val, err := parseValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("trailing characters after JSON value")
+	}
+	return val, nil
+}
+
+func parseValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		obj := NewObject()
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyToken.(string)
+			val, err := parseValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			obj.Put(key, val)
+		}
+		_, err = decoder.Token() // }
+		return obj, err
+	case json.Delim('['):
+		arr := &Array{}
+		for decoder.More() {
+			val, err := parseValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			arr.Items = append(arr.Items, val)
+		}
+		_, err = decoder.Token() // ]
+		return arr, err
+	}
+	return token, nil
+}
+
+// Marshal encodes value into compact JSON text
+func Marshal(val interface{}) []byte {
+	buf := &bytes.Buffer{}
+	writeValue(buf, val)
+	return buf.Bytes()
+}
+
+func writeValue(buf *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case *Object:
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, key)
+			buf.WriteByte(':')
+			writeValue(buf, v.fields[key])
+		}
+		buf.WriteByte('}')
+	case *Array:
+		buf.WriteByte('[')
+		for i, item := range v.Items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeValue(buf, item)
+		}
+		buf.WriteByte(']')
+	case string:
+		writeString(buf, v)
+	case json.Number:
+		buf.WriteString(string(v))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case nil:
+		buf.WriteString("null")
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	// Encode appends a newline
+	buf.Truncate(buf.Len() - 1)
+}
+
+// TypeOf returns type name of value like RedisJSON: object, array, string, integer, number, boolean or null
+func TypeOf(val interface{}) string {
+	switch v := val.(type) {
+	case *Object:
+		return "object"
+	case *Array:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// AddNumber returns a + b, the result is an integer if both of them are integers
+func AddNumber(a, b json.Number) (json.Number, error) {
+	x, errX := a.Int64()
+	y, errY := b.Int64()
+	if errX == nil && errY == nil {
+		sum := x + y
+		// overflow if both operands have the same sign which differs from the sum
+		if (x >= 0) == (y >= 0) && (sum >= 0) != (x >= 0) {
+			return "", errors.New("result is out of range")
+		}
+		return json.Number(strconv.FormatInt(sum, 10)), nil
+	}
+	f, err := a.Float64()
+	if err != nil {
+		return "", err
+	}
+	g, err := b.Float64()
+	if err != nil {
+		return "", err
+	}
+	s := strconv.FormatFloat(f+g, 'f', -1, 64)
+	if s == "+Inf" || s == "-Inf" || s == "NaN" {
+		return "", errors.New("result is not a valid number")
+	}
+	if !bytes.ContainsAny([]byte(s), ".e") {
+		// keep it a float number
+		s += ".0"
+	}
+	return json.Number(s), nil
+}
@@ -0,0 +1,367 @@
+// Package search provides secondary index and full-text search over hashes
+package search
+
+import (
+	"godis/datastruct/sortedset"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// FieldType is the type of indexed field
+type FieldType int
+
+// field types supported
+const (
+	// TypeText is tokenized for full-text search
+	TypeText FieldType = iota
+	// TypeNumeric supports range query
+	TypeNumeric
+	// TypeTag is a list of exact values split by separator
+	TypeTag
+)
+
+var fieldTypeNames = []string{"TEXT", "NUMERIC", "TAG"}
+
+// ParseFieldType returns type of the given name, it is case insensitive
+func ParseFieldType(name string) (FieldType, bool) {
+	name = strings.ToUpper(name)
+	for i, n := range fieldTypeNames {
+		if n == name {
+			return FieldType(i), true
+		}
+	}
+	return 0, false
+}
+
+func (t FieldType) String() string {
+	return fieldTypeNames[t]
+}
+
+// DefaultTagSeparator splits values of tag field
+const DefaultTagSeparator = ','
+
+// Field is a hash field in schema
+type Field struct {
+	Name     string
+	Type     FieldType
+	Sortable bool
+	// Weight of text field in scoring
+	Weight float64
+	// Separator of tag field
+	Separator byte
+}
+
+type document struct {
+	// values of schema fields
+	values map[string]string
+	// values of numeric fields which are valid numbers
+	numbers map[string]float64
+}
+
+// Index indexes hashes whose keys start with one of its prefixes. It is concurrent safe
+type Index struct {
+	Name     string
+	Prefixes []string
+	Fields   []*Field
+
+	mu       sync.RWMutex
+	fieldMap map[string]*Field
+	docs     map[string]*document
+	// term -> field -> key -> term frequency
+	terms map[string]map[string]map[string]int
+	// field -> key sorted by value
+	numbers map[string]*sortedset.SortedSet
+	// field -> tag -> keys
+	tags map[string]map[string]map[string]struct{}
+	// failures counts documents with values not parsed
+	failures int
+}
+
+// MakeIndex creates an empty index, empty prefixes means all keys.
+// Zero weight and separator of fields are set to default
+func MakeIndex(name string, prefixes []string, fields []*Field) *Index {
+	idx := &Index{
+		Name:     name,
+		Prefixes: prefixes,
+		Fields:   fields,
+		fieldMap: make(map[string]*Field, len(fields)),
+		docs:     make(map[string]*document),
+		terms:    make(map[string]map[string]map[string]int),
+		numbers:  make(map[string]*sortedset.SortedSet),
+		tags:     make(map[string]map[string]map[string]struct{}),
+	}
+	for _, f := range fields {
+		idx.fieldMap[f.Name] = f
+		if f.Weight == 0 {
+			f.Weight = 1
+		}
+		if f.Separator == 0 {
+			f.Separator = DefaultTagSeparator
+		}
+		switch f.Type {
+		case TypeNumeric:
+			idx.numbers[f.Name] = sortedset.Make()
+		case TypeTag:
+			idx.tags[f.Name] = make(map[string]map[string]struct{})
+		}
+	}
+	return idx
+}
+
+// Field returns field of schema by name
+func (idx *Index) Field(name string) (*Field, bool) {
+	f, ok := idx.fieldMap[name]
+	return f, ok
+}
+
+// Match tells whether key should be indexed
+func (idx *Index) Match(key string) bool {
+	if len(idx.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range idx.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of documents indexed
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Failures returns the number of documents whose values could not be indexed
+func (idx *Index) Failures() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.failures
+}
+
+// TermCount returns the number of distinct terms in text fields
+func (idx *Index) TermCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.terms)
+}
+
+// Put indexes fields of a hash, replacing its previous version
+func (idx *Index) Put(key string, fields map[string][]byte) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(key)
+	doc := &document{
+		values:  make(map[string]string),
+		numbers: make(map[string]float64),
+	}
+	for name, raw := range fields {
+		f, ok := idx.fieldMap[name]
+		if !ok {
+			continue
+		}
+		value := string(raw)
+		switch f.Type {
+		case TypeText:
+			for _, term := range Tokenize(value) {
+				byField := idx.terms[term]
+				if byField == nil {
+					byField = make(map[string]map[string]int)
+					idx.terms[term] = byField
+				}
+				postings := byField[name]
+				if postings == nil {
+					postings = make(map[string]int)
+					byField[name] = postings
+				}
+				postings[key]++
+			}
+		case TypeNumeric:
+			n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || math.IsNaN(n) {
+				idx.failures++
+				continue
+			}
+			doc.numbers[name] = n
+			idx.numbers[name].Add(key, n)
+		case TypeTag:
+			for _, tag := range SplitTags(value, f.Separator) {
+				keys := idx.tags[name][tag]
+				if keys == nil {
+					keys = make(map[string]struct{})
+					idx.tags[name][tag] = keys
+				}
+				keys[key] = struct{}{}
+			}
+		}
+		doc.values[name] = value
+	}
+	idx.docs[key] = doc
+}
+
+// Remove drops document of key, returns false if not indexed
+func (idx *Index) Remove(key string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.remove(key)
+}
+
+func (idx *Index) remove(key string) bool {
+	doc, ok := idx.docs[key]
+	if !ok {
+		return false
+	}
+	for name, value := range doc.values {
+		f := idx.fieldMap[name]
+		switch f.Type {
+		case TypeText:
+			for _, term := range Tokenize(value) {
+				byField := idx.terms[term]
+				if byField == nil {
+					continue
+				}
+				delete(byField[name], key)
+				if len(byField[name]) == 0 {
+					delete(byField, name)
+				}
+				if len(byField) == 0 {
+					delete(idx.terms, term)
+				}
+			}
+		case TypeNumeric:
+			idx.numbers[name].Remove(key)
+		case TypeTag:
+			for _, tag := range SplitTags(value, f.Separator) {
+				delete(idx.tags[name][tag], key)
+				if len(idx.tags[name][tag]) == 0 {
+					delete(idx.tags[name], tag)
+				}
+			}
+		}
+	}
+	delete(idx.docs, key)
+	return true
+}
+
+// Keys returns keys of all documents
+func (idx *Index) Keys() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	keys := make([]string, 0, len(idx.docs))
+	for key := range idx.docs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Clear drops all documents
+func (idx *Index) Clear() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	fresh := MakeIndex(idx.Name, idx.Prefixes, idx.Fields)
+	idx.docs = fresh.docs
+	idx.terms = fresh.terms
+	idx.numbers = fresh.numbers
+	idx.tags = fresh.tags
+	idx.failures = 0
+}
+
+// Tokenize splits text into lower case terms by characters other than letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SplitTags splits value of tag field, tags are trimmed and case insensitive
+func SplitTags(value string, sep byte) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, string(sep)) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SearchOptions controls order and paging of results
+type SearchOptions struct {
+	// SortBy is a sortable field, results are sorted by relevance if empty
+	SortBy string
+	Desc   bool
+	Offset int
+	Limit  int
+	// Filter drops matched documents if it returns false, it is called while index locked
+	Filter func(key string) bool
+}
+
+// Search returns keys of a page of documents matched by query and the number of all matched
+func (idx *Index) Search(q *Query, opts *SearchOptions) ([]string, int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	scores := q.root.eval(idx)
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		if opts.Filter == nil || opts.Filter(key) {
+			keys = append(keys, key)
+		}
+	}
+	var less func(a, b string) bool
+	if opts.SortBy == "" {
+		less = func(a, b string) bool {
+			if scores[a] != scores[b] {
+				return scores[a] > scores[b]
+			}
+			return a < b
+		}
+	} else {
+		less = idx.sortByField(opts.SortBy, opts.Desc)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return less(keys[i], keys[j])
+	})
+	total := len(keys)
+	if opts.Offset >= len(keys) {
+		return nil, total
+	}
+	keys = keys[opts.Offset:]
+	if opts.Limit < len(keys) {
+		keys = keys[:opts.Limit]
+	}
+	return keys, total
+}
+
+// sortByField compares documents by value of field, documents without the value are always at last
+func (idx *Index) sortByField(name string, desc bool) func(a, b string) bool {
+	numeric := idx.fieldMap[name].Type == TypeNumeric
+	return func(a, b string) bool {
+		docA, docB := idx.docs[a], idx.docs[b]
+		var okA, okB, less, equal bool
+		if numeric {
+			var x, y float64
+			x, okA = docA.numbers[name]
+			y, okB = docB.numbers[name]
+			less, equal = x < y, x == y
+		} else {
+			var x, y string
+			x, okA = docA.values[name]
+			y, okB = docB.values[name]
+			x, y = strings.ToLower(x), strings.ToLower(y)
+			less, equal = x < y, x == y
+		}
+		if okA != okB {
+			return okA
+		}
+		if !okA || equal {
+			return a < b
+		}
+		return less != desc
+	}
+}
@@ -0,0 +1,381 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"godis/datastruct/sortedset"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ErrSyntax is returned for malformed query
+var ErrSyntax = errors.New("Syntax error")
+
+// Query is a parsed search query. Syntax:
+//
+//	hello world       documents containing both terms in any text field
+//	hello | world     either of them, - excludes documents, ( ) groups sub queries
+//	hel*              terms with prefix
+//	@title:hello      terms in the given text field, @title:(hello | world) groups terms of the field
+//	@price:[10 (20]   numeric range, ( means exclusive, -inf and +inf are supported
+//	@tags:{a | b}     documents having any of the tags
+//	*                 all documents
+type Query struct {
+	root queryNode
+}
+
+// queryNode evaluates to scores of matched documents, invoker should hold the read lock of index
+type queryNode interface {
+	eval(idx *Index) map[string]float64
+}
+
+type allNode struct{}
+
+type termNode struct {
+	// empty field means all text fields
+	field  string
+	term   string
+	prefix bool
+}
+
+type numericNode struct {
+	field    string
+	min, max *sortedset.ScoreBorder
+}
+
+type tagNode struct {
+	field string
+	tags  []string
+}
+
+type notNode struct {
+	child queryNode
+}
+
+type andNode struct {
+	children []queryNode
+}
+
+type orNode struct {
+	children []queryNode
+}
+
+func (n *allNode) eval(idx *Index) map[string]float64 {
+	result := make(map[string]float64, len(idx.docs))
+	for key := range idx.docs {
+		result[key] = 0
+	}
+	return result
+}
+
+func (n *termNode) eval(idx *Index) map[string]float64 {
+	result := make(map[string]float64)
+	addTerm := func(byField map[string]map[string]int) {
+		for field, postings := range byField {
+			if n.field != "" && field != n.field {
+				continue
+			}
+			// tf-idf weighted by field
+			idf := math.Log(1 + float64(len(idx.docs))/float64(len(postings)))
+			weight := idx.fieldMap[field].Weight
+			for key, tf := range postings {
+				result[key] += float64(tf) * idf * weight
+			}
+		}
+	}
+	if !n.prefix {
+		addTerm(idx.terms[n.term])
+		return result
+	}
+	for term, byField := range idx.terms {
+		if strings.HasPrefix(term, n.term) {
+			addTerm(byField)
+		}
+	}
+	return result
+}
+
+func (n *numericNode) eval(idx *Index) map[string]float64 {
+	result := make(map[string]float64)
+	idx.numbers[n.field].ForEachByScore(n.min, n.max, 0, -1, false, func(element *sortedset.Element) bool {
+		result[element.Member] = 0
+		return true
+	})
+	return result
+}
+
+func (n *tagNode) eval(idx *Index) map[string]float64 {
+	result := make(map[string]float64)
+	for _, tag := range n.tags {
+		for key := range idx.tags[n.field][tag] {
+			result[key] = 0
+		}
+	}
+	return result
+}
+
+func (n *notNode) eval(idx *Index) map[string]float64 {
+	excluded := n.child.eval(idx)
+	result := make(map[string]float64)
+	for key := range idx.docs {
+		if _, ok := excluded[key]; !ok {
+			result[key] = 0
+		}
+	}
+	return result
+}
+
+func (n *andNode) eval(idx *Index) map[string]float64 {
+	var result map[string]float64
+	for _, child := range n.children {
+		scores := child.eval(idx)
+		if result == nil {
+			result = scores
+			continue
+		}
+		for key, score := range result {
+			s, ok := scores[key]
+			if !ok {
+				delete(result, key)
+				continue
+			}
+			result[key] = score + s
+		}
+	}
+	return result
+}
+
+func (n *orNode) eval(idx *Index) map[string]float64 {
+	result := make(map[string]float64)
+	for _, child := range n.children {
+		for key, score := range child.eval(idx) {
+			result[key] += score
+		}
+	}
+	return result
+}
+
+type queryParser struct {
+	idx  *Index
+	text []rune
+	pos  int
+}
+
+// ParseQuery parses query text against schema of index
+func (idx *Index) ParseQuery(text string) (*Query, error) {
+	p := &queryParser{idx: idx, text: []rune(text)}
+	root, err := p.parseUnion("")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.text) {
+		return nil, ErrSyntax
+	}
+	return &Query{root: root}, nil
+}
+
+func (p *queryParser) skipSpaces() {
+	for p.pos < len(p.text) && unicode.IsSpace(p.text[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, 0 means end of query
+func (p *queryParser) peek() rune {
+	p.skipSpaces()
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+func (p *queryParser) expect(r rune) error {
+	if p.peek() != r {
+		return ErrSyntax
+	}
+	p.pos++
+	return nil
+}
+
+// parseUnion parses sub queries separated by |, terms are searched in field if it is not empty
+func (p *queryParser) parseUnion(field string) (queryNode, error) {
+	var children []queryNode
+	for {
+		child, err := p.parseIntersect(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &orNode{children: children}, nil
+}
+
+func (p *queryParser) parseIntersect(field string) (queryNode, error) {
+	var children []queryNode
+	for {
+		c := p.peek()
+		if c == 0 || c == '|' || c == ')' {
+			break
+		}
+		child, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	switch len(children) {
+	case 0:
+		return nil, ErrSyntax
+	case 1:
+		return children[0], nil
+	}
+	return &andNode{children: children}, nil
+}
+
+func (p *queryParser) parseUnary(field string) (queryNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		child, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{child: child}, nil
+	}
+	return p.parseAtom(field)
+}
+
+func (p *queryParser) parseAtom(field string) (queryNode, error) {
+	switch p.peek() {
+	case '(':
+		p.pos++
+		node, err := p.parseUnion(field)
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(')')
+	case '@':
+		if field != "" {
+			return nil, ErrSyntax
+		}
+		p.pos++
+		return p.parseField()
+	case '*':
+		if field != "" {
+			return nil, ErrSyntax
+		}
+		p.pos++
+		return &allNode{}, nil
+	}
+	word := p.readWord()
+	if word == "" {
+		return nil, ErrSyntax
+	}
+	node := &termNode{field: field, term: strings.ToLower(word)}
+	if p.pos < len(p.text) && p.text[p.pos] == '*' {
+		node.prefix = true
+		p.pos++
+	}
+	return node, nil
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (p *queryParser) readWord() string {
+	start := p.pos
+	for p.pos < len(p.text) && isWordChar(p.text[p.pos]) {
+		p.pos++
+	}
+	return string(p.text[start:p.pos])
+}
+
+// parseField parses the rest of @field:...
+func (p *queryParser) parseField() (queryNode, error) {
+	name := p.readWord()
+	if name == "" || p.pos >= len(p.text) || p.text[p.pos] != ':' {
+		return nil, ErrSyntax
+	}
+	p.pos++
+	f, ok := p.idx.fieldMap[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown field `%s`", name)
+	}
+	switch f.Type {
+	case TypeNumeric:
+		return p.parseRange(name)
+	case TypeTag:
+		return p.parseTags(f)
+	}
+	if p.peek() == '(' {
+		p.pos++
+		node, err := p.parseUnion(name)
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(')')
+	}
+	return p.parseUnary(name)
+}
+
+// parseRange parses [min max]
+func (p *queryParser) parseRange(field string) (queryNode, error) {
+	if err := p.expect('['); err != nil {
+		return nil, err
+	}
+	end := p.indexOf(']')
+	if end < 0 {
+		return nil, ErrSyntax
+	}
+	bounds := strings.FieldsFunc(string(p.text[p.pos:end]), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	p.pos = end + 1
+	if len(bounds) != 2 {
+		return nil, ErrSyntax
+	}
+	min, err := sortedset.ParseScoreBorder(bounds[0])
+	if err != nil {
+		return nil, errors.New("Bad lower range: " + bounds[0])
+	}
+	max, err := sortedset.ParseScoreBorder(bounds[1])
+	if err != nil {
+		return nil, errors.New("Bad upper range: " + bounds[1])
+	}
+	return &numericNode{field: field, min: min, max: max}, nil
+}
+
+// parseTags parses {tag | tag ...}
+func (p *queryParser) parseTags(f *Field) (queryNode, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	end := p.indexOf('}')
+	if end < 0 {
+		return nil, ErrSyntax
+	}
+	tags := SplitTags(string(p.text[p.pos:end]), '|')
+	p.pos = end + 1
+	if len(tags) == 0 {
+		return nil, ErrSyntax
+	}
+	return &tagNode{field: f.Name, tags: tags}, nil
+}
+
+func (p *queryParser) indexOf(r rune) int {
+	for i := p.pos; i < len(p.text); i++ {
+		if p.text[i] == r {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,143 @@
+package search
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func makeTestIndex() *Index {
+	idx := MakeIndex("idx", []string{"doc:"}, []*Field{
+		{Name: "title", Type: TypeText, Weight: 2, Sortable: true},
+		{Name: "body", Type: TypeText},
+		{Name: "price", Type: TypeNumeric, Sortable: true},
+		{Name: "tags", Type: TypeTag},
+	})
+	docs := map[string]map[string]string{
+		"doc:1": {"title": "Hello World", "body": "first document", "price": "10", "tags": "news, Tech"},
+		"doc:2": {"title": "Hello Redis", "body": "redis is fast", "price": "20.5", "tags": "tech"},
+		"doc:3": {"title": "Goodbye", "body": "hello world", "price": "30", "tags": "life"},
+		"doc:4": {"title": "Misc", "body": "nothing", "price": "abc"},
+	}
+	for key, fields := range docs {
+		raw := make(map[string][]byte)
+		for f, v := range fields {
+			raw[f] = []byte(v)
+		}
+		idx.Put(key, raw)
+	}
+	return idx
+}
+
+func search(t *testing.T, idx *Index, text string) []string {
+	q, err := idx.ParseQuery(text)
+	if err != nil {
+		t.Fatalf("parse %s: %v", text, err)
+	}
+	keys, total := idx.Search(q, &SearchOptions{Limit: 10})
+	if total != len(keys) {
+		t.Fatalf("expect total %d, actual %d", len(keys), total)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestQuery(t *testing.T) {
+	idx := makeTestIndex()
+	if idx.Len() != 4 || idx.Failures() != 1 {
+		t.Fatalf("unexpected len %d or failures %d", idx.Len(), idx.Failures())
+	}
+	if !idx.Match("doc:5") || idx.Match("user:1") {
+		t.Fatal("wrong prefix match")
+	}
+	cases := []struct {
+		query string
+		keys  []string
+	}{
+		{"hello", []string{"doc:1", "doc:2", "doc:3"}},
+		{"HELLO world", []string{"doc:1", "doc:3"}},
+		{"@title:hello", []string{"doc:1", "doc:2"}},
+		{"@title:(world | redis)", []string{"doc:1", "doc:2"}},
+		{"hello -redis", []string{"doc:1", "doc:3"}},
+		{"red*", []string{"doc:2"}},
+		{"@price:[10 (30]", []string{"doc:1", "doc:2"}},
+		{"@price:[(20 +inf]", []string{"doc:2", "doc:3"}},
+		{"@tags:{tech}", []string{"doc:1", "doc:2"}},
+		{"@tags:{news | life} hello", []string{"doc:1", "doc:3"}},
+		{"(fast | nothing) | goodbye", []string{"doc:2", "doc:3", "doc:4"}},
+		{"*", []string{"doc:1", "doc:2", "doc:3", "doc:4"}},
+		{"-*", []string{}},
+		{"missing", []string{}},
+	}
+	for _, c := range cases {
+		keys := search(t, idx, c.query)
+		if len(keys) == 0 && len(c.keys) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("query %s: expect %v, actual %v", c.query, c.keys, keys)
+		}
+	}
+	for _, text := range []string{"", "(hello", "@title:", "@price:[1]", "@tags:{}", "hello )"} {
+		if _, err := idx.ParseQuery(text); err == nil {
+			t.Errorf("expect syntax error for %s", text)
+		}
+	}
+	if _, err := idx.ParseQuery("@unknown:a"); err == nil || err.Error() != "Unknown field `unknown`" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSearchOrder(t *testing.T) {
+	idx := makeTestIndex()
+	q, _ := idx.ParseQuery("hello")
+	// title has higher weight
+	keys, _ := idx.Search(q, &SearchOptions{Limit: 10})
+	if !reflect.DeepEqual(keys, []string{"doc:1", "doc:2", "doc:3"}) {
+		t.Errorf("unexpected order %v", keys)
+	}
+	q, _ = idx.ParseQuery("*")
+	keys, total := idx.Search(q, &SearchOptions{SortBy: "price", Desc: true, Offset: 1, Limit: 2})
+	if total != 4 || !reflect.DeepEqual(keys, []string{"doc:2", "doc:1"}) {
+		t.Errorf("unexpected page %v of %d", keys, total)
+	}
+	keys, _ = idx.Search(q, &SearchOptions{SortBy: "price", Limit: 10})
+	if !reflect.DeepEqual(keys, []string{"doc:1", "doc:2", "doc:3", "doc:4"}) {
+		t.Errorf("unexpected order %v", keys)
+	}
+	keys, _ = idx.Search(q, &SearchOptions{SortBy: "title", Limit: 10})
+	if !reflect.DeepEqual(keys, []string{"doc:3", "doc:2", "doc:1", "doc:4"}) {
+		t.Errorf("unexpected order %v", keys)
+	}
+	keys, _ = idx.Search(q, &SearchOptions{Offset: 10, Limit: 10})
+	if len(keys) != 0 {
+		t.Errorf("expect empty page, actual %v", keys)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	idx := makeTestIndex()
+	idx.Put("doc:2", map[string][]byte{"title": []byte("changed"), "price": []byte("100")})
+	if keys := search(t, idx, "redis"); len(keys) != 0 {
+		t.Errorf("old terms should be removed, actual %v", keys)
+	}
+	if keys := search(t, idx, "@price:[50 +inf]"); !reflect.DeepEqual(keys, []string{"doc:2"}) {
+		t.Errorf("unexpected %v", keys)
+	}
+	if keys := search(t, idx, "@tags:{tech}"); !reflect.DeepEqual(keys, []string{"doc:1"}) {
+		t.Errorf("unexpected %v", keys)
+	}
+	if !idx.Remove("doc:1") || idx.Remove("doc:1") {
+		t.Error("wrong remove result")
+	}
+	if keys := search(t, idx, "world"); !reflect.DeepEqual(keys, []string{"doc:3"}) {
+		t.Errorf("unexpected %v", keys)
+	}
+	if keys := search(t, idx, "@tags:{tech}"); len(keys) != 0 {
+		t.Errorf("unexpected %v", keys)
+	}
+	idx.Clear()
+	if idx.Len() != 0 || idx.TermCount() != 0 || len(search(t, idx, "*")) != 0 {
+		t.Error("index should be empty")
+	}
+}
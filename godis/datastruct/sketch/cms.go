@@ -0,0 +1,177 @@
+package sketch
+
+import (
+	"errors"
+	"math"
+)
+
+// maxCounters limits memory of a sketch to 512MB
+const maxCounters = 64 << 20
+
+var (
+	// ErrTooLarge is returned when the sketch needs more than maxCounters counters
+	ErrTooLarge = errors.New("sketch is too large")
+	// ErrDimension is returned when merging sketches of different width or depth
+	ErrDimension = errors.New("width/depth is not equal")
+)
+
+// CountMinSketch estimates frequency of items in fixed memory, the estimation is never less than the real count.
+// It is not concurrent safe
+type CountMinSketch struct {
+	width    uint64
+	depth    uint64
+	counters []uint64 // depth rows of width counters
+	// count is the sum of all increments
+	count uint64
+}
+
+// NewCountMinSketch creates a sketch with depth rows of width counters
+func NewCountMinSketch(width, depth uint64) (*CountMinSketch, error) {
+	if width == 0 || depth == 0 {
+		return nil, errors.New("illegal width or depth")
+	}
+	if width > maxCounters/depth {
+		return nil, ErrTooLarge
+	}
+	return &CountMinSketch{
+		width:    width,
+		depth:    depth,
+		counters: make([]uint64, width*depth),
+	}, nil
+}
+
+// NewCountMinSketchByProb creates a sketch whose estimation exceeds the real count by more than
+// errorRate of total count with the given probability at most
+func NewCountMinSketchByProb(errorRate, probability float64) (*CountMinSketch, error) {
+	if errorRate <= 0 || errorRate >= 1 || probability <= 0 || probability >= 1 {
+		return nil, errors.New("illegal error rate or probability")
+	}
+	width := math.Ceil(2 / errorRate)
+	depth := math.Ceil(math.Log(probability) / math.Log(0.5))
+	if width*depth > maxCounters {
+		return nil, ErrTooLarge
+	}
+	return NewCountMinSketch(uint64(width), uint64(depth))
+}
+
+// saturatingAdd adds without exceeding math.MaxInt64, so that counts could be returned as integer reply
+func saturatingAdd(a, b uint64) uint64 {
+	if b > math.MaxInt64-a {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// IncrBy increases count of item and returns the estimation after increasing
+func (s *CountMinSketch) IncrBy(item string, increment uint64) uint64 {
+	h1, h2 := hash(item)
+	min := uint64(math.MaxUint64)
+	for i := uint64(0); i < s.depth; i++ {
+		pos := i*s.width + (h1+i*h2)%s.width
+		s.counters[pos] = saturatingAdd(s.counters[pos], increment)
+		if s.counters[pos] < min {
+			min = s.counters[pos]
+		}
+	}
+	s.count = saturatingAdd(s.count, increment)
+	return min
+}
+
+// Query returns the estimated count of item
+func (s *CountMinSketch) Query(item string) uint64 {
+	h1, h2 := hash(item)
+	min := uint64(math.MaxUint64)
+	for i := uint64(0); i < s.depth; i++ {
+		if c := s.counters[i*s.width+(h1+i*h2)%s.width]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// Merge replaces counters with the weighted sum of sources, sources may contain s itself
+func (s *CountMinSketch) Merge(sources []*CountMinSketch, weights []uint64) error {
+	for _, src := range sources {
+		if src.width != s.width || src.depth != s.depth {
+			return ErrDimension
+		}
+	}
+	counters := make([]uint64, len(s.counters))
+	var count uint64
+	for i, src := range sources {
+		weight := uint64(1)
+		if weights != nil {
+			weight = weights[i]
+		}
+		for j, c := range src.counters {
+			counters[j] = saturatingAdd(counters[j], saturatingMul(c, weight))
+		}
+		count = saturatingAdd(count, saturatingMul(src.count, weight))
+	}
+	s.counters = counters
+	s.count = count
+	return nil
+}
+
+func saturatingMul(a, b uint64) uint64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+// Width returns the number of counters in a row
+func (s *CountMinSketch) Width() uint64 {
+	return s.width
+}
+
+// Depth returns the number of rows
+func (s *CountMinSketch) Depth() uint64 {
+	return s.depth
+}
+
+// Count returns the sum of all increments
+func (s *CountMinSketch) Count() uint64 {
+	return s.count
+}
+
+// Marshal encodes sketch into bytes, it can be restored by UnmarshalCountMinSketch
+// layout: uvarint(width) uvarint(depth) uvarint(count) uvarint(counter)...
+func (s *CountMinSketch) Marshal() []byte {
+	buf := make([]byte, 0, 3+len(s.counters))
+	buf = appendUvarint(buf, s.width)
+	buf = appendUvarint(buf, s.depth)
+	buf = appendUvarint(buf, s.count)
+	for _, c := range s.counters {
+		buf = appendUvarint(buf, c)
+	}
+	return buf
+}
+
+// UnmarshalCountMinSketch restores sketch encoded by Marshal
+func UnmarshalCountMinSketch(data []byte) (*CountMinSketch, error) {
+	d := &decoder{data: data}
+	width := d.uvarint()
+	depth := d.uvarint()
+	count := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	// each counter takes at least one byte
+	if width == 0 || depth == 0 || width > uint64(len(d.data))/depth {
+		return nil, ErrCorrupted
+	}
+	s := &CountMinSketch{
+		width:    width,
+		depth:    depth,
+		count:    count,
+		counters: make([]uint64, width*depth),
+	}
+	for i := range s.counters {
+		s.counters[i] = d.uvarint()
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
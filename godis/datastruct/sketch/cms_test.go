@@ -0,0 +1,98 @@
+package sketch
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// zipfStream returns n items in zipf distribution and their exact counts
+func zipfStream(n int) ([]string, map[string]uint64) {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.2, 1, 10000)
+	stream := make([]string, n)
+	exact := make(map[string]uint64)
+	for i := range stream {
+		item := strconv.FormatUint(zipf.Uint64(), 10)
+		stream[i] = item
+		exact[item]++
+	}
+	return stream, exact
+}
+
+func TestCountMinSketch(t *testing.T) {
+	errorRate := 0.001
+	s, err := NewCountMinSketchByProb(errorRate, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Width() != 2000 || s.Depth() != 7 {
+		t.Errorf("unexpected dimension %d x %d", s.Width(), s.Depth())
+	}
+	n := 100000
+	stream, exact := zipfStream(n)
+	for _, item := range stream {
+		s.IncrBy(item, 1)
+	}
+	if s.Count() != uint64(n) {
+		t.Errorf("expected count %d, actually %d", n, s.Count())
+	}
+	exceeded := 0
+	for item, count := range exact {
+		estimation := s.Query(item)
+		if estimation < count {
+			t.Errorf("estimation %d of %s is less than real count %d", estimation, item, count)
+		}
+		if float64(estimation-count) > errorRate*float64(n) {
+			exceeded++
+		}
+	}
+	if float64(exceeded) > 0.01*float64(len(exact)) {
+		t.Errorf("%d of %d estimations exceed error bound", exceeded, len(exact))
+	}
+	if s.Query("not exists") > uint64(errorRate*float64(n)) {
+		t.Errorf("estimation of absent item is too large")
+	}
+}
+
+func TestCountMinSketchMerge(t *testing.T) {
+	s1, _ := NewCountMinSketch(100, 5)
+	s2, _ := NewCountMinSketch(100, 5)
+	s1.IncrBy("a", 3)
+	s2.IncrBy("a", 2)
+	s2.IncrBy("b", 1)
+	if err := s1.Merge([]*CountMinSketch{s1, s2}, []uint64{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if s1.Query("a") != 12 || s1.Query("b") != 3 || s1.Count() != 15 {
+		t.Errorf("unexpected merge result %d %d %d", s1.Query("a"), s1.Query("b"), s1.Count())
+	}
+	s3, _ := NewCountMinSketch(50, 5)
+	if err := s1.Merge([]*CountMinSketch{s3}, nil); err != ErrDimension {
+		t.Errorf("expected ErrDimension, actually %v", err)
+	}
+	if _, err := NewCountMinSketch(1<<20, 1<<10); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, actually %v", err)
+	}
+}
+
+func TestCountMinSketchMarshal(t *testing.T) {
+	s, _ := NewCountMinSketch(20, 3)
+	for i := 0; i < 100; i++ {
+		s.IncrBy(strconv.Itoa(i%7), uint64(i))
+	}
+	data := s.Marshal()
+	s2, err := UnmarshalCountMinSketch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s2.Marshal(), data) {
+		t.Error("restored sketch is different")
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := UnmarshalCountMinSketch(data[:i]); err == nil {
+			t.Errorf("expected error for truncated data of length %d", i)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package sketch
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"sort"
+)
+
+// TopK tracks the k most frequent items by HeavyKeeper algorithm.
+// Counters of different items sharing a bucket decay with probability decay^count, so that heavy hitters keep their buckets.
+// Random numbers come from a seeded generator saved with the sketch, so replaying aof builds the same sketch.
+// It is not concurrent safe
+type TopK struct {
+	k      uint64
+	width  uint64
+	depth  uint64
+	decay  float64
+	rng    uint64
+	bucket []topKBucket // depth rows of width buckets
+	heap   *topKHeap
+}
+
+type topKBucket struct {
+	fp    uint64
+	count uint64
+}
+
+// Item is an item and its estimated count
+type Item struct {
+	Item  string
+	Count uint64
+}
+
+// topKHeap is a min heap of the top items by count, index locates item in heap
+type topKHeap struct {
+	items []*Item
+	index map[string]int
+}
+
+func (h *topKHeap) Len() int { return len(h.items) }
+
+func (h *topKHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *topKHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Item] = i
+	h.index[h.items[j].Item] = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	item := x.(*Item)
+	h.index[item.Item] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, last.Item)
+	return last
+}
+
+const topKSeed = 0x9E3779B97F4A7C15
+
+// NewTopK creates a TopK tracking k items with depth rows of width buckets, decay should be in (0, 1]
+func NewTopK(k, width, depth uint64, decay float64) (*TopK, error) {
+	if k == 0 || width == 0 || depth == 0 || decay <= 0 || decay > 1 {
+		return nil, errors.New("illegal top k options")
+	}
+	if width > maxCounters/depth || k > maxCounters {
+		return nil, ErrTooLarge
+	}
+	return &TopK{
+		k:      k,
+		width:  width,
+		depth:  depth,
+		decay:  decay,
+		rng:    topKSeed,
+		bucket: make([]topKBucket, width*depth),
+		heap: &topKHeap{
+			index: make(map[string]int),
+		},
+	}, nil
+}
+
+// random returns a number in [0, 1) by xorshift64*
+func (t *TopK) random() float64 {
+	t.rng ^= t.rng >> 12
+	t.rng ^= t.rng << 25
+	t.rng ^= t.rng >> 27
+	return float64((t.rng*2685821657736338717)>>11) / (1 << 53)
+}
+
+// IncrBy increases count of item, returns the item expelled from top k list if any
+func (t *TopK) IncrBy(item string, increment uint64) (string, bool) {
+	if increment == 0 {
+		return "", false
+	}
+	h1, h2 := hash(item)
+	fp := h1 ^ h2
+	var maxCount uint64
+	for i := uint64(0); i < t.depth; i++ {
+		b := &t.bucket[i*t.width+(h1+i*h2)%t.width]
+		switch {
+		case b.count == 0:
+			b.fp = fp
+			b.count = increment
+		case b.fp == fp:
+			b.count = saturatingAdd(b.count, increment)
+		default:
+			for remain := increment; remain > 0; remain-- {
+				if t.random() < math.Pow(t.decay, float64(b.count)) {
+					b.count--
+					if b.count == 0 {
+						b.fp = fp
+						b.count = remain
+						break
+					}
+				}
+			}
+		}
+		if b.fp == fp && b.count > maxCount {
+			maxCount = b.count
+		}
+	}
+	if maxCount == 0 {
+		return "", false
+	}
+	if idx, ok := t.heap.index[item]; ok {
+		if maxCount > t.heap.items[idx].Count {
+			t.heap.items[idx].Count = maxCount
+			heap.Fix(t.heap, idx)
+		}
+		return "", false
+	}
+	if uint64(t.heap.Len()) < t.k {
+		heap.Push(t.heap, &Item{Item: item, Count: maxCount})
+		return "", false
+	}
+	min := t.heap.items[0]
+	if maxCount <= min.Count {
+		return "", false
+	}
+	delete(t.heap.index, min.Item)
+	t.heap.items[0] = &Item{Item: item, Count: maxCount}
+	t.heap.index[item] = 0
+	heap.Fix(t.heap, 0)
+	return min.Item, true
+}
+
+// Query tells whether item is in top k list
+func (t *TopK) Query(item string) bool {
+	_, ok := t.heap.index[item]
+	return ok
+}
+
+// List returns items in top k list, in descending order of count
+func (t *TopK) List() []Item {
+	result := make([]Item, len(t.heap.items))
+	for i, item := range t.heap.items {
+		result[i] = *item
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Item < result[j].Item
+	})
+	return result
+}
+
+// K returns the number of items tracked
+func (t *TopK) K() uint64 {
+	return t.k
+}
+
+// Width returns the number of buckets in a row
+func (t *TopK) Width() uint64 {
+	return t.width
+}
+
+// Depth returns the number of rows
+func (t *TopK) Depth() uint64 {
+	return t.depth
+}
+
+// Decay returns the base of decay probability
+func (t *TopK) Decay() float64 {
+	return t.decay
+}
+
+// Marshal encodes TopK into bytes, it can be restored by UnmarshalTopK
+// layout: uvarint(k) uvarint(width) uvarint(depth) float64(decay) uvarint(rng) buckets... uvarint(heap size) heap items...
+// bucket: uvarint(fingerprint) uvarint(count), heap item: string(item) uvarint(count)
+func (t *TopK) Marshal() []byte {
+	buf := make([]byte, 0, 32+len(t.bucket)*2)
+	buf = appendUvarint(buf, t.k)
+	buf = appendUvarint(buf, t.width)
+	buf = appendUvarint(buf, t.depth)
+	buf = appendFloat(buf, t.decay)
+	buf = appendUvarint(buf, t.rng)
+	for _, b := range t.bucket {
+		buf = appendUvarint(buf, b.fp)
+		buf = appendUvarint(buf, b.count)
+	}
+	// items are saved in heap order so that the restored heap is the same
+	buf = appendUvarint(buf, uint64(len(t.heap.items)))
+	for _, item := range t.heap.items {
+		buf = appendString(buf, item.Item)
+		buf = appendUvarint(buf, item.Count)
+	}
+	return buf
+}
+
+// UnmarshalTopK restores TopK encoded by Marshal
+func UnmarshalTopK(data []byte) (*TopK, error) {
+	d := &decoder{data: data}
+	t := &TopK{
+		k:     d.uvarint(),
+		width: d.uvarint(),
+		depth: d.uvarint(),
+		decay: d.float(),
+		rng:   d.uvarint(),
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	// each bucket takes at least 2 bytes
+	if t.k == 0 || t.k > maxCounters || t.width == 0 || t.depth == 0 || t.width > uint64(len(d.data))/2/t.depth ||
+		t.decay <= 0 || t.decay > 1 {
+		return nil, ErrCorrupted
+	}
+	t.bucket = make([]topKBucket, t.width*t.depth)
+	for i := range t.bucket {
+		t.bucket[i].fp = d.uvarint()
+		t.bucket[i].count = d.uvarint()
+	}
+	n := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if n > t.k {
+		return nil, ErrCorrupted
+	}
+	t.heap = &topKHeap{
+		items: make([]*Item, 0, n),
+		index: make(map[string]int, n),
+	}
+	for i := uint64(0); i < n; i++ {
+		item := &Item{
+			Item:  d.string(),
+			Count: d.uvarint(),
+		}
+		if _, ok := t.heap.index[item.Item]; ok {
+			return nil, ErrCorrupted
+		}
+		t.heap.index[item.Item] = len(t.heap.items)
+		t.heap.items = append(t.heap.items, item)
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	// no-op for valid data
+	heap.Init(t.heap)
+	return t, nil
+}
@@ -0,0 +1,99 @@
+package sketch
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	k := 10
+	topK, err := NewTopK(uint64(k), 100, 5, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, exact := zipfStream(100000)
+	for _, item := range stream {
+		topK.IncrBy(item, 1)
+	}
+	items := make([]string, 0, len(exact))
+	for item := range exact {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return exact[items[i]] > exact[items[j]]
+	})
+	list := topK.List()
+	if len(list) != k {
+		t.Fatalf("expected %d items, actually %d", k, len(list))
+	}
+	hit := 0
+	for _, item := range items[:k] {
+		if topK.Query(item) {
+			hit++
+		}
+	}
+	if hit < k*8/10 {
+		t.Errorf("only %d of top %d items found", hit, k)
+	}
+	// heavy hitters are counted accurately
+	for _, item := range list[:3] {
+		count := exact[item.Item]
+		if item.Count > count || float64(item.Count) < float64(count)*0.9 {
+			t.Errorf("estimation %d of %s is far from real count %d", item.Count, item.Item, count)
+		}
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i].Count > list[i-1].Count {
+			t.Error("list is not in descending order")
+		}
+	}
+}
+
+func TestTopKExpel(t *testing.T) {
+	topK, _ := NewTopK(2, 8, 7, 0.9)
+	topK.IncrBy("a", 10)
+	topK.IncrBy("b", 5)
+	if _, expelled := topK.IncrBy("c", 1); expelled {
+		t.Error("c should not enter top k")
+	}
+	expelled, ok := topK.IncrBy("c", 100)
+	if !ok || expelled != "b" {
+		t.Errorf("expected b expelled, actually %s", expelled)
+	}
+	if !topK.Query("c") || topK.Query("b") {
+		t.Error("expected c instead of b in top k")
+	}
+}
+
+func TestTopKMarshal(t *testing.T) {
+	t1, _ := NewTopK(5, 10, 3, 0.9)
+	t2, _ := NewTopK(5, 10, 3, 0.9)
+	stream, _ := zipfStream(2000)
+	for i, item := range stream {
+		t1.IncrBy(item, uint64(i%3))
+		t2.IncrBy(item, uint64(i%3))
+	}
+	data := t1.Marshal()
+	// same operations build the same sketch
+	if !bytes.Equal(t2.Marshal(), data) {
+		t.Error("sketch is not deterministic")
+	}
+	t3, err := UnmarshalTopK(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		t1.IncrBy(strconv.Itoa(i), 1)
+		t3.IncrBy(strconv.Itoa(i), 1)
+	}
+	if !bytes.Equal(t3.Marshal(), t1.Marshal()) {
+		t.Error("restored sketch is different")
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := UnmarshalTopK(data[:i]); err == nil {
+			t.Errorf("expected error for truncated data of length %d", i)
+		}
+	}
+}
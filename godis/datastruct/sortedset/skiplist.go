@@ -225,7 +225,11 @@ func (skiplist *skiplist) getByRank(rank int64) *node {
 
 func (skiplist *skiplist) hasInRange(min *ScoreBorder, max *ScoreBorder) bool {
 	// min & max = empty
-	if min.Value > max.Value || (min.Value == max.Value && (min.Exclude || max.Exclude)) {
+	if min.Inf == positiveInf || max.Inf == negativeInf {
+		return false
+	}
+	if min.Inf == 0 && max.Inf == 0 &&
+		(min.Value > max.Value || (min.Value == max.Value && (min.Exclude || max.Exclude))) {
 		return false
 	}
 	// min > tail
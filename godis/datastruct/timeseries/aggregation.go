@@ -0,0 +1,99 @@
+package timeseries
+
+import (
+	"math"
+	"strings"
+)
+
+// Aggregation is the function used to downsample values in a bucket
+type Aggregation int
+
+// aggregations supported
+const (
+	AggAvg Aggregation = iota
+	AggSum
+	AggMin
+	AggMax
+	AggCount
+)
+
+var aggregationNames = []string{"avg", "sum", "min", "max", "count"}
+
+// ParseAggregation returns aggregation of the given name, it is case insensitive
+func ParseAggregation(name string) (Aggregation, bool) {
+	name = strings.ToLower(name)
+	for i, n := range aggregationNames {
+		if n == name {
+			return Aggregation(i), true
+		}
+	}
+	return 0, false
+}
+
+func (agg Aggregation) String() string {
+	return aggregationNames[agg]
+}
+
+type aggregator struct {
+	kind  Aggregation
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func (a *aggregator) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+func (a *aggregator) value() float64 {
+	switch a.kind {
+	case AggAvg:
+		if a.count == 0 {
+			return math.NaN()
+		}
+		return a.sum / float64(a.count)
+	case AggSum:
+		return a.sum
+	case AggMin:
+		return a.min
+	case AggMax:
+		return a.max
+	}
+	return float64(a.count)
+}
+
+// bucketStart returns the start of the bucket where timestamp is, buckets are aligned to 0
+func bucketStart(timestamp int64, duration int64) int64 {
+	return timestamp - timestamp%duration
+}
+
+// Aggregate downsamples sorted samples into buckets of duration, each result is at the start of its bucket
+func Aggregate(samples []Sample, kind Aggregation, duration int64) []Sample {
+	var result []Sample
+	var agg *aggregator
+	var start int64
+	for _, s := range samples {
+		b := bucketStart(s.Timestamp, duration)
+		if agg != nil && b != start {
+			result = append(result, Sample{Timestamp: start, Value: agg.value()})
+			agg = nil
+		}
+		if agg == nil {
+			agg = &aggregator{kind: kind}
+			start = b
+		}
+		agg.add(s.Value)
+	}
+	if agg != nil {
+		result = append(result, Sample{Timestamp: start, Value: agg.value()})
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package timeseries
+
+import (
+	"math"
+	"sort"
+)
+
+// Sample is a value at a timestamp in milliseconds
+type Sample struct {
+	Timestamp int64
+	Value     float64
+}
+
+// chunk stores samples in ascending order of timestamp. Like Gorilla, timestamps are encoded as
+// delta of delta and values are encoded as xor with the previous one, but aligned to bytes
+type chunk struct {
+	data      []byte
+	count     int
+	first     int64
+	last      int64
+	lastDelta int64
+	lastBits  uint64
+}
+
+// append adds a sample whose timestamp is greater than the last one
+func (c *chunk) append(s Sample) {
+	bits := math.Float64bits(s.Value)
+	if c.count == 0 {
+		c.data = appendVarint(c.data, s.Timestamp)
+		c.first = s.Timestamp
+	} else {
+		delta := s.Timestamp - c.last
+		c.data = appendVarint(c.data, delta-c.lastDelta)
+		c.lastDelta = delta
+	}
+	c.data = appendXor(c.data, bits^c.lastBits)
+	c.last = s.Timestamp
+	c.lastBits = bits
+	c.count++
+}
+
+// samples decodes all samples in chunk
+func (c *chunk) samples() []Sample {
+	// data written by append is always valid
+	samples, _ := decodeChunk(c.data, c.count)
+	return samples
+}
+
+func decodeChunk(data []byte, count int) ([]Sample, error) {
+	d := &decoder{data: data}
+	samples := make([]Sample, 0, count)
+	var timestamp, delta int64
+	var valueBits uint64
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			timestamp = d.varint()
+		} else {
+			delta += d.varint()
+			if delta <= 0 {
+				return nil, ErrCorrupted
+			}
+			timestamp += delta
+		}
+		valueBits ^= d.xor()
+		if d.err != nil {
+			return nil, d.err
+		}
+		samples = append(samples, Sample{Timestamp: timestamp, Value: math.Float64frombits(valueBits)})
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// encodeChunks splits sorted samples into chunks of about size bytes
+func encodeChunks(samples []Sample, size int) []*chunk {
+	var chunks []*chunk
+	var current *chunk
+	for _, s := range samples {
+		if current == nil || len(current.data) >= size {
+			current = &chunk{}
+			chunks = append(chunks, current)
+		}
+		current.append(s)
+	}
+	return chunks
+}
+
+// searchSample returns the index of the first sample not before timestamp
+func searchSample(samples []Sample, timestamp int64) int {
+	return sort.Search(len(samples), func(i int) bool {
+		return samples[i].Timestamp >= timestamp
+	})
+}
@@ -0,0 +1,135 @@
+package timeseries
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// ErrCorrupted is returned when restoring a series from broken data
+var ErrCorrupted = errors.New("corrupted time series data")
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(tmp[:], n)
+	return append(buf, tmp[:size]...)
+}
+
+func appendVarint(buf []byte, n int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	size := binary.PutVarint(tmp[:], n)
+	return append(buf, tmp[:size]...)
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(f))
+	return append(buf, tmp[:]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendXor encodes xor of adjacent values. Zero takes 1 byte, otherwise a header byte tells
+// how many leading and trailing zero bytes are omitted, followed by the meaningful bytes
+func appendXor(buf []byte, x uint64) []byte {
+	if x == 0 {
+		return append(buf, 0)
+	}
+	lead := bits.LeadingZeros64(x) / 8
+	trail := bits.TrailingZeros64(x) / 8
+	buf = append(buf, byte(0x80|lead<<4|trail))
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], x)
+	return append(buf, tmp[lead:8-trail]...)
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.data = d.data[size:]
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(d.data)
+	if size <= 0 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.data = d.data[size:]
+	return n
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.err = ErrCorrupted
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) float() float64 {
+	b := d.bytes(8)
+	if d.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+func (d *decoder) xor() uint64 {
+	header := d.bytes(1)
+	if d.err != nil || header[0] == 0 {
+		return 0
+	}
+	lead := int(header[0]>>4) & 0x7
+	trail := int(header[0]) & 0xf
+	if header[0]&0x80 == 0 || lead+trail >= 8 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	b := d.bytes(uint64(8 - lead - trail))
+	if d.err != nil {
+		return 0
+	}
+	var tmp [8]byte
+	copy(tmp[lead:], b)
+	return binary.BigEndian.Uint64(tmp[:])
+}
+
+// end reports error if data is broken or not consumed entirely
+func (d *decoder) end() error {
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.data) > 0 {
+		return ErrCorrupted
+	}
+	return nil
+}
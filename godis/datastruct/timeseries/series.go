@@ -0,0 +1,559 @@
+package timeseries
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+)
+
+// DefaultChunkSize is the default size in bytes of a chunk
+const DefaultChunkSize = 4096
+
+var (
+	// ErrDuplicate is returned when adding a sample at an existing timestamp with PolicyBlock
+	ErrDuplicate = errors.New("duplicate sample is blocked")
+	// ErrTooOld is returned when adding a sample older than retention
+	ErrTooOld = errors.New("timestamp is older than retention")
+)
+
+// DuplicatePolicy decides what to do when adding a sample at an existing timestamp
+type DuplicatePolicy int
+
+// duplicate policies supported
+const (
+	// PolicyBlock rejects the new sample
+	PolicyBlock DuplicatePolicy = iota
+	// PolicyFirst ignores the new sample
+	PolicyFirst
+	// PolicyLast overrides with the new sample
+	PolicyLast
+	// PolicyMin keeps the smaller value
+	PolicyMin
+	// PolicyMax keeps the larger value
+	PolicyMax
+	// PolicySum keeps the sum of both
+	PolicySum
+)
+
+var policyNames = []string{"block", "first", "last", "min", "max", "sum"}
+
+// ParseDuplicatePolicy returns policy of the given name, it is case insensitive
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, bool) {
+	name = strings.ToLower(name)
+	for i, n := range policyNames {
+		if n == name {
+			return DuplicatePolicy(i), true
+		}
+	}
+	return 0, false
+}
+
+func (p DuplicatePolicy) String() string {
+	return policyNames[p]
+}
+
+// merge returns the value kept by policy
+func (p DuplicatePolicy) merge(old, new float64) (float64, error) {
+	switch p {
+	case PolicyBlock:
+		return 0, ErrDuplicate
+	case PolicyFirst:
+		return old, nil
+	case PolicyMin:
+		return math.Min(old, new), nil
+	case PolicyMax:
+		return math.Max(old, new), nil
+	case PolicySum:
+		return old + new, nil
+	}
+	return new, nil
+}
+
+// Label is a name-value pair attached to a series
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Options of a new series
+type Options struct {
+	// Retention is the max age in milliseconds of samples compared to the last one, 0 means forever
+	Retention int64
+	ChunkSize int
+	Policy    DuplicatePolicy
+	Labels    []Label
+}
+
+// Rule downsamples samples of a series into the destination series
+type Rule struct {
+	DestKey        string
+	Aggregation    Aggregation
+	BucketDuration int64
+	// open bucket which has not been written into destination
+	open        bool
+	bucketStart int64
+	agg         aggregator
+}
+
+// Compaction is a sample to be written into the destination series of a rule
+type Compaction struct {
+	DestKey string
+	Sample  Sample
+}
+
+// Series is a time series stored in compressed chunks.
+// It is concurrent safe, since compaction rules write into destination series whose keys are not locked
+type Series struct {
+	mu        sync.RWMutex
+	retention int64
+	chunkSize int
+	policy    DuplicatePolicy
+	labels    []Label
+	// sourceKey is the key of series which is downsampled into this one
+	sourceKey string
+	rules     []*Rule
+	chunks    []*chunk
+}
+
+// New creates an empty series
+func New(opts Options) *Series {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	return &Series{
+		retention: opts.Retention,
+		chunkSize: opts.ChunkSize,
+		policy:    opts.Policy,
+		labels:    opts.Labels,
+	}
+}
+
+// Retention returns max age of samples in milliseconds
+func (ts *Series) Retention() int64 {
+	return ts.retention
+}
+
+// ChunkSize returns size in bytes of chunks
+func (ts *Series) ChunkSize() int {
+	return ts.chunkSize
+}
+
+// Policy returns the default duplicate policy
+func (ts *Series) Policy() DuplicatePolicy {
+	return ts.policy
+}
+
+// Labels returns labels of series, the result should not be modified
+func (ts *Series) Labels() []Label {
+	return ts.labels
+}
+
+// Label returns value of label, returns false if not found
+func (ts *Series) Label(name string) (string, bool) {
+	for _, l := range ts.labels {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+// Len returns the number of samples stored
+func (ts *Series) Len() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	n := 0
+	for _, c := range ts.chunks {
+		n += c.count
+	}
+	return n
+}
+
+// ChunkCount returns the number of chunks
+func (ts *Series) ChunkCount() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return len(ts.chunks)
+}
+
+// MemoryUsage returns estimated bytes used by samples
+func (ts *Series) MemoryUsage() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	size := 0
+	for _, c := range ts.chunks {
+		size += cap(c.data)
+	}
+	return size
+}
+
+// First returns the first sample, returns false if series is empty
+func (ts *Series) First() (Sample, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if len(ts.chunks) == 0 {
+		return Sample{}, false
+	}
+	return ts.chunks[0].samples()[0], true
+}
+
+// Last returns the last sample, returns false if series is empty
+func (ts *Series) Last() (Sample, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.last()
+}
+
+func (ts *Series) last() (Sample, bool) {
+	if len(ts.chunks) == 0 {
+		return Sample{}, false
+	}
+	c := ts.chunks[len(ts.chunks)-1]
+	return Sample{Timestamp: c.last, Value: math.Float64frombits(c.lastBits)}, true
+}
+
+// Add puts a sample into series, policy decides what to do if there is a sample at the same timestamp.
+// It returns samples downsampled by rules to be written into destination series
+func (ts *Series) Add(s Sample, policy DuplicatePolicy) ([]Compaction, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	last, ok := ts.last()
+	if ok && s.Timestamp <= last.Timestamp {
+		if ts.retention > 0 && s.Timestamp < last.Timestamp-ts.retention {
+			return nil, ErrTooOld
+		}
+		if err := ts.upsert(s, policy); err != nil {
+			return nil, err
+		}
+		return ts.compactPast(s.Timestamp), nil
+	}
+	ts.append(s)
+	compactions := ts.compactNew(s)
+	ts.trim()
+	return compactions, nil
+}
+
+func (ts *Series) append(s Sample) {
+	var c *chunk
+	if len(ts.chunks) > 0 {
+		c = ts.chunks[len(ts.chunks)-1]
+	}
+	if c == nil || len(c.data) >= ts.chunkSize {
+		c = &chunk{}
+		ts.chunks = append(ts.chunks, c)
+	}
+	c.append(s)
+}
+
+// upsert inserts or updates a sample not after the last one, the chunk containing it is encoded again
+func (ts *Series) upsert(s Sample, policy DuplicatePolicy) error {
+	i := 0
+	for i < len(ts.chunks)-1 && ts.chunks[i].last < s.Timestamp {
+		i++
+	}
+	samples := ts.chunks[i].samples()
+	j := searchSample(samples, s.Timestamp)
+	if j < len(samples) && samples[j].Timestamp == s.Timestamp {
+		val, err := policy.merge(samples[j].Value, s.Value)
+		if err != nil {
+			return err
+		}
+		samples[j].Value = val
+	} else {
+		samples = append(samples, Sample{})
+		copy(samples[j+1:], samples[j:])
+		samples[j] = s
+	}
+	chunks := make([]*chunk, 0, len(ts.chunks)+1)
+	chunks = append(chunks, ts.chunks[:i]...)
+	chunks = append(chunks, encodeChunks(samples, ts.chunkSize)...)
+	ts.chunks = append(chunks, ts.chunks[i+1:]...)
+	return nil
+}
+
+// trim removes chunks whose samples are all older than retention
+func (ts *Series) trim() {
+	if ts.retention <= 0 {
+		return
+	}
+	minTimestamp := ts.chunks[len(ts.chunks)-1].last - ts.retention
+	i := 0
+	for ts.chunks[i].last < minTimestamp {
+		i++
+	}
+	ts.chunks = ts.chunks[i:]
+}
+
+// Range returns samples within [from, to] in ascending order
+func (ts *Series) Range(from, to int64) []Sample {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.rangeOf(from, to)
+}
+
+func (ts *Series) rangeOf(from, to int64) []Sample {
+	if len(ts.chunks) == 0 {
+		return nil
+	}
+	if ts.retention > 0 {
+		// chunks are trimmed as a whole, so there may be expired samples
+		minTimestamp := ts.chunks[len(ts.chunks)-1].last - ts.retention
+		if from < minTimestamp {
+			from = minTimestamp
+		}
+	}
+	var result []Sample
+	for _, c := range ts.chunks {
+		if c.last < from {
+			continue
+		}
+		if c.first > to {
+			break
+		}
+		for _, s := range c.samples() {
+			if s.Timestamp >= from && s.Timestamp <= to {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+func (ts *Series) aggregate(kind Aggregation, start, duration int64) aggregator {
+	agg := aggregator{kind: kind}
+	for _, s := range ts.rangeOf(start, start+duration-1) {
+		agg.add(s.Value)
+	}
+	return agg
+}
+
+// compactNew updates rules with a sample appended, a bucket is written into destination once a newer bucket starts
+func (ts *Series) compactNew(s Sample) []Compaction {
+	var result []Compaction
+	for _, rule := range ts.rules {
+		start := bucketStart(s.Timestamp, rule.BucketDuration)
+		if rule.open && start == rule.bucketStart {
+			rule.agg.add(s.Value)
+			continue
+		}
+		if rule.open {
+			result = append(result, Compaction{
+				DestKey: rule.DestKey,
+				Sample:  Sample{Timestamp: rule.bucketStart, Value: rule.agg.value()},
+			})
+		}
+		rule.open = true
+		rule.bucketStart = start
+		rule.agg = ts.aggregate(rule.Aggregation, start, rule.BucketDuration)
+	}
+	return result
+}
+
+// compactPast updates rules with a sample changed before the last one,
+// a closed bucket is aggregated again and written into destination
+func (ts *Series) compactPast(timestamp int64) []Compaction {
+	var result []Compaction
+	for _, rule := range ts.rules {
+		start := bucketStart(timestamp, rule.BucketDuration)
+		agg := ts.aggregate(rule.Aggregation, start, rule.BucketDuration)
+		if rule.open && start == rule.bucketStart {
+			rule.agg = agg
+			continue
+		}
+		if rule.open && start > rule.bucketStart {
+			// the bucket is opened by the next sample appended
+			continue
+		}
+		result = append(result, Compaction{
+			DestKey: rule.DestKey,
+			Sample:  Sample{Timestamp: start, Value: agg.value()},
+		})
+	}
+	return result
+}
+
+// SourceKey returns key of the series downsampled into this one
+func (ts *Series) SourceKey() string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.sourceKey
+}
+
+// SetSourceKey sets key of the series downsampled into this one, empty key means none
+func (ts *Series) SetSourceKey(key string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.sourceKey = key
+}
+
+// Rules returns copies of compaction rules
+func (ts *Series) Rules() []Rule {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	result := make([]Rule, len(ts.rules))
+	for i, rule := range ts.rules {
+		result[i] = *rule
+	}
+	return result
+}
+
+// HasRule tells whether there is a rule writing into destKey
+func (ts *Series) HasRule(destKey string) bool {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for _, rule := range ts.rules {
+		if rule.DestKey == destKey {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateRule adds a compaction rule, samples before it are not downsampled
+func (ts *Series) CreateRule(destKey string, kind Aggregation, duration int64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.rules = append(ts.rules, &Rule{
+		DestKey:        destKey,
+		Aggregation:    kind,
+		BucketDuration: duration,
+	})
+}
+
+// DeleteRule removes rule writing into destKey, returns false if not found
+func (ts *Series) DeleteRule(destKey string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for i, rule := range ts.rules {
+		if rule.DestKey == destKey {
+			ts.rules = append(ts.rules[:i], ts.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal encodes series including its rules into bytes
+func (ts *Series) Marshal() []byte {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	buf := make([]byte, 0, 64)
+	buf = appendVarint(buf, ts.retention)
+	buf = appendUvarint(buf, uint64(ts.chunkSize))
+	buf = appendUvarint(buf, uint64(ts.policy))
+	buf = appendUvarint(buf, uint64(len(ts.labels)))
+	for _, l := range ts.labels {
+		buf = appendString(buf, l.Name)
+		buf = appendString(buf, l.Value)
+	}
+	buf = appendString(buf, ts.sourceKey)
+	buf = appendUvarint(buf, uint64(len(ts.rules)))
+	for _, rule := range ts.rules {
+		buf = appendString(buf, rule.DestKey)
+		buf = appendUvarint(buf, uint64(rule.Aggregation))
+		buf = appendVarint(buf, rule.BucketDuration)
+		if !rule.open {
+			buf = append(buf, 0)
+			continue
+		}
+		buf = append(buf, 1)
+		buf = appendVarint(buf, rule.bucketStart)
+		buf = appendVarint(buf, rule.agg.count)
+		buf = appendFloat(buf, rule.agg.sum)
+		buf = appendFloat(buf, rule.agg.min)
+		buf = appendFloat(buf, rule.agg.max)
+	}
+	buf = appendUvarint(buf, uint64(len(ts.chunks)))
+	for _, c := range ts.chunks {
+		buf = appendUvarint(buf, uint64(c.count))
+		buf = appendString(buf, string(c.data))
+	}
+	return buf
+}
+
+// Unmarshal restores series encoded by Marshal
+func Unmarshal(data []byte) (*Series, error) {
+	d := &decoder{data: data}
+	ts := &Series{
+		retention: d.varint(),
+		chunkSize: int(d.uvarint()),
+		policy:    DuplicatePolicy(d.uvarint()),
+	}
+	labelCount := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	// each label takes at least 2 bytes
+	if ts.retention < 0 || ts.chunkSize <= 0 || ts.policy > PolicySum || labelCount > uint64(len(d.data))/2 {
+		return nil, ErrCorrupted
+	}
+	for i := uint64(0); i < labelCount; i++ {
+		ts.labels = append(ts.labels, Label{Name: d.string(), Value: d.string()})
+	}
+	ts.sourceKey = d.string()
+	ruleCount := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if ruleCount > uint64(len(d.data))/4 {
+		return nil, ErrCorrupted
+	}
+	for i := uint64(0); i < ruleCount; i++ {
+		rule := &Rule{
+			DestKey:        d.string(),
+			Aggregation:    Aggregation(d.uvarint()),
+			BucketDuration: d.varint(),
+		}
+		open := d.bytes(1)
+		if d.err != nil {
+			return nil, d.err
+		}
+		if rule.DestKey == "" || rule.Aggregation > AggCount || rule.BucketDuration <= 0 || open[0] > 1 {
+			return nil, ErrCorrupted
+		}
+		rule.agg.kind = rule.Aggregation
+		if open[0] == 1 {
+			rule.open = true
+			rule.bucketStart = d.varint()
+			rule.agg.count = d.varint()
+			rule.agg.sum = d.float()
+			rule.agg.min = d.float()
+			rule.agg.max = d.float()
+		}
+		ts.rules = append(ts.rules, rule)
+	}
+	chunkCount := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if chunkCount > uint64(len(d.data))/2 {
+		return nil, ErrCorrupted
+	}
+	// chunks are encoded again to validate samples and restore states for appending
+	var last *Sample
+	for i := uint64(0); i < chunkCount; i++ {
+		count := d.uvarint()
+		chunkData := d.bytes(d.uvarint())
+		if d.err != nil {
+			return nil, d.err
+		}
+		// each sample takes at least 2 bytes
+		if count == 0 || count > uint64(len(chunkData))/2 {
+			return nil, ErrCorrupted
+		}
+		decoded, err := decodeChunk(chunkData, int(count))
+		if err != nil {
+			return nil, err
+		}
+		if last != nil && decoded[0].Timestamp <= last.Timestamp {
+			return nil, ErrCorrupted
+		}
+		last = &decoded[len(decoded)-1]
+		ts.chunks = append(ts.chunks, encodeChunks(decoded, math.MaxInt32)...)
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
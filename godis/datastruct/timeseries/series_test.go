@@ -0,0 +1,161 @@
+package timeseries
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var samples []Sample
+	timestamp := int64(1600000000000)
+	for i := 0; i < 1000; i++ {
+		timestamp += int64(r.Intn(3) + 1000)
+		value := float64(r.Intn(100))
+		if i%10 == 0 {
+			value = r.Float64()
+		}
+		samples = append(samples, Sample{Timestamp: timestamp, Value: value})
+	}
+	samples = append(samples, Sample{Timestamp: timestamp + 1, Value: math.Inf(-1)})
+	chunks := encodeChunks(samples, 256)
+	var decoded []Sample
+	size := 0
+	for _, c := range chunks {
+		decoded = append(decoded, c.samples()...)
+		size += len(c.data)
+	}
+	if !reflect.DeepEqual(samples, decoded) {
+		t.Error("decoded samples are not the same")
+	}
+	// a raw sample takes 16 bytes
+	if size > len(samples)*16/3 {
+		t.Errorf("samples are not compressed well, %d bytes", size)
+	}
+}
+
+func TestAddAndRange(t *testing.T) {
+	ts := New(Options{ChunkSize: 32})
+	for i := 0; i < 100; i++ {
+		if _, err := ts.Add(Sample{Timestamp: int64(i * 10), Value: float64(i)}, PolicyBlock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// out of order and duplicated samples
+	if _, err := ts.Add(Sample{Timestamp: 55, Value: 100}, PolicyBlock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Add(Sample{Timestamp: 50, Value: 100}, PolicyBlock); err != ErrDuplicate {
+		t.Errorf("expected duplicate error, actually %v", err)
+	}
+	if _, err := ts.Add(Sample{Timestamp: 50, Value: 3}, PolicySum); err != nil {
+		t.Fatal(err)
+	}
+	if ts.Len() != 101 || ts.ChunkCount() < 2 {
+		t.Errorf("wrong size %d, chunks %d", ts.Len(), ts.ChunkCount())
+	}
+	samples := ts.Range(40, 70)
+	expected := []Sample{{40, 4}, {50, 8}, {55, 100}, {60, 6}, {70, 7}}
+	if !reflect.DeepEqual(samples, expected) {
+		t.Errorf("wrong range %v", samples)
+	}
+	aggregated := Aggregate(ts.Range(0, 99), AggAvg, 50)
+	expected = []Sample{{0, 2}, {50, 23}}
+	if !reflect.DeepEqual(aggregated, expected) {
+		t.Errorf("wrong aggregation %v", aggregated)
+	}
+	first, _ := ts.First()
+	last, _ := ts.Last()
+	if first.Timestamp != 0 || last.Timestamp != 990 || last.Value != 99 {
+		t.Errorf("wrong first %v or last %v", first, last)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ts := New(Options{Retention: 100, ChunkSize: 16})
+	for i := 0; i < 100; i++ {
+		_, _ = ts.Add(Sample{Timestamp: int64(i * 10), Value: float64(i)}, PolicyLast)
+	}
+	if _, err := ts.Add(Sample{Timestamp: 880, Value: 1}, PolicyLast); err != ErrTooOld {
+		t.Errorf("expected too old error, actually %v", err)
+	}
+	samples := ts.Range(0, 1000)
+	if len(samples) != 11 || samples[0].Timestamp != 890 {
+		t.Errorf("wrong samples %v", samples)
+	}
+	if ts.Len() > 30 {
+		t.Errorf("expired chunks are not removed, %d samples", ts.Len())
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	ts := New(Options{})
+	ts.CreateRule("dest", AggSum, 10)
+	var result []Sample
+	add := func(timestamp int64, value float64) {
+		compactions, err := ts.Add(Sample{Timestamp: timestamp, Value: value}, PolicyLast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range compactions {
+			if c.DestKey != "dest" {
+				t.Errorf("wrong dest %s", c.DestKey)
+			}
+			result = append(result, c.Sample)
+		}
+	}
+	add(1, 1)
+	add(5, 2)
+	add(12, 3)
+	if !reflect.DeepEqual(result, []Sample{{0, 3}}) {
+		t.Errorf("wrong compaction %v", result)
+	}
+	// change a closed bucket and the open bucket
+	add(5, 5)
+	add(15, 1)
+	add(25, 1)
+	expected := []Sample{{0, 3}, {0, 6}, {10, 4}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("wrong compaction %v", result)
+	}
+	if !ts.DeleteRule("dest") || len(ts.Rules()) != 0 {
+		t.Error("rule is not deleted")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	ts := New(Options{
+		Retention: 1000,
+		ChunkSize: 16,
+		Policy:    PolicyMax,
+		Labels:    []Label{{"a", "1"}, {"b", "2"}},
+	})
+	ts.CreateRule("dest", AggMax, 100)
+	ts.CreateRule("dest2", AggCount, 1000)
+	for i := 0; i < 50; i++ {
+		_, _ = ts.Add(Sample{Timestamp: int64(i * 7), Value: float64(i % 13)}, PolicyBlock)
+	}
+	data := ts.Marshal()
+	ts2, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ts.Range(0, 1000), ts2.Range(0, 1000)) || !reflect.DeepEqual(ts.Rules(), ts2.Rules()) ||
+		!reflect.DeepEqual(ts.Labels(), ts2.Labels()) || ts2.Policy() != PolicyMax || ts2.ChunkCount() != ts.ChunkCount() {
+		t.Error("restored series is not the same")
+	}
+	// restored series keeps appending and compacting
+	c1, _ := ts.Add(Sample{Timestamp: 400, Value: 1}, PolicyBlock)
+	c2, _ := ts2.Add(Sample{Timestamp: 400, Value: 1}, PolicyBlock)
+	if len(c1) != 1 || !reflect.DeepEqual(c1, c2) {
+		t.Errorf("wrong compactions %v %v", c1, c2)
+	}
+	for i := 0; i < len(data); i++ {
+		_, _ = Unmarshal(data[:i])
+	}
+	if _, err := Unmarshal(data[:len(data)-1]); err == nil {
+		t.Error("expected error")
+	}
+}
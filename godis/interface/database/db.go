@@ -22,6 +22,8 @@ type EmbedDB interface {
 	ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLines []CmdLine) redis.Reply
 	GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine
 	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
+	// GetIndexDefs returns commands recreating secondary indexes, they should be executed after data loaded
+	GetIndexDefs(dbIndex int) []CmdLine
 	RWLocks(dbIndex int, writeKeys []string, readKeys []string)
 	RWUnLocks(dbIndex int, writeKeys []string, readKeys []string)
 }
@@ -30,3 +32,11 @@ type EmbedDB interface {
 type DataEntity struct {
 	Data interface{}
 }
+
+// CustomValue is implemented by values of types registered by modules
+type CustomValue interface {
+	// TypeName is name of the registered type, which is returned by TYPE command
+	TypeName() string
+	// Marshal serializes the value for aof, snapshot and DUMP
+	Marshal() []byte
+}
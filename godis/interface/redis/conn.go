@@ -23,4 +23,7 @@ type Connection interface {
 	// used for multi database
 	GetDBIndex() int
 	SelectDB(int)
+
+	// tells whether commands are replayed from aof, some internal commands are only accepted from aof
+	IsReplaying() bool
 }
@@ -13,3 +13,9 @@ type Handler interface {
 	Handle(ctx context.Context, conn net.Conn)
 	Close() error
 }
+
+// Shutdowner is implemented by Handler which could ask server to stop, for example by SHUTDOWN command
+type Shutdowner interface {
+	// ShutdownRequested returns a channel closed when server should stop
+	ShutdownRequested() <-chan struct{}
+}
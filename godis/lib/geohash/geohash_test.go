@@ -3,6 +3,7 @@ package geohash
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -37,3 +38,66 @@ func TestGetNeighbours(t *testing.T) {
 	ranges := GetNeighbours(90, 180, 630*1000)
 	fmt.Printf("%#v", ranges)
 }
+
+// inAreas tells whether the geohash code of coordinate is in one of areas
+func inAreas(areas [][2]uint64, latitude, longitude float64) bool {
+	code := Encode(latitude, longitude)
+	for _, area := range areas {
+		if code >= area[0] && code <= area[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGetAreas(t *testing.T) {
+	centers := [][2]float64{
+		{38.115556, 13.361389},
+		{89.9, 0},         // near north pole
+		{-89.5, 120},      // near south pole
+		{10, 179.99},      // near antimeridian
+		{-45, -179.9},     // near antimeridian
+		{70, 179.5},       // high latitude and antimeridian
+		{0, 0},            // equator and prime meridian
+		{84.9, -179.9999}, // everything
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, center := range centers {
+		for _, radius := range []float64{10, 1000, 50 * 1000, 500 * 1000, 3000 * 1000} {
+			radiusAreas := GetAreasByRadius(center[0], center[1], radius)
+			boxAreas := GetAreasByBox(center[0], center[1], radius*2, radius)
+			if len(radiusAreas) > maxSearchCells || len(boxAreas) > maxSearchCells {
+				t.Errorf("too many areas for %v %f", center, radius)
+			}
+			for i := 0; i < 2000; i++ {
+				// random points around center
+				lat := ensureValidLat(center[0] + (r.Float64()*2-1)*radDeg(radius/earthRadius)*1.2)
+				lng := ensureValidLng(center[1] + (r.Float64()*2-1)*180)
+				if i%2 == 0 {
+					lng = ensureValidLng(center[1] + (r.Float64()*2-1)*radDeg(radius/earthRadius)*3)
+				}
+				if Distance(center[0], center[1], lat, lng) <= radius && !inAreas(radiusAreas, lat, lng) {
+					t.Errorf("%f,%f within %f meters of %v is not in areas", lat, lng, radius, center)
+				}
+				if _, ok := InRectangle(center[0], center[1], radius*2, radius, lat, lng); ok && !inAreas(boxAreas, lat, lng) {
+					t.Errorf("%f,%f in box of %v is not in areas", lat, lng, center)
+				}
+			}
+		}
+	}
+}
+
+func TestInRectangle(t *testing.T) {
+	// 1 degree of latitude is about 111km
+	if _, ok := InRectangle(0, 0, 1000, 300*1000, 1, 0); !ok {
+		t.Error("expected in rectangle")
+	}
+	if _, ok := InRectangle(0, 0, 300*1000, 1000, 1, 0); ok {
+		t.Error("expected not in rectangle")
+	}
+	// crossing the antimeridian
+	dist, ok := InRectangle(0, 179.9, 100*1000, 100*1000, 0, -179.9)
+	if !ok || math.Abs(dist-Distance(0, 179.9, 0, -179.9)) > 1e-6 {
+		t.Error("expected in rectangle")
+	}
+}
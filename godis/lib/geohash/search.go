@@ -0,0 +1,172 @@
+package geohash
+
+import "math"
+
+const (
+	// maxSearchStep is the max bits per dimension of searching cells, so that range borders are exact in float64 scores
+	maxSearchStep = 26
+	// maxSearchCells limits the number of cells to scan in a search
+	maxSearchCells = 9
+)
+
+// boundingBox is a rectangle in degrees, minLng > maxLng means it crosses the antimeridian
+type boundingBox struct {
+	minLat, maxLat float64
+	minLng, maxLng float64
+	// allLng is set if the box covers a pole, so every longitude is included
+	allLng bool
+}
+
+// radiusBoundingBox returns the smallest box containing the circle
+func radiusBoundingBox(latitude, longitude, radiusMeters float64) *boundingBox {
+	angle := radiusMeters / earthRadius
+	box := &boundingBox{
+		minLat: latitude - radDeg(angle),
+		maxLat: latitude + radDeg(angle),
+	}
+	if box.maxLat >= 90 || box.minLat <= -90 || angle >= math.Pi/2 {
+		box.allLng = true
+	} else {
+		// the widest part of a circle on sphere is not on the parallel of its center
+		sinDelta := math.Sin(angle) / math.Cos(degRad(latitude))
+		if sinDelta >= 1 {
+			box.allLng = true
+		} else {
+			box.setLngRange(longitude, radDeg(math.Asin(sinDelta)))
+		}
+	}
+	box.clampLat()
+	return box
+}
+
+// rectangleBoundingBox returns box containing the points matching InRectangle
+func rectangleBoundingBox(latitude, longitude, widthMeters, heightMeters float64) *boundingBox {
+	latDelta := radDeg(heightMeters / 2 / earthRadius)
+	box := &boundingBox{
+		minLat: latitude - latDelta,
+		maxLat: latitude + latDelta,
+	}
+	if box.maxLat >= 90 || box.minLat <= -90 {
+		box.allLng = true
+	} else {
+		// longitude distance is measured between points on the parallel of point, so the parallel nearest to pole is the widest.
+		// By haversine, points on parallel lat with distance d have sin(deltaLng/2) = sin(d/2) / cos(lat)
+		farthest := math.Max(math.Abs(box.minLat), math.Abs(box.maxLat))
+		sinHalfDelta := math.Sin(widthMeters/4/earthRadius) / math.Cos(degRad(farthest))
+		if sinHalfDelta >= 1 || widthMeters/2 >= math.Pi*earthRadius {
+			box.allLng = true
+		} else {
+			box.setLngRange(longitude, radDeg(2*math.Asin(sinHalfDelta)))
+		}
+	}
+	box.clampLat()
+	return box
+}
+
+func (box *boundingBox) setLngRange(longitude, lngDelta float64) {
+	if lngDelta >= 180 {
+		box.allLng = true
+		return
+	}
+	box.minLng = ensureValidLng(longitude - lngDelta)
+	box.maxLng = ensureValidLng(longitude + lngDelta)
+}
+
+func (box *boundingBox) clampLat() {
+	box.minLat = ensureValidLat(box.minLat)
+	box.maxLat = ensureValidLat(box.maxLat)
+}
+
+// cellIndex returns index of the cell containing val at step, cells split [min, max) into 1<<step parts
+func cellIndex(val, min, max float64, step uint) uint64 {
+	n := uint64(1) << step
+	idx := math.Floor((val - min) / (max - min) * float64(n))
+	if idx < 0 {
+		return 0
+	}
+	if idx >= float64(n) {
+		return n - 1
+	}
+	return uint64(idx)
+}
+
+// cells returns the index ranges of cells covering box at step,
+// lng range may wrap around, lngCount is the number of columns
+func (box *boundingBox) cells(step uint) (latFrom, latTo, lngFrom, lngCount uint64) {
+	latFrom = cellIndex(box.minLat, -90, 90, step)
+	latTo = cellIndex(box.maxLat, -90, 90, step)
+	n := uint64(1) << step
+	if box.allLng {
+		return latFrom, latTo, 0, n
+	}
+	lngFrom = cellIndex(box.minLng, -180, 180, step)
+	lngTo := cellIndex(box.maxLng, -180, 180, step)
+	// columns from lngFrom to lngTo, wrapping around at the antimeridian
+	lngCount = (lngTo+n-lngFrom)%n + 1
+	if box.minLng > box.maxLng && lngCount == 1 && n > 1 {
+		// box crosses the antimeridian but both sides are in the same column
+		lngCount = n
+	}
+	return latFrom, latTo, lngFrom, lngCount
+}
+
+// interleave makes geohash prefix of 2*step bits, longitude bits are in front like encode0
+func interleave(latIdx, lngIdx uint64, step uint) uint64 {
+	var code uint64
+	for i := int(step) - 1; i >= 0; i-- {
+		code = code<<2 | (lngIdx>>uint(i)&1)<<1 | (latIdx >> uint(i) & 1)
+	}
+	return code
+}
+
+// areas returns geohash code ranges of cells covering box, both ends of range are inclusive.
+// It chooses the finest step at which there are no more than maxSearchCells cells
+func (box *boundingBox) areas() [][2]uint64 {
+	step := uint(maxSearchStep)
+	for ; step > 0; step-- {
+		latFrom, latTo, _, lngCount := box.cells(step)
+		if (latTo-latFrom+1)*lngCount <= maxSearchCells {
+			break
+		}
+	}
+	latFrom, latTo, lngFrom, lngCount := box.cells(step)
+	n := uint64(1) << step
+	shift := 64 - 2*step
+	span := uint64(1)<<shift - 1 // overflows to MaxUint64 at step 0 as expected
+	result := make([][2]uint64, 0, (latTo-latFrom+1)*lngCount)
+	for lat := latFrom; lat <= latTo; lat++ {
+		for i := uint64(0); i < lngCount; i++ {
+			lng := (lngFrom + i) % n
+			lower := interleave(lat, lng, step) << shift
+			result = append(result, [2]uint64{lower, lower + span})
+		}
+	}
+	return result
+}
+
+// GetAreasByRadius returns geohash code ranges covering the circle, both ends of range are inclusive.
+// Areas near poles and the antimeridian are handled
+func GetAreasByRadius(latitude, longitude, radiusMeters float64) [][2]uint64 {
+	return radiusBoundingBox(latitude, longitude, radiusMeters).areas()
+}
+
+// GetAreasByBox returns geohash code ranges covering the rectangle centered at given coordinate,
+// both ends of range are inclusive
+func GetAreasByBox(latitude, longitude, widthMeters, heightMeters float64) [][2]uint64 {
+	return rectangleBoundingBox(latitude, longitude, widthMeters, heightMeters).areas()
+}
+
+// InRectangle tells whether (latitude, longitude) is in the rectangle centered at (centerLat, centerLng),
+// and returns distance between the two points.
+// Like redis, longitude distance is measured along the parallel of the point
+func InRectangle(centerLat, centerLng, widthMeters, heightMeters, latitude, longitude float64) (float64, bool) {
+	latDistance := earthRadius * math.Abs(degRad(latitude-centerLat))
+	if latDistance > heightMeters/2 {
+		return 0, false
+	}
+	lngDistance := Distance(latitude, longitude, latitude, centerLng)
+	if lngDistance > widthMeters/2 {
+		return 0, false
+	}
+	return Distance(centerLat, centerLng, latitude, longitude), true
+}
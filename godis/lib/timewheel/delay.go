@@ -22,3 +22,8 @@ func At(at time.Time, key string, job func()) {
 func Cancel(key string) {
 	tw.RemoveJob(key)
 }
+
+// Reschedule executes the pending job of key after duration instead, returns false if job not found
+func Reschedule(key string, duration time.Duration) bool {
+	return tw.Reschedule(key, duration)
+}
@@ -3,170 +3,397 @@ package timewheel
 import (
 	"container/list"
 	"godis/lib/logger"
+	"math"
+	"sort"
+	"sync"
 	"time"
 )
 
-// TimeWheel can execute job after waiting given duration
+// TimeWheel can execute job after waiting given duration.
+// It is a hierarchical timing wheel: level 0 moves a slot every interval, and a slot of level i covers a full circle
+// of level i-1. Jobs far away sit in upper levels and cascade down as time goes, so they never spin through circles.
+// Upper levels are created when needed. TimeWheel is safe for concurrent use
 type TimeWheel struct {
 	interval time.Duration
-	ticker   *time.Ticker
-	slots    []*list.List
+	slotNum  int64
 
-	timer             map[string]int
-	currentPos        int
-	slotNum           int
-	addTaskChannel    chan task
-	removeTaskChannel chan string
-	stopChannel       chan bool
+	mu     sync.Mutex
+	start  time.Time
+	levels []*level
+	// currentTick is the number of intervals passed since start, jobs expiring not after it have been executed
+	currentTick int64
+	// tasks with key, a key has at most one pending task
+	timer map[string]*task
+	count int
+	hooks *Hooks
+	stats Stats
+
+	// now returns current time, replaced in tests
+	now      func() time.Time
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+type level struct {
+	// ticks is the number of intervals a slot covers
+	ticks int64
+	slots []*list.List
 }
 
 type task struct {
-	delay  time.Duration
-	circle int
-	key    string
-	job    func()
+	key string
+	job func()
+	// expiration is the tick at which job should be executed
+	expiration int64
+	slot       *list.List
+	elem       *list.Element
+}
+
+// Job describes a job to add by AddJobs
+type Job struct {
+	Delay time.Duration
+	Key   string
+	Job   func()
 }
 
-// New creates a new time wheel
+// PendingJob describes a job waiting for execution
+type PendingJob struct {
+	Key string
+	At  time.Time
+}
+
+// Stats is counters of a TimeWheel
+type Stats struct {
+	Pending     int
+	Levels      int
+	Added       uint64
+	Executed    uint64
+	Canceled    uint64
+	Rescheduled uint64
+}
+
+// Hooks observe jobs with key, so that the caller could persist them and add them again after restart.
+// Hooks are called after the change is made, and must not call methods of TimeWheel
+type Hooks struct {
+	// OnAdd is called when a job is added or rescheduled
+	OnAdd func(key string, at time.Time)
+	// OnRemove is called when a job is executed or canceled
+	OnRemove func(key string, executed bool)
+}
+
+// New creates a new time wheel, each level of it has slotNum slots
 func New(interval time.Duration, slotNum int) *TimeWheel {
-	if interval <= 0 || slotNum <= 0 {
+	if interval <= 0 || slotNum <= 1 {
 		return nil
 	}
 	tw := &TimeWheel{
-		interval:          interval,
-		slots:             make([]*list.List, slotNum),
-		timer:             make(map[string]int),
-		currentPos:        0,
-		slotNum:           slotNum,
-		addTaskChannel:    make(chan task),
-		removeTaskChannel: make(chan string),
-		stopChannel:       make(chan bool),
+		interval: interval,
+		slotNum:  int64(slotNum),
+		timer:    make(map[string]*task),
+		now:      time.Now,
+		stopCh:   make(chan struct{}),
 	}
-	tw.initSlots()
-
+	tw.start = tw.now()
+	tw.levels = []*level{tw.makeLevel(1)}
 	return tw
 }
 
-func (tw *TimeWheel) initSlots() {
-	for i := 0; i < tw.slotNum; i++ {
-		tw.slots[i] = list.New()
+func (tw *TimeWheel) makeLevel(ticks int64) *level {
+	l := &level{
+		ticks: ticks,
+		slots: make([]*list.List, tw.slotNum),
+	}
+	for i := range l.slots {
+		l.slots[i] = list.New()
 	}
+	return l
 }
 
 // Start starts ticker for time wheel
 func (tw *TimeWheel) Start() {
-	tw.ticker = time.NewTicker(tw.interval)
-	go tw.start()
+	go func() {
+		ticker := time.NewTicker(tw.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				tw.advance(tw.tickOf(tw.now()))
+			case <-tw.stopCh:
+				return
+			}
+		}
+	}()
 }
 
-// Stop stops the time wheel
+// Stop stops the time wheel, pending jobs will never be executed
 func (tw *TimeWheel) Stop() {
-	tw.stopChannel <- true
+	tw.stopOnce.Do(func() {
+		close(tw.stopCh)
+	})
+}
+
+// SetHooks sets hooks observing jobs with key, nil removes hooks
+func (tw *TimeWheel) SetHooks(hooks *Hooks) {
+	tw.mu.Lock()
+	tw.hooks = hooks
+	tw.mu.Unlock()
+}
+
+// tickOf returns the first tick not before t
+func (tw *TimeWheel) tickOf(t time.Time) int64 {
+	d := t.Sub(tw.start)
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + tw.interval - 1) / tw.interval)
 }
 
-// AddJob add new job into pending queue
+func (tw *TimeWheel) timeOf(tick int64) time.Time {
+	return tw.start.Add(time.Duration(tick) * tw.interval)
+}
+
+// AddJob add new job into pending queue, the job replaces pending job with the same key.
+// Job with negative delay is overdue, it is executed on the next tick
 func (tw *TimeWheel) AddJob(delay time.Duration, key string, job func()) {
-	if delay < 0 {
-		return
+	tw.AddJobs(Job{Delay: delay, Key: key, Job: job})
+}
+
+// AddJobs adds jobs with one lock acquisition
+func (tw *TimeWheel) AddJobs(jobs ...Job) {
+	now := tw.now()
+	var added []*task
+	var due []*task
+	tw.mu.Lock()
+	for _, j := range jobs {
+		if old, ok := tw.timer[j.Key]; ok && j.Key != "" {
+			// replaced by the new one
+			tw.unlink(old)
+			tw.count--
+		}
+		t := &task{
+			key:        j.Key,
+			job:        j.Job,
+			expiration: tw.tickOf(now.Add(j.Delay)),
+		}
+		if j.Delay < 0 {
+			t.expiration = tw.currentTick + 1
+		}
+		tw.stats.Added++
+		tw.count++
+		if t.key != "" {
+			tw.timer[t.key] = t
+			added = append(added, t)
+		}
+		n := len(due)
+		due = tw.insert(t, due)
+		// expire at once, in case of another job with the same key in batch
+		tw.expire(due[n:])
 	}
-	tw.addTaskChannel <- task{delay: delay, key: key, job: job}
+	hooks := tw.hooks
+	tw.mu.Unlock()
+
+	tw.notifyAdd(hooks, added)
+	tw.run(hooks, due)
 }
 
 // RemoveJob add remove job from pending queue
 // if job is done or not found, then nothing happened
 func (tw *TimeWheel) RemoveJob(key string) {
-	if key == "" {
-		return
+	tw.RemoveJobs(key)
+}
+
+// RemoveJobs removes jobs with one lock acquisition, returns the number of jobs removed
+func (tw *TimeWheel) RemoveJobs(keys ...string) int {
+	var removed []string
+	tw.mu.Lock()
+	for _, key := range keys {
+		t, ok := tw.timer[key]
+		if !ok || key == "" {
+			continue
+		}
+		tw.unlink(t)
+		delete(tw.timer, key)
+		tw.count--
+		tw.stats.Canceled++
+		removed = append(removed, key)
 	}
-	tw.removeTaskChannel <- key
-}
-
-func (tw *TimeWheel) start() {
-	for {
-		select {
-		case <-tw.ticker.C:
-			tw.tickHandler()
-		case task := <-tw.addTaskChannel:
-			tw.addTask(&task)
-		case key := <-tw.removeTaskChannel:
-			tw.removeTask(key)
-		case <-tw.stopChannel:
-			tw.ticker.Stop()
-			return
+	hooks := tw.hooks
+	tw.mu.Unlock()
+
+	if hooks != nil && hooks.OnRemove != nil {
+		for _, key := range removed {
+			hooks.OnRemove(key, false)
 		}
 	}
+	return len(removed)
 }
 
-func (tw *TimeWheel) tickHandler() {
-	l := tw.slots[tw.currentPos]
-	tw.scanAndRunTask(l)
-	if tw.currentPos == tw.slotNum-1 {
-		tw.currentPos = 0
-	} else {
-		tw.currentPos++
+// Reschedule executes pending job of key after delay instead, returns false if job not found
+func (tw *TimeWheel) Reschedule(key string, delay time.Duration) bool {
+	if delay < 0 {
+		delay = 0
+	}
+	now := tw.now()
+	tw.mu.Lock()
+	t, ok := tw.timer[key]
+	if !ok || key == "" {
+		tw.mu.Unlock()
+		return false
 	}
+	tw.unlink(t)
+	t.expiration = tw.tickOf(now.Add(delay))
+	tw.stats.Rescheduled++
+	due := tw.insert(t, nil)
+	tw.expire(due)
+	hooks := tw.hooks
+	tw.mu.Unlock()
+
+	tw.notifyAdd(hooks, []*task{t})
+	tw.run(hooks, due)
+	return true
 }
 
-func (tw *TimeWheel) scanAndRunTask(l *list.List) {
-	for e := l.Front(); e != nil; {
-		task := e.Value.(*task)
-		if task.circle > 0 {
-			task.circle--
-			e = e.Next()
-			continue
+// Pending returns pending jobs in order of execution time
+func (tw *TimeWheel) Pending() []PendingJob {
+	tw.mu.Lock()
+	result := make([]PendingJob, 0, tw.count)
+	for _, l := range tw.levels {
+		for _, slot := range l.slots {
+			for e := slot.Front(); e != nil; e = e.Next() {
+				t := e.Value.(*task)
+				result = append(result, PendingJob{
+					Key: t.key,
+					At:  tw.timeOf(t.expiration),
+				})
+			}
 		}
+	}
+	tw.mu.Unlock()
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].At.Before(result[j].At)
+	})
+	return result
+}
 
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					logger.Error(err)
-				}
-			}()
-			job := task.job
-			job()
-		}()
-		next := e.Next()
-		l.Remove(e)
-		if task.key != "" {
-			delete(tw.timer, task.key)
+// Stats returns counters of time wheel
+func (tw *TimeWheel) Stats() Stats {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	stats := tw.stats
+	stats.Pending = tw.count
+	stats.Levels = len(tw.levels)
+	return stats
+}
+
+// insert puts t into the level whose circle covers its expiration, or appends it to due if it expired.
+// tw.mu must be held
+func (tw *TimeWheel) insert(t *task, due []*task) []*task {
+	if t.expiration <= tw.currentTick {
+		return append(due, t)
+	}
+	for i := 0; ; i++ {
+		if i == len(tw.levels) {
+			ticks := tw.levels[i-1].ticks
+			if ticks > math.MaxInt64/tw.slotNum/tw.slotNum {
+				// the top level is large enough for any duration, put t in its farthest slot
+				i--
+				l := tw.levels[i]
+				t.expiration = (tw.currentTick/l.ticks + tw.slotNum - 1) * l.ticks
+				tw.link(t, l.slots[(t.expiration/l.ticks)%tw.slotNum])
+				return due
+			}
+			tw.levels = append(tw.levels, tw.makeLevel(ticks*tw.slotNum))
+		}
+		l := tw.levels[i]
+		if t.expiration/l.ticks-tw.currentTick/l.ticks < tw.slotNum {
+			tw.link(t, l.slots[(t.expiration/l.ticks)%tw.slotNum])
+			return due
 		}
-		e = next
 	}
 }
 
-func (tw *TimeWheel) addTask(task *task) {
-	pos, circle := tw.getPositionAndCircle(task.delay)
-	task.circle = circle
-
-	tw.slots[pos].PushBack(task)
+func (tw *TimeWheel) link(t *task, slot *list.List) {
+	t.slot = slot
+	t.elem = slot.PushBack(t)
+}
 
-	if task.key != "" {
-		tw.timer[task.key] = pos
+func (tw *TimeWheel) unlink(t *task) {
+	if t.slot != nil {
+		t.slot.Remove(t.elem)
+		t.slot = nil
+		t.elem = nil
 	}
 }
 
-func (tw *TimeWheel) getPositionAndCircle(d time.Duration) (pos int, circle int) {
-	delaySeconds := int(d.Seconds())
-	intervalSeconds := int(tw.interval.Seconds())
-	circle = int(delaySeconds / intervalSeconds / tw.slotNum)
-	pos = int(tw.currentPos+delaySeconds/intervalSeconds) % tw.slotNum
+// advance moves the wheel to target tick and executes expired jobs
+func (tw *TimeWheel) advance(target int64) {
+	var due []*task
+	tw.mu.Lock()
+	for tw.currentTick < target {
+		if tw.count == 0 {
+			// nothing to do, jump
+			tw.currentTick = target
+			break
+		}
+		tw.currentTick++
+		// cascade from top so that jobs moved down are cascaded again by lower levels at the same tick
+		for i := len(tw.levels) - 1; i >= 0; i-- {
+			l := tw.levels[i]
+			if tw.currentTick%l.ticks != 0 {
+				continue
+			}
+			slot := l.slots[(tw.currentTick/l.ticks)%tw.slotNum]
+			for e := slot.Front(); e != nil; {
+				next := e.Next()
+				t := e.Value.(*task)
+				slot.Remove(e)
+				t.slot, t.elem = nil, nil
+				due = tw.insert(t, due)
+				e = next
+			}
+		}
+	}
+	tw.expire(due)
+	hooks := tw.hooks
+	tw.mu.Unlock()
 
-	return
+	tw.run(hooks, due)
 }
 
-func (tw *TimeWheel) removeTask(key string) {
-	position, ok := tw.timer[key]
-	if !ok {
-		return
+// expire removes due tasks from pending, tw.mu must be held
+func (tw *TimeWheel) expire(due []*task) {
+	for _, t := range due {
+		tw.count--
+		tw.stats.Executed++
+		if t.key != "" {
+			delete(tw.timer, t.key)
+		}
 	}
-	l := tw.slots[position]
-	for e := l.Front(); e != nil; {
-		task := e.Value.(*task)
-		if task.key == key {
-			delete(tw.timer, task.key)
-			l.Remove(e)
+}
+
+// run executes due jobs, tw.mu must not be held
+func (tw *TimeWheel) run(hooks *Hooks, due []*task) {
+	for _, t := range due {
+		if hooks != nil && hooks.OnRemove != nil && t.key != "" {
+			hooks.OnRemove(t.key, true)
 		}
+		job := t.job
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Error(err)
+				}
+			}()
+			job()
+		}()
+	}
+}
 
-		e = e.Next()
+func (tw *TimeWheel) notifyAdd(hooks *Hooks, added []*task) {
+	if hooks == nil || hooks.OnAdd == nil {
+		return
+	}
+	for _, t := range added {
+		hooks.OnAdd(t.key, tw.timeOf(t.expiration))
 	}
 }
@@ -0,0 +1,207 @@
+package timewheel
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestWheel returns a wheel not started, tests drive it by advance
+func newTestWheel(slotNum int) *TimeWheel {
+	tw := New(time.Second, slotNum)
+	start := tw.start
+	tw.now = func() time.Time {
+		return start
+	}
+	return tw
+}
+
+func TestHierarchical(t *testing.T) {
+	tw := newTestWheel(60)
+	delays := []int64{1, 2, 59, 60, 61, 3599, 3600, 3601, 216000, 216001, 10000000}
+	for i, d := range delays {
+		tw.AddJob(time.Duration(d)*time.Second, strconv.Itoa(i), func() {})
+	}
+	if stats := tw.Stats(); stats.Pending != len(delays) || stats.Levels < 4 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	for i, d := range delays {
+		tw.advance(d - 1)
+		if executed := tw.Stats().Executed; executed != uint64(i) {
+			t.Errorf("expected %d jobs executed before tick %d, actually %d", i, d, executed)
+		}
+		tw.advance(d)
+		if executed := tw.Stats().Executed; executed != uint64(i+1) {
+			t.Errorf("expected %d jobs executed at tick %d, actually %d", i+1, d, executed)
+		}
+	}
+	if stats := tw.Stats(); stats.Pending != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRemoveAndReschedule(t *testing.T) {
+	tw := newTestWheel(10)
+	var added []string
+	var removed []string
+	tw.SetHooks(&Hooks{
+		OnAdd: func(key string, at time.Time) {
+			added = append(added, key+"@"+strconv.Itoa(int(at.Sub(tw.start)/time.Second)))
+		},
+		OnRemove: func(key string, executed bool) {
+			removed = append(removed, key+":"+strconv.FormatBool(executed))
+		},
+	})
+	tw.AddJobs(
+		Job{Delay: 10 * time.Second, Key: "a", Job: func() {}},
+		Job{Delay: 100 * time.Second, Key: "b", Job: func() {}},
+		Job{Delay: 5 * time.Second, Job: func() {}},
+		Job{Delay: 20 * time.Second, Key: "c", Job: func() {}},
+		Job{Delay: 30 * time.Second, Key: "c", Job: func() {}}, // replaces the former one
+	)
+	if n := tw.RemoveJobs("a", "not-exists"); n != 1 {
+		t.Errorf("expected 1 job removed, actually %d", n)
+	}
+	if !tw.Reschedule("b", 3*time.Second) {
+		t.Error("expected job rescheduled")
+	}
+	if tw.Reschedule("a", time.Second) {
+		t.Error("expected removed job not rescheduled")
+	}
+	pending := tw.Pending()
+	expected := []PendingJob{
+		{Key: "b", At: tw.start.Add(3 * time.Second)},
+		{Key: "", At: tw.start.Add(5 * time.Second)},
+		{Key: "c", At: tw.start.Add(30 * time.Second)},
+	}
+	if len(pending) != len(expected) {
+		t.Fatalf("expected %d pending jobs, actually %d", len(expected), len(pending))
+	}
+	for i := range expected {
+		if pending[i] != expected[i] {
+			t.Errorf("expected pending job %+v, actually %+v", expected[i], pending[i])
+		}
+	}
+
+	tw.advance(30)
+	stats := tw.Stats()
+	if stats.Pending != 0 || stats.Executed != 3 || stats.Canceled != 1 || stats.Rescheduled != 1 || stats.Added != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	expectedAdded := "a@10 b@100 c@20 c@30 b@3"
+	expectedRemoved := "a:false b:true c:true"
+	if actual := joinStrings(added); actual != expectedAdded {
+		t.Errorf("expected added %s, actually %s", expectedAdded, actual)
+	}
+	if actual := joinStrings(removed); actual != expectedRemoved {
+		t.Errorf("expected removed %s, actually %s", expectedRemoved, actual)
+	}
+}
+
+func TestOverdueJob(t *testing.T) {
+	tw := newTestWheel(10)
+	tw.advance(5)
+	executed := make(chan struct{})
+	tw.AddJob(-time.Hour, "overdue", func() {
+		close(executed)
+	})
+	if stats := tw.Stats(); stats.Pending != 1 || stats.Executed != 0 {
+		t.Errorf("expected overdue job pending until the next tick, stats %+v", stats)
+	}
+	tw.advance(6)
+	if stats := tw.Stats(); stats.Pending != 0 || stats.Executed != 1 {
+		t.Errorf("expected overdue job executed on the next tick, stats %+v", stats)
+	}
+	select {
+	case <-executed:
+	case <-time.After(3 * time.Second):
+		t.Error("overdue job is not executed")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	tw := New(10*time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+	ch := make(chan struct{})
+	tw.AddJob(200*time.Millisecond, "k", func() {
+		close(ch)
+	})
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		t.Error("job not executed")
+	}
+}
+
+func joinStrings(strs []string) string {
+	result := ""
+	for i, s := range strs {
+		if i > 0 {
+			result += " "
+		}
+		result += s
+	}
+	return result
+}
+
+const millionTimers = 1000000
+
+func addMillion(tw *TimeWheel) {
+	jobs := make([]Job, 0, 1000)
+	for i := 0; i < millionTimers; i++ {
+		jobs = append(jobs, Job{
+			Delay: time.Duration(i%100000+1) * time.Second,
+			Key:   strconv.Itoa(i),
+			Job:   func() {},
+		})
+		if len(jobs) == cap(jobs) {
+			tw.AddJobs(jobs...)
+			jobs = jobs[:0]
+		}
+	}
+}
+
+// BenchmarkAddJob adds jobs into a wheel holding a million timers
+func BenchmarkAddJob(b *testing.B) {
+	tw := newTestWheel(3600)
+	addMillion(tw)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tw.AddJob(time.Duration(i%100000+1)*time.Second, "bench"+strconv.Itoa(i), func() {})
+	}
+}
+
+// BenchmarkRemoveJob cancels jobs from a wheel holding a million timers
+func BenchmarkRemoveJob(b *testing.B) {
+	tw := newTestWheel(3600)
+	addMillion(tw)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := strconv.Itoa(i % millionTimers)
+		tw.RemoveJob(key)
+		if i%millionTimers == millionTimers-1 {
+			b.StopTimer()
+			addMillion(tw)
+			b.StartTimer()
+		}
+	}
+}
+
+// BenchmarkAddMillion adds a million timers by batch
+func BenchmarkAddMillion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		addMillion(newTestWheel(3600))
+	}
+}
+
+// BenchmarkAdvanceMillion moves wheel till a million timers expired
+func BenchmarkAdvanceMillion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tw := newTestWheel(3600)
+		addMillion(tw)
+		b.StartTimer()
+		tw.advance(100000)
+	}
+}
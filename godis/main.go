@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"godis/config"
 	"godis/lib/logger"
+	"godis/lib/tlsutil"
+	"godis/module"
 	RedisServer "godis/redis/server"
 	"godis/tcp"
 	"os"
+	"strconv"
 )
 
 var banner = `
@@ -17,12 +20,14 @@ var banner = `
 \____/\____/\__,_/_/____/
 `
 
-var defaultProperties = &config.ServerProperties{
-	Bind:           "0.0.0.0",
-	Port:           6399,
-	AppendOnly:     false,
-	AppendFilename: "",
-	MaxClients:     1000,
+// makeDefaultProperties returns properties used without config file,
+// options not listed here take the defaults of config
+func makeDefaultProperties() *config.ServerProperties {
+	props := config.MakeDefaultProperties()
+	props.Bind = "0.0.0.0"
+	props.Port = 6399
+	props.MaxClients = 1000
+	return props
 }
 
 func fileExists(filename string) bool {
@@ -43,16 +48,63 @@ func main() {
 		if fileExists("redis.conf") {
 			config.SetupConfig("redis.conf")
 		} else {
-			config.Properties = defaultProperties
+			config.SetProperties(makeDefaultProperties())
 		}
 	} else {
 		config.SetupConfig(configFilename)
 	}
 
-	err := tcp.ListenAndServeWithSignal(&tcp.Config{
-		Address: fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port),
-	}, RedisServer.MakeHandler())
+	// commands and types of modules should be registered before aof loaded
+	if err := module.LoadFromConfig(); err != nil {
+		logger.Fatal(err)
+	}
+	tcpConfig, err := makeTCPConfig()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	err = tcp.ListenAndServeWithSignal(tcpConfig, RedisServer.MakeHandler())
 	if err != nil {
 		logger.Error(err)
 	}
 }
+
+// reloadConfig applies changes of config file, current config is kept if the file is invalid
+func reloadConfig() {
+	err := config.Reload()
+	if err != nil {
+		logger.Warn("reload config failed: " + err.Error())
+		return
+	}
+	logger.Info("config reloaded")
+}
+
+// makeTCPConfig creates listeners config, port 0 disables plain tcp if tls or unix socket is enabled
+func makeTCPConfig() (*tcp.Config, error) {
+	props := config.Properties()
+	cfg := &tcp.Config{
+		OnReload: reloadConfig,
+	}
+	if props.Port != 0 || (props.TLSPort == 0 && props.UnixSocket == "") {
+		cfg.Address = fmt.Sprintf("%s:%d", props.Bind, props.Port)
+	}
+	if props.TLSPort != 0 {
+		tlsConfig, err := tlsutil.MakeServerConfig(props.TLSCertFile, props.TLSKeyFile,
+			props.TLSCACertFile, props.TLSAuthClients)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLSAddress = fmt.Sprintf("%s:%d", props.Bind, props.TLSPort)
+		cfg.TLSConfig = tlsConfig
+	}
+	if props.UnixSocket != "" {
+		cfg.UnixSocket = props.UnixSocket
+		if props.UnixSocketPerm != "" {
+			perm, err := strconv.ParseUint(props.UnixSocketPerm, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("illegal unixsocketperm: %s", props.UnixSocketPerm)
+			}
+			cfg.UnixSocketPerm = os.FileMode(perm)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,116 @@
+package module
+
+import (
+	"godis/database"
+	idatabase "godis/interface/database"
+	"godis/interface/redis"
+	"godis/redis/reply"
+	"strings"
+	"time"
+)
+
+// Reply is the result of a command, see package godis/redis/reply for implementations
+type Reply = redis.Reply
+
+// Entity is value bound to a key
+type Entity = idatabase.DataEntity
+
+// Value is implemented by values of types registered by modules
+type Value = idatabase.CustomValue
+
+// flags of commands
+const (
+	// FlagWrite appends command line into aof verbatim once the command succeeds
+	FlagWrite = database.FlagWrite
+	// FlagNoMulti forbids the command in MULTI, commands locking keys by themselves should have it
+	FlagNoMulti = database.FlagNoMulti
+)
+
+// DB is the database selected by client, keys returned by Prepare are locked while the command executing
+type DB interface {
+	// Index returns index of the database
+	Index() int
+	// GetEntity returns value of key, expired key is removed and not returned
+	GetEntity(key string) (*Entity, bool)
+	PutEntity(key string, entity *Entity) int
+	PutIfExists(key string, entity *Entity) int
+	PutIfAbsent(key string, entity *Entity) int
+	Remove(key string)
+	Expire(key string, expireTime time.Time)
+	Persist(key string)
+	// Propagate appends command line into aof, commands without FlagWrite use it to persist their effects
+	Propagate(cmdLine [][]byte)
+}
+
+// Command describes a command of module
+type Command struct {
+	// Executor executes the command, args don't include command name
+	Executor func(db DB, args [][]byte) Reply
+	// Prepare returns keys written and read by the command, they are locked during execution
+	Prepare func(args [][]byte) (write []string, read []string)
+	// Undo returns command lines rolling back the command in a failed MULTI, write keys are restored if it is nil
+	Undo func(db DB, args [][]byte) [][][]byte
+	// Arity is the allowed number of arguments including command name, arity < 0 means len(args) >= -arity
+	Arity int
+	Flags int
+}
+
+// Type describes a value type of module
+type Type struct {
+	// Name is returned by TYPE command and written into aof, it should not be changed once data persisted
+	Name string
+	// Unmarshal rebuilds value from result of Value.Marshal
+	Unmarshal func(data []byte) (Value, error)
+}
+
+// WriteFirstKey is Prepare of commands writing the first argument
+func WriteFirstKey(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, nil
+}
+
+// ReadFirstKey is Prepare of commands reading the first argument
+func ReadFirstKey(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0])}
+}
+
+// NoKeys is Prepare of commands without keys
+func NoKeys(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+// execModule lists loaded modules, modules can only be loaded at startup
+// usage: MODULE LIST
+func execModule(db *database.DB, args [][]byte) redis.Reply {
+	if strings.ToUpper(string(args[0])) != "LIST" {
+		return reply.MakeErrReply("ERR modules can only be loaded at startup by loadmodule in config")
+	}
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("module|list")
+	}
+	modules := List()
+	result := make([]redis.Reply, len(modules))
+	for i, m := range modules {
+		toBytes := func(items []string) [][]byte {
+			b := make([][]byte, len(items))
+			for j, item := range items {
+				b[j] = []byte(item)
+			}
+			return b
+		}
+		result[i] = reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte("name")),
+			reply.MakeBulkReply([]byte(m.Name)),
+			reply.MakeBulkReply([]byte("args")),
+			reply.MakeMultiBulkReply(toBytes(m.Args)),
+			reply.MakeBulkReply([]byte("commands")),
+			reply.MakeMultiBulkReply(toBytes(m.Commands)),
+			reply.MakeBulkReply([]byte("types")),
+			reply.MakeMultiBulkReply(toBytes(m.Types)),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func init() {
+	database.RegisterCommand("Module", execModule, NoKeys, nil, -2)
+}
@@ -0,0 +1,199 @@
+// Package module is the public API to extend godis with commands, value types and keyspace hooks without forking it.
+//
+// A module is a Go package calling Register in its init function. It is compiled into godis by a blank import,
+// or built as a Go plugin exporting `var GodisModule module.Module`.
+// Modules are loaded at startup in the order of `loadmodule` lines in config, each line is a module name
+// or plugin path followed by space separated arguments, for example:
+//
+//	loadmodule ratelimit 100
+//	loadmodule /opt/godis/geofence.so
+//
+// Commands of modules are available in standalone mode only.
+package module
+
+import (
+	"errors"
+	"godis/config"
+	"godis/database"
+	"godis/lib/logger"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Module is an extension of godis
+type Module struct {
+	Name string
+	// OnLoad registers commands, types and hooks of the module, args follow the module name in config
+	OnLoad func(ctx *Context, args []string) error
+}
+
+// loadedModule records what a module registered
+type loadedModule struct {
+	module   *Module
+	args     []string
+	commands []string
+	types    []string
+	// hooks are added once OnLoad succeeded
+	hooks []database.KeyspaceHook
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]*Module)
+	loaded   = make(map[string]*loadedModule)
+)
+
+// Register makes a module available to be loaded, it panics if the name is used
+func Register(m *Module) {
+	if err := register(m); err != nil {
+		panic("module: " + err.Error())
+	}
+}
+
+func register(m *Module) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if m == nil || m.Name == "" || m.OnLoad == nil {
+		return errors.New("name and OnLoad of module are required")
+	}
+	if _, ok := registry[m.Name]; ok {
+		return errors.New("module " + m.Name + " already exists")
+	}
+	registry[m.Name] = m
+	return nil
+}
+
+// Load loads a registered module by name or a plugin by path ending with .so
+func Load(name string, args []string) error {
+	if strings.HasSuffix(name, ".so") {
+		m, err := openPlugin(name)
+		if err != nil {
+			return err
+		}
+		if err := register(m); err != nil {
+			return err
+		}
+		name = m.Name
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	m, ok := registry[name]
+	if !ok {
+		return errors.New("module " + name + " is not registered, is its package imported?")
+	}
+	if _, ok := loaded[name]; ok {
+		return errors.New("module " + name + " is already loaded")
+	}
+	lm := &loadedModule{module: m, args: args}
+	if err := m.OnLoad(&Context{module: lm}, args); err != nil {
+		// names registered by the failed module could be used by others
+		for _, cmd := range lm.commands {
+			database.UnregisterModuleCommand(cmd)
+		}
+		for _, t := range lm.types {
+			database.UnregisterCustomType(t)
+		}
+		return errors.New("load module " + name + " failed: " + err.Error())
+	}
+	for _, hook := range lm.hooks {
+		database.AddKeyspaceHook(hook)
+	}
+	loaded[name] = lm
+	logger.Info("module " + name + " loaded")
+	return nil
+}
+
+// LoadFromConfig loads modules listed in `loadmodule` of config
+func LoadFromConfig() error {
+	for _, spec := range config.Properties().LoadModules {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := Load(fields[0], fields[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Info describes a loaded module
+type Info struct {
+	Name     string
+	Args     []string
+	Commands []string
+	Types    []string
+}
+
+// List returns loaded modules sorted by name
+func List() []*Info {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make([]*Info, 0, len(loaded))
+	for name, lm := range loaded {
+		result = append(result, &Info{
+			Name:     name,
+			Args:     lm.args,
+			Commands: lm.commands,
+			Types:    lm.types,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Context is given to OnLoad to register extensions of the module
+type Context struct {
+	module *loadedModule
+}
+
+// Name returns name of the module being loaded
+func (ctx *Context) Name() string {
+	return ctx.module.module.Name
+}
+
+// RegisterCommand adds a command, it fails if the name is used by godis or other modules
+func (ctx *Context) RegisterCommand(name string, cmd *Command) error {
+	if cmd.Executor == nil {
+		return errors.New("executor of command " + name + " is required")
+	}
+	executor := cmd.Executor
+	var undo database.UndoFunc
+	if cmd.Undo != nil {
+		undoFunc := cmd.Undo
+		undo = func(db *database.DB, args [][]byte) []database.CmdLine {
+			return undoFunc(db, args)
+		}
+	}
+	err := database.RegisterModuleCommand(name, func(db *database.DB, args [][]byte) Reply {
+		return executor(db, args)
+	}, cmd.Prepare, undo, cmd.Arity, cmd.Flags)
+	if err != nil {
+		return err
+	}
+	ctx.module.commands = append(ctx.module.commands, strings.ToLower(name))
+	return nil
+}
+
+// RegisterType adds a value type, its values could be stored by commands of the module and persisted by godis
+func (ctx *Context) RegisterType(t *Type) error {
+	err := database.RegisterCustomType(&database.CustomType{
+		Name:      t.Name,
+		Unmarshal: t.Unmarshal,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.module.types = append(ctx.module.types, t.Name)
+	return nil
+}
+
+// SubscribeKeyspaceEvents registers a hook notified of keys modified by succeeded commands and expiration.
+// event is name of the command in lower case, or "expired".
+// Hooks are called synchronously while keys are locked, so they must not execute commands on the keys
+func (ctx *Context) SubscribeKeyspaceEvents(hook func(dbIndex int, event string, key string)) {
+	ctx.module.hooks = append(ctx.module.hooks, hook)
+}
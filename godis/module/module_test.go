@@ -0,0 +1,323 @@
+package module
+
+import (
+	"errors"
+	"godis/config"
+	"godis/database"
+	"godis/lib/utils"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type counter struct {
+	n int64
+}
+
+func (c *counter) TypeName() string {
+	return "counter"
+}
+
+func (c *counter) Marshal() []byte {
+	return []byte(strconv.FormatInt(c.n, 10))
+}
+
+func getCounter(db DB, key string) (*counter, Reply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	c, ok := entity.Data.(*counter)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return c, nil
+}
+
+// counterModule stores integers in its own type
+var counterModule = &Module{
+	Name: "counter",
+	OnLoad: func(ctx *Context, args []string) error {
+		err := ctx.RegisterType(&Type{
+			Name: "counter",
+			Unmarshal: func(data []byte) (Value, error) {
+				n, err := strconv.ParseInt(string(data), 10, 64)
+				return &counter{n: n}, err
+			},
+		})
+		if err != nil {
+			return err
+		}
+		err = ctx.RegisterCommand("Counter.IncrBy", &Command{
+			Executor: func(db DB, args [][]byte) Reply {
+				delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+				if err != nil {
+					return reply.MakeErrReply("ERR value is not an integer or out of range")
+				}
+				c, errReply := getCounter(db, string(args[0]))
+				if errReply != nil {
+					return errReply
+				}
+				if c == nil {
+					c = &counter{}
+					db.PutEntity(string(args[0]), &Entity{Data: c})
+				}
+				c.n += delta
+				return reply.MakeIntReply(c.n)
+			},
+			Prepare: WriteFirstKey,
+			Arity:   3,
+			Flags:   FlagWrite,
+		})
+		if err != nil {
+			return err
+		}
+		err = ctx.RegisterCommand("Counter.Get", &Command{
+			Executor: func(db DB, args [][]byte) Reply {
+				c, errReply := getCounter(db, string(args[0]))
+				if errReply != nil {
+					return errReply
+				}
+				if c == nil {
+					return reply.MakeNullBulkReply()
+				}
+				return reply.MakeIntReply(c.n)
+			},
+			Prepare: ReadFirstKey,
+			Arity:   2,
+		})
+		if err != nil {
+			return err
+		}
+		// reset the counter to a random value, which cannot be replayed verbatim
+		return ctx.RegisterCommand("Counter.Shuffle", &Command{
+			Executor: func(db DB, args [][]byte) Reply {
+				c := &counter{n: time.Now().UnixNano() % 1000}
+				db.PutEntity(string(args[0]), &Entity{Data: c})
+				db.Propagate(utils.ToCmdLine("MODULE.LOADVALUE", string(args[0]), c.TypeName(), string(c.Marshal())))
+				return reply.MakeIntReply(c.n)
+			},
+			Prepare: WriteFirstKey,
+			Arity:   2,
+		})
+	},
+}
+
+var (
+	loadOnce sync.Once
+	eventsMu sync.Mutex
+	events   []string
+)
+
+func loadCounterModule(t *testing.T) {
+	loadOnce.Do(func() {
+		Register(counterModule)
+		Register(&Module{
+			Name: "events",
+			OnLoad: func(ctx *Context, args []string) error {
+				ctx.SubscribeKeyspaceEvents(func(dbIndex int, event string, key string) {
+					eventsMu.Lock()
+					events = append(events, strconv.Itoa(dbIndex)+" "+event+" "+key)
+					eventsMu.Unlock()
+				})
+				return nil
+			},
+		})
+		config.SetProperties(&config.ServerProperties{
+			LoadModules: []string{"counter 1 2", "events"},
+		})
+		if err := LoadFromConfig(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func popEvents() []string {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	result := events
+	events = nil
+	return result
+}
+
+func TestLoad(t *testing.T) {
+	loadCounterModule(t)
+	if err := Load("counter", nil); err == nil {
+		t.Error("module should not be loaded twice")
+	}
+	if err := Load("missing", nil); err == nil {
+		t.Error("expect error for unregistered module")
+	}
+	if err := Load("/not/exists.so", nil); err == nil {
+		t.Error("expect error for missing plugin")
+	}
+	Register(&Module{
+		Name: "bad",
+		OnLoad: func(ctx *Context, args []string) error {
+			if err := ctx.RegisterType(&Type{Name: "hash", Unmarshal: func([]byte) (Value, error) { return nil, nil }}); err == nil {
+				return errors.New("builtin type should not be overridden")
+			}
+			if err := ctx.RegisterCommand("get", &Command{Executor: func(DB, [][]byte) Reply { return nil }, Prepare: NoKeys, Arity: 1}); err == nil {
+				return errors.New("builtin command should not be overridden")
+			}
+			return ctx.RegisterCommand("bad.cmd", &Command{Executor: func(DB, [][]byte) Reply { return nil }, Arity: 1})
+		},
+	})
+	if err := Load("bad", nil); err == nil || !strings.Contains(err.Error(), "prepare") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// names registered by failed module are released
+	for _, name := range []string{"broken", "fixed"} {
+		fail := name == "broken"
+		Register(&Module{
+			Name: name,
+			OnLoad: func(ctx *Context, args []string) error {
+				if err := ctx.RegisterType(&Type{Name: "shared", Unmarshal: func([]byte) (Value, error) { return nil, nil }}); err != nil {
+					return err
+				}
+				if err := ctx.RegisterCommand("shared.cmd", &Command{Executor: func(DB, [][]byte) Reply { return nil }, Prepare: NoKeys, Arity: 1}); err != nil {
+					return err
+				}
+				if fail {
+					return errors.New("broken")
+				}
+				return nil
+			},
+		})
+	}
+	if err := Load("broken", nil); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := Load("fixed", nil); err != nil {
+		t.Errorf("names of failed module should be released: %v", err)
+	}
+
+	server := database.NewStandaloneServer()
+	defer server.Close()
+	ret := server.Exec(&connection.FakeConn{}, utils.ToCmdLine("MODULE", "LIST"))
+	expected := "*3\r\n" +
+		"*8\r\n$4\r\nname\r\n$7\r\ncounter\r\n$4\r\nargs\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n" +
+		"$8\r\ncommands\r\n*3\r\n$14\r\ncounter.incrby\r\n$11\r\ncounter.get\r\n$15\r\ncounter.shuffle\r\n" +
+		"$5\r\ntypes\r\n*1\r\n$7\r\ncounter\r\n" +
+		"*8\r\n$4\r\nname\r\n$6\r\nevents\r\n$4\r\nargs\r\n*0\r\n$8\r\ncommands\r\n*0\r\n$5\r\ntypes\r\n*0\r\n" +
+		"*8\r\n$4\r\nname\r\n$5\r\nfixed\r\n$4\r\nargs\r\n*0\r\n$8\r\ncommands\r\n*1\r\n$10\r\nshared.cmd\r\n$5\r\ntypes\r\n*1\r\n$6\r\nshared\r\n"
+	if string(ret.ToBytes()) != expected {
+		t.Errorf("unexpected module list %q", ret.ToBytes())
+	}
+	ret = server.Exec(&connection.FakeConn{}, utils.ToCmdLine("MODULE", "LOAD", "x"))
+	asserts.AssertErrReply(t, ret, "ERR modules can only be loaded at startup by loadmodule in config")
+}
+
+func TestModuleCommands(t *testing.T) {
+	loadCounterModule(t)
+	server := database.NewStandaloneServer()
+	defer server.Close()
+	conn := &connection.FakeConn{}
+	conn.SelectDB(1)
+	popEvents()
+	ret := server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "k", "5"))
+	asserts.AssertIntReply(t, ret, 5)
+	ret = server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "k", "2"))
+	asserts.AssertIntReply(t, ret, 7)
+	ret = server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "k"))
+	asserts.AssertErrReply(t, ret, "ERR wrong number of arguments for 'counter.incrby' command")
+	ret = server.Exec(conn, utils.ToCmdLine("TYPE", "k"))
+	asserts.AssertStatusReply(t, ret, "counter")
+	server.Exec(conn, utils.ToCmdLine("SET", "s", "x"))
+	ret = server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "s", "1"))
+	asserts.AssertErrReply(t, ret, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	if e := popEvents(); strings.Join(e, ",") != "1 counter.incrby k,1 counter.incrby k,1 set s" {
+		t.Errorf("unexpected events %v", e)
+	}
+
+	// dump and restore
+	ret = server.Exec(conn, utils.ToCmdLine("DUMP", "k"))
+	payload, ok := ret.(*reply.BulkReply)
+	if !ok {
+		t.Fatalf("unexpected dump result %q", ret.ToBytes())
+	}
+	ret = server.Exec(conn, utils.ToCmdLine("RESTORE", "k2", "0", string(payload.Arg)))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "k2"))
+	asserts.AssertIntReply(t, ret, 7)
+	ret = server.Exec(conn, utils.ToCmdLine("MODULE.LOADVALUE", "k3", "counter", "1"))
+	asserts.AssertErrReply(t, ret, "ERR command 'module.loadvalue' is only used by aof")
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	ret = server.Exec(conn, utils.ToCmdLine("MODULE.LOADVALUE", "k3", "counter", "1"))
+	asserts.AssertErrReply(t, ret, "ERR command 'module.loadvalue' is only used by aof")
+	server.Exec(conn, utils.ToCmdLine("DISCARD"))
+
+	// failed transaction restores write keys
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "k", "1"))
+	server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "s", "1"))
+	ret = server.Exec(conn, utils.ToCmdLine("EXEC"))
+	asserts.AssertErrReply(t, ret, "EXECABORT Transaction discarded because of previous errors.")
+	ret = server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "k"))
+	asserts.AssertIntReply(t, ret, 7)
+
+	popEvents()
+	server.Exec(conn, utils.ToCmdLine("PEXPIRE", "k2", "1"))
+	time.Sleep(5 * time.Millisecond)
+	ret = server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "k2"))
+	asserts.AssertNullBulk(t, ret)
+	if e := popEvents(); strings.Join(e, ",") != "1 pexpire k2,1 expired k2" {
+		t.Errorf("unexpected events %v", e)
+	}
+}
+
+func TestModulePersistence(t *testing.T) {
+	loadCounterModule(t)
+	for _, preamble := range []bool{false, true} {
+		tmpDir, err := ioutil.TempDir("", "godis")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.SetProperties(&config.ServerProperties{
+			AppendOnly:        true,
+			AppendFilename:    path.Join(tmpDir, "a.aof"),
+			AofUseRdbPreamble: preamble,
+		})
+		conn := &connection.FakeConn{}
+		server := database.NewStandaloneServer()
+		server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "a", "3"))
+		server.Exec(conn, utils.ToCmdLine("COUNTER.INCRBY", "a", "4"))
+		shuffled := server.Exec(conn, utils.ToCmdLine("COUNTER.SHUFFLE", "b")).(*reply.IntReply).Code
+		server.Close()
+
+		// aof is replayed without notifying hooks
+		popEvents()
+		server = database.NewStandaloneServer()
+		asserts.AssertIntReply(t, server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "a")), 7)
+		asserts.AssertIntReply(t, server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "b")), int(shuffled))
+		if e := popEvents(); len(e) != 0 {
+			t.Errorf("unexpected events %v", e)
+		}
+		ret := server.Exec(conn, utils.ToCmdLine("REWRITEAOF"))
+		asserts.AssertNotError(t, ret)
+		server.Close()
+
+		base, _ := filepath.Glob(path.Join(tmpDir, "a.aof.*.base.*"))
+		if len(base) != 1 {
+			t.Fatalf("expect one base file, actual %v", base)
+		}
+		if content, _ := ioutil.ReadFile(base[0]); !strings.Contains(string(content), "counter") {
+			t.Errorf("custom value is not rewritten: %q", content)
+		}
+		server = database.NewStandaloneServer()
+		asserts.AssertIntReply(t, server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "a")), 7)
+		asserts.AssertIntReply(t, server.Exec(conn, utils.ToCmdLine("COUNTER.GET", "b")), int(shuffled))
+		server.Close()
+		_ = os.RemoveAll(tmpDir)
+	}
+}
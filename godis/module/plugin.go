@@ -0,0 +1,27 @@
+package module
+
+import (
+	"errors"
+	"plugin"
+)
+
+// pluginSymbol is the variable of type Module exported by plugins
+const pluginSymbol = "GodisModule"
+
+// openPlugin opens a module built by `go build -buildmode=plugin`, the plugin should be built with the same
+// version of godis and Go toolchain
+func openPlugin(path string) (*Module, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := sym.(*Module)
+	if !ok {
+		return nil, errors.New(path + ": " + pluginSymbol + " is not a module.Module")
+	}
+	return m, nil
+}
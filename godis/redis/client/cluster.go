@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"godis/interface/redis"
+	"godis/lib/consistenthash"
+)
+
+// same as replicas of godis cluster, so that commands are sent to the node owning the key
+const clusterReplicas = 4
+
+var errNoNode = errors.New("redis: no node in cluster")
+
+// ClusterClient sends commands to the godis cluster node owning the key, it is safe for concurrent use.
+// Every godis node is able to relay commands, so another node is used if the owner is unreachable
+type ClusterClient struct {
+	Commands
+	opts   *Options
+	addrs  []string
+	picker *consistenthash.Map
+	pools  map[string]*Pool
+}
+
+// NewClusterClient creates a ClusterClient, addrs must be same as peers and self in config of cluster nodes.
+// Addr of opts is ignored
+func NewClusterClient(addrs []string, opts *Options) *ClusterClient {
+	opts = opts.withDefaults()
+	c := &ClusterClient{
+		opts:   opts,
+		picker: consistenthash.New(clusterReplicas, nil),
+		pools:  make(map[string]*Pool),
+	}
+	for _, addr := range addrs {
+		if _, ok := c.pools[addr]; ok {
+			continue
+		}
+		nodeOpts := *opts
+		nodeOpts.Addr = addr
+		c.pools[addr] = NewPool(&nodeOpts)
+		c.addrs = append(c.addrs, addr)
+	}
+	c.picker.AddNode(c.addrs...)
+	c.Commands = Commands{
+		do: c.do,
+	}
+	return c
+}
+
+// pickNode returns the node owning key of cmdLine
+func (c *ClusterClient) pickNode(cmdLine [][]byte) string {
+	key := ""
+	if len(cmdLine) > 1 {
+		key = string(cmdLine[1])
+	}
+	return c.picker.PickNode(key)
+}
+
+// NodePool returns pool of the node owning key
+func (c *ClusterClient) NodePool(key string) *Pool {
+	return c.pools[c.picker.PickNode(key)]
+}
+
+// acquire gets a connection of owner, or of another node if owner is unreachable
+func (c *ClusterClient) acquire(ctx context.Context, owner string) (*Pool, *Conn, error) {
+	conn, err := c.pools[owner].Acquire(ctx)
+	if err == nil {
+		return c.pools[owner], conn, nil
+	}
+	for _, addr := range c.addrs {
+		if _, isErrReply := err.(Error); isErrReply || ctx.Err() != nil || err == ErrPoolClosed {
+			// wrong password or canceled, other nodes won't work either
+			break
+		}
+		if addr == owner {
+			continue
+		}
+		conn, err = c.pools[addr].Acquire(ctx)
+		if err == nil {
+			return c.pools[addr], conn, nil
+		}
+	}
+	return nil, nil, err
+}
+
+// do sends command only once, since command may have been executed if io error occurred after it sent
+func (c *ClusterClient) do(ctx context.Context, cmdLine [][]byte) (redis.Reply, error) {
+	if len(c.addrs) == 0 {
+		return nil, errNoNode
+	}
+	pool, conn, err := c.acquire(ctx, c.pickNode(cmdLine))
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release(conn)
+	return conn.Do(ctx, cmdLine)
+}
+
+// Pipeline groups commands by node owning their keys and sends each group by one connection concurrently.
+// Replies are in the same order as cmdLines, error replies are kept in result
+func (c *ClusterClient) Pipeline(ctx context.Context, cmdLines ...[][]byte) ([]redis.Reply, error) {
+	if len(c.addrs) == 0 {
+		return nil, errNoNode
+	}
+	groups := make(map[string][]int) // node -> indexes of its commands
+	for i, cmdLine := range cmdLines {
+		owner := c.pickNode(cmdLine)
+		groups[owner] = append(groups[owner], i)
+	}
+	results := make([]redis.Reply, len(cmdLines))
+	errs := make(chan error, len(groups))
+	for owner, indexes := range groups {
+		go func(owner string, indexes []int) {
+			pool, conn, err := c.acquire(ctx, owner)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer pool.Release(conn)
+			group := make([][][]byte, len(indexes))
+			for i, index := range indexes {
+				group[i] = cmdLines[index]
+			}
+			replies, err := conn.Pipeline(ctx, group...)
+			if err == nil {
+				for i, index := range indexes {
+					results[index] = replies[i]
+				}
+			}
+			errs <- err
+		}(owner, indexes)
+	}
+	var firstErr error
+	for range groups {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// Subscribe creates a Subscriber, godis cluster broadcasts published messages so any node works
+func (c *ClusterClient) Subscribe(ctx context.Context, channels ...string) (*Subscriber, error) {
+	var err error
+	for _, addr := range c.addrs {
+		var s *Subscriber
+		s, err = newSubscriber(ctx, c.pools[addr].opts, channels)
+		if err == nil {
+			return s, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if err == nil {
+		err = errNoNode
+	}
+	return nil, err
+}
+
+// Close closes pools of all nodes
+func (c *ClusterClient) Close() error {
+	for _, p := range c.pools {
+		_ = p.Close()
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"strconv"
+	"time"
+)
+
+// ErrNil is returned when key or field does not exist
+var ErrNil = errors.New("redis: nil")
+
+// Commands provides typed helpers of commands, it is embedded by Pool, ClusterClient and Tx
+type Commands struct {
+	do func(ctx context.Context, cmdLine [][]byte) (redis.Reply, error)
+}
+
+// Do executes a command, error reply is returned as Error
+func (c Commands) Do(ctx context.Context, args ...string) (redis.Reply, error) {
+	return c.do(ctx, utils.ToCmdLine(args...))
+}
+
+func unexpectedReply(r redis.Reply) error {
+	return fmt.Errorf("redis: unexpected reply %q", r.ToBytes())
+}
+
+func toString(r redis.Reply, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch r := r.(type) {
+	case *reply.BulkReply:
+		return string(r.Arg), nil
+	case *reply.StatusReply:
+		return r.Status, nil
+	case *reply.OkReply:
+		return "OK", nil
+	case *reply.PongReply:
+		return "PONG", nil
+	case *reply.NullBulkReply:
+		return "", ErrNil
+	}
+	return "", unexpectedReply(r)
+}
+
+func toInt(r redis.Reply, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch r := r.(type) {
+	case *reply.IntReply:
+		return r.Code, nil
+	case *reply.NullBulkReply:
+		return 0, ErrNil
+	case *reply.BulkReply:
+		return strconv.ParseInt(string(r.Arg), 10, 64)
+	}
+	return 0, unexpectedReply(r)
+}
+
+func toFloat(r redis.Reply, err error) (float64, error) {
+	s, err := toString(r, err)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func toBool(r redis.Reply, err error) (bool, error) {
+	n, err := toInt(r, err)
+	return n == 1, err
+}
+
+// toStrings converts a multi bulk reply, nil elements are converted to empty string
+func toStrings(r redis.Reply, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch r := r.(type) {
+	case *reply.MultiBulkReply:
+		result := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			result[i] = string(arg)
+		}
+		return result, nil
+	case *reply.MultiRawReply:
+		result := make([]string, len(r.Replies))
+		for i, element := range r.Replies {
+			s, err := toString(element, nil)
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+			result[i] = s
+		}
+		return result, nil
+	case *reply.EmptyMultiBulkReply:
+		return []string{}, nil
+	}
+	return nil, unexpectedReply(r)
+}
+
+func toStringMap(r redis.Reply, err error) (map[string]string, error) {
+	list, err := toStrings(r, err)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		result[list[i]] = list[i+1]
+	}
+	return result, nil
+}
+
+func toOk(r redis.Reply, err error) error {
+	_, err = toString(r, err)
+	return err
+}
+
+func (c Commands) exec(ctx context.Context, args ...string) (redis.Reply, error) {
+	return c.do(ctx, utils.ToCmdLine(args...))
+}
+
+func appendInts(args []string, values ...int64) []string {
+	for _, v := range values {
+		args = append(args, strconv.FormatInt(v, 10))
+	}
+	return args
+}
+
+/* ---- Keys ---- */
+
+// Ping checks connection
+func (c Commands) Ping(ctx context.Context) error {
+	return toOk(c.exec(ctx, "PING"))
+}
+
+// Del removes keys and returns number of removed keys
+func (c Commands) Del(ctx context.Context, keys ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"DEL"}, keys...)...))
+}
+
+// Exists returns number of existing keys
+func (c Commands) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"EXISTS"}, keys...)...))
+}
+
+// Expire sets ttl of key in milliseconds precision, returns false if key does not exist
+func (c Commands) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return toBool(c.exec(ctx, appendInts([]string{"PEXPIRE", key}, ttl.Milliseconds())...))
+}
+
+// TTL returns remaining time to live of key, -1 means no ttl, -2 means key does not exist
+func (c Commands) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ms, err := toInt(c.exec(ctx, "PTTL", key))
+	if err != nil || ms < 0 {
+		return time.Duration(ms), err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Persist removes ttl of key
+func (c Commands) Persist(ctx context.Context, key string) (bool, error) {
+	return toBool(c.exec(ctx, "PERSIST", key))
+}
+
+// Type returns type name of key
+func (c Commands) Type(ctx context.Context, key string) (string, error) {
+	return toString(c.exec(ctx, "TYPE", key))
+}
+
+// Keys returns keys matching pattern
+func (c Commands) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return toStrings(c.exec(ctx, "KEYS", pattern))
+}
+
+/* ---- String ---- */
+
+// Get returns value of key, ErrNil if key does not exist
+func (c Commands) Get(ctx context.Context, key string) (string, error) {
+	return toString(c.exec(ctx, "GET", key))
+}
+
+// Set sets value of key, key expires after ttl if ttl is positive
+func (c Commands) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = appendInts(append(args, "PX"), ttl.Milliseconds())
+	}
+	return toOk(c.exec(ctx, args...))
+}
+
+// SetNX sets value only if key does not exist, returns whether value is set
+func (c Commands) SetNX(ctx context.Context, key string, value string) (bool, error) {
+	return toBool(c.exec(ctx, "SETNX", key, value))
+}
+
+// GetSet sets value and returns old value
+func (c Commands) GetSet(ctx context.Context, key string, value string) (string, error) {
+	return toString(c.exec(ctx, "GETSET", key, value))
+}
+
+// MGet returns values of keys, value of missing key is empty string
+func (c Commands) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	return toStrings(c.exec(ctx, append([]string{"MGET"}, keys...)...))
+}
+
+// MSet sets key value pairs, pairs are in format of key1, value1, key2, value2...
+func (c Commands) MSet(ctx context.Context, pairs ...string) error {
+	return toOk(c.exec(ctx, append([]string{"MSET"}, pairs...)...))
+}
+
+// Incr increases value of key by 1 and returns new value
+func (c Commands) Incr(ctx context.Context, key string) (int64, error) {
+	return toInt(c.exec(ctx, "INCR", key))
+}
+
+// IncrBy increases value of key by delta and returns new value
+func (c Commands) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return toInt(c.exec(ctx, appendInts([]string{"INCRBY", key}, delta)...))
+}
+
+// Decr decreases value of key by 1 and returns new value
+func (c Commands) Decr(ctx context.Context, key string) (int64, error) {
+	return toInt(c.exec(ctx, "DECR", key))
+}
+
+/* ---- Hash ---- */
+
+// HSet sets field of hash, returns whether it is a new field
+func (c Commands) HSet(ctx context.Context, key string, field string, value string) (bool, error) {
+	return toBool(c.exec(ctx, "HSET", key, field, value))
+}
+
+// HGet returns value of field, ErrNil if field does not exist
+func (c Commands) HGet(ctx context.Context, key string, field string) (string, error) {
+	return toString(c.exec(ctx, "HGET", key, field))
+}
+
+// HGetAll returns all fields and values of hash
+func (c Commands) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return toStringMap(c.exec(ctx, "HGETALL", key))
+}
+
+// HDel removes fields and returns number of removed fields
+func (c Commands) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"HDEL", key}, fields...)...))
+}
+
+// HIncrBy increases value of field by delta and returns new value
+func (c Commands) HIncrBy(ctx context.Context, key string, field string, delta int64) (int64, error) {
+	return toInt(c.exec(ctx, appendInts([]string{"HINCRBY", key, field}, delta)...))
+}
+
+/* ---- List ---- */
+
+// LPush inserts values at head of list and returns length of list
+func (c Commands) LPush(ctx context.Context, key string, values ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"LPUSH", key}, values...)...))
+}
+
+// RPush inserts values at tail of list and returns length of list
+func (c Commands) RPush(ctx context.Context, key string, values ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"RPUSH", key}, values...)...))
+}
+
+// LPop removes and returns the first element, ErrNil if list is empty
+func (c Commands) LPop(ctx context.Context, key string) (string, error) {
+	return toString(c.exec(ctx, "LPOP", key))
+}
+
+// RPop removes and returns the last element, ErrNil if list is empty
+func (c Commands) RPop(ctx context.Context, key string) (string, error) {
+	return toString(c.exec(ctx, "RPOP", key))
+}
+
+// LLen returns length of list
+func (c Commands) LLen(ctx context.Context, key string) (int64, error) {
+	return toInt(c.exec(ctx, "LLEN", key))
+}
+
+// LRange returns elements between start and stop, both are inclusive and could be negative
+func (c Commands) LRange(ctx context.Context, key string, start int64, stop int64) ([]string, error) {
+	return toStrings(c.exec(ctx, appendInts([]string{"LRANGE", key}, start, stop)...))
+}
+
+/* ---- Set ---- */
+
+// SAdd adds members and returns number of new members
+func (c Commands) SAdd(ctx context.Context, key string, members ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"SADD", key}, members...)...))
+}
+
+// SRem removes members and returns number of removed members
+func (c Commands) SRem(ctx context.Context, key string, members ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"SREM", key}, members...)...))
+}
+
+// SIsMember tells whether member is in set
+func (c Commands) SIsMember(ctx context.Context, key string, member string) (bool, error) {
+	return toBool(c.exec(ctx, "SISMEMBER", key, member))
+}
+
+// SMembers returns all members of set
+func (c Commands) SMembers(ctx context.Context, key string) ([]string, error) {
+	return toStrings(c.exec(ctx, "SMEMBERS", key))
+}
+
+// SCard returns number of members
+func (c Commands) SCard(ctx context.Context, key string) (int64, error) {
+	return toInt(c.exec(ctx, "SCARD", key))
+}
+
+/* ---- SortedSet ---- */
+
+// Z is a member of sorted set with its score
+type Z struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd adds members and returns number of new members
+func (c Commands) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	args := []string{"ZADD", key}
+	for _, z := range members {
+		args = append(args, strconv.FormatFloat(z.Score, 'f', -1, 64), z.Member)
+	}
+	return toInt(c.exec(ctx, args...))
+}
+
+// ZScore returns score of member, ErrNil if member does not exist
+func (c Commands) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	return toFloat(c.exec(ctx, "ZSCORE", key, member))
+}
+
+// ZIncrBy increases score of member and returns new score
+func (c Commands) ZIncrBy(ctx context.Context, key string, delta float64, member string) (float64, error) {
+	return toFloat(c.exec(ctx, "ZINCRBY", key, strconv.FormatFloat(delta, 'f', -1, 64), member))
+}
+
+// ZRank returns rank of member in ascending order, ErrNil if member does not exist
+func (c Commands) ZRank(ctx context.Context, key string, member string) (int64, error) {
+	return toInt(c.exec(ctx, "ZRANK", key, member))
+}
+
+// ZCard returns number of members
+func (c Commands) ZCard(ctx context.Context, key string) (int64, error) {
+	return toInt(c.exec(ctx, "ZCARD", key))
+}
+
+// ZRange returns members ranked between start and stop in ascending order
+func (c Commands) ZRange(ctx context.Context, key string, start int64, stop int64) ([]string, error) {
+	return toStrings(c.exec(ctx, appendInts([]string{"ZRANGE", key}, start, stop)...))
+}
+
+// ZRangeWithScores returns members with scores ranked between start and stop in ascending order
+func (c Commands) ZRangeWithScores(ctx context.Context, key string, start int64, stop int64) ([]Z, error) {
+	list, err := toStrings(c.exec(ctx, append(appendInts([]string{"ZRANGE", key}, start, stop), "WITHSCORES")...))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Z, 0, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		score, err := strconv.ParseFloat(list[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Z{Member: list[i], Score: score})
+	}
+	return result, nil
+}
+
+// ZRem removes members and returns number of removed members
+func (c Commands) ZRem(ctx context.Context, key string, members ...string) (int64, error) {
+	return toInt(c.exec(ctx, append([]string{"ZREM", key}, members...)...))
+}
+
+/* ---- Pub / Sub ---- */
+
+// Publish sends message to channel and returns number of receivers
+func (c Commands) Publish(ctx context.Context, channel string, message string) (int64, error) {
+	return toInt(c.exec(ctx, "PUBLISH", channel, message))
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/parser"
+	"godis/redis/reply"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is an error reply sent by server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Conn is a synchronous connection, it is not safe for concurrent use
+type Conn struct {
+	netConn net.Conn
+	parser  *parser.Parser
+	opts    *Options
+	buf     bytes.Buffer
+
+	lastUsed time.Time
+	// broken connection should not be used any more, such as after io error or timeout
+	broken bool
+
+	// states left by commands, so that Pool won't give a dirty connection to the next user
+	db      int
+	inMulti bool
+	// server pushes messages to the connection, such as after SUBSCRIBE, MONITOR or CLIENT TRACKING ON
+	pushing bool
+}
+
+// Dial connects to server, then authenticates and selects db according to opts
+func Dial(ctx context.Context, opts *Options) (*Conn, error) {
+	opts = opts.withDefaults()
+	return dial(ctx, opts)
+}
+
+func dial(ctx context.Context, opts *Options) (*Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: opts.DialTimeout,
+	}
+	var netConn net.Conn
+	var err error
+	if opts.TLSConfig != nil {
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config:    opts.TLSConfig,
+		}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", opts.Addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", opts.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{
+		netConn:  netConn,
+		parser:   parser.NewReplyParser(netConn),
+		opts:     opts,
+		lastUsed: time.Now(),
+	}
+	if opts.Password != "" {
+		_, err = conn.Do(ctx, utils.ToCmdLine("AUTH", opts.Password))
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if opts.DB != 0 {
+		_, err = conn.Do(ctx, utils.ToCmdLine("SELECT", strconv.Itoa(opts.DB)))
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Do sends a command and waits for its reply, error reply is returned as Error
+func (conn *Conn) Do(ctx context.Context, cmdLine [][]byte) (redis.Reply, error) {
+	err := conn.send(ctx, cmdLine)
+	if err != nil {
+		return nil, err
+	}
+	result, err := conn.receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.track(cmdLine, result)
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return nil, Error(errReply.Error())
+	}
+	return result, nil
+}
+
+// Pipeline sends all commands at once then reads their replies, error replies are kept in result
+func (conn *Conn) Pipeline(ctx context.Context, cmdLines ...[][]byte) ([]redis.Reply, error) {
+	err := conn.send(ctx, cmdLines...)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]redis.Reply, len(cmdLines))
+	for i := range cmdLines {
+		results[i], err = conn.receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn.track(cmdLines[i], results[i])
+	}
+	return results, nil
+}
+
+// track records states changed by the command according to its reply
+func (conn *Conn) track(cmdLine [][]byte, result redis.Reply) {
+	if len(cmdLine) == 0 {
+		return
+	}
+	_, isErr := result.(reply.ErrorReply)
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "multi":
+		if !isErr {
+			conn.inMulti = true
+		}
+		return
+	case "exec", "discard":
+		conn.inMulti = false
+		return
+	}
+	if conn.inMulti {
+		// queued commands take effect after EXEC
+		return
+	}
+	switch cmdName {
+	case "select":
+		if isErr || len(cmdLine) != 2 {
+			return
+		}
+		if db, err := strconv.Atoi(string(cmdLine[1])); err == nil {
+			conn.db = db
+		}
+	case "subscribe", "psubscribe", "ssubscribe", "monitor":
+		conn.pushing = true
+	case "client":
+		if len(cmdLine) >= 3 && strings.EqualFold(string(cmdLine[1]), "tracking") &&
+			strings.EqualFold(string(cmdLine[2]), "on") && !isErr {
+			conn.pushing = true
+		}
+	}
+}
+
+// Receive reads the next reply without sending command, such as messages after SUBSCRIBE or MONITOR
+func (conn *Conn) Receive(ctx context.Context) (redis.Reply, error) {
+	return conn.receive(ctx)
+}
+
+// Close closes the underlying network connection
+func (conn *Conn) Close() error {
+	conn.broken = true
+	return conn.netConn.Close()
+}
+
+// RemoteAddr returns address of server
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.netConn.RemoteAddr()
+}
+
+// watchContext interrupts blocking io once ctx is done, the returned function must be called after io finished
+func (conn *Conn) watchContext(ctx context.Context) func() {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			_ = conn.netConn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	if timeout > 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
+// ioError marks connection broken, and reports ctx error instead if ctx is done
+func (conn *Conn) ioError(ctx context.Context, err error) error {
+	conn.broken = true
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (conn *Conn) send(ctx context.Context, cmdLines ...[][]byte) error {
+	if conn.broken {
+		return ErrBrokenConn
+	}
+	defer conn.watchContext(ctx)()
+	conn.lastUsed = time.Now()
+	conn.buf.Reset()
+	for _, cmdLine := range cmdLines {
+		conn.buf.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	}
+	err := conn.netConn.SetWriteDeadline(deadline(ctx, conn.opts.WriteTimeout))
+	if err != nil {
+		return conn.ioError(ctx, err)
+	}
+	_, err = conn.netConn.Write(conn.buf.Bytes())
+	if err != nil {
+		return conn.ioError(ctx, err)
+	}
+	return nil
+}
+
+// receive reads a reply, error reply is returned as reply
+func (conn *Conn) receive(ctx context.Context) (redis.Reply, error) {
+	if conn.broken {
+		return nil, ErrBrokenConn
+	}
+	defer conn.watchContext(ctx)()
+	err := conn.netConn.SetReadDeadline(deadline(ctx, conn.opts.ReadTimeout))
+	if err != nil {
+		return nil, conn.ioError(ctx, err)
+	}
+	result, err := conn.parser.Next()
+	if err != nil {
+		// parser cannot recover from malformed reply since it doesn't know where the next reply begins
+		return nil, conn.ioError(ctx, err)
+	}
+	return result, nil
+}
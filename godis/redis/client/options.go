@@ -0,0 +1,87 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Options configures connections created by Pool, ClusterClient and Subscriber
+type Options struct {
+	// Addr is address of server, such as 127.0.0.1:6399
+	Addr string
+	// Password is sent by AUTH after connected if it is not empty
+	Password string
+	// DB is selected after connected
+	DB int
+	// connect by tls if it is not nil
+	TLSConfig *tls.Config
+
+	// DialTimeout limits time of connecting, default is 5 seconds
+	DialTimeout time.Duration
+	// ReadTimeout and WriteTimeout are used when context of call has no deadline,
+	// default is 3 seconds, negative value means no timeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// PoolSize is max number of connections of a Pool, default is 10
+	PoolSize int
+	// connections idle longer than HealthCheckInterval are checked by PING before use, default is 1 minute
+	HealthCheckInterval time.Duration
+	// MaxRetries is times to retry dialing, default is 3, negative value disables retry.
+	// Delay between retries begins from MinRetryBackoff and doubles every time until MaxRetryBackoff
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+const (
+	defaultDialTimeout         = 5 * time.Second
+	defaultIOTimeout           = 3 * time.Second
+	defaultPoolSize            = 10
+	defaultHealthCheckInterval = time.Minute
+	defaultMaxRetries          = 3
+	defaultMinRetryBackoff     = 8 * time.Millisecond
+	defaultMaxRetryBackoff     = 512 * time.Millisecond
+)
+
+// withDefaults returns a copy of opts whose zero fields are set to default
+func (opts *Options) withDefaults() *Options {
+	o := *opts
+	if o.DialTimeout == 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = defaultIOTimeout
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = defaultIOTimeout
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = defaultPoolSize
+	}
+	if o.HealthCheckInterval == 0 {
+		o.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.MinRetryBackoff <= 0 {
+		o.MinRetryBackoff = defaultMinRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	return &o
+}
+
+// backoff returns delay before the attempt-th retry, attempt begins from 0
+func (opts *Options) backoff(attempt int) time.Duration {
+	if attempt > 30 {
+		return opts.MaxRetryBackoff
+	}
+	d := opts.MinRetryBackoff << uint(attempt)
+	if d > opts.MaxRetryBackoff || d <= 0 {
+		d = opts.MaxRetryBackoff
+	}
+	return d
+}
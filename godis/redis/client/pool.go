@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPoolClosed is returned when using a closed Pool
+	ErrPoolClosed = errors.New("redis: pool is closed")
+	// ErrBrokenConn is returned when using a connection after io error
+	ErrBrokenConn = errors.New("redis: connection is broken")
+)
+
+// Pool is a client holding a pool of connections, it is safe for concurrent use
+type Pool struct {
+	Commands
+	opts *Options
+	// idle connections, newest last
+	idle []*Conn
+	// slots limits number of open connections
+	slots  chan struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPool creates a Pool, connections are created when needed
+func NewPool(opts *Options) *Pool {
+	opts = opts.withDefaults()
+	pool := &Pool{
+		opts:  opts,
+		slots: make(chan struct{}, opts.PoolSize),
+	}
+	pool.Commands = Commands{
+		do: pool.do,
+	}
+	return pool
+}
+
+// Addr returns address of server
+func (pool *Pool) Addr() string {
+	return pool.opts.Addr
+}
+
+// dialWithRetry dials until success or retried MaxRetries times, wrong password will not be retried
+func dialWithRetry(ctx context.Context, opts *Options) (*Conn, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := dial(ctx, opts)
+		if err == nil {
+			return conn, nil
+		}
+		if _, ok := err.(Error); ok || attempt >= opts.MaxRetries {
+			return nil, err
+		}
+		timer := time.NewTimer(opts.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Acquire takes a connection from pool or dials a new one, it blocks if there are PoolSize connections in use.
+// The connection must be given back by Release
+func (pool *Pool) Acquire(ctx context.Context) (*Conn, error) {
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for {
+		conn, err := pool.popIdle()
+		if err != nil {
+			<-pool.slots
+			return nil, err
+		}
+		if conn == nil {
+			break
+		}
+		if pool.checkHealth(ctx, conn) {
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
+	conn, err := dialWithRetry(ctx, pool.opts)
+	if err != nil {
+		<-pool.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (pool *Pool) popIdle() (*Conn, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return nil, ErrPoolClosed
+	}
+	n := len(pool.idle)
+	if n == 0 {
+		return nil, nil
+	}
+	conn := pool.idle[n-1]
+	pool.idle[n-1] = nil
+	pool.idle = pool.idle[:n-1]
+	return conn, nil
+}
+
+// checkHealth pings connection which is idle for a long time, server may have closed it
+func (pool *Pool) checkHealth(ctx context.Context, conn *Conn) bool {
+	if conn.broken {
+		return false
+	}
+	if time.Since(conn.lastUsed) < pool.opts.HealthCheckInterval {
+		return true
+	}
+	_, err := conn.Do(ctx, utils.ToCmdLine("PING"))
+	return err == nil
+}
+
+// Release gives back connection acquired from pool, broken connection will be closed.
+// Connection selecting another db is switched back to Options.DB,
+// connection still in MULTI or receiving pushed messages is closed since its state cannot be reset
+func (pool *Pool) Release(conn *Conn) {
+	defer func() {
+		<-pool.slots
+	}()
+	if !conn.broken && (conn.inMulti || conn.pushing) {
+		_ = conn.Close()
+	}
+	if !conn.broken && conn.db != pool.opts.DB {
+		_, err := conn.Do(context.Background(), utils.ToCmdLine("SELECT", strconv.Itoa(pool.opts.DB)))
+		if err != nil {
+			_ = conn.Close()
+		}
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if conn.broken || pool.closed {
+		_ = conn.Close()
+		return
+	}
+	pool.idle = append(pool.idle, conn)
+}
+
+// Close closes idle connections, connections in use are closed when they are released
+func (pool *Pool) Close() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return nil
+	}
+	pool.closed = true
+	for _, conn := range pool.idle {
+		_ = conn.Close()
+	}
+	pool.idle = nil
+	return nil
+}
+
+func (pool *Pool) do(ctx context.Context, cmdLine [][]byte) (redis.Reply, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release(conn)
+	return conn.Do(ctx, cmdLine)
+}
+
+// Pipeline sends commands by one connection without waiting for replies one by one
+func (pool *Pool) Pipeline(ctx context.Context, cmdLines ...[][]byte) ([]redis.Reply, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release(conn)
+	return conn.Pipeline(ctx, cmdLines...)
+}
@@ -0,0 +1,472 @@
+package client_test
+
+import (
+	"context"
+	"godis/lib/utils"
+	"godis/redis/client"
+	"godis/redis/reply"
+	"godis/redis/server"
+	"godis/tcp"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer starts a standalone godis server, call the returned function to stop it
+func startServer(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	go tcp.ListenAndServe(listener, server.MakeHandler(), closeChan)
+	return listener.Addr().String(), func() {
+		close(closeChan)
+	}
+}
+
+func TestPool(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	pool := client.NewPool(&client.Options{Addr: addr, PoolSize: 2})
+	defer pool.Close()
+	ctx := context.Background()
+
+	if err := pool.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	key := utils.RandString(10)
+	if _, err := pool.Get(ctx, key); err != client.ErrNil {
+		t.Errorf("expect ErrNil, actual %v", err)
+	}
+	if err := pool.Set(ctx, key, "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := pool.TTL(ctx, key); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("wrong ttl %v, err %v", ttl, err)
+	}
+	if n, err := pool.IncrBy(ctx, key, 9); err != nil || n != 10 {
+		t.Errorf("expect 10, actual %d, err %v", n, err)
+	}
+	if _, err := pool.LPush(ctx, key, "a"); err == nil {
+		t.Error("expect wrong type error")
+	} else if _, ok := err.(client.Error); !ok {
+		t.Errorf("expect error reply, actual %v", err)
+	}
+
+	hashKey := utils.RandString(10)
+	if _, err := pool.HSet(ctx, hashKey, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.HSet(ctx, hashKey, "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := pool.HGetAll(ctx, hashKey)
+	if err != nil || len(hash) != 2 || hash["a"] != "1" || hash["b"] != "2" {
+		t.Errorf("wrong hash %v, err %v", hash, err)
+	}
+
+	listKey := utils.RandString(10)
+	if n, err := pool.RPush(ctx, listKey, "a", "b", "c"); err != nil || n != 3 {
+		t.Errorf("expect 3, actual %d, err %v", n, err)
+	}
+	list, err := pool.LRange(ctx, listKey, 0, -1)
+	if err != nil || len(list) != 3 || list[0] != "a" || list[2] != "c" {
+		t.Errorf("wrong list %v, err %v", list, err)
+	}
+
+	setKey := utils.RandString(10)
+	if _, err := pool.SAdd(ctx, setKey, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	members, err := pool.SMembers(ctx, setKey)
+	sort.Strings(members)
+	if err != nil || len(members) != 2 || members[0] != "a" || members[1] != "b" {
+		t.Errorf("wrong members %v, err %v", members, err)
+	}
+
+	zsetKey := utils.RandString(10)
+	if _, err := pool.ZAdd(ctx, zsetKey, client.Z{Member: "a", Score: 2}, client.Z{Member: "b", Score: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	zs, err := pool.ZRangeWithScores(ctx, zsetKey, 0, -1)
+	if err != nil || len(zs) != 2 || zs[0].Member != "b" || zs[0].Score != 1.5 {
+		t.Errorf("wrong sorted set %v, err %v", zs, err)
+	}
+
+	values, err := pool.MGet(ctx, key, utils.RandString(10))
+	if err != nil || len(values) != 2 || values[0] != "10" || values[1] != "" {
+		t.Errorf("wrong values %v, err %v", values, err)
+	}
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	pool := client.NewPool(&client.Options{Addr: addr, PoolSize: 3})
+	defer pool.Close()
+	ctx := context.Background()
+	key := utils.RandString(10)
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func() {
+			_, err := pool.Incr(ctx, key)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if n, err := pool.Get(ctx, key); err != nil || n != "20" {
+		t.Errorf("expect 20, actual %s, err %v", n, err)
+	}
+
+	// all connections are in use
+	var conns []*client.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Acquire(timeoutCtx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, actual %v", err)
+	}
+	for _, conn := range conns {
+		pool.Release(conn)
+	}
+}
+
+func TestDialError(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	ctx := context.Background()
+	_, err := client.Dial(ctx, &client.Options{Addr: addr, DB: 100})
+	if _, ok := err.(client.Error); !ok {
+		t.Errorf("expect error reply of select, actual %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := listener.Addr().String()
+	_ = listener.Close()
+	pool := client.NewPool(&client.Options{Addr: deadAddr, MaxRetries: 2, MinRetryBackoff: time.Millisecond})
+	defer pool.Close()
+	if err := pool.Ping(ctx); err == nil {
+		t.Error("expect dial error")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	pool := client.NewPool(&client.Options{Addr: addr, PoolSize: 2})
+	defer pool.Close()
+	ctx := context.Background()
+	key := utils.RandString(10)
+	if err := pool.Set(ctx, key, "1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	incr := func(modify bool) error {
+		return pool.Watch(ctx, func(tx *client.Tx) error {
+			value, err := tx.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			n, _ := strconv.Atoi(value)
+			if modify {
+				// modified by another connection
+				if err := pool.Set(ctx, key, "100", 0); err != nil {
+					return err
+				}
+			}
+			results, err := tx.Exec(ctx, utils.ToCmdLine("SET", key, strconv.Itoa(n+1)), utils.ToCmdLine("GET", key))
+			if err != nil {
+				return err
+			}
+			if len(results) != 2 || string(results[1].ToBytes()) != string(reply.MakeBulkReply([]byte(strconv.Itoa(n+1))).ToBytes()) {
+				t.Errorf("wrong results of exec")
+			}
+			return nil
+		}, key)
+	}
+	if err := incr(false); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := pool.Get(ctx, key); value != "2" {
+		t.Errorf("expect 2, actual %s", value)
+	}
+	if err := incr(true); err != client.ErrTxFailed {
+		t.Errorf("expect ErrTxFailed, actual %v", err)
+	}
+	if value, _ := pool.Get(ctx, key); value != "100" {
+		t.Errorf("expect 100, actual %s", value)
+	}
+
+	results, err := pool.TxPipelined(ctx, utils.ToCmdLine("INCR", key), utils.ToCmdLine("INCR", key))
+	if err != nil || len(results) != 2 {
+		t.Fatalf("wrong results %v, err %v", results, err)
+	}
+	if _, err := pool.TxPipelined(ctx, utils.ToCmdLine("NO_SUCH_COMMAND")); err == nil {
+		t.Error("expect error")
+	}
+	if value, _ := pool.Get(ctx, key); value != "102" {
+		t.Errorf("expect 102, actual %s", value)
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	pool := client.NewPool(&client.Options{Addr: addr})
+	defer pool.Close()
+	ctx := context.Background()
+	sub, err := pool.Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Subscribe("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for subscriptions
+	for i := 0; ; i++ {
+		n, err := pool.Publish(ctx, "b", "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("subscribe timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := pool.Publish(ctx, "a", "world"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []client.Message{{Channel: "b", Payload: "hello"}, {Channel: "a", Payload: "world"}}
+	for _, exp := range expected {
+		select {
+		case msg := <-sub.Messages():
+			if *msg != exp {
+				t.Errorf("expect %v, actual %v", exp, *msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout")
+		}
+	}
+
+	if err := sub.Unsubscribe("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	_ = sub.Close()
+	for range sub.Messages() {
+	}
+	if err := sub.Subscribe("a"); err != client.ErrSubscriberClosed {
+		t.Errorf("expect ErrSubscriberClosed, actual %v", err)
+	}
+}
+
+func TestClusterClient(t *testing.T) {
+	addr1, stop1 := startServer(t)
+	defer stop1()
+	addr2, stop2 := startServer(t)
+	defer stop2()
+	ctx := context.Background()
+	c := client.NewClusterClient([]string{addr1, addr2}, &client.Options{})
+	defer c.Close()
+	pool1 := client.NewPool(&client.Options{Addr: addr1})
+	defer pool1.Close()
+	pool2 := client.NewPool(&client.Options{Addr: addr2})
+	defer pool2.Close()
+
+	for i := 0; i < 20; i++ {
+		key := "k" + strconv.Itoa(i)
+		if err := c.Set(ctx, key, key, 0); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := c.Get(ctx, key); err != nil || value != key {
+			t.Errorf("expect %s, actual %s, err %v", key, value, err)
+		}
+		// key is stored on the node owning it
+		owner, other := pool1, pool2
+		if c.NodePool(key).Addr() == addr2 {
+			owner, other = pool2, pool1
+		}
+		if n, err := owner.Exists(ctx, key); err != nil || n != 1 {
+			t.Errorf("key %s is not on owner node", key)
+		}
+		if n, err := other.Exists(ctx, key); err != nil || n != 0 {
+			t.Errorf("key %s is on wrong node", key)
+		}
+	}
+
+	cmdLines := make([][][]byte, 20)
+	for i := range cmdLines {
+		cmdLines[i] = utils.ToCmdLine("GET", "k"+strconv.Itoa(i))
+	}
+	results, err := c.Pipeline(ctx, cmdLines...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		expected := reply.MakeBulkReply([]byte("k" + strconv.Itoa(i)))
+		if string(result.ToBytes()) != string(expected.ToBytes()) {
+			t.Errorf("expect %s, actual %s", expected.ToBytes(), result.ToBytes())
+		}
+	}
+
+	// unreachable node
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := listener.Addr().String()
+	_ = listener.Close()
+	c2 := client.NewClusterClient([]string{deadAddr, addr1}, &client.Options{MaxRetries: -1})
+	defer c2.Close()
+	for i := 0; i < 20; i++ {
+		if err := c2.Ping(ctx); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// proxy forwards connections to addr, call the returned function to break all forwarded connections
+func startProxy(t *testing.T, addr string) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			mu.Lock()
+			conns = append(conns, conn, upstream)
+			mu.Unlock()
+			go io.Copy(upstream, conn)
+			go io.Copy(conn, upstream)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return listener.Addr().String(), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		conns = nil
+	}
+}
+
+func TestSubscriberReconnect(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	proxyAddr, breakConns := startProxy(t, addr)
+	pool := client.NewPool(&client.Options{Addr: addr})
+	defer pool.Close()
+	ctx := context.Background()
+	sub, err := client.NewPool(&client.Options{Addr: proxyAddr}).Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	publish := func(payload string) {
+		// server may not have noticed the broken connection, publish until the subscriber receives
+		for i := 0; ; i++ {
+			if _, err := pool.Publish(ctx, "a", payload); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case msg := <-sub.Messages():
+				// message published by previous round may be duplicated
+				if msg.Payload == payload {
+					return
+				}
+			case <-time.After(20 * time.Millisecond):
+			}
+			if i > 100 {
+				t.Fatal("receive timeout")
+			}
+		}
+	}
+	publish("before")
+	breakConns()
+	publish("after")
+}
+
+func TestPoolResetState(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+	pool := client.NewPool(&client.Options{Addr: addr, PoolSize: 1})
+	defer pool.Close()
+	ctx := context.Background()
+
+	// db selected by a user is switched back before the connection is reused
+	if _, err := pool.Do(ctx, "SELECT", "1"); err != nil {
+		t.Fatal(err)
+	}
+	key := utils.RandString(10)
+	if err := pool.Set(ctx, key, "0", 0); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.Dial(ctx, &client.Options{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ret, err := conn.Do(ctx, utils.ToCmdLine("GET", key))
+	if bulk, ok := ret.(*reply.BulkReply); err != nil || !ok || string(bulk.Arg) != "0" {
+		t.Errorf("key should be set in db 0, actual %v, err %v", ret, err)
+	}
+
+	// connections in MULTI or subscribing cannot be reused
+	for _, cmdLine := range [][][]byte{utils.ToCmdLine("MULTI"), utils.ToCmdLine("SUBSCRIBE", "ch")} {
+		c, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Do(ctx, cmdLine); err != nil {
+			t.Fatal(err)
+		}
+		pool.Release(c)
+		c2, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c2 == c {
+			t.Errorf("connection after %s should be closed", cmdLine[0])
+		}
+		pool.Release(c2)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"godis/lib/logger"
+	"godis/lib/utils"
+	"godis/redis/reply"
+	"sync"
+	"time"
+)
+
+// ErrSubscriberClosed is returned when using a closed Subscriber
+var ErrSubscriberClosed = errors.New("redis: subscriber is closed")
+
+const messageChanSize = 256
+
+// Message is a message received from a subscribed channel
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// Subscriber receives messages on a dedicated connection.
+// It reconnects after connection lost and subscribes the channels again, messages published in between are lost
+type Subscriber struct {
+	opts *Options
+
+	mu       sync.Mutex
+	conn     *Conn
+	channels map[string]struct{}
+	closed   bool
+
+	messages chan *Message
+	closing  chan struct{}
+}
+
+// Subscribe creates a Subscriber listening on channels
+func (pool *Pool) Subscribe(ctx context.Context, channels ...string) (*Subscriber, error) {
+	return newSubscriber(ctx, pool.opts, channels)
+}
+
+func newSubscriber(ctx context.Context, opts *Options, channels []string) (*Subscriber, error) {
+	o := *opts
+	o.ReadTimeout = -1 // waiting for messages, no read timeout
+	conn, err := dialWithRetry(ctx, &o)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{
+		opts:     &o,
+		conn:     conn,
+		channels: make(map[string]struct{}),
+		messages: make(chan *Message, messageChanSize),
+		closing:  make(chan struct{}),
+	}
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+	}
+	if len(channels) > 0 {
+		err = s.send(append([]string{"SUBSCRIBE"}, channels...))
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	go s.receive()
+	return s, nil
+}
+
+// Messages returns the channel of received messages, it is closed after Subscriber closed
+func (s *Subscriber) Messages() <-chan *Message {
+	return s.messages
+}
+
+// Subscribe listens on more channels.
+// If connection is lost the channels will be subscribed after reconnected
+func (s *Subscriber) Subscribe(channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSubscriberClosed
+	}
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+	}
+	return s.send(append([]string{"SUBSCRIBE"}, channels...))
+}
+
+// Unsubscribe stops listening on channels
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSubscriberClosed
+	}
+	for _, channel := range channels {
+		delete(s.channels, channel)
+	}
+	return s.send(append([]string{"UNSUBSCRIBE"}, channels...))
+}
+
+// Close closes connection and the message channel
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.closing)
+	return s.conn.netConn.Close()
+}
+
+// send writes command without waiting for reply, replies are read by receive goroutine. s.mu must be held
+func (s *Subscriber) send(args []string) error {
+	data := reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()
+	err := s.conn.netConn.SetWriteDeadline(deadline(context.Background(), s.opts.WriteTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.netConn.Write(data)
+	return err
+}
+
+func (s *Subscriber) receive() {
+	defer close(s.messages)
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	for {
+		// conn is only replaced by this goroutine, so it is safe to read parser without lock
+		result, err := conn.parser.Next()
+		if err != nil {
+			conn = s.reconnect(err)
+			if conn == nil {
+				return
+			}
+			continue
+		}
+		msg, ok := result.(*reply.MultiBulkReply)
+		if !ok || len(msg.Args) != 3 || string(msg.Args[0]) != "message" {
+			// confirmations of subscribe and unsubscribe
+			continue
+		}
+		select {
+		case s.messages <- &Message{Channel: string(msg.Args[1]), Payload: string(msg.Args[2])}:
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// reconnect dials until success and subscribes channels again, returns nil if Subscriber closed
+func (s *Subscriber) reconnect(cause error) *Conn {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+	logger.Warn("subscriber lost connection: " + cause.Error())
+	_ = s.conn.netConn.Close()
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(s.opts.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-s.closing:
+			timer.Stop()
+			return nil
+		}
+		conn, err := dial(context.Background(), s.opts)
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		s.conn = conn
+		if len(s.channels) > 0 {
+			channels := make([]string, 0, len(s.channels))
+			for channel := range s.channels {
+				channels = append(channels, channel)
+			}
+			err = s.send(append([]string{"SUBSCRIBE"}, channels...))
+		}
+		s.mu.Unlock()
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		return conn
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"godis/interface/redis"
+	"godis/lib/utils"
+	"godis/redis/reply"
+)
+
+// ErrTxFailed is returned by Tx.Exec when watched keys are modified
+var ErrTxFailed = errors.New("redis: transaction failed")
+
+// Tx holds a connection which watches keys, commands of Tx are executed immediately by the connection
+type Tx struct {
+	Commands
+	conn *Conn
+}
+
+// Watch watches keys on a connection and calls fn with it,
+// fn reads values by Tx and commits its changes by Tx.Exec which fails with ErrTxFailed if any watched key has been modified
+func (pool *Pool) Watch(ctx context.Context, fn func(tx *Tx) error, keys ...string) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Release(conn)
+	tx := &Tx{
+		conn: conn,
+	}
+	tx.Commands = Commands{
+		do: conn.Do,
+	}
+	if len(keys) > 0 {
+		_, err = conn.Do(ctx, utils.ToCmdLine(append([]string{"WATCH"}, keys...)...))
+		if err != nil {
+			return err
+		}
+	}
+	err = fn(tx)
+	if len(keys) > 0 && !conn.broken {
+		// watching is kept by connection, clear it before the connection is reused
+		if _, err2 := conn.Do(ctx, utils.ToCmdLine("UNWATCH")); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Exec executes commands in MULTI/EXEC and returns their replies
+func (tx *Tx) Exec(ctx context.Context, cmdLines ...[][]byte) ([]redis.Reply, error) {
+	return execTx(ctx, tx.conn, cmdLines)
+}
+
+// TxPipelined executes commands in MULTI/EXEC without watching
+func (pool *Pool) TxPipelined(ctx context.Context, cmdLines ...[][]byte) ([]redis.Reply, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release(conn)
+	return execTx(ctx, conn, cmdLines)
+}
+
+func execTx(ctx context.Context, conn *Conn, cmdLines [][][]byte) ([]redis.Reply, error) {
+	lines := make([][][]byte, 0, len(cmdLines)+2)
+	lines = append(lines, utils.ToCmdLine("MULTI"))
+	lines = append(lines, cmdLines...)
+	lines = append(lines, utils.ToCmdLine("EXEC"))
+	results, err := conn.Pipeline(ctx, lines...)
+	if err != nil {
+		return nil, err
+	}
+	// the first error of MULTI or queuing is more meaningful than EXECABORT
+	for _, result := range results {
+		if errReply, ok := result.(reply.ErrorReply); ok {
+			return nil, Error(errReply.Error())
+		}
+	}
+	switch execResult := results[len(results)-1].(type) {
+	case *reply.MultiRawReply:
+		return execResult.Replies, nil
+	case *reply.MultiBulkReply:
+		replies := make([]redis.Reply, len(execResult.Args))
+		for i, arg := range execResult.Args {
+			if arg == nil {
+				replies[i] = reply.MakeNullBulkReply()
+			} else {
+				replies[i] = reply.MakeBulkReply(arg)
+			}
+		}
+		return replies, nil
+	case *reply.EmptyMultiBulkReply:
+		// godis replies empty multi bulk if watched keys changed
+		if len(cmdLines) > 0 {
+			return nil, ErrTxFailed
+		}
+		return []redis.Reply{}, nil
+	case *reply.NullBulkReply:
+		return nil, ErrTxFailed
+	}
+	return nil, unexpectedReply(results[len(results)-1])
+}
@@ -18,6 +18,9 @@ type Connection struct {
 	// lock while server sending response
 	mu sync.Mutex
 
+	// replies of pipelined requests waiting to be sent together
+	pending []byte
+
 	// subscribing channels
 	subs map[string]bool
 
@@ -31,13 +34,27 @@ type Connection struct {
 
 	// selected db
 	selectedDB int
+
+	// set on connections used for loading aof
+	replaying bool
 }
 
-// RemoteAddr returns the remote network address
+// RemoteAddr returns the remote network address, nil for FakeConn
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.RemoteAddr()
 }
 
+// SetReadDeadline makes reading from client fail after t, it wakes up the reader blocked on an idle client
+func (c *Connection) SetReadDeadline(t time.Time) error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
 // Close disconnect with the client
 func (c *Connection) Close() error {
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
@@ -52,7 +69,10 @@ func NewConn(conn net.Conn) *Connection {
 	}
 }
 
-// Write sends response to client over tcp connection
+// maxPendingKeep limits capacity of pending buffer kept for reuse
+const maxPendingKeep = 64 * 1024
+
+// Write sends response to client over tcp connection, pending replies will be sent before it
 func (c *Connection) Write(b []byte) error {
 	if len(b) == 0 {
 		return nil
@@ -64,10 +84,50 @@ func (c *Connection) Write(b []byte) error {
 		c.mu.Unlock()
 	}()
 
+	if len(c.pending) > 0 {
+		c.pending = append(c.pending, b...)
+		return c.flushLocked()
+	}
 	_, err := c.conn.Write(b)
 	return err
 }
 
+// AppendReply buffers reply, it will be sent by Flush or the next Write
+func (c *Connection) AppendReply(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, b...)
+}
+
+// PendingSize returns size of buffered replies
+func (c *Connection) PendingSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Flush sends all buffered replies in one write
+func (c *Connection) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return nil
+	}
+	c.waitingReply.Add(1)
+	defer c.waitingReply.Done()
+	return c.flushLocked()
+}
+
+func (c *Connection) flushLocked() error {
+	_, err := c.conn.Write(c.pending)
+	if cap(c.pending) > maxPendingKeep {
+		c.pending = nil
+	} else {
+		c.pending = c.pending[:0]
+	}
+	return err
+}
+
 // Subscribe add current connection into subscribers of the given channel
 func (c *Connection) Subscribe(channel string) {
 	c.mu.Lock()
@@ -166,6 +226,16 @@ func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
 
+// IsReplaying tells whether the connection is used for loading aof
+func (c *Connection) IsReplaying() bool {
+	return c.replaying
+}
+
+// SetReplaying marks the connection used for loading aof
+func (c *Connection) SetReplaying(replaying bool) {
+	c.replaying = replaying
+}
+
 // FakeConn implements redis.Connection for test
 type FakeConn struct {
 	Connection
@@ -10,7 +10,16 @@ import (
 	"io"
 	"runtime/debug"
 	"strconv"
-	"strings"
+)
+
+const (
+	// limits of request size, prevent huge allocation caused by malformed request
+	maxInlineSize    = 64 * 1024
+	maxMultiBulkLen  = 1024 * 1024
+	maxBulkLen       = 512 * 1024 * 1024
+	readerBufferSize = 16 * 1024
+	// scratch buffer larger than it will be released after use
+	maxScratchKeep = 1024 * 1024
 )
 
 // Payload stores redis.Reply or error
@@ -19,232 +28,343 @@ type Payload struct {
 	Err  error
 }
 
-// ParseStream reads data from io.Reader and send payloads through channel
-func ParseStream(reader io.Reader) <-chan *Payload {
-	ch := make(chan *Payload)
-	go parse0(reader, ch)
-	return ch
+// ProtocolError means the received message is malformed, parser can go on reading the next message
+type ProtocolError struct {
+	Msg string
 }
 
-// ParseBytes reads data from []byte and return all replies
-func ParseBytes(data []byte) ([]redis.Reply, error) {
-	ch := make(chan *Payload)
-	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
-	var results []redis.Reply
-	for payload := range ch {
-		if payload == nil {
-			return nil, errors.New("no reply")
-		}
-		if payload.Err != nil {
-			if payload.Err == io.EOF {
-				break
-			}
-			return nil, payload.Err
-		}
-		results = append(results, payload.Data)
+func (e *ProtocolError) Error() string {
+	return e.Msg
+}
+
+func makeProtocolError(line []byte) error {
+	return &ProtocolError{
+		Msg: "protocol error: " + string(line),
 	}
-	return results, nil
 }
 
-// ParseOne reads data from []byte and return the first payload
-func ParseOne(data []byte) (redis.Reply, error) {
-	ch := make(chan *Payload)
-	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
-	payload := <-ch // parse0 will close the channel
-	if payload == nil {
-		return nil, errors.New("no reply")
+// IsProtocolError tells whether err is caused by malformed message rather than io
+func IsProtocolError(err error) bool {
+	_, ok := err.(*ProtocolError)
+	return ok
+}
+
+// Parser reads redis messages from a stream one by one.
+// Lines are read without copying, and all arguments of a multi bulk share one allocation
+type Parser struct {
+	reader *bufio.Reader
+	// lineBuf joins a line longer than buffer of reader
+	lineBuf []byte
+	// scratch holds arguments of the multi bulk being read, ends records where each argument ends
+	scratch []byte
+	ends    []int
+	// replyMode accepts null multi bulk and nested elements which only appear in replies
+	replyMode bool
+}
+
+// NewParser creates a Parser reading requests from reader
+func NewParser(reader io.Reader) *Parser {
+	return &Parser{
+		reader: bufio.NewReaderSize(reader, readerBufferSize),
 	}
-	return payload.Data, payload.Err
 }
 
-type readState struct {
-	readingMultiLine  bool
-	expectedArgsCount int
-	msgType           byte
-	args              [][]byte
-	bulkLen           int64
+// NewReplyParser creates a Parser reading replies from reader,
+// multi bulk replies may be null or contain elements other than bulk strings, such as reply of EXEC
+func NewReplyParser(reader io.Reader) *Parser {
+	p := NewParser(reader)
+	p.replyMode = true
+	return p
 }
 
-func (s *readState) finished() bool {
-	return s.expectedArgsCount > 0 && len(s.args) == s.expectedArgsCount
+// Buffered returns the number of bytes received but not parsed yet
+func (p *Parser) Buffered() int {
+	return p.reader.Buffered()
 }
 
-func parse0(reader io.Reader, ch chan<- *Payload) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
+// Next reads the next message, error returned is either *ProtocolError or io error.
+// Parser cannot be used any more after io error
+func (p *Parser) Next() (redis.Reply, error) {
 	for {
-		// read line
-		var ioErr bool
-		msg, ioErr, err = readLine(bufReader, &state)
+		line, err := p.readLine()
 		if err != nil {
-			if ioErr { // encounter io err, stop read
-				ch <- &Payload{
-					Err: err,
-				}
-				close(ch)
-				return
-			}
-			// protocol err, reset read state
-			ch <- &Payload{
-				Err: err,
-			}
-			state = readState{}
+			return nil, err
+		}
+		if len(line) < 2 || line[len(line)-2] != '\r' {
+			// inline command sent by nc or other tools may end with LF only.
+			// line may refer to buffer of reader, so it must be copied before appending
+			p.lineBuf = append(append(p.lineBuf[:0], line[:len(line)-1]...), '\r', '\n')
+			line = p.lineBuf
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			// ignore empty inline command
 			continue
 		}
+		switch line[0] {
+		case '*':
+			return p.readMultiBulk(line)
+		case '$':
+			return p.readBulk(line)
+		default:
+			return parseSingleLineReply(line)
+		}
+	}
+}
 
-		// parse line
-		if !state.readingMultiLine {
-			// receive new response
-			if msg[0] == '*' {
-				// multi bulk reply
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // reset state
-					continue
-				}
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{
-						Data: &reply.EmptyMultiBulkReply{},
-					}
-					state = readState{} // reset state
-					continue
-				}
-			} else if msg[0] == '$' { // bulk reply
-				err = parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // reset state
-					continue
-				}
-				if state.bulkLen == -1 { // null bulk reply
-					ch <- &Payload{
-						Data: &reply.NullBulkReply{},
-					}
-					state = readState{} // reset state
-					continue
-				}
-			} else {
-				// single line reply
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{} // reset state
-				continue
-			}
-		} else {
-			// receive following bulk reply
-			err = readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
-				}
-				state = readState{} // reset state
-				continue
-			}
-			// if sending finished
-			if state.finished() {
-				var result redis.Reply
-				if state.msgType == '*' {
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = reply.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
+// readLine returns line with its tailing LF, the returned slice is only valid until next read
+func (p *Parser) readLine() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if err == nil {
+		return line, nil
+	}
+	if err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	p.lineBuf = append(p.lineBuf[:0], line...)
+	for err == bufio.ErrBufferFull {
+		if len(p.lineBuf) > maxInlineSize {
+			return nil, &ProtocolError{Msg: "protocol error: too big inline request"}
+		}
+		line, err = p.reader.ReadSlice('\n')
+		p.lineBuf = append(p.lineBuf, line...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.lineBuf, nil
+}
+
+// parseLength parses the integer after type byte and before CRLF
+func parseLength(line []byte) (int64, bool) {
+	if len(line) < 4 || line[len(line)-2] != '\r' {
+		return 0, false
+	}
+	digits := line[1 : len(line)-2]
+	negative := false
+	if digits[0] == '-' {
+		negative = true
+		digits = digits[1:]
+		if len(digits) == 0 {
+			return 0, false
+		}
+	}
+	var n int64
+	for _, c := range digits {
+		if c < '0' || c > '9' || n > maxBulkLen {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if negative {
+		n = -n
+	}
+	return n, true
+}
+
+func (p *Parser) readMultiBulk(header []byte) (redis.Reply, error) {
+	argc, ok := parseLength(header)
+	if argc == -1 && p.replyMode { // null multi bulk, such as reply of aborted EXEC
+		return &reply.NullBulkReply{}, nil
+	}
+	if !ok || argc < 0 || argc > maxMultiBulkLen {
+		return nil, makeProtocolError(header)
+	}
+	if argc == 0 {
+		return &reply.EmptyMultiBulkReply{}, nil
+	}
+	p.scratch = p.scratch[:0]
+	p.ends = p.ends[:0]
+	for i := int64(0); i < argc; i++ {
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 2 || line[len(line)-2] != '\r' {
+			return nil, makeProtocolError(line)
+		}
+		if line[0] != '$' {
+			if p.replyMode {
+				// elements are not all bulk strings, such as reply of EXEC or SUBSCRIBE
+				return p.readMultiRaw(argc, line)
 			}
+			p.scratch = append(p.scratch, line[:len(line)-2]...)
+			p.ends = append(p.ends, len(p.scratch))
+			continue
+		}
+		bulkLen, ok := parseLength(line)
+		if !ok || bulkLen < -1 || bulkLen > maxBulkLen {
+			return nil, makeProtocolError(line)
 		}
+		if bulkLen == -1 { // null bulk in multi bulks
+			p.ends = append(p.ends, len(p.scratch))
+			continue
+		}
+		start := len(p.scratch)
+		end := start + int(bulkLen)
+		p.scratch = growBytes(p.scratch, end+2)
+		_, err = io.ReadFull(p.reader, p.scratch[start:end+2])
+		if err != nil {
+			return nil, err
+		}
+		if p.scratch[end] != '\r' || p.scratch[end+1] != '\n' {
+			return nil, makeProtocolError(p.scratch[start : end+2])
+		}
+		p.scratch = p.scratch[:end]
+		p.ends = append(p.ends, end)
+	}
+	// arguments may be retained by database, so copy them out of reused scratch
+	block := make([]byte, len(p.scratch))
+	copy(block, p.scratch)
+	args := make([][]byte, len(p.ends))
+	start := 0
+	for i, end := range p.ends {
+		args[i] = block[start:end:end]
+		start = end
+	}
+	if cap(p.scratch) > maxScratchKeep {
+		p.scratch = nil
 	}
+	return reply.MakeMultiBulkReply(args), nil
 }
 
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
-	var msg []byte
-	var err error
-	if state.bulkLen == 0 { // read normal line
-		msg, err = bufReader.ReadBytes('\n')
+// readMultiRaw reads rest elements of a multi bulk into MultiRawReply,
+// line is the first element which is not a bulk string, elements before it are in scratch
+func (p *Parser) readMultiRaw(argc int64, line []byte) (redis.Reply, error) {
+	replies := make([]redis.Reply, 0, argc)
+	start := 0
+	for _, end := range p.ends {
+		replies = append(replies, reply.MakeBulkReply(append([]byte{}, p.scratch[start:end]...)))
+		start = end
+	}
+	for {
+		// scratch may be reused by nested multi bulk
+		element, err := p.readElement(line)
 		if err != nil {
-			return nil, true, err
+			return nil, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		replies = append(replies, element)
+		if int64(len(replies)) == argc {
+			return reply.MakeMultiRawReply(replies), nil
 		}
-	} else { // read bulk line (binary safe)
-		msg = make([]byte, state.bulkLen+2)
-		_, err = io.ReadFull(bufReader, msg)
+		line, err = p.readLine()
 		if err != nil {
-			return nil, true, err
+			return nil, err
 		}
-		if len(msg) == 0 ||
-			msg[len(msg)-2] != '\r' ||
-			msg[len(msg)-1] != '\n' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		if len(line) < 2 || line[len(line)-2] != '\r' {
+			return nil, makeProtocolError(line)
 		}
-		state.bulkLen = 0
 	}
-	return msg, false, nil
 }
 
-func parseMultiBulkHeader(msg []byte, state *readState) error {
-	var err error
-	var expectedLine uint64
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 32)
+// readElement reads an element of multi bulk starting with line
+func (p *Parser) readElement(line []byte) (redis.Reply, error) {
+	switch line[0] {
+	case '*':
+		return p.readMultiBulk(line)
+	case '$':
+		return p.readBulk(line)
+	case '+', '-', ':':
+		return parseSingleLineReply(line)
+	default:
+		return nil, makeProtocolError(line)
+	}
+}
+
+func (p *Parser) readBulk(header []byte) (redis.Reply, error) {
+	bulkLen, ok := parseLength(header)
+	if !ok || bulkLen < -1 || bulkLen > maxBulkLen {
+		return nil, makeProtocolError(header)
+	}
+	if bulkLen == -1 { // null bulk reply
+		return &reply.NullBulkReply{}, nil
+	}
+	body := make([]byte, bulkLen+2)
+	_, err := io.ReadFull(p.reader, body)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
+		return nil, err
 	}
-	if expectedLine == 0 {
-		state.expectedArgsCount = 0
-		return nil
-	} else if expectedLine > 0 {
-		// first line of multi bulk reply
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = int(expectedLine)
-		state.args = make([][]byte, 0, expectedLine)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+	if body[bulkLen] != '\r' || body[bulkLen+1] != '\n' {
+		return nil, makeProtocolError(body)
 	}
+	return reply.MakeBulkReply(body[:bulkLen:bulkLen]), nil
 }
 
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
-	if err != nil {
-		return errors.New("protocol error: " + string(msg))
+// growBytes extends length of buf to n, reallocates if capacity is not enough
+func growBytes(buf []byte, n int) []byte {
+	if n <= cap(buf) {
+		return buf[:n]
 	}
-	if state.bulkLen == -1 { // null bulk
-		return nil
-	} else if state.bulkLen > 0 {
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+	newCap := 2 * cap(buf)
+	if newCap < n {
+		newCap = n
+	}
+	newBuf := make([]byte, n, newCap)
+	copy(newBuf, buf)
+	return newBuf
+}
+
+// ParseStream reads data from io.Reader and send payloads through channel, replies such as reply of EXEC are accepted
+func ParseStream(reader io.Reader) <-chan *Payload {
+	ch := make(chan *Payload)
+	go parse0(reader, ch)
+	return ch
+}
+
+// ParseBytes reads data from []byte and return all replies
+func ParseBytes(data []byte) ([]redis.Reply, error) {
+	p := NewReplyParser(bytes.NewReader(data))
+	var results []redis.Reply
+	for {
+		result, err := p.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ParseOne reads data from []byte and return the first payload
+func ParseOne(data []byte) (redis.Reply, error) {
+	p := NewReplyParser(bytes.NewReader(data))
+	result, err := p.Next()
+	if err == io.EOF {
+		return nil, errors.New("no reply")
+	}
+	return result, err
+}
+
+func parse0(reader io.Reader, ch chan<- *Payload) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+		close(ch)
+	}()
+	p := NewReplyParser(reader)
+	for {
+		result, err := p.Next()
+		if err != nil {
+			ch <- &Payload{
+				Err: err,
+			}
+			if IsProtocolError(err) {
+				continue
+			}
+			// encounter io err, stop read
+			return
+		}
+		ch <- &Payload{
+			Data: result,
+		}
 	}
 }
 
 func parseSingleLineReply(msg []byte) (redis.Reply, error) {
-	str := strings.TrimSuffix(string(msg), "\r\n")
+	str := string(msg[:len(msg)-2])
 	var result redis.Reply
 	switch msg[0] {
 	case '+': // status reply
@@ -254,37 +374,16 @@ func parseSingleLineReply(msg []byte) (redis.Reply, error) {
 	case ':': // int reply
 		val, err := strconv.ParseInt(str[1:], 10, 64)
 		if err != nil {
-			return nil, errors.New("protocol error: " + string(msg))
+			return nil, makeProtocolError(msg)
 		}
 		result = reply.MakeIntReply(val)
 	default:
-		// parse as text protocol
-		strs := strings.Split(str, " ")
-		args := make([][]byte, len(strs))
-		for i, s := range strs {
-			args[i] = []byte(s)
+		// parse as inline command
+		args, err := SplitArgs([]byte(str))
+		if err != nil {
+			return nil, err
 		}
 		result = reply.MakeMultiBulkReply(args)
 	}
 	return result, nil
 }
-
-// read the non-first lines of multi bulk reply or bulk reply
-func readBody(msg []byte, state *readState) error {
-	line := msg[0 : len(msg)-2]
-	var err error
-	if line[0] == '$' {
-		// bulk reply
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return errors.New("protocol error: " + string(msg))
-		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
-			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
-		}
-	} else {
-		state.args = append(state.args, line)
-	}
-	return nil
-}
@@ -20,6 +20,11 @@ func TestParseStream(t *testing.T) {
 			[]byte("a"),
 			[]byte("\r\n"),
 		}),
+		reply.MakeMultiBulkReply([][]byte{
+			[]byte(""), // test empty bulk
+			[]byte("a"),
+			[]byte(""),
+		}),
 		reply.MakeEmptyMultiBulkReply(),
 	}
 	reqs := bytes.Buffer{}
@@ -79,3 +84,127 @@ func TestParseOne(t *testing.T) {
 		}
 	}
 }
+
+func TestParseInline(t *testing.T) {
+	reqs := "set a \"b c\"\n\r\nget 'a'\r\n  \nset a \"x\n"
+	expected := []redis.Reply{
+		reply.MakeMultiBulkReply(utils.ToCmdLine("set", "a", "b c")),
+		reply.MakeMultiBulkReply(utils.ToCmdLine("get", "a")),
+	}
+	ch := ParseStream(bytes.NewReader([]byte(reqs)))
+	i := 0
+	for payload := range ch {
+		if payload.Err == io.EOF {
+			break
+		}
+		if i == len(expected) {
+			// unbalanced quotes
+			if payload.Err == nil {
+				t.Error("expect protocol error")
+			}
+			i++
+			continue
+		}
+		if payload.Err != nil {
+			t.Error(payload.Err)
+			return
+		}
+		if !utils.BytesEquals(expected[i].ToBytes(), payload.Data.ToBytes()) {
+			t.Errorf("parse failed: %q", payload.Data.ToBytes())
+		}
+		i++
+	}
+	if i != len(expected)+1 {
+		t.Errorf("expect %d payloads, actually %d", len(expected)+1, i)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+		err      bool
+	}{
+		{line: "set a b", expected: []string{"set", "a", "b"}},
+		{line: "  set   a\tb  ", expected: []string{"set", "a", "b"}},
+		{line: `set "a b" 'c d'`, expected: []string{"set", "a b", "c d"}},
+		{line: `set "a\nb\x41\"" 'it\'s'`, expected: []string{"set", "a\nbA\"", "it's"}},
+		{line: `set "" ''`, expected: []string{"set", "", ""}},
+		{line: `set "a`, err: true},
+		{line: `set 'a`, err: true},
+		{line: `set "a"b`, err: true},
+		{line: "", expected: []string{}},
+	}
+	for _, c := range cases {
+		args, err := SplitArgs([]byte(c.line))
+		if c.err {
+			if err == nil {
+				t.Errorf("expect error for %q", c.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("split %q failed: %v", c.line, err)
+			continue
+		}
+		if len(args) != len(c.expected) {
+			t.Errorf("split %q, expect %q, actually %q", c.line, c.expected, args)
+			continue
+		}
+		for i, arg := range args {
+			if string(arg) != c.expected[i] {
+				t.Errorf("split %q, expect %q, actually %q", c.line, c.expected, args)
+				break
+			}
+		}
+	}
+}
+
+func TestParseNestedMultiBulk(t *testing.T) {
+	nested := reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte("a")),
+		reply.MakeIntReply(1),
+		reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeStatusReply("OK"),
+			reply.MakeMultiBulkReply(utils.ToCmdLine("b", "c")),
+		}),
+		reply.MakeErrReply("ERR unknown"),
+		reply.MakeBulkReply([]byte("d")),
+	})
+	data := append(nested.ToBytes(), "*-1\r\n"...)
+	result, err := ParseBytes(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(result) != 2 {
+		t.Errorf("expect 2 replies, actual %d", len(result))
+		return
+	}
+	if _, ok := result[0].(*reply.MultiRawReply); !ok {
+		t.Errorf("expect multi raw reply, actual %T", result[0])
+	}
+	if !utils.BytesEquals(nested.ToBytes(), result[0].ToBytes()) {
+		t.Errorf("wrong result: %s", result[0].ToBytes())
+	}
+	if _, ok := result[1].(*reply.NullBulkReply); !ok {
+		t.Errorf("expect null reply, actual %T", result[1])
+	}
+}
+
+func TestParseRequestMultiBulk(t *testing.T) {
+	// requests are multi bulk of bulk strings, null multi bulk only appears in replies
+	p := NewParser(bytes.NewReader([]byte("*-1\r\n*1\r\n$4\r\nPING\r\n")))
+	_, err := p.Next()
+	if !IsProtocolError(err) {
+		t.Errorf("expect protocol error, actual %v", err)
+	}
+	result, err := p.Next()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !utils.BytesEquals(result.ToBytes(), reply.MakeMultiBulkReply(utils.ToCmdLine("PING")).ToBytes()) {
+		t.Errorf("wrong result: %q", result.ToBytes())
+	}
+}
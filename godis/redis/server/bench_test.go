@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"godis/lib/utils"
+	"godis/redis/parser"
+	"godis/redis/reply"
+	"godis/tcp"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// run with: go test -run none -bench . ./redis/server
+
+func startBenchServer(b *testing.B) (string, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+	return listener.Addr().String(), closeChan
+}
+
+// benchPipeline sends b.N commands in batches of pipelineSize and waits for all replies
+func benchPipeline(b *testing.B, pipelineSize int, makeCmd func(i int) [][]byte) {
+	addr, closeChan := startBenchServer(b)
+	defer func() {
+		closeChan <- struct{}{}
+	}()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	buf := &bytes.Buffer{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for sent := 0; sent < b.N; {
+		buf.Reset()
+		batch := 0
+		for ; batch < pipelineSize && sent < b.N; batch++ {
+			buf.Write(reply.MakeMultiBulkReply(makeCmd(sent)).ToBytes())
+			sent++
+		}
+		_, err = conn.Write(buf.Bytes())
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < batch; i++ {
+			err = skipReply(reader)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// skipReply reads a status, integer or bulk reply
+func skipReply(reader *bufio.Reader) error {
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	if line[0] != '$' {
+		return nil
+	}
+	size, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil || size < 0 {
+		return err
+	}
+	_, err = reader.Discard(size + 2)
+	return err
+}
+
+func setCmd(i int) [][]byte {
+	key := strconv.Itoa(i % 10000)
+	return utils.ToCmdLine("SET", key, key)
+}
+
+func getCmd(i int) [][]byte {
+	return utils.ToCmdLine("GET", strconv.Itoa(i%10000))
+}
+
+func BenchmarkSet(b *testing.B) {
+	benchPipeline(b, 1, setCmd)
+}
+
+func BenchmarkPipelinedSet(b *testing.B) {
+	benchPipeline(b, 1000, setCmd)
+}
+
+func BenchmarkGet(b *testing.B) {
+	benchPipeline(b, 1, getCmd)
+}
+
+func BenchmarkPipelinedGet(b *testing.B) {
+	benchPipeline(b, 1000, getCmd)
+}
+
+func BenchmarkParseMultiBulk(b *testing.B) {
+	cmd := reply.MakeMultiBulkReply(utils.ToCmdLine("SET", "key:000001", "value:000001")).ToBytes()
+	data := bytes.Repeat(cmd, 1000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := parser.NewParser(bytes.NewReader(data))
+		for j := 0; j < 1000; j++ {
+			_, err := p.Next()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
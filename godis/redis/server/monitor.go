@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"godis/config"
+	"godis/redis/connection"
+	"godis/redis/reply"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// monitorQueueSize is the max number of lines waiting to be sent to a monitor,
+// a monitor which cannot keep up is disconnected instead of slowing down other clients
+const monitorQueueSize = 1024
+
+// monitorHub sends every executed command to clients in MONITOR mode
+type monitorHub struct {
+	mu       sync.RWMutex
+	monitors map[*connection.Connection]*monitor
+}
+
+// monitor sends lines to client in its own goroutine
+type monitor struct {
+	client   *connection.Connection
+	queue    chan []byte
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (m *monitor) close() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// serve writes queued lines to client until the monitor is removed or overflowed,
+// then it closes the connection so that the handler of client will remove the monitor
+func (m *monitor) serve() {
+	defer func() {
+		_ = m.client.Close()
+	}()
+	for {
+		select {
+		case line := <-m.queue:
+			if err := m.client.Write(line); err != nil {
+				return
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (hub *monitorHub) add(client *connection.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.monitors == nil {
+		hub.monitors = make(map[*connection.Connection]*monitor)
+	}
+	if _, ok := hub.monitors[client]; ok {
+		return
+	}
+	m := &monitor{
+		client: client,
+		queue:  make(chan []byte, monitorQueueSize),
+		stop:   make(chan struct{}),
+	}
+	hub.monitors[client] = m
+	go m.serve()
+}
+
+func (hub *monitorHub) remove(client *connection.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if m, ok := hub.monitors[client]; ok {
+		m.close()
+		delete(hub.monitors, client)
+	}
+}
+
+// feed formats command like redis: +1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func (hub *monitorHub) feed(client *connection.Connection, cmdLine [][]byte) {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if len(hub.monitors) == 0 {
+		return
+	}
+	now := time.Now()
+	buf := &bytes.Buffer{}
+	buf.WriteByte('+')
+	buf.WriteString(strconv.FormatInt(now.Unix(), 10))
+	buf.WriteByte('.')
+	micro := strconv.Itoa(now.Nanosecond() / 1000)
+	for i := len(micro); i < 6; i++ {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(micro)
+	buf.WriteString(" [")
+	buf.WriteString(strconv.Itoa(client.GetDBIndex()))
+	buf.WriteByte(' ')
+	buf.WriteString(client.RemoteAddr().String())
+	buf.WriteByte(']')
+	for _, arg := range cmdLine {
+		buf.WriteByte(' ')
+		writeQuoted(buf, arg)
+	}
+	buf.WriteString(reply.CRLF)
+	line := buf.Bytes()
+	for conn, m := range hub.monitors {
+		if conn == client {
+			continue
+		}
+		select {
+		case m.queue <- line:
+		case <-m.stop:
+		default:
+			// the monitor cannot keep up, it will be disconnected
+			m.close()
+		}
+	}
+}
+
+// writeQuoted writes arg in double quotes, escaping special and non-printable characters
+func writeQuoted(buf *bytes.Buffer, arg []byte) {
+	const hexDigits = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, c := range arg {
+		switch c {
+		case '\\', '"':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		default:
+			if c >= 0x20 && c < 0x7f {
+				buf.WriteByte(c)
+			} else {
+				buf.WriteString(`\x`)
+				buf.WriteByte(hexDigits[c>>4])
+				buf.WriteByte(hexDigits[c&0xf])
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// isAuthenticated is the same as the check in database, MONITOR is served before commands reach database
+func isAuthenticated(client *connection.Connection) bool {
+	if config.Properties().RequirePass == "" {
+		return true
+	}
+	return client.GetPassword() == config.Properties().RequirePass
+}
+
+// execMonitor turns client into a monitor
+func (h *Handler) execMonitor(client *connection.Connection, cmdLine [][]byte) []byte {
+	if len(cmdLine) != 1 {
+		return reply.MakeArgNumErrReply("monitor").ToBytes()
+	}
+	if !isAuthenticated(client) {
+		return reply.MakeErrReply("NOAUTH Authentication required").ToBytes()
+	}
+	if client.InMultiState() {
+		return reply.MakeErrReply("ERR MONITOR is not allowed in transaction").ToBytes()
+	}
+	h.monitors.add(client)
+	return reply.MakeOkReply().ToBytes()
+}
@@ -12,70 +12,124 @@ import (
 	"godis/interface/database"
 	"godis/lib/logger"
 	"godis/lib/sync/atomic"
+	"godis/lib/sync/wait"
 	"godis/redis/connection"
 	"godis/redis/parser"
 	"godis/redis/reply"
-	"io"
 	"net"
 	"strings"
 	"sync"
+	atomic2 "sync/atomic"
+	"time"
 )
 
 var (
-	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
+	unknownErrReplyBytes    = []byte("-ERR unknown\r\n")
+	maxClientsErrReplyBytes = []byte("-ERR max number of clients reached\r\n")
 )
 
+// flush replies of pipelined requests once they are larger than it
+const maxPendingReplySize = 64 * 1024
+
 // Handler implements tcp.Handler and serves as a redis server
 type Handler struct {
 	activeConn sync.Map // *client -> placeholder
 	db         database.DB
 	closing    atomic.Boolean // refusing new client and new request
+	monitors   monitorHub
+	// number of connected clients, compared with maxclients
+	clientCount int32
+
+	// counts running Handle goroutines
+	handling wait.Wait
+	// handlingMu makes checking closing and adding handling atomic, so Close never waits on a counter growing from 0
+	handlingMu sync.Mutex
+	// commands hold read lock while executing, Close holds write lock to wait for them before closing db
+	dbLock    sync.RWMutex
+	dbClosed  bool
+	closeOnce sync.Once
+	// closed when SHUTDOWN succeeded
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
 }
 
 // MakeHandler creates a Handler instance
 func MakeHandler() *Handler {
 	var db database.DB
-	if config.Properties.Self != "" &&
-		len(config.Properties.Peers) > 0 {
+	if config.Properties().Self != "" &&
+		len(config.Properties().Peers) > 0 {
 		db = cluster.MakeCluster()
 	} else {
 		db = database2.NewStandaloneServer()
 	}
 	return &Handler{
-		db: db,
+		db:           db,
+		shutdownChan: make(chan struct{}),
 	}
 }
 
+// ShutdownRequested implements tcp.Shutdowner
+func (h *Handler) ShutdownRequested() <-chan struct{} {
+	return h.shutdownChan
+}
+
+func (h *Handler) requestShutdown() {
+	h.shutdownOnce.Do(func() {
+		close(h.shutdownChan)
+	})
+}
+
 func (h *Handler) closeClient(client *connection.Connection) {
 	_ = client.Close()
 	h.db.AfterClientClose(client)
+	h.monitors.remove(client)
 	h.activeConn.Delete(client)
+	atomic2.AddInt32(&h.clientCount, -1)
 }
 
 // Handle receives and executes redis commands
 func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
+	h.handlingMu.Lock()
 	if h.closing.Get() {
+		h.handlingMu.Unlock()
 		// closing handler refuse new connection
 		_ = conn.Close()
+		return
+	}
+	h.handling.Add(1)
+	h.handlingMu.Unlock()
+	defer h.handling.Done()
+
+	// maxclients could be changed by CONFIG SET, 0 means no limit
+	count := atomic2.AddInt32(&h.clientCount, 1)
+	if maxClients := config.Properties().MaxClients; maxClients > 0 && int(count) > maxClients {
+		atomic2.AddInt32(&h.clientCount, -1)
+		_, _ = conn.Write(maxClientsErrReplyBytes)
+		_ = conn.Close()
+		return
 	}
 
 	client := connection.NewConn(conn)
 	h.activeConn.Store(client, 1)
 
-	ch := parser.ParseStream(conn)
-	for payload := range ch {
-		if payload.Err != nil {
-			if payload.Err == io.EOF ||
-				payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
+	// replies are buffered while there are pipelined requests to handle,
+	// they are sent together before parser waiting for more data
+	p := parser.NewParser(&flushBeforeRead{
+		conn:   conn,
+		client: client,
+	})
+	for {
+		payload, err := p.Next()
+		if err != nil {
+			if !parser.IsProtocolError(err) {
 				// connection closed
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
 			}
 			// protocol err
-			errReply := reply.MakeErrReply(payload.Err.Error())
-			err := client.Write(errReply.ToBytes())
+			errReply := reply.MakeErrReply(err.Error())
+			err = client.Write(errReply.ToBytes())
 			if err != nil {
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
@@ -83,34 +137,113 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 			}
 			continue
 		}
-		if payload.Data == nil {
-			logger.Error("empty payload")
-			continue
-		}
-		r, ok := payload.Data.(*reply.MultiBulkReply)
+		r, ok := payload.(*reply.MultiBulkReply)
 		if !ok {
 			logger.Error("require multi bulk reply")
 			continue
 		}
+		if len(r.Args) == 0 {
+			continue
+		}
+		cmdName := strings.ToLower(string(r.Args[0]))
+		if cmdName == "monitor" {
+			client.AppendReply(h.execMonitor(client, r.Args))
+			continue
+		}
+		if cmdName != "auth" { // never expose password
+			h.monitors.feed(client, r.Args)
+		}
+		h.dbLock.RLock()
+		if h.dbClosed {
+			h.dbLock.RUnlock()
+			h.closeClient(client)
+			return
+		}
 		result := h.db.Exec(client, r.Args)
+		h.dbLock.RUnlock()
+		if cmdName == "shutdown" && !reply.IsErrorReply(result) {
+			// like redis, client gets no reply but a closed connection once shutdown starts
+			_ = client.Flush()
+			h.closeClient(client)
+			h.requestShutdown()
+			return
+		}
 		if result != nil {
-			_ = client.Write(result.ToBytes())
+			client.AppendReply(result.ToBytes())
 		} else {
-			_ = client.Write(unknownErrReplyBytes)
+			client.AppendReply(unknownErrReplyBytes)
 		}
+		if h.closing.Get() && !client.InMultiState() {
+			// draining, client is closed once its running command or MULTI block finished
+			_ = client.Flush()
+			h.closeClient(client)
+			return
+		}
+		if client.PendingSize() >= maxPendingReplySize {
+			_ = client.Flush()
+		}
+	}
+}
+
+// flushBeforeRead sends buffered replies before blocking on reading connection
+type flushBeforeRead struct {
+	conn   net.Conn
+	client *connection.Connection
+}
+
+func (r *flushBeforeRead) Read(b []byte) (int, error) {
+	err := r.client.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return r.conn.Read(b)
+}
+
+// getShutdownTimeout returns how long closing waits for clients, negative config means no waiting
+func getShutdownTimeout() time.Duration {
+	timeout := config.Properties().ShutdownTimeout
+	if timeout < 0 {
+		timeout = 0
 	}
+	return time.Duration(timeout) * time.Second
 }
 
-// Close stops handler
+// Close stops handler gracefully, it is safe to call Close more than once.
+// New connections are refused and idle clients are closed at once,
+// running commands and MULTI blocks could finish within shutdown-timeout before clients are closed forcibly.
+// Then database is closed, which flushes and fsyncs aof
 func (h *Handler) Close() error {
-	logger.Info("handler shutting down...")
-	h.closing.Set(true)
-	// TODO: concurrent wait
-	h.activeConn.Range(func(key interface{}, val interface{}) bool {
-		client := key.(*connection.Connection)
-		_ = client.Close()
-		return true
+	h.closeOnce.Do(func() {
+		logger.Info("handler shutting down...")
+		h.handlingMu.Lock()
+		h.closing.Set(true)
+		h.handlingMu.Unlock()
+		timeout := getShutdownTimeout()
+		deadline := time.Now().Add(timeout)
+		h.activeConn.Range(func(key interface{}, val interface{}) bool {
+			client := key.(*connection.Connection)
+			if client.InMultiState() {
+				_ = client.SetReadDeadline(deadline)
+			} else {
+				// wake up client waiting for request, running command is not affected
+				_ = client.SetReadDeadline(time.Now())
+			}
+			return true
+		})
+		if h.handling.WaitWithTimeout(timeout) {
+			logger.Warn("shutdown timeout, close remaining clients")
+			h.activeConn.Range(func(key interface{}, val interface{}) bool {
+				client := key.(*connection.Connection)
+				_ = client.Close()
+				return true
+			})
+		}
+		// database must not be closed while commands are writing it
+		h.dbLock.Lock()
+		h.dbClosed = true
+		h.dbLock.Unlock()
+		h.db.Close()
+		logger.Info("handler closed")
 	})
-	h.db.Close()
 	return nil
 }
@@ -2,8 +2,23 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/tls"
+	"godis/config"
+	"godis/lib/tlsutil"
+	"godis/lib/utils"
+	"godis/redis/client"
+	"godis/redis/connection"
+	"godis/redis/parser"
+	"godis/redis/reply"
+	"godis/redis/reply/asserts"
 	"godis/tcp"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"regexp"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -42,3 +57,292 @@ func TestListenAndServe(t *testing.T) {
 	closeChan <- struct{}{}
 	time.Sleep(time.Second)
 }
+
+func TestMonitor(t *testing.T) {
+	closeChan := make(chan struct{})
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+
+	monitorConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// inline command ends with LF only, like nc does
+	_, _ = monitorConn.Write([]byte("MONITOR\n"))
+	monitorReader := bufio.NewReader(monitorConn)
+	line, _, err := monitorReader.ReadLine()
+	if err != nil || string(line) != "+OK" {
+		t.Errorf("monitor failed: %s", line)
+		return
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = conn.Write([]byte("set a \"b c\\n\"\r\n"))
+	line, _, err = bufio.NewReader(conn).ReadLine()
+	if err != nil || string(line) != "+OK" {
+		t.Errorf("set failed: %s", line)
+		return
+	}
+	line, _, err = monitorReader.ReadLine()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := regexp.MustCompile(`^\+\d+\.\d{6} \[0 \S+\] "set" "a" "b c\\n"$`)
+	if !expected.Match(line) {
+		t.Errorf("wrong monitor output: %s", line)
+	}
+	closeChan <- struct{}{}
+	time.Sleep(time.Second)
+}
+
+func TestSlowMonitor(t *testing.T) {
+	hub := &monitorHub{}
+	// nobody reads from the pipe, so the monitor is stuck by its first line
+	monitorConn, peer := net.Pipe()
+	defer func() {
+		_ = peer.Close()
+	}()
+	monitor := connection.NewConn(monitorConn)
+	hub.add(monitor)
+	clientConn, _ := net.Pipe()
+	client := connection.NewConn(clientConn)
+	for i := 0; i < monitorQueueSize+2; i++ {
+		hub.feed(client, utils.ToCmdLine("set", "a", "b"))
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	// read the first line, then the connection should be closed
+	buf := make([]byte, 4096)
+	for {
+		_, err := peer.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("slow monitor should be disconnected: %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	closeChan := make(chan struct{})
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	size := 1000
+	buf := &bytes.Buffer{}
+	for i := 0; i < size; i++ {
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("SET", "p"+strconv.Itoa(i), strconv.Itoa(i))).ToBytes())
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("GET", "p"+strconv.Itoa(i))).ToBytes())
+	}
+	_, err = conn.Write(buf.Bytes())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ch := parser.ParseStream(conn)
+	for i := 0; i < size; i++ {
+		payload := <-ch
+		if payload.Err != nil || string(payload.Data.ToBytes()) != "+OK\r\n" {
+			t.Errorf("wrong reply of set: %v", payload)
+			return
+		}
+		payload = <-ch
+		if payload.Err != nil {
+			t.Error(payload.Err)
+			return
+		}
+		bulk, ok := payload.Data.(*reply.BulkReply)
+		if !ok || string(bulk.Arg) != strconv.Itoa(i) {
+			t.Errorf("wrong reply of get: %s", payload.Data.ToBytes())
+			return
+		}
+	}
+	_ = conn.Close()
+	closeChan <- struct{}{}
+	time.Sleep(time.Second)
+}
+
+func TestTLS(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	certFile, keyFile, err := tlsutil.MakeSelfSignedCert(tmpDir, "127.0.0.1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	serverTLS, err := tlsutil.MakeServerConfig(certFile, keyFile, certFile, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	closeChan := make(chan struct{})
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+
+	clientTLS, err := tlsutil.MakeClientConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := client.MakeTLSClient(listener.Addr().String(), clientTLS)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c.Start()
+	ret := c.Send(utils.ToCmdLine("SET", "tls", "ok"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = c.Send(utils.ToCmdLine("GET", "tls"))
+	asserts.AssertBulkReply(t, ret, "ok")
+	c.Close()
+	closeChan <- struct{}{}
+	time.Sleep(time.Second)
+}
+
+func TestMaxClients(t *testing.T) {
+	props := config.Properties()
+	config.SetProperties(&config.ServerProperties{
+		MaxClients: 1,
+	})
+	defer func() {
+		config.SetProperties(props)
+	}()
+	closeChan := make(chan struct{})
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = conn.Write([]byte("PING\r\n"))
+	line, _, err := bufio.NewReader(conn).ReadLine()
+	if err != nil || string(line) != "+PONG" {
+		t.Errorf("ping failed: %s", line)
+		return
+	}
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	line, _, err = bufio.NewReader(conn2).ReadLine()
+	if err != nil || string(line) != "-ERR max number of clients reached" {
+		t.Errorf("expect max clients error: %s", line)
+	}
+	_ = conn.Close()
+	closeChan <- struct{}{}
+	time.Sleep(time.Second)
+}
+
+func TestShutdown(t *testing.T) {
+	props := config.Properties()
+	config.SetProperties(&config.ServerProperties{
+		ShutdownTimeout: 5,
+	})
+	defer func() {
+		config.SetProperties(props)
+	}()
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	stopped := make(chan struct{})
+	go func() {
+		tcp.ListenAndServe(listener, MakeHandler(), make(chan struct{}))
+		close(stopped)
+	}()
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+	idleConn, idleReader := dial()
+	multiConn, multiReader := dial()
+	_, _ = multiConn.Write([]byte("MULTI\r\nSET a 1\r\n"))
+	for _, expected := range []string{"+OK", "+QUEUED"} {
+		line, _, err := multiReader.ReadLine()
+		if err != nil || string(line) != expected {
+			t.Errorf("expect %s, actual %s", expected, line)
+			return
+		}
+	}
+	conn, reader := dial()
+	_, _ = conn.Write([]byte("SHUTDOWN NOSAVE EXTRA\r\n"))
+	line, _, err := reader.ReadLine()
+	if err != nil || string(line) != "-Err syntax error" {
+		t.Errorf("expect syntax error, actual %s", line)
+		return
+	}
+	_, _ = conn.Write([]byte("SHUTDOWN\r\n"))
+	// connection is closed without reply
+	if _, _, err = reader.ReadLine(); err == nil {
+		t.Error("expect connection closed")
+	}
+	// idle client is closed at once
+	if _, _, err = idleReader.ReadLine(); err == nil {
+		t.Error("expect idle connection closed")
+	}
+	// MULTI block could finish
+	_, _ = multiConn.Write([]byte("EXEC\r\n"))
+	for _, expected := range []string{"*1", "+OK"} {
+		line, _, err := multiReader.ReadLine()
+		if err != nil || string(line) != expected {
+			t.Errorf("expect %s, actual %s", expected, line)
+			return
+		}
+	}
+	if _, _, err = multiReader.ReadLine(); err == nil {
+		t.Error("expect connection closed after EXEC")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Error("server is not stopped")
+	}
+	_ = idleConn.Close()
+	_ = multiConn.Close()
+	_ = conn.Close()
+}
@@ -6,6 +6,8 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"godis/interface/tcp"
 	"godis/lib/logger"
@@ -19,65 +21,163 @@ import (
 
 // Config stores tcp server properties
 type Config struct {
+	// Address of plain tcp listener, empty means disabled
 	Address    string        `yaml:"address"`
 	MaxConnect uint32        `yaml:"max-connect"`
 	Timeout    time.Duration `yaml:"timeout"`
+
+	// TLSAddress is address of tls listener, TLSConfig is required if it is set
+	TLSAddress string
+	TLSConfig  *tls.Config
+
+	// UnixSocket is path of unix domain socket listener, UnixSocketPerm is its file mode(0 means default)
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
+
+	// OnReload is invoked when receiving SIGHUP, nil means SIGHUP is ignored
+	OnReload func()
 }
 
-// ListenAndServeWithSignal binds port and handle requests, blocking until receive stop signal
+// Listen creates all listeners described by cfg
+func Listen(cfg *Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+		listeners = append(listeners, listener)
+	}
+	if cfg.TLSAddress != "" {
+		if cfg.TLSConfig == nil {
+			closeAll()
+			return nil, errors.New("tls config is required by tls listener")
+		}
+		listener, err := tls.Listen("tcp", cfg.TLSAddress, cfg.TLSConfig)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		logger.Info(fmt.Sprintf("bind tls: %s, start listening...", cfg.TLSAddress))
+		listeners = append(listeners, listener)
+	}
+	if cfg.UnixSocket != "" {
+		// remove socket file left by last run
+		_ = os.Remove(cfg.UnixSocket)
+		listener, err := net.Listen("unix", cfg.UnixSocket)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+		if cfg.UnixSocketPerm != 0 {
+			err = os.Chmod(cfg.UnixSocket, cfg.UnixSocketPerm)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+		}
+		logger.Info(fmt.Sprintf("bind unix socket: %s, start listening...", cfg.UnixSocket))
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no listener configured")
+	}
+	return listeners, nil
+}
+
+// ListenAndServeWithSignal binds port and handle requests, blocking until receive stop signal.
+// SIGHUP triggers cfg.OnReload instead of stopping server
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		sig := <-sigCh
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
-			closeChan <- struct{}{}
+		for sig := range sigCh {
+			switch sig {
+			case syscall.SIGHUP:
+				logger.Info("received SIGHUP, reloading...")
+				if cfg.OnReload != nil {
+					cfg.OnReload()
+				}
+			case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+				closeChan <- struct{}{}
+				return
+			}
 		}
 	}()
-	listener, err := net.Listen("tcp", cfg.Address)
+	listeners, err := Listen(cfg)
 	if err != nil {
 		return err
 	}
-	//cfg.Address = listener.Addr().String()
-	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
+	ListenAndServeMulti(listeners, handler, closeChan)
 	return nil
 }
 
 // ListenAndServe binds port and handle requests, blocking until close
 func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+	ListenAndServeMulti([]net.Listener{listener}, handler, closeChan)
+}
+
+// ListenAndServeMulti serves all listeners by the same handler, blocking until close
+func ListenAndServeMulti(listeners []net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close() // listener.Accept() will return err immediately
+		}
+	}
+	var shutdownChan <-chan struct{} // nil channel blocks forever
+	if shutdowner, ok := handler.(tcp.Shutdowner); ok {
+		shutdownChan = shutdowner.ShutdownRequested()
+	}
 	// listen signal
 	go func() {
-		<-closeChan
+		select {
+		case <-closeChan:
+		case <-shutdownChan:
+		}
 		logger.Info("shutting down...")
-		_ = listener.Close() // listener.Accept() will return err immediately
-		_ = handler.Close()  // close connections
+		closeListeners()
+		_ = handler.Close() // close connections
 	}()
 
 	// listen port
 	defer func() {
 		// close during unexpected error
-		_ = listener.Close()
+		closeListeners()
 		_ = handler.Close()
 	}()
 	ctx := context.Background()
 	var waitDone sync.WaitGroup
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			break
-		}
-		// handle
-		logger.Info("accept link")
-		waitDone.Add(1)
-		go func() {
-			defer func() {
-				waitDone.Done()
-			}()
-			handler.Handle(ctx, conn)
-		}()
+	var waitListeners sync.WaitGroup
+	for _, listener := range listeners {
+		waitListeners.Add(1)
+		go func(listener net.Listener) {
+			defer waitListeners.Done()
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					// one listener fails, stop others too
+					closeListeners()
+					break
+				}
+				// handle
+				logger.Info("accept link")
+				waitDone.Add(1)
+				go func() {
+					defer func() {
+						waitDone.Done()
+					}()
+					handler.Handle(ctx, conn)
+				}()
+			}
+		}(listener)
 	}
+	waitListeners.Wait()
 	waitDone.Wait()
 }
@@ -0,0 +1,118 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"godis/lib/tlsutil"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func echo(t *testing.T, conn net.Conn, msg string) {
+	_, err := conn.Write([]byte(msg + "\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	line, _, err := bufio.NewReader(conn).ReadLine()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(line) != msg {
+		t.Errorf("get wrong response: %s", line)
+	}
+}
+
+func TestListenMulti(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	certFile, keyFile, err := tlsutil.MakeSelfSignedCert(tmpDir, "127.0.0.1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	serverTLS, err := tlsutil.MakeServerConfig(certFile, keyFile, certFile, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	socket := filepath.Join(tmpDir, "godis.sock")
+	listeners, err := Listen(&Config{
+		Address:        "127.0.0.1:0",
+		TLSAddress:     "127.0.0.1:0",
+		TLSConfig:      serverTLS,
+		UnixSocket:     socket,
+		UnixSocketPerm: 0700,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	closeChan := make(chan struct{})
+	go ListenAndServeMulti(listeners, MakeEchoHandler(), closeChan)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("wrong permission of unix socket: %v", info.Mode().Perm())
+	}
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	echo(t, conn, "tcp")
+	_ = conn.Close()
+
+	clientTLS, err := tlsutil.MakeClientConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err = tls.Dial("tcp", listeners[1].Addr().String(), clientTLS)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	echo(t, conn, "tls")
+	_ = conn.Close()
+
+	// client without certificate is refused
+	noCertTLS, _ := tlsutil.MakeClientConfig("", "", certFile)
+	conn, err = tls.Dial("tcp", listeners[1].Addr().String(), noCertTLS)
+	if err == nil {
+		_, err = conn.Write([]byte("x\n"))
+		if err == nil {
+			_, _, err = bufio.NewReader(conn).ReadLine()
+		}
+		_ = conn.Close()
+	}
+	if err == nil {
+		t.Error("expect client without certificate refused")
+	}
+
+	conn, err = net.Dial("unix", socket)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	echo(t, conn, "unix")
+	_ = conn.Close()
+
+	closeChan <- struct{}{}
+	time.Sleep(time.Second)
+}